@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Limits on what compare_to_baseline reports
+const (
+	maxBaselineMismatches = 20
+	diffContextBefore     = 16
+	diffContextAfter      = 48
+)
+
+// loadCaptureFile reads a JSON lines capture file written with capture_dir.
+// Records from files predating conn_id all belong to connection 0.
+func loadCaptureFile(path string) ([]*CapturedPacket, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var captures []*CapturedPacket
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record captureRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", path, line, err)
+		}
+		captures = append(captures, &CapturedPacket{
+			Seq:              record.Seq,
+			Timestamp:        record.Timestamp,
+			ConnID:           record.ConnID,
+			Direction:        record.Direction,
+			Src:              record.Src,
+			Dst:              record.Dst,
+			Bytes:            len(record.RawData),
+			StreamOffset:     record.StreamOffset,
+			RawData:          record.RawData,
+			DetectedProtocol: record.DetectedProtocol,
+			Hash:             record.Hash,
+			Injected:         record.Injected,
+			PossibleRetry:    record.PossibleRetry,
+			TraceID:          record.TraceID,
+			Truncated:        record.Truncated,
+			BodyFiltered:     record.BodyFiltered,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return captures, nil
+}
+
+// baselineMismatch is one difference between a baseline and live captures
+type baselineMismatch map[string]interface{}
+
+// compareStreams pairs baseline and live connections in order and reports
+// the first differing offset of each direction
+func compareStreams(baseline, live []*CapturedPacket) []baselineMismatch {
+	var mismatches []baselineMismatch
+	baseConns := reassembleStreams(baseline)
+	liveConns := reassembleStreams(live)
+
+	for i := 0; i < max(len(baseConns), len(liveConns)); i++ {
+		if i >= len(liveConns) {
+			mismatches = append(mismatches, baselineMismatch{
+				"connection":       i,
+				"baseline_conn_id": baseConns[i].connID,
+				"missing":          "live",
+			})
+			continue
+		}
+		if i >= len(baseConns) {
+			mismatches = append(mismatches, baselineMismatch{
+				"connection":   i,
+				"live_conn_id": liveConns[i].connID,
+				"missing":      "baseline",
+			})
+			continue
+		}
+
+		pairs := []struct {
+			direction  string
+			base, live *captureStream
+		}{
+			{DirectionClientToServer, &baseConns[i].clientToServer, &liveConns[i].clientToServer},
+			{DirectionServerToClient, &baseConns[i].serverToClient, &liveConns[i].serverToClient},
+		}
+		for _, pair := range pairs {
+			offset, differs := firstDifference(pair.base.data, pair.live.data)
+			if !differs {
+				continue
+			}
+			mismatches = append(mismatches, baselineMismatch{
+				"connection":       i,
+				"baseline_conn_id": baseConns[i].connID,
+				"live_conn_id":     liveConns[i].connID,
+				"direction":        pair.direction,
+				"offset":           offset,
+				"baseline_bytes":   len(pair.base.data),
+				"live_bytes":       len(pair.live.data),
+				"baseline_context": diffContext(pair.base.data, offset),
+				"live_context":     diffContext(pair.live.data, offset),
+			})
+		}
+	}
+
+	return mismatches
+}
+
+// compareHTTP pairs baseline and live HTTP transactions in order and
+// reports fields that differ
+func compareHTTP(baseline, live []*CapturedPacket) []baselineMismatch {
+	var mismatches []baselineMismatch
+	baseTx := extractHTTPTransactions(baseline)
+	liveTx := extractHTTPTransactions(live)
+
+	for i := 0; i < max(len(baseTx), len(liveTx)); i++ {
+		if i >= len(liveTx) {
+			mismatches = append(mismatches, baselineMismatch{
+				"transaction": i,
+				"missing":     "live",
+				"baseline":    transactionLine(baseTx[i]),
+			})
+			continue
+		}
+		if i >= len(baseTx) {
+			mismatches = append(mismatches, baselineMismatch{
+				"transaction": i,
+				"missing":     "baseline",
+				"live":        transactionLine(liveTx[i]),
+			})
+			continue
+		}
+
+		base, cur := transactionFields(baseTx[i]), transactionFields(liveTx[i])
+		for _, field := range transactionFieldNames {
+			if base[field] != cur[field] {
+				mismatches = append(mismatches, baselineMismatch{
+					"transaction": i,
+					"field":       field,
+					"baseline":    base[field],
+					"live":        cur[field],
+				})
+			}
+		}
+	}
+
+	return mismatches
+}
+
+// transactionFieldNames lists the HTTP transaction fields compared, in order
+var transactionFieldNames = []string{"method", "uri", "request_body_bytes", "status_code", "response_body_bytes"}
+
+// transactionFields extracts the compared fields of a transaction
+func transactionFields(tx HTTPTransaction) map[string]interface{} {
+	fields := map[string]interface{}{
+		"method":              tx.Request.Method,
+		"uri":                 tx.Request.URI,
+		"request_body_bytes":  tx.Request.BodyBytes,
+		"status_code":         0,
+		"response_body_bytes": int64(0),
+	}
+	if tx.Response != nil {
+		fields["status_code"] = tx.Response.StatusCode
+		fields["response_body_bytes"] = tx.Response.BodyBytes
+	}
+	return fields
+}
+
+// transactionLine summarizes a transaction as "METHOD URI -> STATUS"
+func transactionLine(tx HTTPTransaction) string {
+	line := tx.Request.Method + " " + tx.Request.URI
+	if tx.Response != nil {
+		line += fmt.Sprintf(" -> %d", tx.Response.StatusCode)
+	}
+	return line
+}
+
+// firstDifference returns the first offset at which a and b differ
+func firstDifference(a, b []byte) (int, bool) {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i, true
+		}
+	}
+	return n, len(a) != len(b)
+}
+
+// diffContext renders the bytes around offset as printable text
+func diffContext(data []byte, offset int) string {
+	start := max(offset-diffContextBefore, 0)
+	end := min(offset+diffContextAfter, len(data))
+	if start >= end {
+		return ""
+	}
+	text, _ := printableText(data[start:end])
+	return text
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Transport protocols a proxy can listen and forward on
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
+// Limits of UDP/TCP bridging
+const (
+	maxDatagramSize     = 65535           // Largest datagram, also the largest frame payload
+	udpSessionQueueLen  = 64              // Datagrams waiting for a session's backend
+	udpSessionIdleLimit = 2 * time.Minute // A UDP client silent this long is forgotten
+)
+
+// validateProtocols checks listen_protocol and forward_protocol. Only
+// bridging between UDP and TCP is supported, not UDP to UDP.
+func validateProtocols(listen, forward string) error {
+	for _, protocol := range []string{listen, forward} {
+		if protocol != ProtocolTCP && protocol != ProtocolUDP {
+			return fmt.Errorf("invalid protocol %q (expected tcp or udp)", protocol)
+		}
+	}
+	if listen == ProtocolUDP && forward == ProtocolUDP {
+		return fmt.Errorf("udp to udp forwarding is not supported, one side must be tcp")
+	}
+	return nil
+}
+
+// IP versions a proxy can listen on, as net.Listen networks
+const (
+	NetworkTCP4 = "tcp4" // IPv4 only
+	NetworkTCP6 = "tcp6" // IPv6 only
+)
+
+// validateListenNetwork checks listen_network: tcp (dual-stack where the
+// platform supports it), tcp4 or tcp6
+func validateListenNetwork(network string) error {
+	switch network {
+	case "", ProtocolTCP, NetworkTCP4, NetworkTCP6:
+		return nil
+	}
+	return fmt.Errorf("invalid listen_network %q (expected tcp, tcp4 or tcp6)", network)
+}
+
+// socketNetwork returns the network the proxy listens on, with the IP
+// version of listen_network applied to a UDP listener as well
+func (o *ProxyOptions) socketNetwork() string {
+	network := o.ListenNetwork
+	if network == "" {
+		network = ProtocolTCP
+	}
+	if o.ListenProtocol == ProtocolUDP {
+		return strings.Replace(network, ProtocolTCP, ProtocolUDP, 1)
+	}
+	return network
+}
+
+// bridged reports whether the proxy converts between UDP and TCP
+func (o *ProxyOptions) bridged() bool {
+	return o.ListenProtocol == ProtocolUDP || o.ForwardProtocol == ProtocolUDP
+}
+
+// writeFrame sends a datagram over a stream as a 2-byte big-endian length
+// followed by the payload
+func writeFrame(w io.Writer, payload []byte) error {
+	if len(payload) > maxDatagramSize {
+		return fmt.Errorf("datagram of %d bytes exceeds %d", len(payload), maxDatagramSize)
+	}
+	frame := make([]byte, 2+len(payload))
+	binary.BigEndian.PutUint16(frame, uint16(len(payload)))
+	copy(frame[2:], payload)
+	_, _, err := writeFull(w, frame)
+	return err
+}
+
+// readFrame reads one length-prefixed datagram from a stream
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
+
+// udpSession is the TCP backend connection of one UDP client address
+type udpSession struct {
+	addr     net.Addr
+	queue    chan []byte
+	lastSeen atomic.Int64 // Unix nanoseconds of the last datagram from the client
+}
+
+// runUDP reads datagrams on a UDP listening proxy, handing each client
+// address its own session with a TCP backend connection
+func (p *ProxyInstance) runUDP() {
+	defer p.wg.Done()
+
+	infof("Proxy listening on udp :%d, forwarding to tcp %s:%d", p.ListenPort, p.ForwardHost, p.ForwardPort)
+
+	var sessions sync.Map // Client address -> *udpSession
+	buf := make([]byte, maxDatagramSize)
+	var backoff acceptBackoff
+	for {
+		n, addr, err := p.PacketConn.ReadFrom(buf)
+		if err != nil {
+			if p.ctx.Err() != nil {
+				return // Proxy is shutting down
+			}
+			warnf("UDP read error on port %d: %v", p.ListenPort, err)
+			p.accepts.recordAccept(err)
+			if !backoff.wait(p.ctx) {
+				return
+			}
+			continue
+		}
+		backoff.reset()
+
+		key := addr.String()
+		value, exists := sessions.Load(key)
+		if !exists {
+			p.accepts.recordAccept(nil)
+
+			// Sessions count against the same limits as TCP connections.
+			// The read loop can't wait for a handler slot without stalling
+			// every session, so a datagram that can't open one is dropped.
+			ip := addrIP(addr)
+			if !p.acquireIPSlot(ip) {
+				warnf("Dropped datagram from %s on port %d: per-IP limit of %d reached", key, p.ListenPort, p.Options.MaxConnsPerIP)
+				p.Stats.mu.Lock()
+				p.Stats.Rejected++
+				p.Stats.mu.Unlock()
+				continue
+			}
+			select {
+			case p.handlerSlots <- struct{}{}:
+			default:
+				p.releaseIPSlot(ip)
+				warnf("Dropped datagram from %s on port %d: %d sessions already open", key, p.ListenPort, cap(p.handlerSlots))
+				p.Stats.mu.Lock()
+				p.Stats.Rejected++
+				p.Stats.mu.Unlock()
+				continue
+			}
+
+			session := &udpSession{addr: addr, queue: make(chan []byte, udpSessionQueueLen)}
+			sessions.Store(key, session)
+			value = session
+
+			atomic.AddInt32(&p.connections, 1)
+			p.Stats.mu.Lock()
+			p.Stats.Connections++
+			p.Stats.mu.Unlock()
+			p.notifyActivity()
+
+			p.wg.Add(1)
+			go func() {
+				p.handleUDPSession(session)
+				sessions.Delete(key)
+				p.releaseIPSlot(ip)
+				<-p.handlerSlots
+			}()
+		}
+
+		session := value.(*udpSession)
+		session.lastSeen.Store(time.Now().UnixNano())
+		select {
+		case session.queue <- append([]byte(nil), buf[:n]...):
+		default:
+			warnf("Dropped datagram from %s on port %d: backend is too slow", key, p.ListenPort)
+		}
+	}
+}
+
+// handleUDPSession relays one UDP client's datagrams to a TCP backend as
+// frames and the backend's frames back as datagrams
+func (p *ProxyInstance) handleUDPSession(session *udpSession) {
+	defer p.wg.Done()
+	defer atomic.AddInt32(&p.connections, -1)
+
+	target := net.JoinHostPort(p.ForwardHost, strconv.Itoa(p.ForwardPort))
+	if i := p.pickTarget(); i >= 0 {
+		target = p.Options.ForwardTargets[i].String()
+		p.targetConns[i].Add(1)
+	}
+	serverConn, err := dialBackend(p.ctx, target)
+	if err != nil {
+		if p.ctx.Err() == nil {
+			warnf("Failed to connect to %s: %v", target, err)
+			dropped := p.newConnection(nil, nil)
+			dropped.ClientAddr = session.addr.String()
+			dropped.LocalAddr = p.PacketConn.LocalAddr().String()
+			p.recordDropped(dropped)
+		}
+		return
+	}
+	defer serverConn.Close()
+	p.tuneTCP(serverConn)
+
+	conn := p.newConnection(nil, serverConn)
+	conn.ClientAddr = session.addr.String()
+	conn.LocalAddr = p.PacketConn.LocalAddr().String()
+	if p.excludedAddr(conn.ClientAddr) {
+		conn.excluded = true
+		p.Stats.mu.Lock()
+		p.Stats.Excluded++
+		p.Stats.mu.Unlock()
+	}
+	p.registerConnection(conn)
+	defer p.unregisterConnection(conn)
+	defer p.releaseHeldCaptures(conn)
+	defer p.flushCoalesced(conn)
+	defer p.releaseUndetected(conn)
+	infof("New udp session #%d from %s -> %s", conn.ID, conn.ClientAddr, target)
+
+	connCtx, connCancel := context.WithCancel(p.ctx)
+	defer connCancel()
+	stopClose := context.AfterFunc(connCtx, func() { serverConn.Close() })
+	defer stopClose()
+
+	// Backend frames back to the client as datagrams
+	var responses sync.WaitGroup
+	responses.Add(1)
+	go func() {
+		defer responses.Done()
+		defer connCancel()
+		reader := bufio.NewReader(serverConn)
+		for {
+			payload, err := readFrame(reader)
+			if err != nil {
+				p.recordCloseReason(conn, DirectionServerToClient, err, false)
+				return
+			}
+			conn.countBytes(DirectionServerToClient, int64(len(payload)))
+			p.captureData(conn, payload, DirectionServerToClient)
+			if _, err := p.PacketConn.WriteTo(payload, session.addr); err != nil {
+				p.recordCloseReason(conn, DirectionServerToClient, err, true)
+				return
+			}
+		}
+	}()
+
+	// Client datagrams to the backend as frames, until the client goes quiet
+	idle := time.NewTicker(udpSessionIdleLimit / 4)
+	defer idle.Stop()
+	for done := false; !done; {
+		select {
+		case <-connCtx.Done():
+			done = true
+		case <-idle.C:
+			if time.Since(time.Unix(0, session.lastSeen.Load())) > udpSessionIdleLimit {
+				conn.setCloseReason(CloseIdle)
+				done = true
+			}
+		case payload := <-session.queue:
+			conn.countBytes(DirectionClientToServer, int64(len(payload)))
+			p.captureData(conn, payload, DirectionClientToServer)
+			if err := writeFrame(serverConn, payload); err != nil {
+				p.recordCloseReason(conn, DirectionClientToServer, err, true)
+				done = true
+			}
+		}
+	}
+	connCancel()
+	responses.Wait()
+
+	infof("UDP session closed: %s", conn.ClientAddr)
+}
+
+// bridgeToUDP relays a TCP client's frames to a UDP backend as datagrams
+// and the backend's datagrams back as frames
+func (p *ProxyInstance) bridgeToUDP(clientConn net.Conn, target string) {
+	var dialer net.Dialer
+	serverConn, err := dialer.DialContext(p.ctx, "udp", target)
+	if err != nil {
+		if p.ctx.Err() == nil {
+			warnf("Failed to connect to udp %s: %v", target, err)
+			p.recordDropped(p.newConnection(clientConn, nil))
+		}
+		return
+	}
+	defer serverConn.Close()
+	p.tuneTCP(clientConn)
+
+	conn := p.newConnection(clientConn, serverConn)
+	if p.excludedSource(clientConn) {
+		conn.excluded = true
+		p.Stats.mu.Lock()
+		p.Stats.Excluded++
+		p.Stats.mu.Unlock()
+	}
+	p.registerConnection(conn)
+	defer p.unregisterConnection(conn)
+	defer p.releaseHeldCaptures(conn)
+	defer p.flushCoalesced(conn)
+	defer p.releaseUndetected(conn)
+	infof("New connection #%d from %s -> udp %s", conn.ID, conn.ClientAddr, target)
+
+	connCtx, connCancel := context.WithCancel(p.ctx)
+	defer connCancel()
+	stopClose := context.AfterFunc(connCtx, func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+	defer stopClose()
+
+	// Backend datagrams back to the client as frames
+	var responses sync.WaitGroup
+	responses.Add(1)
+	go func() {
+		defer responses.Done()
+		defer connCancel()
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, err := serverConn.Read(buf)
+			if err != nil {
+				p.recordCloseReason(conn, DirectionServerToClient, err, false)
+				return
+			}
+			conn.countBytes(DirectionServerToClient, int64(len(buf[:n])))
+			p.captureData(conn, buf[:n], DirectionServerToClient)
+			conn.toClientMu.Lock()
+			err = writeFrame(clientConn, buf[:n])
+			conn.toClientMu.Unlock()
+			if err != nil {
+				p.recordCloseReason(conn, DirectionServerToClient, err, true)
+				return
+			}
+		}
+	}()
+
+	// Client frames to the backend as datagrams
+	reader := bufio.NewReader(clientConn)
+	for {
+		payload, err := readFrame(reader)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) && err != io.EOF {
+				warnf("%s frame error: %v", DirectionClientToServer, err)
+			}
+			p.recordCloseReason(conn, DirectionClientToServer, err, false)
+			break
+		}
+		conn.countBytes(DirectionClientToServer, int64(len(payload)))
+		p.captureData(conn, payload, DirectionClientToServer)
+		if _, err := serverConn.Write(payload); err != nil {
+			p.recordCloseReason(conn, DirectionClientToServer, err, true)
+			break
+		}
+	}
+	connCancel()
+	responses.Wait()
+
+	infof("Connection closed: %s", clientConn.RemoteAddr())
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestBridgeUDPToTCP tests that a datagram reaches a TCP backend as a
+// length-prefixed frame and the framed reply returns as a datagram
+func TestBridgeUDPToTCP(t *testing.T) {
+	// TCP backend answering each frame with "pong:" + payload
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start backend: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			payload, err := readFrame(reader)
+			if err != nil {
+				return
+			}
+			writeFrame(conn, append([]byte("pong:"), payload...))
+		}
+	}()
+
+	manager := NewProxyManager()
+	opts := ProxyOptions{ListenProtocol: ProtocolUDP, ForwardProtocol: ProtocolTCP}
+	if err := manager.StartProxyWithOptions(19170, "127.0.0.1", ln.Addr().(*net.TCPAddr).Port, 1024*1024, opts); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19170)
+
+	client, err := net.Dial("udp", "127.0.0.1:19170")
+	if err != nil {
+		t.Fatalf("Failed to dial proxy: %v", err)
+	}
+	defer client.Close()
+	client.Write([]byte("ping"))
+
+	reply := make([]byte, 64)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := client.Read(reply)
+	if err != nil || string(reply[:n]) != "pong:ping" {
+		t.Fatalf("Expected pong:ping datagram, got %q (%v)", reply[:n], err)
+	}
+
+	proxy, _ := manager.GetProxy(19170)
+	captures := proxy.Buffer.GetAll()
+	if len(captures) != 2 || string(captures[0].RawData) != "ping" || string(captures[1].RawData) != "pong:ping" {
+		t.Errorf("Expected unframed captures of both datagrams, got %d captures", len(captures))
+	}
+}
+
+// TestBridgeTCPToUDP tests that a frame from a TCP client reaches a UDP
+// backend as a datagram and the reply returns as a frame
+func TestBridgeTCPToUDP(t *testing.T) {
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start backend: %v", err)
+	}
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := backend.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteTo(bytes.ToUpper(buf[:n]), addr)
+		}
+	}()
+
+	manager := NewProxyManager()
+	opts := ProxyOptions{ListenProtocol: ProtocolTCP, ForwardProtocol: ProtocolUDP}
+	if err := manager.StartProxyWithOptions(19171, "127.0.0.1", backend.LocalAddr().(*net.UDPAddr).Port, 1024*1024, opts); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19171)
+
+	client, err := net.Dial("tcp", "127.0.0.1:19171")
+	if err != nil {
+		t.Fatalf("Failed to dial proxy: %v", err)
+	}
+	defer client.Close()
+	writeFrame(client, []byte("hello"))
+
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	payload, err := readFrame(bufio.NewReader(client))
+	if err != nil || string(payload) != "HELLO" {
+		t.Fatalf("Expected HELLO frame, got %q (%v)", payload, err)
+	}
+}
+
+// TestBridgeUDPSessionLimit tests that UDP sessions count against
+// max_conns_per_ip, so a second client address from the same IP is refused
+func TestBridgeUDPSessionLimit(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start backend: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	manager := NewProxyManager()
+	opts := ProxyOptions{ListenProtocol: ProtocolUDP, ForwardProtocol: ProtocolTCP, MaxConnsPerIP: 1}
+	if err := manager.StartProxyWithOptions(19226, "127.0.0.1", ln.Addr().(*net.TCPAddr).Port, 1024*1024, opts); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19226)
+	proxy, _ := manager.GetProxy(19226)
+
+	for i := 0; i < 2; i++ {
+		client, err := net.Dial("udp", "127.0.0.1:19226")
+		if err != nil {
+			t.Fatalf("Failed to dial proxy: %v", err)
+		}
+		defer client.Close()
+		client.Write([]byte("ping"))
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		proxy.Stats.mu.RLock()
+		rejected, connections := proxy.Stats.Rejected, proxy.Stats.Connections
+		proxy.Stats.mu.RUnlock()
+		if rejected == 1 && connections == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 1 session and 1 rejected client, got %d and %d", connections, rejected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
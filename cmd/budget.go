@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sync/atomic"
+)
+
+// captureBudget bounds the bytes stored across every proxy's buffer. Buffers
+// reserve from it before growing, and a capture that does not fit is dropped.
+type captureBudget struct {
+	limit   int64
+	used    atomic.Int64
+	dropped atomic.Int64 // Captures dropped because the budget was exhausted
+}
+
+// reserve takes n bytes from the budget, reporting false if they do not fit
+func (b *captureBudget) reserve(n int) bool {
+	for {
+		used := b.used.Load()
+		if used+int64(n) > b.limit {
+			return false
+		}
+		if b.used.CompareAndSwap(used, used+int64(n)) {
+			return true
+		}
+	}
+}
+
+// release returns n bytes to the budget
+func (b *captureBudget) release(n int) {
+	b.used.Add(-int64(n))
+}
+
+// SetMaxTotalCaptureBytes bounds the bytes stored across all proxies started
+// from now on (0 = unlimited)
+func (pm *ProxyManager) SetMaxTotalCaptureBytes(limit int) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if limit <= 0 {
+		pm.budget = nil
+		return
+	}
+	pm.budget = &captureBudget{limit: int64(limit)}
+}
+
+// CaptureBudget reports the global budget as limit, used and dropped
+// captures, with ok false when no budget is set
+func (pm *ProxyManager) CaptureBudget() (limit, used, dropped int64, ok bool) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	if pm.budget == nil {
+		return 0, 0, 0, false
+	}
+	return pm.budget.limit, pm.budget.used.Load(), pm.budget.dropped.Load(), true
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestCaptureBudget tests that two proxies cannot together store more than
+// the global budget, and that stopping one returns its bytes
+func TestCaptureBudget(t *testing.T) {
+	manager := NewProxyManager()
+	manager.SetMaxTotalCaptureBytes(1000)
+	for _, port := range []int{19147, 19148} {
+		if err := manager.StartProxy(port, "127.0.0.1", 18082, 1024*1024); err != nil {
+			t.Fatalf("Failed to start proxy: %v", err)
+		}
+		defer manager.StopProxy(port)
+	}
+	first, _ := manager.GetProxy(19147)
+	second, _ := manager.GetProxy(19148)
+
+	payload := bytes.Repeat([]byte("a"), 600)
+	first.recordCapture(first.newConnection(nil, nil), payload, DirectionClientToServer, false)
+	secondConn := second.newConnection(nil, nil)
+	second.recordCapture(secondConn, payload, DirectionClientToServer, false)
+	second.recordCapture(secondConn, payload[:300], DirectionClientToServer, false)
+
+	if packets, _, _ := second.Buffer.GetStats(); packets != 1 {
+		t.Errorf("Expected only the capture that fits the budget, got %d packets", packets)
+	}
+	if second.Stats.BudgetDropped != 1 {
+		t.Errorf("Expected 1 capture dropped, got %d", second.Stats.BudgetDropped)
+	}
+
+	result := callTool(t, NewGetCaptureBudgetHandler(manager).Execute, map[string]interface{}{})
+	expected := map[string]interface{}{
+		"enabled":          true,
+		"max_bytes":        float64(1000),
+		"used_bytes":       float64(900),
+		"remaining_bytes":  float64(100),
+		"captures_dropped": float64(1),
+	}
+	for key, want := range expected {
+		if result[key] != want {
+			t.Errorf("%s: expected %v, got %v", key, want, result[key])
+		}
+	}
+
+	// Stopping the first proxy frees its 600 bytes
+	manager.StopProxy(19147)
+	second.recordCapture(secondConn, payload, DirectionClientToServer, false)
+	if packets, used := second.Buffer.count, manager.budget.used.Load(); packets != 2 || used != 900 {
+		t.Errorf("Expected the capture to fit after the stop, got %d packets and %d bytes used", packets, used)
+	}
+
+	// Clearing a buffer returns its bytes too
+	second.Buffer.Clear()
+	if used := manager.budget.used.Load(); used != 0 {
+		t.Errorf("Expected an empty budget after clearing, got %d bytes used", used)
+	}
+}
@@ -1,21 +1,138 @@
 package main
 
 import (
+	"encoding/hex"
+	"fmt"
+	"strings"
 	"sync"
 	"time"
+	"unsafe"
 )
 
+// hexDumpBytes is how much of a packet its hex dump covers
+const hexDumpBytes = 200
+
 // CapturedPacket represents a single captured packet
 type CapturedPacket struct {
-	Timestamp        time.Time `json:"timestamp"`
-	Direction        string    `json:"direction"`
-	Bytes            int       `json:"bytes"`
-	HexDump          string    `json:"hex_dump"`
-	AsciiStrings     []string  `json:"ascii_strings"`
-	DetectedProtocol string    `json:"detected_protocol"`
-	RawData          []byte    `json:"-"` // Not included in JSON output
+	Seq                uint64              `json:"seq"` // Per-proxy capture sequence number
+	Timestamp          time.Time           `json:"timestamp"`
+	ConnID             uint64              `json:"conn_id"`
+	Direction          string              `json:"direction"`
+	Src                string              `json:"src,omitempty"` // Sending end of the client connection
+	Dst                string              `json:"dst,omitempty"` // Receiving end of the client connection
+	Bytes              int                 `json:"bytes"`
+	StreamOffset       int64               `json:"stream_offset"` // Offset of the first byte within the direction's stream
+	DetectedProtocol   string              `json:"detected_protocol"`
+	Hash               string              `json:"hash"`                           // SHA-256 of the payload before filtering, after redaction
+	Injected           bool                `json:"injected,omitempty"`             // Written by inject_bytes
+	PossibleRetry      bool                `json:"possible_retry,omitempty"`       // Completes a repeat of the direction's previous request within retryWindow
+	TraceID            string              `json:"trace_id,omitempty"`             // Correlation header value under trace_header
+	Truncated          bool                `json:"truncated,omitempty"`            // RawData holds only a prefix of Bytes
+	BodyFiltered       bool                `json:"body_filtered,omitempty"`        // HTTP body bytes were left out of RawData
+	HTTPContentLengths []int64             `json:"http_content_lengths,omitempty"` // Body sizes of HTTP messages known here, under http_max_body_bytes
+	HTTP2Frames        []HTTP2FrameSummary `json:"http2_frames,omitempty"`
+	TLSRecords         []TLSRecordSummary  `json:"tls_records,omitempty"`
+	RawData            []byte              `json:"-"` // Not included in JSON output
+}
+
+// HexDump renders the hex dump of the first 200 stored bytes. It is built on
+// demand rather than stored, as most captures are never displayed.
+func (c *CapturedPacket) HexDump() string {
+	data := c.RawData
+	if len(data) > hexDumpBytes {
+		data = data[:hexDumpBytes]
+	}
+	return hex.Dump(data)
+}
+
+// hexDumpLayout is the shape of a rendered hex dump
+type hexDumpLayout struct {
+	width int  // Bytes per line: 8, 16 or 32
+	ascii bool // Whether each line ends with an ASCII gutter
+}
+
+// defaultHexDump is the layout of encoding/hex's Dump
+var defaultHexDump = hexDumpLayout{width: 16, ascii: true}
+
+// FormatHexDump renders the hex dump of the first 200 stored bytes in the
+// given layout
+func (c *CapturedPacket) FormatHexDump(layout hexDumpLayout) string {
+	if layout == defaultHexDump {
+		return c.HexDump()
+	}
+	data := c.RawData
+	if len(data) > hexDumpBytes {
+		data = data[:hexDumpBytes]
+	}
+	return formatHexDump(data, layout)
 }
 
+// formatHexDump renders data like hex.Dump with layout.width bytes per line,
+// grouped in eights
+func formatHexDump(data []byte, layout hexDumpLayout) string {
+	var out strings.Builder
+	for offset := 0; offset < len(data); offset += layout.width {
+		chunk := data[offset:min(offset+layout.width, len(data))]
+
+		var line strings.Builder
+		fmt.Fprintf(&line, "%08x  ", offset)
+		for i := 0; i < layout.width; i++ {
+			if i < len(chunk) {
+				fmt.Fprintf(&line, "%02x ", chunk[i])
+			} else {
+				line.WriteString("   ")
+			}
+			if i%8 == 7 {
+				line.WriteByte(' ')
+			}
+		}
+		if !layout.ascii {
+			out.WriteString(strings.TrimRight(line.String(), " "))
+			out.WriteByte('\n')
+			continue
+		}
+		line.WriteByte('|')
+		for _, b := range chunk {
+			if b < 32 || b > 126 {
+				b = '.'
+			}
+			line.WriteByte(b)
+		}
+		line.WriteString("|\n")
+		out.WriteString(line.String())
+	}
+	return out.String()
+}
+
+// AsciiStrings extracts the readable strings of the stored bytes on demand
+func (c *CapturedPacket) AsciiStrings() []string {
+	return extractAsciiStrings(c.RawData)
+}
+
+// connCaptures returns the captures of connection connID, in order
+func connCaptures(captures []*CapturedPacket, connID uint64) []*CapturedPacket {
+	var filtered []*CapturedPacket
+	for _, capture := range captures {
+		if capture.ConnID == connID {
+			filtered = append(filtered, capture)
+		}
+	}
+	return filtered
+}
+
+// Initial ring buffer capacity is sized for packets of about
+// expectedPacketSize bytes, within these bounds
+const (
+	expectedPacketSize = 1024
+	minInitialCapacity = 16
+	maxInitialCapacity = 64 * 1024
+)
+
+// minSlotBytes caps the slot count at one per this many bytes of the limit,
+// so empty or tiny packets cannot grow the slot slice without bound. Once the
+// cap is reached the oldest packet is evicted even if bytes remain.
+const minSlotBytes = 64
+
 // RingBuffer is a thread-safe circular buffer for captured packets
 type RingBuffer struct {
 	data        []*CapturedPacket
@@ -24,26 +141,63 @@ type RingBuffer struct {
 	head        int
 	tail        int
 	count       int
+	minSlots    int            // Slot slice length never shrinks below this
+	maxSlots    int            // Slot slice length never grows beyond this
+	grows       int            // Times the slot slice was grown
+	budget      *captureBudget // Shared across proxies, nil when unlimited
+	stopAt      int            // Bytes past which packets are refused instead of evicting (0 = evict)
+	policy      evictionPolicy // Which packets are evicted first, FIFO by default
+	retain      time.Duration  // Packets older than this are evicted (0 = no time limit)
 	mu          sync.Mutex
 }
 
+// Reasons RingBuffer.Add refuses a packet
+const (
+	dropNone      = ""
+	dropBudget    = "budget"    // The global capture budget is exhausted
+	dropWatermark = "watermark" // The buffer reached stop_capture_at_percent
+	dropPolicy    = "policy"    // The eviction policy ranks the packet below every stored one
+)
+
+// SetStopAtPercent makes the buffer refuse packets that would take its usage
+// past percent of the limit, keeping the oldest captures rather than evicting
+func (rb *RingBuffer) SetStopAtPercent(percent float64) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.stopAt = int(float64(rb.maxSize) * percent / 100)
+}
+
 // NewRingBuffer creates a new ring buffer with specified max size in bytes
 func NewRingBuffer(maxSize int) *RingBuffer {
 	if maxSize <= 0 {
 		maxSize = 10 * 1024 * 1024 // Default 10MB
 	}
 
-	// Pre-allocate with reasonable capacity
-	initialCapacity := 1000
+	// Pre-allocate in proportion to the limit, so small buffers do not
+	// over-allocate and large ones do not repeatedly grow
+	initialCapacity := maxSize / expectedPacketSize
+	if initialCapacity < minInitialCapacity {
+		initialCapacity = minInitialCapacity
+	} else if initialCapacity > maxInitialCapacity {
+		initialCapacity = maxInitialCapacity
+	}
+
+	maxSlots := maxSize / minSlotBytes
+	if maxSlots < initialCapacity {
+		maxSlots = initialCapacity
+	}
 
 	return &RingBuffer{
-		data:    make([]*CapturedPacket, initialCapacity),
-		maxSize: maxSize,
+		data:     make([]*CapturedPacket, initialCapacity),
+		maxSize:  maxSize,
+		minSlots: initialCapacity,
+		maxSlots: maxSlots,
 	}
 }
 
-// Add adds a packet to the buffer
-func (rb *RingBuffer) Add(packet *CapturedPacket) {
+// Add adds a packet to the buffer, returning why it was dropped instead, or
+// dropNone if it was stored
+func (rb *RingBuffer) Add(packet *CapturedPacket) string {
 	rb.mu.Lock()
 	defer rb.mu.Unlock()
 
@@ -55,17 +209,51 @@ func (rb *RingBuffer) Add(packet *CapturedPacket) {
 		packetSize = rb.maxSize
 	}
 
+	// Drop packets that have left the retention window
+	rb.expireLocked(time.Now())
+
+	// Past the watermark new packets are refused so early ones survive
+	if rb.stopAt > 0 && (rb.currentSize+packetSize > rb.stopAt || rb.count == rb.maxSlots) {
+		return dropWatermark
+	}
+
+	// A full buffer whose policy would evict the new packet first keeps
+	// what it has
+	full := rb.currentSize+packetSize > rb.maxSize || rb.count == rb.maxSlots
+	if full && rb.refuses(packetSize) {
+		return dropPolicy
+	}
+
+	// Reserve what the buffer can grow by; the excess is returned once
+	// eviction has settled the actual growth
+	reserved := 0
+	if rb.budget != nil {
+		reserved = min(packetSize, rb.maxSize-rb.currentSize)
+		if reserved > 0 && !rb.budget.reserve(reserved) {
+			rb.budget.dropped.Add(1)
+			return dropBudget
+		}
+	}
+	before := rb.currentSize
+
 	// Remove old packets if necessary to make room
 	for rb.currentSize+packetSize > rb.maxSize && rb.count > 0 {
-		oldPacket := rb.data[rb.tail]
-		rb.currentSize -= len(oldPacket.RawData)
-		rb.tail = (rb.tail + 1) % len(rb.data)
-		rb.count--
+		rb.evict()
 	}
 
-	// Grow the buffer if needed
+	// Give back slots left idle after byte pressure evicted many small
+	// packets, so a burst of them does not pin a large slice
+	for len(rb.data) > rb.minSlots && rb.count < len(rb.data)/4 {
+		rb.resize(len(rb.data) / 2)
+	}
+
+	// Grow the buffer if needed, or evict once the slot cap is reached
 	if rb.count == len(rb.data) {
-		rb.grow()
+		if len(rb.data) < rb.maxSlots {
+			rb.grow()
+		} else {
+			rb.evict()
+		}
 	}
 
 	// Add the new packet
@@ -73,11 +261,48 @@ func (rb *RingBuffer) Add(packet *CapturedPacket) {
 	rb.head = (rb.head + 1) % len(rb.data)
 	rb.count++
 	rb.currentSize += packetSize
+
+	if rb.budget != nil {
+		rb.budget.release(reserved - (rb.currentSize - before))
+	}
+	return dropNone
+}
+
+// releaseBudget returns the buffer's bytes to the global capture budget when
+// its proxy stops
+func (rb *RingBuffer) releaseBudget() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.budget != nil {
+		rb.budget.release(rb.currentSize)
+		rb.budget = nil
+	}
 }
 
-// grow doubles the buffer capacity
+// evictOldest drops the oldest packet
+func (rb *RingBuffer) evictOldest() {
+	oldPacket := rb.data[rb.tail]
+	rb.data[rb.tail] = nil
+	rb.currentSize -= len(oldPacket.RawData)
+	rb.tail = (rb.tail + 1) % len(rb.data)
+	rb.count--
+}
+
+// grow doubles the buffer capacity, up to maxSlots
 func (rb *RingBuffer) grow() {
-	newData := make([]*CapturedPacket, len(rb.data)*2)
+	size := len(rb.data) * 2
+	if size > rb.maxSlots {
+		size = rb.maxSlots
+	}
+	rb.resize(size)
+	rb.grows++
+}
+
+// resize moves the packets into a slot slice of the given length, which must
+// be at least count
+func (rb *RingBuffer) resize(size int) {
+	newData := make([]*CapturedPacket, size)
 
 	// Copy existing data in order
 	if rb.tail < rb.head {
@@ -89,14 +314,19 @@ func (rb *RingBuffer) grow() {
 
 	rb.data = newData
 	rb.tail = 0
-	rb.head = rb.count
+	rb.head = rb.count % size
 }
 
 // GetAll returns all packets in the buffer
 func (rb *RingBuffer) GetAll() []*CapturedPacket {
 	rb.mu.Lock()
 	defer rb.mu.Unlock()
+	return rb.getAllLocked()
+}
 
+// getAllLocked returns all packets in the buffer
+// IMPORTANT: This assumes the mutex is already held by the caller
+func (rb *RingBuffer) getAllLocked() []*CapturedPacket {
 	if rb.count == 0 {
 		return nil
 	}
@@ -117,7 +347,65 @@ func (rb *RingBuffer) GetAll() []*CapturedPacket {
 func (rb *RingBuffer) Clear() {
 	rb.mu.Lock()
 	defer rb.mu.Unlock()
+	rb.clearLocked()
+}
+
+// Drain returns all packets and clears the buffer in one step, so no packet
+// added in between is lost
+func (rb *RingBuffer) Drain() []*CapturedPacket {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	packets := rb.getAllLocked()
+	rb.clearLocked()
+	return packets
+}
+
+// Remove drops the given packets from the buffer in one step, keeping any
+// added since they were read, and returns how many were still stored
+func (rb *RingBuffer) Remove(packets []*CapturedPacket) int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	remove := make(map[*CapturedPacket]bool, len(packets))
+	for _, packet := range packets {
+		remove[packet] = true
+	}
+
+	removed, freed := 0, 0
+	kept := make([]*CapturedPacket, 0, rb.count)
+	for _, packet := range rb.getAllLocked() {
+		if remove[packet] {
+			removed++
+			freed += len(packet.RawData)
+			continue
+		}
+		kept = append(kept, packet)
+	}
+	if removed == 0 {
+		return 0
+	}
+
+	for i := range rb.data {
+		rb.data[i] = nil
+	}
+	copy(rb.data, kept)
+	rb.tail = 0
+	rb.count = len(kept)
+	rb.head = rb.count % len(rb.data)
+	rb.currentSize -= freed
+	if rb.budget != nil {
+		rb.budget.release(freed)
+	}
+	return removed
+}
 
+// clearLocked removes all packets from the buffer
+// IMPORTANT: This assumes the mutex is already held by the caller
+func (rb *RingBuffer) clearLocked() {
+	if rb.budget != nil {
+		rb.budget.release(rb.currentSize)
+	}
 	rb.head = 0
 	rb.tail = 0
 	rb.count = 0
@@ -145,6 +433,32 @@ func (rb *RingBuffer) GetUsagePercent() float64 {
 	return rb.getUsagePercentLocked()
 }
 
+// MemoryUsage is an estimate of the memory a buffer retains, by component
+type MemoryUsage struct {
+	Packets           int `json:"packets"`
+	RawDataBytes      int `json:"raw_data_bytes"`      // Stored payloads, the part capture_limit bounds
+	PacketStructBytes int `json:"packet_struct_bytes"` // Fixed size of each CapturedPacket
+	SlotBytes         int `json:"slot_bytes"`          // The buffer's slot slice
+	TotalBytes        int `json:"total_bytes"`
+}
+
+// MemoryUsage estimates the memory retained by the buffer's packets and
+// slots. Parsed protocol summaries are not counted.
+func (rb *RingBuffer) MemoryUsage() MemoryUsage {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	var usage MemoryUsage
+	for _, packet := range rb.getAllLocked() {
+		usage.Packets++
+		usage.RawDataBytes += cap(packet.RawData)
+	}
+	usage.PacketStructBytes = usage.Packets * int(unsafe.Sizeof(CapturedPacket{}))
+	usage.SlotBytes = cap(rb.data) * int(unsafe.Sizeof((*CapturedPacket)(nil)))
+	usage.TotalBytes = usage.RawDataBytes + usage.PacketStructBytes + usage.SlotBytes
+	return usage
+}
+
 // GetStats returns buffer statistics
 func (rb *RingBuffer) GetStats() (packets int, bytes int, usage float64) {
 	rb.mu.Lock()
@@ -0,0 +1,39 @@
+package main
+
+import "sync"
+
+const (
+	defaultReadBufferSize = 4096    // Bytes read per copy when read_buffer_size is unset
+	maxReadBufferSize     = 1 << 20 // Largest accepted read_buffer_size
+)
+
+// readBufferPool hands out read buffers of one size, shared by all of a
+// proxy's connections so each copy loop doesn't allocate its own
+type readBufferPool struct {
+	pool sync.Pool
+}
+
+// newReadBufferPool creates a pool of size-byte buffers (0 = default size)
+func newReadBufferPool(size int) *readBufferPool {
+	if size <= 0 {
+		size = defaultReadBufferSize
+	}
+	return &readBufferPool{pool: sync.Pool{
+		New: func() interface{} {
+			buf := make([]byte, size)
+			return &buf
+		},
+	}}
+}
+
+// get takes a buffer from the pool. Pointers are pooled so put doesn't
+// allocate.
+func (p *readBufferPool) get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+// put returns a buffer to the pool. The caller must not keep any slice of
+// it; captures and mirrors copy the bytes they hold on to.
+func (p *readBufferPool) put(buf *[]byte) {
+	p.pool.Put(buf)
+}
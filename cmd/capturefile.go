@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// captureRecord is the on-disk representation of a captured packet
+type captureRecord struct {
+	Seq              uint64    `json:"seq,omitempty"` // Absent from files predating seq
+	Timestamp        time.Time `json:"timestamp"`
+	ConnID           uint64    `json:"conn_id"`
+	Direction        string    `json:"direction"`
+	Src              string    `json:"src,omitempty"`
+	Dst              string    `json:"dst,omitempty"`
+	Bytes            int       `json:"bytes"`
+	StreamOffset     int64     `json:"stream_offset,omitempty"`
+	DetectedProtocol string    `json:"detected_protocol"`
+	Hash             string    `json:"hash,omitempty"`
+	Injected         bool      `json:"injected,omitempty"`
+	PossibleRetry    bool      `json:"possible_retry,omitempty"`
+	TraceID          string    `json:"trace_id,omitempty"`
+	Truncated        bool      `json:"truncated,omitempty"`
+	BodyFiltered     bool      `json:"body_filtered,omitempty"`
+	RawData          []byte    `json:"raw_data"` // base64 encoded by encoding/json
+}
+
+// newCaptureRecord returns the on-disk representation of a packet
+func newCaptureRecord(packet *CapturedPacket) captureRecord {
+	return captureRecord{
+		Seq:              packet.Seq,
+		Timestamp:        packet.Timestamp,
+		ConnID:           packet.ConnID,
+		Direction:        packet.Direction,
+		Src:              packet.Src,
+		Dst:              packet.Dst,
+		Bytes:            packet.Bytes,
+		StreamOffset:     packet.StreamOffset,
+		DetectedProtocol: packet.DetectedProtocol,
+		Hash:             packet.Hash,
+		Injected:         packet.Injected,
+		PossibleRetry:    packet.PossibleRetry,
+		TraceID:          packet.TraceID,
+		Truncated:        packet.Truncated,
+		BodyFiltered:     packet.BodyFiltered,
+		RawData:          packet.RawData,
+	}
+}
+
+// exportCaptures writes captures to path as JSON lines in the capture file
+// format, replacing any existing file, and returns the file's size
+func exportCaptures(path string, captures []*CapturedPacket, overwrite bool) (int64, error) {
+	if _, err := os.Lstat(path); err == nil && !overwrite {
+		return 0, fmt.Errorf("%s already exists; set overwrite to replace it", path)
+	}
+
+	// Write next to the target and rename it into place, so a failed export
+	// never leaves a partial file behind
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	tmp := file.Name()
+	fail := func(err error) (int64, error) {
+		file.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	for _, packet := range captures {
+		if err := encoder.Encode(newCaptureRecord(packet)); err != nil {
+			return fail(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := file.Chmod(0644); err != nil {
+		return fail(err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return fail(err)
+	}
+	if err := file.Close(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// CaptureFileInfo describes a single capture file on disk
+type CaptureFileInfo struct {
+	Path    string    `json:"path"`
+	Bytes   int64     `json:"bytes"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// CaptureFileWriter writes captured packets as JSON lines into a rotating
+// set of numbered files, deleting the oldest once maxFiles is exceeded
+type CaptureFileWriter struct {
+	dir         string
+	prefix      string
+	rotateBytes int64
+	maxFiles    int
+	file        *os.File
+	written     int64    // Bytes written to the current file
+	index       int      // Number of the current file
+	files       []string // Paths of retained files, oldest first
+	mu          sync.Mutex
+}
+
+// NewCaptureFileWriter creates a writer storing files named <prefix>-NNNNNN.jsonl in dir
+func NewCaptureFileWriter(dir, prefix string, rotateBytes int64, maxFiles int) (*CaptureFileWriter, error) {
+	if rotateBytes <= 0 {
+		rotateBytes = 10 * 1024 * 1024 // Default 10MB
+	}
+	if maxFiles <= 0 {
+		maxFiles = 10
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create capture directory %s: %v", dir, err)
+	}
+
+	// Number on from files an earlier run left behind rather than
+	// overwriting them
+	w := &CaptureFileWriter{
+		dir:         dir,
+		prefix:      prefix,
+		rotateBytes: rotateBytes,
+		maxFiles:    maxFiles,
+		index:       lastCaptureFileIndex(dir, prefix),
+	}
+
+	if err := w.rotateLocked(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends a packet to the current file, rotating first if it is full
+func (w *CaptureFileWriter) Write(packet *CapturedPacket) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return fmt.Errorf("capture file writer is closed")
+	}
+
+	line, err := json.Marshal(newCaptureRecord(packet))
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	// Roll to a new file if this line would overflow a non-empty file
+	if w.written > 0 && w.written+int64(len(line)) > w.rotateBytes {
+		if err := w.rotateLocked(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.file.Write(line)
+	w.written += int64(n)
+	return err
+}
+
+// rotateLocked closes the current file, opens the next one and prunes old files
+// IMPORTANT: This assumes the mutex is already held by the caller
+func (w *CaptureFileWriter) rotateLocked() error {
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+
+	w.index++
+	path := filepath.Join(w.dir, fmt.Sprintf("%s-%06d.jsonl", w.prefix, w.index))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create capture file %s: %v", path, err)
+	}
+
+	w.file = file
+	w.written = 0
+	w.files = append(w.files, path)
+
+	// Delete the oldest files beyond the retention limit
+	for len(w.files) > w.maxFiles {
+		os.Remove(w.files[0])
+		w.files = w.files[1:]
+	}
+	return nil
+}
+
+// lastCaptureFileIndex returns the highest number among the capture files
+// named <prefix>-NNNNNN.jsonl in dir, or 0 if there are none
+func lastCaptureFileIndex(dir, prefix string) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	last := 0
+	for _, entry := range entries {
+		number, ok := strings.CutPrefix(entry.Name(), prefix+"-")
+		if !ok {
+			continue
+		}
+		number, ok = strings.CutSuffix(number, ".jsonl")
+		if n, err := strconv.Atoi(number); ok && err == nil && n > last {
+			last = n
+		}
+	}
+	return last
+}
+
+// Files returns the retained capture files, oldest first
+func (w *CaptureFileWriter) Files() []CaptureFileInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	result := make([]CaptureFileInfo, 0, len(w.files))
+	for _, path := range w.files {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		result = append(result, CaptureFileInfo{
+			Path:    path,
+			Bytes:   info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+	return result
+}
+
+// Close closes the current capture file
+func (w *CaptureFileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCaptureFileRotation tests that files roll over at rotate_bytes and
+// that only the newest max_files are retained
+func TestCaptureFileRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	writer, err := NewCaptureFileWriter(dir, "proxy-1234", 300, 2)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	defer writer.Close()
+
+	for i := 0; i < 10; i++ {
+		packet := &CapturedPacket{
+			Timestamp:        time.Now(),
+			Direction:        "Client->Server",
+			Bytes:            100,
+			DetectedProtocol: "Unknown",
+			RawData:          make([]byte, 100),
+		}
+		if err := writer.Write(packet); err != nil {
+			t.Fatalf("Write %d failed: %v", i, err)
+		}
+	}
+
+	files := writer.Files()
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 retained files, got %d", len(files))
+	}
+
+	// The first file must have been pruned
+	if _, err := os.Stat(filepath.Join(dir, "proxy-1234-000001.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("Expected oldest capture file to be deleted")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 files on disk, got %d", len(entries))
+	}
+
+	// Every retained file must stay within the rotation size and hold valid records
+	for _, info := range files {
+		if info.Bytes > 300 {
+			t.Errorf("File %s is %d bytes, exceeds rotate size", info.Path, info.Bytes)
+		}
+
+		f, err := os.Open(info.Path)
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", info.Path, err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var record captureRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Errorf("Invalid record in %s: %v", info.Path, err)
+			}
+			if len(record.RawData) != 100 {
+				t.Errorf("Expected 100 raw bytes, got %d", len(record.RawData))
+			}
+		}
+		f.Close()
+	}
+
+	// A writer restarted on the same directory numbers on from the last file
+	// instead of overwriting it
+	last := files[len(files)-1].Path
+	before, _ := os.ReadFile(last)
+	restarted, err := NewCaptureFileWriter(dir, "proxy-1234", 300, 2)
+	if err != nil {
+		t.Fatalf("Failed to restart writer: %v", err)
+	}
+	defer restarted.Close()
+	if after, _ := os.ReadFile(last); string(after) != string(before) {
+		t.Errorf("Expected %s left intact by the restarted writer", last)
+	}
+	if next := restarted.Files()[0].Path; next <= last {
+		t.Errorf("Expected a file after %s, got %s", last, next)
+	}
+}
+
+// TestCompareToBaseline tests that captures matching a saved capture file
+// report no diff and diverging captures report where they differ
+func TestCompareToBaseline(t *testing.T) {
+	dir := t.TempDir()
+	manager := NewProxyManager()
+	defer manager.StopAll()
+
+	if err := manager.StartProxyWithOptions(19161, "127.0.0.1", 18082, 1024*1024, ProxyOptions{CaptureDir: dir}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	if err := manager.StartProxy(19162, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	good, _ := manager.GetProxy(19161)
+	bad, _ := manager.GetProxy(19162)
+
+	record := func(p *ProxyInstance, uri, status string) {
+		conn := p.newConnection(nil, nil)
+		p.recordCapture(conn, []byte("GET "+uri+" HTTP/1.1\r\nHost: example.com\r\n\r\n"), DirectionClientToServer, false)
+		p.recordCapture(conn, []byte("HTTP/1.1 "+status+"\r\nContent-Length: 2\r\n\r\nok"), DirectionServerToClient, false)
+	}
+	record(good, "/a", "200 OK")
+	record(bad, "/b", "404 Not Found")
+
+	files := good.Files.Files()
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 capture file, got %d", len(files))
+	}
+	baseline := files[0].Path
+
+	handler := NewCompareToBaselineHandler(manager)
+
+	// The proxy that wrote the baseline matches it in both modes
+	for _, mode := range []string{"stream", "http"} {
+		result := callTool(t, handler.Execute, map[string]interface{}{
+			"listen_port": float64(19161),
+			"baseline":    baseline,
+			"mode":        mode,
+		})
+		if result["match"] != true {
+			t.Errorf("Expected %s match against own baseline, got %v", mode, result)
+		}
+	}
+
+	// A stream diff reports the first differing byte of the request
+	result := callTool(t, handler.Execute, map[string]interface{}{
+		"listen_port": float64(19162),
+		"baseline":    baseline,
+	})
+	if result["match"] != false {
+		t.Fatalf("Expected stream mismatch, got %v", result)
+	}
+	mismatches := result["mismatches"].([]interface{})
+	first := mismatches[0].(map[string]interface{})
+	if first["direction"] != DirectionClientToServer || first["offset"] != float64(5) {
+		t.Errorf("Expected client diff at offset 5, got %v", first)
+	}
+
+	// An HTTP diff reports the differing fields
+	result = callTool(t, handler.Execute, map[string]interface{}{
+		"listen_port": float64(19162),
+		"baseline":    baseline,
+		"mode":        "http",
+	})
+	fields := map[string]bool{}
+	for _, m := range result["mismatches"].([]interface{}) {
+		fields[m.(map[string]interface{})["field"].(string)] = true
+	}
+	if !fields["uri"] || !fields["status_code"] || len(fields) != 2 {
+		t.Errorf("Expected uri and status_code mismatches, got %v", result["mismatches"])
+	}
+}
+
+// TestExportCaptures tests that export_captures writes every capture to a
+// JSON Lines file that reads back unchanged, leaving the buffer intact
+func TestExportCaptures(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+
+	if err := manager.StartProxy(19201, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	proxy, _ := manager.GetProxy(19201)
+
+	conn := proxy.newConnection(nil, nil)
+	proxy.recordCapture(conn, []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"), DirectionClientToServer, false)
+	proxy.recordCapture(conn, []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"), DirectionServerToClient, false)
+	binary := proxy.newConnection(nil, nil)
+	proxy.recordCapture(binary, []byte{0x00, 0xff, 0x10, 0x80, 0x7f}, DirectionClientToServer, false)
+
+	path := filepath.Join(t.TempDir(), "export.jsonl")
+	result := callTool(t, NewExportCapturesHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19201),
+		"path":        path,
+	})
+	if result["status"] != "exported" || result["captures"] != float64(3) {
+		t.Fatalf("Expected 3 exported captures, got %v", result)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Export file missing: %v", err)
+	}
+	if result["file_bytes"] != float64(info.Size()) {
+		t.Errorf("Expected file_bytes %d, got %v", info.Size(), result["file_bytes"])
+	}
+
+	original := proxy.Buffer.GetAll()
+	if len(original) != 3 {
+		t.Fatalf("Expected buffer to keep 3 captures, got %d", len(original))
+	}
+
+	exported, err := loadCaptureFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read export: %v", err)
+	}
+	if len(exported) != len(original) {
+		t.Fatalf("Expected %d records, got %d", len(original), len(exported))
+	}
+	for i, want := range original {
+		got := exported[i]
+		if got.Seq != want.Seq || got.ConnID != want.ConnID || got.Direction != want.Direction ||
+			got.DetectedProtocol != want.DetectedProtocol || !bytes.Equal(got.RawData, want.RawData) ||
+			!got.Timestamp.Equal(want.Timestamp) || got.Src != want.Src || got.Dst != want.Dst ||
+			got.Hash != want.Hash || got.StreamOffset != want.StreamOffset {
+			t.Errorf("Record %d differs: got %+v, want %+v", i, got, want)
+		}
+	}
+
+	// An existing file is only replaced with overwrite
+	again := callTool(t, NewExportCapturesHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19201),
+		"path":        path,
+	})
+	if err, _ := again["error"].(string); !strings.Contains(err, "already exists") {
+		t.Errorf("Expected an existing file to be refused, got %v", again)
+	}
+	again = callTool(t, NewExportCapturesHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19201),
+		"path":        path,
+		"overwrite":   true,
+	})
+	if again["status"] != "exported" {
+		t.Errorf("Expected overwrite to replace the file, got %v", again)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".*.tmp-*")); len(leftovers) != 0 {
+		t.Errorf("Expected no temporary files left, got %v", leftovers)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// CBOR major types (RFC 8949)
+const (
+	cborUnsigned = 0 << 5
+	cborNegative = 1 << 5
+	cborText     = 3 << 5
+	cborArray    = 4 << 5
+	cborMap      = 5 << 5
+	cborSimple   = 7 << 5
+)
+
+// encodeCBOR encodes v as CBOR using the same field names and values as its
+// JSON form. Integers become CBOR integers, other numbers float64, and map
+// keys are sorted so the output is deterministic.
+func encodeCBOR(v interface{}) ([]byte, error) {
+	// Going through JSON applies the existing json tags and time formats
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeCBORValue(&buf, generic); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeCBORValue encodes a value produced by decoding JSON with UseNumber
+func writeCBORValue(buf *bytes.Buffer, v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		buf.WriteByte(cborSimple | 22)
+	case bool:
+		if val {
+			buf.WriteByte(cborSimple | 21)
+		} else {
+			buf.WriteByte(cborSimple | 20)
+		}
+	case json.Number:
+		if n, err := val.Int64(); err == nil {
+			if n >= 0 {
+				writeCBORHead(buf, cborUnsigned, uint64(n))
+			} else {
+				writeCBORHead(buf, cborNegative, uint64(-(n + 1)))
+			}
+			return nil
+		}
+		f, err := val.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(cborSimple | 27)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+	case string:
+		writeCBORHead(buf, cborText, uint64(len(val)))
+		buf.WriteString(val)
+	case []interface{}:
+		writeCBORHead(buf, cborArray, uint64(len(val)))
+		for _, item := range val {
+			if err := writeCBORValue(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		writeCBORHead(buf, cborMap, uint64(len(val)))
+		for _, key := range keys {
+			writeCBORHead(buf, cborText, uint64(len(key)))
+			buf.WriteString(key)
+			if err := writeCBORValue(buf, val[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cannot encode %T as CBOR", v)
+	}
+	return nil
+}
+
+// writeCBORHead writes the initial byte and argument of a data item
+func writeCBORHead(buf *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(major | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(major | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
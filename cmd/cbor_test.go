@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// decodeCBOR decodes the subset of CBOR produced by encodeCBOR
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("unexpected end of data")
+	}
+	major, info := data[0]&0xe0, data[0]&0x1f
+	data = data[1:]
+
+	if major == cborSimple {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22:
+			return nil, data, nil
+		case 27:
+			return math.Float64frombits(binary.BigEndian.Uint64(data)), data[8:], nil
+		}
+		return nil, nil, fmt.Errorf("unsupported simple value %d", info)
+	}
+
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info == 24:
+		n, data = uint64(data[0]), data[1:]
+	case info == 25:
+		n, data = uint64(binary.BigEndian.Uint16(data)), data[2:]
+	case info == 26:
+		n, data = uint64(binary.BigEndian.Uint32(data)), data[4:]
+	case info == 27:
+		n, data = binary.BigEndian.Uint64(data), data[8:]
+	}
+
+	switch major {
+	case cborUnsigned:
+		return n, data, nil
+	case cborNegative:
+		return -1 - int64(n), data, nil
+	case cborText:
+		return string(data[:n]), data[n:], nil
+	case cborArray:
+		items := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			var item interface{}
+			var err error
+			if item, data, err = decodeCBOR(data); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case cborMap:
+		m := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			key, rest, err := decodeCBOR(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			var value interface{}
+			if value, data, err = decodeCBOR(rest); err != nil {
+				return nil, nil, err
+			}
+			m[key.(string)] = value
+		}
+		return m, data, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported major type %d", major>>5)
+}
+
+// TestCBORRoundTrip tests that decoding the CBOR output yields the JSON structure
+func TestCBORRoundTrip(t *testing.T) {
+	duration := 12.5
+	original := map[string]interface{}{
+		"proxies": []map[string]interface{}{
+			{
+				"listen_port":    9090,
+				"buffer_usage":   "0.1%",
+				"total_captures": 2,
+				"captures": renderCaptures([]*CapturedPacket{
+					{Seq: 1, Timestamp: time.Now(), ConnID: 1, Direction: DirectionClientToServer, Bytes: 300, RawData: []byte("GET /"), DetectedProtocol: "HTTP/1.x", Hash: "abc"},
+					{Seq: 2, Timestamp: time.Now(), ConnID: 1, Direction: DirectionServerToClient, Bytes: 70000, Injected: true},
+				}, false, defaultHexDump),
+				"transactions": []HTTPTransaction{{ConnID: 1, Request: &HTTPRequestSummary{Method: "GET", URI: "/"}, DurationMs: &duration}},
+				"delta":        -5,
+				"nothing":      nil,
+			},
+		},
+	}
+
+	encoded, err := encodeCBOR(original)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decoded, rest, err := decodeCBOR(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if len(rest) != 0 {
+		t.Errorf("Expected no trailing bytes, got %d", len(rest))
+	}
+
+	// Compare through generic JSON so struct field order does not matter
+	jsonBytes, _ := json.Marshal(original)
+	var generic interface{}
+	json.Unmarshal(jsonBytes, &generic)
+	expected, _ := json.Marshal(generic)
+	actual, _ := json.Marshal(decoded)
+	if string(expected) != string(actual) {
+		t.Errorf("Round trip mismatch:\nexpected %s\nactual   %s", expected, actual)
+	}
+
+	if len(encoded) >= len(jsonBytes) {
+		t.Errorf("Expected CBOR (%d bytes) to be smaller than JSON (%d bytes)", len(encoded), len(jsonBytes))
+	}
+}
+
+// TestGetProxyOutputCBOR tests the cbor-base64 format of get_proxy_output
+func TestGetProxyOutputCBOR(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+
+	if err := manager.StartProxy(19109, "localhost", 18082, 100); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	proxy, _ := manager.GetProxy(19109)
+	proxy.captureData(proxy.newConnection(nil, nil), []byte("ping"), DirectionClientToServer)
+
+	handler := NewGetProxyOutputHandler(manager)
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{
+		"listen_port": float64(19109),
+		"format":      "cbor-base64",
+	}
+	result, err := handler.Execute(context.Background(), request)
+	if err != nil {
+		t.Fatalf("get_proxy_output failed: %v", err)
+	}
+
+	encoded, err := base64.StdEncoding.DecodeString(result.Content[0].(mcp.TextContent).Text)
+	if err != nil {
+		t.Fatalf("Output is not base64: %v", err)
+	}
+	decoded, _, err := decodeCBOR(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	proxies := decoded.(map[string]interface{})["proxies"].([]interface{})
+	if len(proxies) != 1 {
+		t.Fatalf("Expected 1 proxy, got %d", len(proxies))
+	}
+	if port := proxies[0].(map[string]interface{})["listen_port"]; port != uint64(19109) {
+		t.Errorf("Expected listen_port 19109, got %v", port)
+	}
+
+	// The capture is in the encoded output and cleared after encoding
+	if captures := proxies[0].(map[string]interface{})["captures"].([]interface{}); len(captures) != 1 {
+		t.Errorf("Expected 1 encoded capture, got %d", len(captures))
+	}
+	if left := len(proxy.Buffer.GetAll()); left != 0 {
+		t.Errorf("Expected the returned capture cleared, %d left", left)
+	}
+}
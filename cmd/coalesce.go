@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"sync"
+	"time"
+)
+
+// maxCoalescedBytes bounds a merged capture when max_stored_bytes_per_packet
+// is not set, so a steady stream still ends up in the buffer piece by piece
+const maxCoalescedBytes = 64 * 1024
+
+// captureCoalescer holds a connection's latest capture back for the coalesce
+// window so that further reads in the same direction can be merged into it.
+// A read in the other direction, an idle window or the connection closing
+// stores it.
+type captureCoalescer struct {
+	pending *CapturedPacket
+	hash    hash.Hash // SHA-256 of the original payloads merged so far
+	last    time.Time // Arrival of the latest merged read
+	timer   *time.Timer
+	mu      sync.Mutex
+}
+
+// coalesceCapture merges capture into the connection's pending capture when
+// it continues the same direction within the window, and otherwise stores
+// the pending capture and holds this one back instead. A pending capture is
+// also stored once merging would take it past the per-capture size limit.
+// data is the original payload, used for the merged hash.
+func (p *ProxyInstance) coalesceCapture(conn *Connection, capture *CapturedPacket, data []byte) {
+	c := &conn.coalescer
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	window := p.Options.CoalesceWindow
+
+	// Injected bytes stay separate so they remain identifiable
+	if capture.Injected {
+		p.flushCoalescedLocked(c)
+		p.addCapture(capture)
+		return
+	}
+
+	limit := p.Options.MaxStoredBytesPerPacket
+	if limit <= 0 {
+		limit = maxCoalescedBytes
+	}
+
+	if pending := c.pending; pending != nil && pending.Direction == capture.Direction && capture.Timestamp.Sub(c.last) <= window &&
+		len(pending.RawData)+len(capture.RawData) <= limit {
+		pending.Bytes += capture.Bytes
+		pending.RawData = append(pending.RawData, capture.RawData...)
+		pending.Truncated = pending.Truncated || capture.Truncated
+		pending.BodyFiltered = pending.BodyFiltered || capture.BodyFiltered
+		pending.HTTPContentLengths = append(pending.HTTPContentLengths, capture.HTTPContentLengths...)
+		pending.PossibleRetry = pending.PossibleRetry || capture.PossibleRetry
+		pending.HTTP2Frames = append(pending.HTTP2Frames, capture.HTTP2Frames...)
+		pending.TLSRecords = append(pending.TLSRecords, capture.TLSRecords...)
+		if pending.DetectedProtocol == "Unknown" {
+			pending.DetectedProtocol = capture.DetectedProtocol
+		}
+		c.hash.Write(data)
+		c.last = capture.Timestamp
+		c.timer.Reset(window)
+		return
+	}
+
+	p.flushCoalescedLocked(c)
+	c.pending = capture
+	c.hash = sha256.New()
+	c.hash.Write(data)
+	c.last = capture.Timestamp
+	if c.timer == nil {
+		c.timer = time.AfterFunc(window, func() { p.flushIdleCapture(conn) })
+	} else {
+		c.timer.Reset(window)
+	}
+}
+
+// flushIdleCapture stores the pending capture once no read has extended it
+// for a full window
+func (p *ProxyInstance) flushIdleCapture(conn *Connection) {
+	c := &conn.coalescer
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pending != nil && time.Since(c.last) >= p.Options.CoalesceWindow {
+		p.flushCoalescedLocked(c)
+	}
+}
+
+// flushCoalesced stores the pending capture of a connection that is closing
+func (p *ProxyInstance) flushCoalesced(conn *Connection) {
+	c := &conn.coalescer
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	p.flushCoalescedLocked(c)
+}
+
+// flushCoalescedLocked stores the pending capture, if any, and wakes
+// wait_for, as an idle flush happens outside any read
+// IMPORTANT: This assumes c.mu is already held by the caller
+func (p *ProxyInstance) flushCoalescedLocked(c *captureCoalescer) {
+	if c.pending == nil {
+		return
+	}
+	c.pending.Hash = hex.EncodeToString(c.hash.Sum(nil))
+	p.addCapture(c.pending)
+	c.pending = nil
+	c.hash = nil
+	p.notifyActivity()
+}
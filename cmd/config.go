@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ProxyConfig is the complete configuration of a single proxy
+type ProxyConfig struct {
+	ListenPort   int
+	ForwardHost  string
+	ForwardPort  int
+	CaptureLimit int
+	Options      ProxyOptions
+}
+
+// configFile is the format of the --config file. Each proxy entry takes the
+// same fields as the start_proxy tool.
+type configFile struct {
+	Proxies []map[string]interface{} `json:"proxies"`
+}
+
+// LoadConfig reads a config file and parses its proxy definitions. Entries
+// that fail to parse are logged and skipped.
+func LoadConfig(path string) ([]ProxyConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config %s: %v", path, err)
+	}
+
+	var file configFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+
+	configs := make([]ProxyConfig, 0, len(file.Proxies))
+	for i, entry := range file.Proxies {
+		cfg, err := parseProxyConfig(entry)
+		if err != nil {
+			warnf("Skipping proxy #%d in %s: %v", i+1, path, err)
+			continue
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, nil
+}
+
+// ConfigDiff reports what applying the config file changed, by listen port
+type ConfigDiff struct {
+	Started   []int    `json:"started"`
+	Stopped   []int    `json:"stopped"`
+	Updated   []int    `json:"updated"`   // Label or tags changed in place
+	Restarted []int    `json:"restarted"` // Restarted because settings fixed at start changed
+	Unchanged []int    `json:"unchanged"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// ConfigManager keeps the proxies declared in the config file in sync with
+// it. Only proxies it started are stopped when removed from the file;
+// proxies started through tools are left alone.
+type ConfigManager struct {
+	path    string
+	manager *ProxyManager
+	managed map[int]bool // Ports of proxies started from the config
+	mu      sync.Mutex
+}
+
+// NewConfigManager creates a config manager for the file at path
+func NewConfigManager(manager *ProxyManager, path string) *ConfigManager {
+	return &ConfigManager{
+		path:    path,
+		manager: manager,
+		managed: make(map[int]bool),
+	}
+}
+
+// Path returns the config file location
+func (c *ConfigManager) Path() string {
+	return c.path
+}
+
+// Apply re-reads the config file and converges the running proxies to it.
+// Failures of individual proxies are collected in the diff, not returned.
+func (c *ConfigManager) Apply() (ConfigDiff, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	diff := ConfigDiff{
+		Started:   []int{},
+		Stopped:   []int{},
+		Updated:   []int{},
+		Restarted: []int{},
+		Unchanged: []int{},
+	}
+
+	configs, err := LoadConfig(c.path)
+	if err != nil {
+		return diff, err
+	}
+
+	declared := make(map[int]bool, len(configs))
+	for _, cfg := range configs {
+		declared[cfg.ListenPort] = true
+
+		existing, running := c.manager.GetProxy(cfg.ListenPort)
+		switch {
+		case running && existing.matchesConfig(cfg.ForwardHost, cfg.ForwardPort, cfg.CaptureLimit, cfg.Options):
+			// A matching proxy started through tools stays unmanaged
+			diff.Unchanged = append(diff.Unchanged, cfg.ListenPort)
+
+		case running && !c.managed[cfg.ListenPort]:
+			diff.Errors = append(diff.Errors, fmt.Sprintf("port %d: already used by a proxy not started from the config", cfg.ListenPort))
+
+		case running && existing.ForwardHost == cfg.ForwardHost && existing.ForwardPort == cfg.ForwardPort &&
+			existing.CaptureLimit == cfg.CaptureLimit && existing.Options.sameSettings(cfg.Options):
+			// Only the label or tags changed, which apply to the running
+			// proxy without losing its captures or connections
+			existing.SetLabel(cfg.Options.Label)
+			existing.SetTags(cfg.Options.Tags)
+			diff.Updated = append(diff.Updated, cfg.ListenPort)
+
+		case running:
+			// Other settings are fixed at start, so the proxy is restarted
+			if _, err := c.manager.EnsureProxy(cfg.ListenPort, cfg.ForwardHost, cfg.ForwardPort, cfg.CaptureLimit, cfg.Options, IfExistsRestart); err != nil {
+				delete(c.managed, cfg.ListenPort)
+				diff.Errors = append(diff.Errors, fmt.Sprintf("port %d: %v", cfg.ListenPort, err))
+				continue
+			}
+			diff.Restarted = append(diff.Restarted, cfg.ListenPort)
+
+		default:
+			if err := c.manager.StartProxyWithOptions(cfg.ListenPort, cfg.ForwardHost, cfg.ForwardPort, cfg.CaptureLimit, cfg.Options); err != nil {
+				diff.Errors = append(diff.Errors, fmt.Sprintf("port %d: %v", cfg.ListenPort, err))
+				continue
+			}
+			c.managed[cfg.ListenPort] = true
+			diff.Started = append(diff.Started, cfg.ListenPort)
+		}
+	}
+
+	// Stop proxies that were removed from the config
+	for port := range c.managed {
+		if declared[port] {
+			continue
+		}
+		delete(c.managed, port)
+		if _, err := c.manager.StopProxy(port); err == nil {
+			diff.Stopped = append(diff.Stopped, port)
+		}
+	}
+	sort.Ints(diff.Stopped)
+
+	for _, msg := range diff.Errors {
+		warnf("Config %s: %s", c.path, msg)
+	}
+	return diff, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestConfigStartsProxies tests that every valid proxy in a config file
+// starts and invalid entries are skipped
+func TestConfigStartsProxies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxies.json")
+	config := `{
+  "proxies": [
+    {"listen_port": 19121, "forward_port": 18082, "label": "boot"},
+    {"listen_port": 19122, "forward_host": "127.0.0.1", "forward_port": 18083, "capture_limit": 4096, "tags": ["boot"]},
+    {"forward_port": 18084}
+  ]
+}`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	manager := NewProxyManager()
+	defer manager.StopAll()
+
+	diff, err := NewConfigManager(manager, path).Apply()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(diff.Started) != 2 {
+		t.Errorf("Expected 2 proxies to start, got %v", diff.Started)
+	}
+
+	proxy, exists := manager.GetProxy(19121)
+	if !exists || proxy.ForwardHost != "localhost" || proxy.Label() != "boot" {
+		t.Errorf("Proxy 19121 not started as configured")
+	}
+	proxy, exists = manager.GetProxy(19122)
+	if !exists || proxy.ForwardPort != 18083 || proxy.CaptureLimit != 4096 || !proxy.HasLabel("boot") {
+		t.Errorf("Proxy 19122 not started as configured")
+	}
+}
+
+// TestReloadConfig tests that reloading converges the manager to an edited config
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxies.json")
+	writeConfig := func(config string) {
+		if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	manager := NewProxyManager()
+	defer manager.StopAll()
+
+	// A proxy started through the tools must survive reloads
+	if err := manager.StartProxy(19126, "localhost", 18082, 1024); err != nil {
+		t.Fatalf("Failed to start unmanaged proxy: %v", err)
+	}
+
+	// One declared exactly like it must not be adopted by the config either
+	if err := manager.StartProxy(19218, "localhost", 18082, 1024); err != nil {
+		t.Fatalf("Failed to start unmanaged proxy: %v", err)
+	}
+
+	writeConfig(`{"proxies": [
+		{"listen_port": 19123, "forward_port": 18082},
+		{"listen_port": 19124, "forward_port": 18082},
+		{"listen_port": 19125, "forward_port": 18082},
+		{"listen_port": 19128, "forward_port": 18082, "tags": []},
+		{"listen_port": 19218, "forward_host": "localhost", "forward_port": 18082, "capture_limit": 1024}
+	]}`)
+	config := NewConfigManager(manager, path)
+	handler := NewReloadConfigHandler(config)
+
+	response := callTool(t, handler.Execute, map[string]interface{}{})
+	if started := response["started"].([]interface{}); len(started) != 4 {
+		t.Fatalf("Expected 4 proxies started, got %v", response)
+	}
+	original, _ := manager.GetProxy(19123)
+	relabeled, _ := manager.GetProxy(19128)
+
+	// Reloading the same file changes nothing
+	response = callTool(t, handler.Execute, map[string]interface{}{})
+	if unchanged := response["unchanged"].([]interface{}); len(unchanged) != 5 {
+		t.Errorf("Expected all 5 proxies unchanged, got %v", response)
+	}
+
+	// Drop 19124 and 19218, retarget 19125, relabel 19128, add 19127
+	writeConfig(`{"proxies": [
+		{"listen_port": 19123, "forward_port": 18082},
+		{"listen_port": 19125, "forward_port": 18083},
+		{"listen_port": 19127, "forward_port": 18082},
+		{"listen_port": 19128, "forward_port": 18082, "label": "moved", "tags": ["x"]}
+	]}`)
+	response = callTool(t, handler.Execute, map[string]interface{}{})
+
+	expected := map[string][]float64{
+		"started":   {19127},
+		"stopped":   {19124},
+		"updated":   {19128},
+		"restarted": {19125},
+		"unchanged": {19123},
+	}
+	for key, ports := range expected {
+		got := response[key].([]interface{})
+		if len(got) != len(ports) || got[0] != ports[0] {
+			t.Errorf("Expected %s %v, got %v", key, ports, got)
+		}
+	}
+
+	if current, _ := manager.GetProxy(19123); current != original {
+		t.Error("Unchanged proxy was restarted")
+	}
+	if current, _ := manager.GetProxy(19128); current != relabeled || current.Label() != "moved" || current.Tags()[0] != "x" {
+		t.Error("Relabeled proxy was not updated in place")
+	}
+	if _, exists := manager.GetProxy(19218); !exists {
+		t.Error("Proxy started outside the config was stopped after matching it")
+	}
+	if _, exists := manager.GetProxy(19124); exists {
+		t.Error("Removed proxy is still running")
+	}
+	if proxy, exists := manager.GetProxy(19125); !exists || proxy.ForwardPort != 18083 {
+		t.Error("Changed proxy was not updated")
+	}
+	if _, exists := manager.GetProxy(19126); !exists {
+		t.Error("Proxy started outside the config was stopped")
+	}
+	if len(manager.GetAllProxies()) != 6 {
+		t.Errorf("Expected 6 running proxies, got %d", len(manager.GetAllProxies()))
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxConnectionTextBytes bounds the reassembled text returned per direction
+const maxConnectionTextBytes = 64 * 1024
+
+// ConnectionPacket is the timing of one capture within a connection
+type ConnectionPacket struct {
+	Seq       uint64  `json:"seq"`
+	Direction string  `json:"direction"`
+	Bytes     int     `json:"bytes"`
+	Timestamp string  `json:"timestamp"`
+	OffsetMs  float64 `json:"offset_ms"` // Since the connection opened, or the first capture if unknown
+	GapMs     float64 `json:"gap_ms"`    // Since the previous capture of the connection
+}
+
+// ConnectionDetail is everything known about one connection
+type ConnectionDetail struct {
+	ListenPort          int                `json:"listen_port"`
+	ConnID              uint64             `json:"conn_id"`
+	State               string             `json:"state"` // open, closed or unknown once the metadata is evicted
+	ClientAddr          string             `json:"client_addr,omitempty"`
+	LocalAddr           string             `json:"local_addr,omitempty"`
+	BackendAddr         string             `json:"backend_addr,omitempty"`
+	BackendLocalAddr    string             `json:"backend_local_addr,omitempty"`
+	OpenedAt            string             `json:"opened_at,omitempty"`
+	ClosedAt            string             `json:"closed_at,omitempty"`
+	CloseReason         string             `json:"close_reason,omitempty"`
+	DurationMs          float64            `json:"duration_ms,omitempty"`
+	ClientToServerBytes int64              `json:"client_to_server_bytes"`
+	ServerToClientBytes int64              `json:"server_to_client_bytes"`
+	CapturedPackets     int                `json:"captured_packets"`
+	Packets             []ConnectionPacket `json:"packets"`
+	ClientToServerText  string             `json:"client_to_server_text"`
+	ServerToClientText  string             `json:"server_to_client_text"`
+	TextTruncated       bool               `json:"text_truncated,omitempty"`
+}
+
+// connectionDetail assembles the metadata and stored captures of a connection
+func connectionDetail(proxy *ProxyInstance, connID uint64) (*ConnectionDetail, error) {
+	captures := connCaptures(proxy.Buffer.GetAll(), connID)
+
+	info, known := proxy.LookupConnection(connID)
+	if !known && len(captures) == 0 {
+		return nil, fmt.Errorf("no connection %d on port %d", connID, proxy.ListenPort)
+	}
+
+	detail := &ConnectionDetail{
+		ListenPort:      proxy.ListenPort,
+		ConnID:          connID,
+		State:           "unknown",
+		CapturedPackets: len(captures),
+		Packets:         make([]ConnectionPacket, 0, len(captures)),
+	}
+
+	var start time.Time
+	if known {
+		detail.ClientAddr = info.ClientAddr
+		detail.LocalAddr = info.LocalAddr
+		detail.BackendAddr = info.BackendAddr
+		detail.BackendLocalAddr = info.BackendLocalAddr
+		detail.OpenedAt = formatTimestamp(info.OpenedAt)
+		detail.ClientToServerBytes = info.ClientToServerBytes
+		detail.ServerToClientBytes = info.ServerToClientBytes
+		start = info.OpenedAt
+
+		end := time.Now()
+		detail.State = "open"
+		if !info.ClosedAt.IsZero() {
+			detail.State = "closed"
+			detail.ClosedAt = formatTimestamp(info.ClosedAt)
+			detail.CloseReason = info.CloseReason
+			end = info.ClosedAt
+		}
+		detail.DurationMs = durationMs(end.Sub(info.OpenedAt))
+	} else {
+		// Totals of what is still buffered is the best left to report
+		start = captures[0].Timestamp
+		for _, capture := range captures {
+			if capture.Injected {
+				continue
+			}
+			if capture.Direction == DirectionClientToServer {
+				detail.ClientToServerBytes += int64(capture.Bytes)
+			} else {
+				detail.ServerToClientBytes += int64(capture.Bytes)
+			}
+		}
+	}
+
+	previous := start
+	for _, capture := range captures {
+		detail.Packets = append(detail.Packets, ConnectionPacket{
+			Seq:       capture.Seq,
+			Direction: capture.Direction,
+			Bytes:     capture.Bytes,
+			Timestamp: formatTimestamp(capture.Timestamp),
+			OffsetMs:  durationMs(capture.Timestamp.Sub(start)),
+			GapMs:     durationMs(capture.Timestamp.Sub(previous)),
+		})
+		previous = capture.Timestamp
+	}
+
+	for _, streams := range reassembleStreams(captures) {
+		var truncated bool
+		detail.ClientToServerText, truncated = printableText(streams.clientToServer.data)
+		detail.TextTruncated = truncated
+		detail.ServerToClientText, truncated = printableText(streams.serverToClient.data)
+		detail.TextTruncated = detail.TextTruncated || truncated
+	}
+	return detail, nil
+}
+
+// printableText renders a stream as text, replacing bytes other than
+// printable ASCII and whitespace with '.', and reports whether it was cut
+func printableText(data []byte) (string, bool) {
+	truncated := len(data) > maxConnectionTextBytes
+	if truncated {
+		data = data[:maxConnectionTextBytes]
+	}
+	text := make([]byte, len(data))
+	for i, b := range data {
+		if (b >= 32 && b <= 126) || b == '\t' || b == '\n' || b == '\r' {
+			text[i] = b
+		} else {
+			text[i] = '.'
+		}
+	}
+	return string(text), truncated
+}
+
+// durationMs converts a duration to fractional milliseconds
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestGetConnection tests the detail view of a completed connection
+func TestGetConnection(t *testing.T) {
+	echoPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19140, "127.0.0.1", echoPort, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19140)
+	proxy, _ := manager.GetProxy(19140)
+
+	client, err := net.Dial("tcp", "127.0.0.1:19140")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	clientAddr := client.LocalAddr().String()
+	messages := []string{"PING one\r\n", "PING\x00two\r\n"}
+	for _, msg := range messages {
+		client.Write([]byte(msg))
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := io.ReadFull(client, make([]byte, len(msg))); err != nil {
+			t.Fatalf("Failed to read echo: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	client.Close()
+	for deadline := time.Now().Add(2 * time.Second); proxy.GetConnectionCount() > 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	result := callTool(t, NewGetConnectionHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19140),
+		"conn_id":     float64(1),
+	})
+
+	total := float64(len(messages[0]) + len(messages[1]))
+	text := "PING one\r\nPING.two\r\n"
+	expected := map[string]interface{}{
+		"listen_port":            float64(19140),
+		"conn_id":                float64(1),
+		"state":                  "closed",
+		"client_addr":            clientAddr,
+		"backend_addr":           fmt.Sprintf("127.0.0.1:%d", echoPort),
+		"client_to_server_bytes": total,
+		"server_to_client_bytes": total,
+		"captured_packets":       float64(4),
+		"client_to_server_text":  text,
+		"server_to_client_text":  text,
+	}
+	for key, want := range expected {
+		if result[key] != want {
+			t.Errorf("%s: expected %v, got %v", key, want, result[key])
+		}
+	}
+
+	opened, err1 := time.Parse(time.RFC3339, result["opened_at"].(string))
+	closed, err2 := time.Parse(time.RFC3339, result["closed_at"].(string))
+	if err1 != nil || err2 != nil || closed.Before(opened) {
+		t.Errorf("Bad open/close times: %v, %v", result["opened_at"], result["closed_at"])
+	}
+	if duration, _ := result["duration_ms"].(float64); duration < 20 {
+		t.Errorf("Expected a duration of at least 20ms, got %v", result["duration_ms"])
+	}
+
+	packets, _ := result["packets"].([]interface{})
+	if len(packets) != 4 {
+		t.Fatalf("Expected 4 packets, got %d", len(packets))
+	}
+	lastOffset := -1.0
+	for i, p := range packets {
+		packet := p.(map[string]interface{})
+		wantDirection := DirectionClientToServer
+		if i%2 == 1 {
+			wantDirection = DirectionServerToClient
+		}
+		offset, gap := packet["offset_ms"].(float64), packet["gap_ms"].(float64)
+		if packet["direction"] != wantDirection || offset < lastOffset || gap < 0 || packet["bytes"] != float64(len(messages[i/2])) {
+			t.Errorf("Packet %d: unexpected %v", i, packet)
+		}
+		lastOffset = offset
+	}
+
+	missing := callTool(t, NewGetConnectionHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19140),
+		"conn_id":     float64(99),
+	})
+	if _, ok := missing["error"]; !ok {
+		t.Errorf("Expected an error for an unknown connection, got %v", missing)
+	}
+}
+
+// TestGetConnectionByteCounts tests that bytes are counted while capture is
+// off and that injected bytes are not
+func TestGetConnectionByteCounts(t *testing.T) {
+	echoPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	if err := manager.StartProxyWithOptions(19229, "127.0.0.1", echoPort, 1024*1024, ProxyOptions{PassThrough: true}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19229)
+	proxy, _ := manager.GetProxy(19229)
+
+	client, err := net.Dial("tcp", "127.0.0.1:19229")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	client.Write([]byte("ping"))
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+
+	conn := waitForConnection(t, proxy)
+
+	// Pass-through copies are counted once each direction finishes
+	client.Close()
+	for deadline := time.Now().Add(2 * time.Second); proxy.GetConnectionCount() > 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info := conn.Info(); info.ClientToServerBytes != 4 || info.ServerToClientBytes != 4 {
+		t.Errorf("Expected 4 bytes each way without capture, got %d and %d", info.ClientToServerBytes, info.ServerToClientBytes)
+	}
+
+	proxy.recordCapture(conn, []byte("injected"), DirectionClientToServer, true)
+	if info := conn.Info(); info.ClientToServerBytes != 4 {
+		t.Errorf("Expected injected bytes left out, got %d", info.ClientToServerBytes)
+	}
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Traffic directions as recorded on captured packets
+const (
+	DirectionClientToServer = "Client->Server"
+	DirectionServerToClient = "Server->Client"
+)
+
+// maxClosedConnections bounds the metadata kept for closed connections
+const maxClosedConnections = 1000
+
+// Reasons a connection was torn down, as recorded in ConnectionInfo
+const (
+	CloseClientEOF    = "client_eof"    // The client closed its side
+	CloseBackendEOF   = "backend_eof"   // The backend closed its side
+	CloseReadError    = "read_error"    // Reading from either side failed
+	CloseWriteError   = "write_error"   // Forwarding to either side failed
+	CloseProxyStopped = "proxy_stopped" // The proxy was stopped with the connection open
+	CloseManual       = "manual"        // Closed with close_connection
+	CloseIdle         = "idle"          // A UDP client was silent for the session idle limit
+	CloseDropped      = "dropped"       // The client was dropped without forwarding, as the backend was unreachable
+)
+
+// ConnectionInfo is the metadata of a connection, kept for a while after it
+// closes so its captures can still be attributed to endpoints
+type ConnectionInfo struct {
+	ID               uint64    `json:"conn_id"`
+	ClientAddr       string    `json:"client_addr"`
+	LocalAddr        string    `json:"local_addr"` // Proxy side of the client connection
+	BackendAddr      string    `json:"backend_addr"`
+	BackendLocalAddr string    `json:"backend_local_addr"` // Proxy side of the backend connection
+	OpenedAt         time.Time `json:"opened_at"`
+	ClosedAt         time.Time `json:"closed_at"` // Zero while open
+
+	ClientToServerBytes int64 `json:"client_to_server_bytes"`
+	ServerToClientBytes int64 `json:"server_to_client_bytes"`
+	ShortWrites         int64 `json:"short_writes,omitempty"` // Writes continued after returning short
+
+	BackendRTTMs *float64 `json:"backend_rtt_ms,omitempty"` // Nil where TCP_INFO is unavailable
+
+	TLS            bool     `json:"tls,omitempty"`                 // A ClientHello was seen
+	TLSHandshakeMs *float64 `json:"tls_handshake_ms,omitempty"`    // Nil until the handshake completes
+	TLSALPN        string   `json:"tls_negotiated_alpn,omitempty"` // From the ServerHello, empty until one is seen
+
+	CloseReason string `json:"close_reason,omitempty"` // Empty while open
+}
+
+// Connection holds a single proxied client connection and its metadata
+type Connection struct {
+	ID         uint64
+	ClientAddr string // Remote address of the client connection
+	LocalAddr  string // Local (proxy) address of the client connection
+	OpenedAt   time.Time
+	ClientConn net.Conn
+	ServerConn net.Conn
+
+	// Bytes read from each peer and forwarded, whether or not capture is
+	// on. Bytes written by inject_bytes are not included.
+	bytesToServer atomic.Int64
+	bytesToClient atomic.Int64
+
+	// Position reached in each direction's captured stream, injected bytes
+	// included, for the stream offsets of captures
+	offsetToServer atomic.Int64
+	offsetToClient atomic.Int64
+
+	// Writes to either side that returned short and had to be continued
+	shortWrites atomic.Int64
+
+	// Last sampled backend RTT in microseconds, valid once rttSampled is set
+	backendRTT atomic.Int64
+	rttSampled atomic.Bool
+
+	// Whether a packet has been seen in each direction, for first_packet_only
+	seenToServer atomic.Bool
+	seenToClient atomic.Bool
+
+	// Requests sent in each direction, for possible_retry
+	retries retryDetector
+
+	// Request framing state for trace_header
+	tracer traceInjector
+
+	// Transactions held back until their response status is known, for
+	// http_capture_errors_only
+	errorsOnly errorsOnlyFilter
+
+	// Writes to each side are serialized so injected bytes never interleave
+	// with a forwarded chunk
+	toServerMu sync.Mutex
+	toClientMu sync.Mutex
+
+	// Per-direction redaction state, so matches spanning reads are masked
+	requestRedact  redactStream
+	responseRedact redactStream
+
+	// Per-direction body stripping state for http_headers_only
+	requestFilter  *httpHeaderFilter
+	responseFilter *httpHeaderFilter
+
+	// Per-direction HTTP/2 frame parsing state
+	requestHTTP2  *http2StreamParser
+	responseHTTP2 *http2StreamParser
+
+	// Per-direction TLS record layer state
+	requestTLS  *tlsStreamParser
+	responseTLS *tlsStreamParser
+
+	// Per-direction protocol detection from the opening bytes, and the
+	// protocol they settled on for the whole connection ("" until then)
+	requestSniffer  protocolSniffer
+	responseSniffer protocolSniffer
+	protocol        string
+	protocolMu      sync.Mutex
+
+	// Plaintext to TLS upgrade state for STARTTLS protocols
+	starttls starttlsTracker
+
+	// Time from ClientHello to the client's first application data
+	tlsTiming tlsHandshakeTimer
+
+	// Shadow backend receiving the client's bytes, nil without mirror_target
+	mirror *mirrorConn
+
+	// Partial lines held back per direction when line_mode is set
+	requestLines  lineSplitter
+	responseLines lineSplitter
+
+	// End of each direction's previous reads, for ingest_contains
+	requestIngest  ingestMatcher
+	responseIngest ingestMatcher
+
+	// Capture held back for merging when coalesce_window_ms is set
+	coalescer captureCoalescer
+
+	// Reads held back until each direction's opening settles the protocol
+	requestHold, responseHold detectionHold
+
+	// Set when the client matches exclude_cidrs, so nothing is captured
+	excluded bool
+
+	// Why the connection was torn down; the first reason recorded wins
+	closeReason string
+	closeMu     sync.Mutex
+}
+
+// newConnection allocates the next connection ID for the proxy
+func (p *ProxyInstance) newConnection(clientConn, serverConn net.Conn) *Connection {
+	conn := &Connection{
+		ID:         atomic.AddUint64(&p.nextConnID, 1),
+		OpenedAt:   time.Now(),
+		ClientConn: clientConn,
+		ServerConn: serverConn,
+	}
+	if clientConn != nil {
+		conn.ClientAddr = clientConn.RemoteAddr().String()
+		conn.LocalAddr = clientConn.LocalAddr().String()
+	}
+
+	methods := &httpMethodQueue{}
+	bodyLimit := int64(p.Options.HTTPMaxBodyBytes)
+	conn.requestFilter = &httpHeaderFilter{methods: methods, bodyLimit: bodyLimit}
+	conn.responseFilter = &httpHeaderFilter{methods: methods, bodyLimit: bodyLimit}
+	conn.requestHTTP2 = newHTTP2StreamParser(true)
+	conn.responseHTTP2 = newHTTP2StreamParser(false)
+	conn.requestTLS = &tlsStreamParser{}
+	conn.responseTLS = &tlsStreamParser{}
+	return conn
+}
+
+// endpoints returns the source and destination of the client connection's
+// four-tuple for a direction
+func (c *Connection) endpoints(direction string) (src, dst string) {
+	if direction == DirectionClientToServer {
+		return c.ClientAddr, c.LocalAddr
+	}
+	return c.LocalAddr, c.ClientAddr
+}
+
+// headerFilter returns the HTTP body stripping state for a direction
+func (c *Connection) headerFilter(direction string) *httpHeaderFilter {
+	if direction == DirectionClientToServer {
+		return c.requestFilter
+	}
+	return c.responseFilter
+}
+
+// redactState returns the redaction state of a direction
+func (c *Connection) redactState(direction string) *redactStream {
+	if direction == DirectionClientToServer {
+		return &c.requestRedact
+	}
+	return &c.responseRedact
+}
+
+// http2Parser returns the HTTP/2 frame parsing state for a direction
+func (c *Connection) http2Parser(direction string) *http2StreamParser {
+	if direction == DirectionClientToServer {
+		return c.requestHTTP2
+	}
+	return c.responseHTTP2
+}
+
+// sniffer returns the protocol sniffer of a direction
+func (c *Connection) sniffer(direction string) *protocolSniffer {
+	if direction == DirectionClientToServer {
+		return &c.requestSniffer
+	}
+	return &c.responseSniffer
+}
+
+// detectProtocol labels a packet with the connection's protocol, which the
+// opening bytes of either direction decide. Until they do, packets are
+// labeled on their own. A STARTTLS upgrade re-labels the connection TLS.
+// The second result repeats the label, or is empty while the direction's
+// opening is a partial signature that a later read may still complete.
+func (c *Connection) detectProtocol(data []byte, direction string, upgraded bool) (string, string) {
+	sniffer := c.sniffer(direction)
+	protocol, identified := sniffer.detect(data, direction)
+
+	c.protocolMu.Lock()
+	defer c.protocolMu.Unlock()
+	switch {
+	case upgraded:
+		c.protocol = "TLS"
+	case c.protocol == "" && identified:
+		c.protocol = protocol
+	case refinesProtocol(c.protocol, protocol):
+		c.protocol = protocol
+	}
+	if c.protocol != "" {
+		return c.protocol, c.protocol
+	}
+	if sniffer.partial() {
+		return protocol, ""
+	}
+	return protocol, protocol
+}
+
+// detectionHold returns the reads a direction holds for detection
+func (c *Connection) detectionHold(direction string) *detectionHold {
+	if direction == DirectionClientToServer {
+		return &c.requestHold
+	}
+	return &c.responseHold
+}
+
+// countBytes adds bytes forwarded from a peer to the byte total of a direction
+func (c *Connection) countBytes(direction string, n int64) {
+	if direction == DirectionClientToServer {
+		c.bytesToServer.Add(n)
+	} else {
+		c.bytesToClient.Add(n)
+	}
+}
+
+// streamOffset advances the captured stream of a direction by n bytes,
+// returning the offset at which they start
+func (c *Connection) streamOffset(direction string, n int) int64 {
+	if direction == DirectionClientToServer {
+		return c.offsetToServer.Add(int64(n)) - int64(n)
+	}
+	return c.offsetToClient.Add(int64(n)) - int64(n)
+}
+
+// firstPacket reports whether this is the first packet seen in a direction
+func (c *Connection) firstPacket(direction string) bool {
+	if direction == DirectionClientToServer {
+		return c.seenToServer.CompareAndSwap(false, true)
+	}
+	return c.seenToClient.CompareAndSwap(false, true)
+}
+
+// setCloseReason records why the connection is being torn down, unless a
+// reason was already recorded, and returns the recorded reason
+func (c *Connection) setCloseReason(reason string) string {
+	c.closeMu.Lock()
+	defer c.closeMu.Unlock()
+	if c.closeReason == "" {
+		c.closeReason = reason
+	}
+	return c.closeReason
+}
+
+// tlsParser returns the TLS record parsing state for a direction
+func (c *Connection) tlsParser(direction string) *tlsStreamParser {
+	if direction == DirectionClientToServer {
+		return c.requestTLS
+	}
+	return c.responseTLS
+}
+
+// source returns the conn that data flowing in direction is read from
+func (c *Connection) source(direction string) net.Conn {
+	if direction == DirectionClientToServer {
+		return c.ClientConn
+	}
+	return c.ServerConn
+}
+
+// sink returns the conn that data flowing in direction is written to
+func (c *Connection) sink(direction string) net.Conn {
+	if direction == DirectionClientToServer {
+		return c.ServerConn
+	}
+	return c.ClientConn
+}
+
+// injectWriteTimeout bounds how long inject_bytes waits on a peer that has
+// stopped reading, so it can't hold the direction's write lock forever
+const injectWriteTimeout = 5 * time.Second
+
+// Write sends all of data to the side of the connection that direction
+// points at, retrying short writes. A timeout above zero fails the write if
+// it hasn't completed in time; the deadline is cleared again afterwards.
+func (c *Connection) Write(direction string, data []byte, timeout time.Duration) (int, error) {
+	var dst net.Conn
+	switch direction {
+	case DirectionClientToServer:
+		c.toServerMu.Lock()
+		defer c.toServerMu.Unlock()
+		dst = c.ServerConn
+	case DirectionServerToClient:
+		c.toClientMu.Lock()
+		defer c.toClientMu.Unlock()
+		dst = c.ClientConn
+	default:
+		return 0, fmt.Errorf("invalid direction %q (expected %q or %q)", direction, DirectionClientToServer, DirectionServerToClient)
+	}
+
+	if timeout > 0 {
+		dst.SetWriteDeadline(time.Now().Add(timeout))
+		defer dst.SetWriteDeadline(time.Time{})
+	}
+
+	n, short, err := writeFull(dst, data)
+	if short > 0 {
+		c.shortWrites.Add(int64(short))
+	}
+	if err != nil && n > 0 {
+		debugf("Connection %d %s: wrote %d of %d bytes: %v", c.ID, direction, n, len(data), err)
+	}
+	return n, err
+}
+
+// writeFull writes data until all of it is written or w fails, returning
+// the bytes written and how many writes came back short without an error.
+// A write making no progress fails with io.ErrShortWrite rather than spin.
+func writeFull(w io.Writer, data []byte) (written, short int, err error) {
+	for written < len(data) {
+		n, err := w.Write(data[written:])
+		written += n
+		if err != nil {
+			return written, short, err
+		}
+		if written < len(data) {
+			if n == 0 {
+				return written, short, io.ErrShortWrite
+			}
+			short++
+		}
+	}
+	return written, short, nil
+}
+
+// registerConnection adds a live connection to the proxy's registry
+func (p *ProxyInstance) registerConnection(conn *Connection) {
+	p.connsMu.Lock()
+	defer p.connsMu.Unlock()
+	p.conns[conn.ID] = conn
+}
+
+// unregisterConnection removes a connection from the registry, keeping its
+// metadata among the recently closed connections
+func (p *ProxyInstance) unregisterConnection(conn *Connection) {
+	info := conn.Info()
+	info.ClosedAt = time.Now()
+
+	p.connsMu.Lock()
+	defer p.connsMu.Unlock()
+	delete(p.conns, conn.ID)
+	if len(p.closedConns) == maxClosedConnections {
+		p.closedConns = p.closedConns[1:]
+	}
+	p.closedConns = append(p.closedConns, info)
+}
+
+// recordDropped keeps the metadata of a client dropped before any bytes
+// were forwarded among the closed connections
+func (p *ProxyInstance) recordDropped(conn *Connection) {
+	conn.setCloseReason(CloseDropped)
+	p.unregisterConnection(conn)
+}
+
+// Info returns the connection's metadata
+func (c *Connection) Info() ConnectionInfo {
+	info := ConnectionInfo{
+		ID:                  c.ID,
+		ClientAddr:          c.ClientAddr,
+		LocalAddr:           c.LocalAddr,
+		OpenedAt:            c.OpenedAt,
+		ClientToServerBytes: c.bytesToServer.Load(),
+		ServerToClientBytes: c.bytesToClient.Load(),
+		ShortWrites:         c.shortWrites.Load(),
+	}
+	info.TLS, info.TLSHandshakeMs = c.tlsTiming.handshakeMs()
+	info.TLSALPN = c.responseTLS.negotiatedALPN()
+	c.sampleBackendRTT()
+	if c.rttSampled.Load() {
+		rtt := float64(c.backendRTT.Load()) / 1000
+		info.BackendRTTMs = &rtt
+	}
+	if c.ServerConn != nil {
+		info.BackendAddr = c.ServerConn.RemoteAddr().String()
+		info.BackendLocalAddr = c.ServerConn.LocalAddr().String()
+	}
+	c.closeMu.Lock()
+	info.CloseReason = c.closeReason
+	c.closeMu.Unlock()
+	return info
+}
+
+// Connections returns the metadata of live and recently closed connections,
+// ordered by ID. Live connections are collected under connsMu but described
+// after releasing it, as Info samples the backend RTT with a syscall.
+func (p *ProxyInstance) Connections() []ConnectionInfo {
+	p.connsMu.Lock()
+	infos := make([]ConnectionInfo, 0, len(p.conns)+len(p.closedConns))
+	infos = append(infos, p.closedConns...)
+	live := make([]*Connection, 0, len(p.conns))
+	for _, conn := range p.conns {
+		live = append(live, conn)
+	}
+	p.connsMu.Unlock()
+
+	for _, conn := range live {
+		infos = append(infos, conn.Info())
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// LookupConnection returns the metadata of a live or recently closed
+// connection by ID
+func (p *ProxyInstance) LookupConnection(id uint64) (ConnectionInfo, bool) {
+	if conn, exists := p.GetConnection(id); exists {
+		return conn.Info(), true
+	}
+
+	p.connsMu.Lock()
+	defer p.connsMu.Unlock()
+	for i := len(p.closedConns) - 1; i >= 0; i-- {
+		if p.closedConns[i].ID == id {
+			return p.closedConns[i], true
+		}
+	}
+	return ConnectionInfo{}, false
+}
+
+// GetConnection returns a live connection by ID
+func (p *ProxyInstance) GetConnection(id uint64) (*Connection, bool) {
+	p.connsMu.Lock()
+	defer p.connsMu.Unlock()
+	conn, exists := p.conns[id]
+	return conn, exists
+}
+
+// CloseConnection tears down a live connection by closing both sides,
+// recording the close reason as manual. It returns the reason the
+// connection keeps, which differs when it was already closing.
+func (p *ProxyInstance) CloseConnection(id uint64) (string, error) {
+	conn, exists := p.GetConnection(id)
+	if !exists {
+		return "", fmt.Errorf("no active connection %d on port %d", id, p.ListenPort)
+	}
+
+	// Record the reason first so the copy loops see a closed conn and keep it
+	reason := conn.setCloseReason(CloseManual)
+	if conn.ClientConn != nil {
+		conn.ClientConn.Close()
+	}
+	if conn.ServerConn != nil {
+		conn.ServerConn.Close()
+	}
+	infof("Closed connection #%d on port %d manually (%s)", id, p.ListenPort, reason)
+	return reason, nil
+}
+
+// InjectBytes writes data into a live connection as if it had been sent in
+// the given direction, capturing it with the injected marker set
+func (p *ProxyInstance) InjectBytes(id uint64, direction string, data []byte) error {
+	conn, exists := p.GetConnection(id)
+	if !exists {
+		return fmt.Errorf("no active connection %d on port %d", id, p.ListenPort)
+	}
+	if p.Options.PassThrough {
+		// Forwarding bypasses Write, so injected bytes could interleave
+		return fmt.Errorf("cannot inject into proxy on port %d started with capture: false", p.ListenPort)
+	}
+	if p.Options.bridged() {
+		// Bridged connections carry framed datagrams, not a byte stream
+		return fmt.Errorf("cannot inject into proxy on port %d bridging udp and tcp", p.ListenPort)
+	}
+
+	if _, err := conn.Write(direction, data, injectWriteTimeout); err != nil {
+		return fmt.Errorf("failed to inject into connection %d: %v", id, err)
+	}
+
+	p.recordCapture(conn, data, direction, true)
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestCloseReasons tests that client EOF, backend EOF, an unreachable
+// backend and stopping the proxy are each recorded as the connection's
+// close reason
+func TestCloseReasons(t *testing.T) {
+	echoPort := startEchoServer(t)
+
+	// A backend that hangs up after reading one message
+	hangup, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start backend: %v", err)
+	}
+	defer hangup.Close()
+	go func() {
+		for {
+			conn, err := hangup.Accept()
+			if err != nil {
+				return
+			}
+			conn.Read(make([]byte, 64))
+			conn.Close()
+		}
+	}()
+
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19153, "127.0.0.1", echoPort, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19153)
+	if err := manager.StartProxy(19154, "127.0.0.1", hangup.Addr().(*net.TCPAddr).Port, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19154)
+	echoProxy, _ := manager.GetProxy(19153)
+	hangupProxy, _ := manager.GetProxy(19154)
+
+	waitClosed := func(proxy *ProxyInstance) {
+		for deadline := time.Now().Add(2 * time.Second); proxy.GetConnectionCount() > 0 && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	closeReasons := func(port int) []string {
+		result := callTool(t, NewGetConnectionsHandler(manager).Execute, map[string]interface{}{
+			"listen_port": float64(port),
+			"state":       "closed",
+		})
+		var reasons []string
+		for _, c := range result["connections"].([]interface{}) {
+			reasons = append(reasons, fmt.Sprint(c.(map[string]interface{})["close_reason"]))
+		}
+		return reasons
+	}
+
+	// The client hangs up
+	client, err := net.Dial("tcp", "127.0.0.1:19153")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	client.Write([]byte("hello"))
+	io.ReadFull(client, make([]byte, 5))
+	client.Close()
+	waitClosed(echoProxy)
+	if reasons := closeReasons(19153); fmt.Sprint(reasons) != "[client_eof]" {
+		t.Errorf("Expected client_eof, got %v", reasons)
+	}
+
+	// The backend hangs up
+	client, err = net.Dial("tcp", "127.0.0.1:19154")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+	client.Write([]byte("hello"))
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	io.ReadAll(client)
+	waitClosed(hangupProxy)
+	if reasons := closeReasons(19154); fmt.Sprint(reasons) != "[backend_eof]" {
+		t.Errorf("Expected backend_eof, got %v", reasons)
+	}
+
+	// The backend can't be reached, so the client is dropped
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve a port: %v", err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+	if err := manager.StartProxy(19232, "127.0.0.1", closedPort, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19232)
+	droppedProxy, _ := manager.GetProxy(19232)
+	client, err = net.Dial("tcp", "127.0.0.1:19232")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	io.ReadAll(client)
+	waitClosed(droppedProxy)
+	if reasons := closeReasons(19232); fmt.Sprint(reasons) != "[dropped]" {
+		t.Errorf("Expected dropped, got %v", reasons)
+	}
+
+	// The proxy stops with a connection open
+	idle, err := net.Dial("tcp", "127.0.0.1:19153")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer idle.Close()
+	for deadline := time.Now().Add(2 * time.Second); echoProxy.GetConnectionCount() == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	result := callTool(t, NewGetConnectionsHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19153),
+		"state":       "open",
+	})
+	if open := result["connections"].([]interface{}); len(open) != 1 {
+		t.Fatalf("Expected 1 open connection, got %v", open)
+	}
+	manager.StopProxy(19153)
+	if info, ok := echoProxy.LookupConnection(2); !ok || info.CloseReason != CloseProxyStopped {
+		t.Errorf("Expected proxy_stopped, got %+v", info)
+	}
+}
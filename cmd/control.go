@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// controlHelp lists the commands the control socket understands
+const controlHelp = "commands: list, stats, stop <listen_port>, help, quit"
+
+// ControlServer serves a line protocol on a Unix socket so scripts can list
+// and stop proxies without an MCP client. Each command line gets a single
+// line of JSON in reply, the same result the matching tool returns.
+type ControlServer struct {
+	manager  *ProxyManager
+	listener net.Listener
+	path     string
+	conns    map[net.Conn]struct{} // Open control connections, closed by Close
+	closed   bool
+	mu       sync.Mutex
+	wg       sync.WaitGroup // The accept loop and one per connection
+}
+
+// NewControlServer listens on the Unix socket at path and starts serving.
+// A stale socket left behind by an earlier run is replaced.
+func NewControlServer(manager *ProxyManager, path string) (*ControlServer, error) {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Anyone who can connect can stop proxies, so only the owner may
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	s := &ControlServer{manager: manager, listener: listener, path: path, conns: make(map[net.Conn]struct{})}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Close stops accepting commands, closes open control connections and
+// removes the socket
+func (s *ControlServer) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	os.Remove(s.path)
+}
+
+// serve accepts control connections until the listener is closed
+func (s *ControlServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// handle answers the commands of one control connection
+func (s *ControlServer) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" {
+			return
+		}
+		reply := s.execute(fields)
+		if _, err := conn.Write(append(reply, '\n')); err != nil {
+			return
+		}
+	}
+}
+
+// execute runs one command and returns its single-line JSON reply
+func (s *ControlServer) execute(fields []string) []byte {
+	var handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+	args := map[string]interface{}{}
+
+	switch fields[0] {
+	case "list":
+		handler = NewListProxiesHandler(s.manager).Execute
+	case "stats":
+		handler = NewGetGlobalStatsHandler(s.manager).Execute
+	case "stop":
+		if len(fields) != 2 {
+			return controlError("usage: stop <listen_port>")
+		}
+		port, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return controlError(fmt.Sprintf("invalid listen_port %q", fields[1]))
+		}
+		handler = NewStopProxyHandler(s.manager).Execute
+		args["listen_port"] = float64(port)
+	case "help":
+		result := map[string]interface{}{"help": controlHelp}
+		jsonBytes, _ := json.Marshal(result)
+		return jsonBytes
+	default:
+		return controlError(fmt.Sprintf("unknown command %q; %s", fields[0], controlHelp))
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = args
+	result, err := handler(context.Background(), request)
+	if err != nil {
+		return controlError(err.Error())
+	}
+	text, ok := result.Content[0].(mcp.TextContent)
+	if !ok {
+		return controlError("unexpected tool result")
+	}
+
+	// Tool results are indented; replies must fit on one line
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(text.Text)); err != nil {
+		return controlError(err.Error())
+	}
+	return compact.Bytes()
+}
+
+// controlError builds an error reply
+func controlError(message string) []byte {
+	result := map[string]interface{}{
+		"error": message,
+	}
+	jsonBytes, _ := json.Marshal(result)
+	return jsonBytes
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestControlSocket tests listing and stopping proxies over the control
+// socket's line protocol
+func TestControlSocket(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+	if err := manager.StartProxy(19212, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	control, err := NewControlServer(manager, path)
+	if err != nil {
+		t.Fatalf("Failed to open control socket: %v", err)
+	}
+	defer control.Close()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to connect to control socket: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	command := func(line string) map[string]interface{} {
+		t.Helper()
+		if _, err := fmt.Fprintf(conn, "%s\n", line); err != nil {
+			t.Fatalf("Failed to send %q: %v", line, err)
+		}
+		reply, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read reply to %q: %v", line, err)
+		}
+		var result map[string]interface{}
+		if err := json.Unmarshal([]byte(reply), &result); err != nil {
+			t.Fatalf("Reply to %q is not one line of JSON: %q", line, reply)
+		}
+		return result
+	}
+
+	proxies, _ := command("list")["proxies"].([]interface{})
+	if len(proxies) != 1 || proxies[0].(map[string]interface{})["listen_port"] != float64(19212) {
+		t.Fatalf("Expected list to show the proxy on 19212, got %v", proxies)
+	}
+	if stats := command("stats"); stats["proxies"] != float64(1) {
+		t.Errorf("Expected stats for 1 proxy, got %v", stats)
+	}
+	if result := command("bogus"); result["error"] == nil {
+		t.Errorf("Expected an error for an unknown command, got %v", result)
+	}
+
+	if result := command("stop 19212"); result["status"] != "stopped" {
+		t.Errorf("Expected the proxy to be stopped, got %v", result)
+	}
+	if _, exists := manager.GetProxy(19212); exists {
+		t.Error("Expected no proxy on 19212 after stop")
+	}
+	if proxies, _ := command("list")["proxies"].([]interface{}); len(proxies) != 0 {
+		t.Errorf("Expected an empty list after stop, got %v", proxies)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the socket to be private to its owner, got %v (%v)", info.Mode(), err)
+	}
+
+	// Close doesn't wait for the idle client to hang up
+	closed := make(chan struct{})
+	go func() {
+		control.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close blocked on an open control connection")
+	}
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Error("Expected the control connection to be closed")
+	}
+}
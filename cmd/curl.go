@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// requestAtSeq reassembles the client stream of a connection starting at the
+// capture with the given sequence number and parses it as an HTTP request
+func requestAtSeq(captures []*CapturedPacket, seq uint64) (*http.Request, []byte, error) {
+	start, err := captureAtSeq(captures, seq)
+	if err != nil {
+		return nil, nil, err
+	}
+	if start.Direction != DirectionClientToServer {
+		return nil, nil, fmt.Errorf("capture %d is %s, expected a client request", seq, start.Direction)
+	}
+
+	// Concatenate this and the following client captures of the same connection
+	var stream []byte
+	for _, capture := range captures {
+		if capture.ConnID == start.ConnID && capture.Direction == DirectionClientToServer && capture.Seq >= seq {
+			stream = append(stream, capture.RawData...)
+		}
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("capture %d is not the start of an HTTP request: %v", seq, err)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("request body of capture %d is incomplete: %v", seq, err)
+	}
+
+	return req, body, nil
+}
+
+// buildCurlCommand renders an equivalent curl command for a captured request.
+// Requests without a Host header, such as HTTP/1.0 ones, are sent to
+// defaultHost, the proxy's forward address.
+func buildCurlCommand(req *http.Request, body []byte, defaultHost string) string {
+	var b strings.Builder
+	b.WriteString("curl")
+
+	if req.Method != http.MethodGet || len(body) > 0 {
+		b.WriteString(" -X ")
+		b.WriteString(shellQuote(req.Method))
+	}
+
+	host := req.Host
+	if host == "" {
+		host = defaultHost
+	}
+	b.WriteString(" ")
+	b.WriteString(shellQuote("http://" + host + req.RequestURI))
+
+	// Emit headers in a stable order; curl derives Host and Content-Length
+	// itself, and the body below is already de-chunked
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		switch name {
+		case "Content-Length", "Transfer-Encoding":
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			b.WriteString(" -H ")
+			b.WriteString(shellQuote(name + ": " + value))
+		}
+	}
+
+	if len(body) > 0 {
+		b.WriteString(" --data-binary ")
+		b.WriteString(shellQuote(string(body)))
+	}
+
+	return b.String()
+}
+
+// shellQuote wraps s in single quotes so a POSIX shell passes it through literally
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestToCurl tests curl generation from a captured POST request
+func TestToCurl(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19096, "localhost", 18084, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19096)
+
+	proxy, _ := manager.GetProxy(19096)
+	conn := proxy.newConnection(nil, nil)
+	proxy.captureData(conn, []byte("POST /submit?x=1 HTTP/1.1\r\nHost: example.com:8080\r\nX-Token: it's secret\r\n"), DirectionClientToServer)
+	proxy.captureData(conn, []byte("HTTP/1.1 100 Continue\r\n\r\n"), DirectionServerToClient)
+	proxy.captureData(conn, []byte("Content-Length: 11\r\n\r\nname='bob'!"), DirectionClientToServer)
+
+	seq := proxy.Buffer.GetAll()[0].Seq
+	response := callTool(t, NewToCurlHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19096),
+		"seq":         float64(seq),
+	})
+
+	cmd, ok := response["curl"].(string)
+	if !ok {
+		t.Fatalf("No curl command in response: %v", response)
+	}
+
+	for _, want := range []string{
+		"curl -X 'POST' ",
+		"'http://example.com:8080/submit?x=1'",
+		`-H 'X-Token: it'\''s secret'`,
+		`--data-binary 'name='\''bob'\''!'`,
+	} {
+		if !strings.Contains(cmd, want) {
+			t.Errorf("Expected curl command to contain %q, got: %s", want, cmd)
+		}
+	}
+	if strings.Contains(cmd, "Content-Length") {
+		t.Errorf("Content-Length should be left to curl, got: %s", cmd)
+	}
+
+	// Without a Host header the request goes to the forward address
+	proxy.captureData(conn, []byte("GET /old HTTP/1.0\r\n\r\n"), DirectionClientToServer)
+	captures := proxy.Buffer.GetAll()
+	response = callTool(t, NewToCurlHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19096),
+		"seq":         float64(captures[len(captures)-1].Seq),
+	})
+	if cmd, _ := response["curl"].(string); !strings.Contains(cmd, "'http://localhost:18084/old'") {
+		t.Errorf("Expected the forward address as host, got: %v", response)
+	}
+
+	// A response capture is not a request
+	response = callTool(t, NewToCurlHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19096),
+		"seq":         float64(seq + 1),
+	})
+	if _, ok := response["error"]; !ok {
+		t.Errorf("Expected error for a response capture, got: %v", response)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// GRPCMessageSummary describes one length-prefixed gRPC message
+type GRPCMessageSummary struct {
+	Offset     int  `json:"offset"` // Offset of the message prefix within the frame data, excluding padding; negative if the prefix began in an earlier frame
+	Compressed bool `json:"compressed"`
+	Length     int  `json:"length"`
+	Incomplete bool `json:"incomplete,omitempty"` // Message continues beyond this frame
+}
+
+// DecodedCapture is the protocol-aware view of a single capture
+type DecodedCapture struct {
+	Seq      uint64              `json:"seq"`
+	Decoding string              `json:"decoding"` // "grpc", "http2" or "hex"
+	Preface  bool                `json:"http2_preface,omitempty"`
+	Frames   []HTTP2FrameSummary `json:"frames,omitempty"`
+	HexDump  string              `json:"hex_dump,omitempty"`
+}
+
+// decodeCapture decodes a capture as HTTP/2 frames carrying gRPC messages,
+// falling back to a full hex dump when the payload is not HTTP/2 framed.
+// Frames followed live across reads are used when the capture has them;
+// otherwise the capture is parsed on its own.
+func decodeCapture(capture *CapturedPacket) DecodedCapture {
+	data := capture.RawData
+	preface := bytes.HasPrefix(data, http2Preface)
+	if preface {
+		data = data[len(http2Preface):]
+	}
+
+	frames := capture.HTTP2Frames
+	if len(frames) == 0 {
+		parsed, ok := parseHTTP2FrameHeaders(data)
+		if !ok || (!preface && len(parsed) == 0) {
+			return DecodedCapture{Seq: capture.Seq, Decoding: "hex", HexDump: hex.Dump(capture.RawData)}
+		}
+		frames = parseGRPCMessages(parsed)
+	}
+
+	decoded := DecodedCapture{Seq: capture.Seq, Decoding: "http2", Preface: preface, Frames: frames}
+	for _, frame := range frames {
+		if len(frame.Messages) > 0 {
+			decoded.Decoding = "grpc"
+		}
+	}
+	return decoded
+}
+
+// parseGRPCMessages finds the gRPC messages in the DATA frames of a single
+// capture, following each stream across its frames
+func parseGRPCMessages(frames []http2Frame) []HTTP2FrameSummary {
+	streams := make(map[uint32]*grpcStreamReader)
+	summaries := make([]HTTP2FrameSummary, 0, len(frames))
+	for _, frame := range frames {
+		summary := frame.HTTP2FrameSummary
+		payload := frame.payload
+		if summary.Type == http2FrameData && summary.Flags&http2FlagPadded != 0 {
+			if len(payload) == 0 || int(payload[0]) >= len(payload) {
+				payload = nil
+			} else {
+				payload = payload[1 : len(payload)-int(payload[0])]
+			}
+		}
+		if summary.Type == http2FrameData && len(payload) > 0 {
+			stream := streams[summary.StreamID]
+			if stream == nil {
+				stream = &grpcStreamReader{}
+				streams[summary.StreamID] = stream
+			}
+			summary.Messages = stream.read(payload, 0)
+			if last := len(summary.Messages) - 1; last >= 0 && stream.remaining > 0 {
+				summary.Messages[last].Incomplete = true
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// grpcStreamReader finds gRPC length-prefixed messages (1 byte compressed
+// flag, 4 byte big-endian length, message) in the DATA of one stream. The
+// prefix and the message may both span frames and reads.
+type grpcStreamReader struct {
+	prefix    []byte // Prefix bytes of the next message seen so far
+	remaining int    // Message bytes left before the next prefix
+}
+
+// read consumes the next DATA bytes of the stream, which start at offset
+// within their frame, and returns the messages whose prefix ends within them
+func (r *grpcStreamReader) read(data []byte, offset int) []GRPCMessageSummary {
+	var messages []GRPCMessageSummary
+	for len(data) > 0 {
+		if r.remaining > 0 {
+			n := min(r.remaining, len(data))
+			r.remaining -= n
+			offset += n
+			data = data[n:]
+			continue
+		}
+
+		start := offset - len(r.prefix)
+		n := min(5-len(r.prefix), len(data))
+		r.prefix = append(r.prefix, data[:n]...)
+		offset += n
+		data = data[n:]
+		if len(r.prefix) < 5 {
+			break
+		}
+		length := int(binary.BigEndian.Uint32(r.prefix[1:5]))
+		messages = append(messages, GRPCMessageSummary{Offset: start, Compressed: r.prefix[0] == 1, Length: length})
+		r.prefix = r.prefix[:0]
+		r.remaining = length
+	}
+	return messages
+}
+
+// captureAtSeq returns the capture with the given sequence number
+func captureAtSeq(captures []*CapturedPacket, seq uint64) (*CapturedPacket, error) {
+	for _, capture := range captures {
+		if capture.Seq == seq {
+			return capture, nil
+		}
+	}
+	return nil, fmt.Errorf("no capture with seq %d in buffer", seq)
+}
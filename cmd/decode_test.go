@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// http2FrameBytes builds a raw HTTP/2 frame
+func http2FrameBytes(frameType, flags uint8, streamID uint32, payload []byte) []byte {
+	frame := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), frameType, flags, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(frame[5:], streamID)
+	return append(frame, payload...)
+}
+
+// grpcMessageBytes builds a gRPC length-prefixed message
+func grpcMessageBytes(compressed bool, message []byte) []byte {
+	prefix := make([]byte, 5)
+	if compressed {
+		prefix[0] = 1
+	}
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(message)))
+	return append(prefix, message...)
+}
+
+// TestDecodeCaptureGRPC tests that gRPC message boundaries are reported for a captured DATA frame
+func TestDecodeCaptureGRPC(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19111, "localhost", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19111)
+
+	proxy, _ := manager.GetProxy(19111)
+	conn := proxy.newConnection(nil, nil)
+
+	var data []byte
+	data = append(data, grpcMessageBytes(false, []byte("hello"))...)
+	data = append(data, grpcMessageBytes(true, make([]byte, 300))...)
+	payload := http2FrameBytes(0x4, 0, 0, nil) // SETTINGS
+	payload = append(payload, http2FrameBytes(0x0, 0x1, 3, data)...)
+	proxy.captureData(conn, payload, DirectionClientToServer)
+	proxy.captureData(conn, []byte("plain text"), DirectionServerToClient)
+
+	captures := proxy.Buffer.GetAll()
+	handler := NewDecodeCaptureHandler(manager)
+
+	response := callTool(t, handler.Execute, map[string]interface{}{
+		"listen_port": float64(19111),
+		"seq":         float64(captures[0].Seq),
+	})
+	if response["decoding"] != "grpc" {
+		t.Fatalf("Expected grpc decoding, got %v", response)
+	}
+	frames := response["frames"].([]interface{})
+	if len(frames) != 2 {
+		t.Fatalf("Expected 2 frames, got %d", len(frames))
+	}
+	dataFrame := frames[1].(map[string]interface{})
+	if dataFrame["stream_id"] != float64(3) || dataFrame["length"] != float64(len(data)) {
+		t.Errorf("Unexpected DATA frame header: %v", dataFrame)
+	}
+	messages := dataFrame["grpc_messages"].([]interface{})
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 gRPC messages, got %v", messages)
+	}
+	first, second := messages[0].(map[string]interface{}), messages[1].(map[string]interface{})
+	if first["offset"] != float64(0) || first["length"] != float64(5) || first["compressed"] != false {
+		t.Errorf("Unexpected first message: %v", first)
+	}
+	if second["offset"] != float64(10) || second["length"] != float64(300) || second["compressed"] != true {
+		t.Errorf("Unexpected second message: %v", second)
+	}
+
+	// Non-HTTP/2 captures fall back to hex
+	response = callTool(t, handler.Execute, map[string]interface{}{
+		"listen_port": float64(19111),
+		"seq":         float64(captures[1].Seq),
+	})
+	if response["decoding"] != "hex" || response["hex_dump"] == "" {
+		t.Errorf("Expected hex fallback, got %v", response)
+	}
+}
+
+// TestDecodeCaptureGRPCAcrossReads tests that gRPC messages are followed per
+// stream when a length prefix spans DATA frames and a frame spans reads
+func TestDecodeCaptureGRPCAcrossReads(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19224, "localhost", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19224)
+
+	proxy, _ := manager.GetProxy(19224)
+	conn := proxy.newConnection(nil, nil)
+
+	// content-type: application/grpc, a literal with the static table name
+	block := append([]byte{0x0f, 0x10, 0x10}, "application/grpc"...)
+	second := grpcMessageBytes(true, make([]byte, 300))
+
+	var first []byte
+	first = append(first, http2Preface...)
+	first = append(first, http2FrameBytes(http2FrameHeaders, http2FlagEndHeaders, 1, block)...)
+	first = append(first, http2FrameBytes(http2FrameData, 0, 1, append(grpcMessageBytes(false, []byte("hello")), second[:3]...))...)
+	rest := http2FrameBytes(http2FrameData, http2FlagEndStream, 1, second[3:])
+	proxy.captureData(conn, first, DirectionClientToServer)
+	proxy.captureData(conn, rest[:100], DirectionClientToServer)
+	proxy.captureData(conn, rest[100:], DirectionClientToServer)
+
+	captures := proxy.Buffer.GetAll()
+	handler := NewDecodeCaptureHandler(manager)
+	message := func(seq uint64) map[string]interface{} {
+		response := callTool(t, handler.Execute, map[string]interface{}{
+			"listen_port": float64(19224),
+			"seq":         float64(seq),
+		})
+		if response["decoding"] != "grpc" {
+			t.Fatalf("Expected grpc decoding of capture %d, got %v", seq, response)
+		}
+		frames := response["frames"].([]interface{})
+		messages := frames[len(frames)-1].(map[string]interface{})["grpc_messages"].([]interface{})
+		if len(messages) != 1 {
+			t.Fatalf("Expected 1 gRPC message in capture %d, got %v", seq, messages)
+		}
+		return messages[0].(map[string]interface{})
+	}
+
+	if got := message(captures[0].Seq); got["offset"] != float64(0) || got["length"] != float64(5) || got["incomplete"] != nil {
+		t.Errorf("Unexpected first message: %v", got)
+	}
+	// The second message's prefix began 3 bytes before its frame
+	if got := message(captures[2].Seq); got["offset"] != float64(-3) || got["length"] != float64(300) || got["compressed"] != true {
+		t.Errorf("Unexpected second message: %v", got)
+	}
+	if len(captures[1].HTTP2Frames) != 0 {
+		t.Errorf("Expected the DATA frame summarized once complete, got %v", captures[1].HTTP2Frames)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// protocolDisabled labels captures of proxies started with detect_protocol false
+const protocolDisabled = "Disabled"
+
+// ProtocolDetector labels packets of a protocol. Detect returns false when
+// the packet is not recognised, so the next detector is consulted.
+type ProtocolDetector interface {
+	Detect(data []byte, direction string) (string, bool)
+}
+
+// ProtocolDetectorFunc adapts a function to the ProtocolDetector interface
+type ProtocolDetectorFunc func(data []byte, direction string) (string, bool)
+
+// Detect calls f
+func (f ProtocolDetectorFunc) Detect(data []byte, direction string) (string, bool) {
+	return f(data, direction)
+}
+
+// builtinSignature is a built-in detector with the openings it matches, so
+// detection and partial signatures are both driven by one table
+type builtinSignature struct {
+	detector ProtocolDetector
+	prefixes [][]byte // Openings the detector matches; nil if it matches anywhere
+	minLen   int      // Bytes the detector needs when that is more than a prefix
+}
+
+// builtinSignatures are consulted after any registered detector, in order
+var builtinSignatures = []builtinSignature{
+	{detector: ProtocolDetectorFunc(detectHTTP1), prefixes: httpPrefixes},
+	{detector: ProtocolDetectorFunc(detectHTTP2Preface), prefixes: [][]byte{http2PrefacePrefix}},
+	{detector: ProtocolDetectorFunc(detectGRPC)},
+	{detector: ProtocolDetectorFunc(detectTLS), prefixes: [][]byte{tlsHandshakePrefix}, minLen: tlsRecordHeaderBytes + 1},
+}
+
+// Detectors added with RegisterProtocolDetector, in registration order
+var (
+	customDetectors   []ProtocolDetector
+	customDetectorsMu sync.RWMutex
+)
+
+// RegisterProtocolDetector adds a detector consulted before the built-in
+// ones, so it can claim packets they would otherwise mislabel. Call it at
+// startup, before proxies are started.
+func RegisterProtocolDetector(detector ProtocolDetector) {
+	customDetectorsMu.Lock()
+	defer customDetectorsMu.Unlock()
+	customDetectors = append(customDetectors, detector)
+}
+
+// detectProtocol labels a packet with the first matching detector's
+// protocol, or "Unknown"
+func detectProtocol(data []byte, direction string) string {
+	customDetectorsMu.RLock()
+	custom := customDetectors
+	customDetectorsMu.RUnlock()
+
+	for _, detector := range custom {
+		if protocol, ok := detector.Detect(data, direction); ok {
+			return protocol
+		}
+	}
+	for _, signature := range builtinSignatures {
+		if protocol, ok := signature.detector.Detect(data, direction); ok {
+			return protocol
+		}
+	}
+	return "Unknown"
+}
+
+// sniffWindow is how many opening bytes of a direction are kept so a
+// signature split across reads is still recognised
+const sniffWindow = 512
+
+// protocolSniffer detects the protocol of one direction of a connection from
+// its opening bytes, joining reads until a detector matches or sniffWindow
+// bytes have been seen
+type protocolSniffer struct {
+	opening []byte
+	done    bool // The opening is settled, whether or not it matched
+	mu      sync.Mutex
+}
+
+// detect labels a packet, reporting whether this packet completed an
+// opening that identifies the protocol. Once the opening is settled,
+// packets are labeled on their own.
+func (s *protocolSniffer) detect(data []byte, direction string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	protocol := detectProtocol(data, direction)
+	if s.done {
+		return protocol, false
+	}
+
+	if protocol == "Unknown" && len(s.opening) > 0 {
+		s.opening = append(s.opening, data[:min(len(data), sniffWindow-len(s.opening))]...)
+		protocol = detectProtocol(s.opening, direction)
+	} else if protocol == "Unknown" {
+		s.opening = append(s.opening, data[:min(len(data), sniffWindow)]...)
+	}
+	identified := protocol != "Unknown"
+	if identified || len(s.opening) >= sniffWindow {
+		s.done = true
+		s.opening = nil
+	}
+	return protocol, identified
+}
+
+// partial reports whether the opening seen so far is the start of a
+// built-in signature, so a later read may still identify it
+func (s *protocolSniffer) partial() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.done && partialSignature(s.opening)
+}
+
+// partialSignature reports whether data is the start of a built-in
+// signature but too short for its detector to match yet
+func partialSignature(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	for _, signature := range builtinSignatures {
+		for _, prefix := range signature.prefixes {
+			if len(data) < max(len(prefix), signature.minLen) &&
+				(bytes.HasPrefix(prefix, data) || bytes.HasPrefix(data, prefix)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// detectHoldWindow is how long captures wait for a partial signature to
+// settle the protocol before they are stored as they are
+const detectHoldWindow = 200 * time.Millisecond
+
+// heldRead is a read waiting for its direction's protocol to settle
+type heldRead struct {
+	capture *CapturedPacket // nil for a read that isn't stored
+	events  []httpEvent     // Message events for http_capture_errors_only
+	clean   []byte          // Redacted payload, for coalescing
+}
+
+// detectionHold holds back the reads of a direction while its opening is
+// a partial signature, so a signature split across reads labels the
+// captures before it too. A read that settles the opening releases them
+// with its label; otherwise a timer or the connection closing stores them
+// as they are.
+type detectionHold struct {
+	pending []heldRead
+	timer   *time.Timer
+	mu      sync.Mutex
+}
+
+// holdForDetection stores a read once its direction's protocol is settled,
+// releasing the reads held before it first. settled is the connection's
+// protocol, or empty while the opening is undecided. A read that isn't
+// stored only waits behind earlier held reads.
+func (p *ProxyInstance) holdForDetection(conn *Connection, read heldRead, direction, settled string) {
+	h := conn.detectionHold(direction)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if settled != "" || (read.capture == nil && len(h.pending) == 0) {
+		p.releaseHeldReadsLocked(conn, h, direction, settled)
+		p.storeCapture(conn, read, direction)
+		return
+	}
+
+	// Message events are reused by the next read of the direction
+	read.events = append([]httpEvent(nil), read.events...)
+	h.pending = append(h.pending, read)
+	if h.timer == nil {
+		h.timer = time.AfterFunc(detectHoldWindow, func() { p.releaseHeldReads(conn, direction) })
+	} else {
+		h.timer.Reset(detectHoldWindow)
+	}
+}
+
+// releaseHeldReads stores the held reads of a direction as they are, once
+// the opening stayed undecided for the window or the connection closes
+func (p *ProxyInstance) releaseHeldReads(conn *Connection, direction string) {
+	h := conn.detectionHold(direction)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	p.releaseHeldReadsLocked(conn, h, direction, "")
+}
+
+// releaseUndetected stores the reads still held on a closing connection
+func (p *ProxyInstance) releaseUndetected(conn *Connection) {
+	p.releaseHeldReads(conn, DirectionClientToServer)
+	p.releaseHeldReads(conn, DirectionServerToClient)
+}
+
+// releaseHeldReadsLocked stores the held reads of a direction, labeled with
+// protocol unless it is empty
+// IMPORTANT: This assumes h.mu is already held by the caller
+func (p *ProxyInstance) releaseHeldReadsLocked(conn *Connection, h *detectionHold, direction, protocol string) {
+	for _, read := range h.pending {
+		if read.capture != nil && protocol != "" {
+			read.capture.DetectedProtocol = protocol
+		}
+		p.storeCapture(conn, read, direction)
+	}
+	h.pending = nil
+}
+
+// refinesProtocol reports whether a packet labeled detected narrows down a
+// connection labeled current, as gRPC paths do after the HTTP/2 preface
+func refinesProtocol(current, detected string) bool {
+	return current == "HTTP/2" && detected == "gRPC"
+}
+
+// httpPrefixes start HTTP/1.x requests and responses
+var httpPrefixes = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("DELETE "),
+	[]byte("HEAD "), []byte("OPTIONS "), []byte("HTTP/1."),
+}
+
+// detectHTTP1 recognises HTTP/1.x request and status lines
+func detectHTTP1(data []byte, direction string) (string, bool) {
+	for _, prefix := range httpPrefixes {
+		if bytes.HasPrefix(data, prefix) {
+			return "HTTP/1.x", true
+		}
+	}
+	return "", false
+}
+
+// Markers of the HTTP/2 preface, gRPC paths and TLS handshakes, converted
+// once rather than per packet
+var (
+	http2PrefacePrefix = []byte("PRI * HTTP/2.0")
+	grpcPathMarker     = []byte("/grpc.")
+	grpcProtoMarker    = []byte(".proto.")
+	tlsHandshakePrefix = []byte{0x16, 0x03}
+)
+
+// detectHTTP2Preface recognises the HTTP/2 connection preface
+func detectHTTP2Preface(data []byte, direction string) (string, bool) {
+	return "HTTP/2", bytes.HasPrefix(data, http2PrefacePrefix)
+}
+
+// detectGRPC recognises common gRPC paths
+func detectGRPC(data []byte, direction string) (string, bool) {
+	return "gRPC", bytes.Contains(data, grpcPathMarker) || bytes.Contains(data, grpcProtoMarker)
+}
+
+// detectTLS recognises TLS handshake records (simplified detection)
+func detectTLS(data []byte, direction string) (string, bool) {
+	return "TLS", len(data) > tlsRecordHeaderBytes && bytes.HasPrefix(data, tlsHandshakePrefix)
+}
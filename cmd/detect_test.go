@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRegisterProtocolDetector tests that a registered detector labels
+// matching packets ahead of the built-ins and leaves others alone
+func TestRegisterProtocolDetector(t *testing.T) {
+	customDetectorsMu.Lock()
+	saved := customDetectors
+	customDetectorsMu.Unlock()
+	defer func() {
+		customDetectorsMu.Lock()
+		customDetectors = saved
+		customDetectorsMu.Unlock()
+	}()
+
+	RegisterProtocolDetector(ProtocolDetectorFunc(func(data []byte, direction string) (string, bool) {
+		return "CafeProto", direction == DirectionClientToServer && bytes.HasPrefix(data, []byte{0xca, 0xfe})
+	}))
+
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19165, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19165)
+	proxy, _ := manager.GetProxy(19165)
+
+	// The payload also matches the gRPC built-in, which the custom detector
+	// precedes. Each packet opens its own connection, as the first packet
+	// labels a whole connection.
+	proxy.recordCapture(proxy.newConnection(nil, nil), []byte("\xca\xfe/grpc.health"), DirectionClientToServer, false)
+	proxy.recordCapture(proxy.newConnection(nil, nil), []byte("\xca\xfe/grpc.health"), DirectionServerToClient, false)
+	proxy.recordCapture(proxy.newConnection(nil, nil), []byte("GET / HTTP/1.1\r\n\r\n"), DirectionClientToServer, false)
+
+	want := []string{"CafeProto", "gRPC", "HTTP/1.x"}
+	captures := proxy.Buffer.GetAll()
+	if len(captures) != len(want) {
+		t.Fatalf("Expected %d captures, got %d", len(want), len(captures))
+	}
+	for i, capture := range captures {
+		if capture.DetectedProtocol != want[i] {
+			t.Errorf("Capture %d: expected %s, got %s", i, want[i], capture.DetectedProtocol)
+		}
+	}
+}
+
+// detectProtocolString is the string-based detection detectProtocol
+// replaced, kept to compare allocations
+func detectProtocolString(data []byte) string {
+	dataStr := string(data)
+	for _, prefix := range []string{"GET ", "POST ", "PUT ", "DELETE ", "HEAD ", "OPTIONS ", "HTTP/1."} {
+		if strings.HasPrefix(dataStr, prefix) {
+			return "HTTP/1.x"
+		}
+	}
+	if strings.HasPrefix(dataStr, "PRI * HTTP/2.0") {
+		return "HTTP/2"
+	}
+	if strings.Contains(dataStr, "/grpc.") || strings.Contains(dataStr, ".proto.") {
+		return "gRPC"
+	}
+	if len(data) > 5 && data[0] == 0x16 && data[1] == 0x03 {
+		return "TLS"
+	}
+	return "Unknown"
+}
+
+// BenchmarkDetectProtocol measures detection on a binary packet that no
+// detector matches, the worst case, against the string-based version
+func BenchmarkDetectProtocol(b *testing.B) {
+	payload := bytes.Repeat([]byte{0x00, 0x17, 0xff, 0x42}, 1024)
+
+	b.Run("bytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			detectProtocol(payload, DirectionClientToServer)
+		}
+	})
+	b.Run("string", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			detectProtocolString(payload)
+		}
+	})
+}
+
+// TestDetectProtocolNoAllocs tests that detection does not copy the packet
+func TestDetectProtocolNoAllocs(t *testing.T) {
+	packets := [][]byte{
+		[]byte("GET / HTTP/1.1\r\n\r\n"),
+		[]byte("POST /grpc.health.v1.Health/Check HTTP/2.0"),
+		{0x16, 0x03, 0x01, 0x00, 0x05, 0x01},
+		bytes.Repeat([]byte{0x00, 0x17, 0xff, 0x42}, 1024),
+	}
+	for _, packet := range packets {
+		want := detectProtocolString(packet)
+		if got := detectProtocol(packet, DirectionClientToServer); got != want {
+			t.Errorf("Expected %s for %q, got %s", want, packet[:min(len(packet), 16)], got)
+		}
+		allocs := testing.AllocsPerRun(100, func() {
+			detectProtocol(packet, DirectionClientToServer)
+		})
+		if allocs != 0 {
+			t.Errorf("Expected no allocations detecting %q, got %v", packet[:min(len(packet), 16)], allocs)
+		}
+	}
+}
+
+// TestDetectSplitSignature tests that a signature split across reads is
+// recognised and labels the connection's captures, including the read
+// holding the start of the signature
+func TestDetectSplitSignature(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19198, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19198)
+	proxy, _ := manager.GetProxy(19198)
+
+	steps := []struct {
+		conn      int
+		data      string
+		direction string
+		want      string
+	}{
+		{0, "GE", DirectionClientToServer, "HTTP/1.x"},
+		{0, "T /index.html HTTP/1.1\r\nHost: example.com\r\n\r\n", DirectionClientToServer, "HTTP/1.x"},
+		{0, "body bytes without a signature", DirectionClientToServer, "HTTP/1.x"},
+		{1, "\x16", DirectionServerToClient, "TLS"},
+		{1, "\x03\x03\x00\x02\x02\x00", DirectionServerToClient, "TLS"},
+		{1, "\x17\x03\x03\x00\x05hello", DirectionServerToClient, "TLS"},
+	}
+	conns := []*Connection{proxy.newConnection(nil, nil), proxy.newConnection(nil, nil)}
+	for _, step := range steps {
+		proxy.captureData(conns[step.conn], []byte(step.data), step.direction)
+	}
+
+	captures := proxy.Buffer.GetAll()
+	if len(captures) != len(steps) {
+		t.Fatalf("Expected %d captures, got %d", len(steps), len(captures))
+	}
+	for i, step := range steps {
+		if captures[i].DetectedProtocol != step.want {
+			t.Errorf("Capture %d (%q): expected %s, got %s", i, step.data, step.want, captures[i].DetectedProtocol)
+		}
+	}
+
+	// An opening that matches nothing within the window leaves later
+	// packets detected on their own
+	other := proxy.newConnection(nil, nil)
+	proxy.captureData(other, bytes.Repeat([]byte{0xff}, sniffWindow), DirectionClientToServer)
+	proxy.captureData(other, []byte("more"), DirectionClientToServer)
+	if last := proxy.Buffer.GetAll()[len(steps)+1]; last.DetectedProtocol != "Unknown" {
+		t.Errorf("Expected Unknown after an unrecognised opening, got %s", last.DetectedProtocol)
+	}
+
+	// A partial signature that is never completed is stored as it is once
+	// the hold window passes
+	partial := proxy.newConnection(nil, nil)
+	proxy.captureData(partial, []byte("HT"), DirectionServerToClient)
+	if got := len(proxy.Buffer.GetAll()); got != len(steps)+2 {
+		t.Fatalf("Expected the partial signature held, got %d captures", got)
+	}
+	time.Sleep(2 * detectHoldWindow)
+	if captures := proxy.Buffer.GetAll(); len(captures) != len(steps)+3 || captures[len(steps)+2].DetectedProtocol != "Unknown" {
+		t.Errorf("Expected the partial signature stored as Unknown after the window, got %d captures", len(captures))
+	}
+}
+
+// TestPartialSignature tests that partial openings are judged from the
+// built-in signature table
+func TestPartialSignature(t *testing.T) {
+	tests := []struct {
+		data string
+		want bool
+	}{
+		{"GE", true},
+		{"GET ", false},
+		{"HTTP/1", true},
+		{"PRI * HTTP", true},
+		{"\x16", true},
+		{"\x16\x03\x03\x00\x02", true},
+		{"\x16\x03\x03\x00\x02\x02", false},
+		{"\x16\x04", false},
+		{"/grpc", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := partialSignature([]byte(tt.data)); got != tt.want {
+			t.Errorf("partialSignature(%q) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+// TestStickyProtocol tests that every capture of a connection carries the
+// protocol its opening bytes identified
+func TestStickyProtocol(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19199, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19199)
+	proxy, _ := manager.GetProxy(19199)
+
+	// Application data records match no detector on their own
+	conn := proxy.newConnection(nil, nil)
+	clientHello := append([]byte{1, 0, 0, 6}, make([]byte, 6)...)
+	serverHello := append([]byte{2, 0, 0, 6}, make([]byte, 6)...)
+	proxy.captureData(conn, tlsRecordBytes(tlsHandshake, clientHello), DirectionClientToServer)
+	proxy.captureData(conn, tlsRecordBytes(tlsHandshake, serverHello), DirectionServerToClient)
+	proxy.captureData(conn, tlsRecordBytes(tlsApplicationData, []byte("request")), DirectionClientToServer)
+	proxy.captureData(conn, tlsRecordBytes(tlsApplicationData, []byte("response")), DirectionServerToClient)
+	proxy.captureData(conn, []byte("GET / HTTP/1.1\r\n\r\n"), DirectionClientToServer)
+
+	captures := proxy.Buffer.GetAll()
+	if len(captures) != 5 {
+		t.Fatalf("Expected 5 captures, got %d", len(captures))
+	}
+	for i, capture := range captures {
+		if capture.DetectedProtocol != "TLS" {
+			t.Errorf("Capture %d: expected the connection's TLS, got %s", i, capture.DetectedProtocol)
+		}
+	}
+
+	// gRPC paths narrow an HTTP/2 connection down from then on
+	h2 := proxy.newConnection(nil, nil)
+	proxy.captureData(h2, []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"), DirectionClientToServer)
+	proxy.captureData(h2, []byte("\x00\x00\x00\x04\x00\x00\x00\x00\x00"), DirectionServerToClient)
+	proxy.captureData(h2, []byte("headers /grpc.health.v1.Health/Check"), DirectionClientToServer)
+	proxy.captureData(h2, []byte("\x00\x00\x05\x00\x01\x00\x00\x00\x01hello"), DirectionServerToClient)
+
+	want := []string{"HTTP/2", "HTTP/2", "gRPC", "gRPC"}
+	captures = proxy.Buffer.GetAll()[5:]
+	for i, capture := range captures {
+		if capture.DetectedProtocol != want[i] {
+			t.Errorf("HTTP/2 capture %d: expected %s, got %s", i, want[i], capture.DetectedProtocol)
+		}
+	}
+}
+
+// TestDetectSkippedOpening tests that reads skipped by capture filters
+// still feed detection, so later captures carry the connection's protocol
+func TestDetectSkippedOpening(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxyWithOptions(19227, "127.0.0.1", 18082, 1024*1024, ProxyOptions{IngestContains: "marker"}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19227)
+	proxy, _ := manager.GetProxy(19227)
+
+	conn := proxy.newConnection(nil, nil)
+	proxy.captureData(conn, []byte("POST /upload HTTP/1.1\r\nContent-Length: 11\r\n\r\n"), DirectionClientToServer)
+	proxy.captureData(conn, []byte("marker body"), DirectionClientToServer)
+
+	captures := proxy.Buffer.GetAll()
+	if len(captures) != 1 || captures[0].DetectedProtocol != "HTTP/1.x" {
+		t.Fatalf("Expected the marker capture labeled HTTP/1.x, got %d captures", len(captures))
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"sync"
+)
+
+// httpErrorStatus is the lowest response status http_capture_errors_only keeps
+const httpErrorStatus = 400
+
+// maxErrorsOnlyHeldBytes bounds the capture bytes held per connection while
+// waiting for responses, so unanswered or non-HTTP traffic can't grow forever
+const maxErrorsOnlyHeldBytes = 1 << 20
+
+// errorsOnlyFilter holds back the captures of each HTTP transaction on a
+// connection until its response status is known. Message boundaries come
+// from the connection's header filters, so responses that start mid-read,
+// status lines split across reads and bodies that look like a status line
+// are all paired correctly. Requests are paired with responses in FIFO order.
+type errorsOnlyFilter struct {
+	unanswered []*errorsTransaction // Transactions whose final response hasn't started, oldest first
+	request    *errorsTransaction   // Transaction the client is sending
+	response   *errorsTransaction   // Transaction the server is answering
+	queue      []*heldCapture       // Captures in the order they were held, for eviction
+	held       int                  // Bytes of the held captures
+	mu         sync.Mutex
+}
+
+// errorsTransaction is one request and its response
+type errorsTransaction struct {
+	captures []*heldCapture // Captures carrying its bytes, held until status is known
+	status   int            // Final response status, 0 until known
+	interim  bool           // The response in progress is an interim 1xx
+}
+
+// heldCapture is a capture that may belong to several transactions when a
+// read spans a message boundary. It is stored once if any of them is an
+// error, and skipped once all of them turn out successful.
+type heldCapture struct {
+	capture *CapturedPacket
+	refs    int  // Transactions it belongs to whose status is unknown
+	held    bool // Counted in the filter's held bytes
+	done    bool // Stored, skipped or evicted
+}
+
+// errorsOnlyResult collects what one call to the filter releases
+type errorsOnlyResult struct {
+	stored  []*CapturedPacket
+	skipped int
+	evicted int
+}
+
+// filter takes the next read of the connection in one direction, with the
+// message events the direction's header filter found in it. capture is nil
+// for a read that isn't stored, which still moves the pairing along.
+func (f *errorsOnlyFilter) filter(capture *CapturedPacket, direction string, events []httpEvent) errorsOnlyResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var r errorsOnlyResult
+	var h *heldCapture
+	if capture != nil {
+		h = &heldCapture{capture: capture}
+	}
+
+	// Bytes before the first message start continue the message in progress
+	if len(events) == 0 || !events[0].start || events[0].offset > 0 {
+		if direction == DirectionClientToServer {
+			f.attach(&r, f.request, h)
+		} else {
+			f.attach(&r, f.response, h)
+		}
+	}
+
+	for _, event := range events {
+		switch {
+		case direction == DirectionClientToServer:
+			if event.start {
+				f.request = &errorsTransaction{}
+				f.unanswered = append(f.unanswered, f.request)
+				f.attach(&r, f.request, h)
+			}
+		case event.start:
+			// A final response answers the oldest unanswered request; one
+			// following an interim response answers the same request
+			if f.response == nil || !f.response.interim {
+				f.response = &errorsTransaction{}
+				if len(f.unanswered) > 0 {
+					f.response = f.unanswered[0]
+					f.unanswered = f.unanswered[1:]
+				}
+			}
+			f.attach(&r, f.response, h)
+		case f.response != nil:
+			f.response.interim = event.status < 200
+			if !f.response.interim {
+				f.resolve(&r, f.response, event.status)
+			}
+		}
+	}
+
+	if h != nil && !h.done {
+		if h.refs == 0 {
+			h.done = true
+			r.skipped++
+		} else {
+			h.held = true
+			f.held += len(h.capture.RawData)
+			f.queue = append(f.queue, h)
+			r.evicted = f.evict()
+		}
+	}
+	return r
+}
+
+// attach adds a capture to a transaction, storing it right away if the
+// transaction is already known to be an error.
+// IMPORTANT: This assumes f.mu is already held by the caller
+func (f *errorsOnlyFilter) attach(r *errorsOnlyResult, t *errorsTransaction, h *heldCapture) {
+	if t == nil || h == nil {
+		return
+	}
+	if t.status != 0 {
+		if t.status >= httpErrorStatus {
+			f.store(r, h)
+		}
+		return
+	}
+	if n := len(t.captures); n > 0 && t.captures[n-1] == h {
+		return
+	}
+	t.captures = append(t.captures, h)
+	h.refs++
+}
+
+// resolve settles a transaction once its final status is known
+// IMPORTANT: This assumes f.mu is already held by the caller
+func (f *errorsOnlyFilter) resolve(r *errorsOnlyResult, t *errorsTransaction, status int) {
+	t.status = status
+	for _, h := range t.captures {
+		h.refs--
+		switch {
+		case status >= httpErrorStatus:
+			f.store(r, h)
+		case h.refs == 0 && !h.done:
+			f.unhold(h)
+			r.skipped++
+		}
+	}
+	t.captures = nil
+}
+
+// store releases a capture to be stored, once
+// IMPORTANT: This assumes f.mu is already held by the caller
+func (f *errorsOnlyFilter) store(r *errorsOnlyResult, h *heldCapture) {
+	if h.done {
+		return
+	}
+	r.stored = append(r.stored, h.capture)
+	f.unhold(h)
+}
+
+// unhold marks a capture done and returns its bytes to the cap
+// IMPORTANT: This assumes f.mu is already held by the caller
+func (f *errorsOnlyFilter) unhold(h *heldCapture) {
+	h.done = true
+	if h.held {
+		h.held = false
+		f.held -= len(h.capture.RawData)
+	}
+	h.capture = nil
+}
+
+// evict drops the oldest held captures until the held bytes fit the cap and
+// returns how many it dropped. Their transactions keep their place so later
+// responses still pair with the right request.
+// IMPORTANT: This assumes f.mu is already held by the caller
+func (f *errorsOnlyFilter) evict() int {
+	dropped := 0
+	for len(f.queue) > 0 && (f.queue[0].done || f.held > maxErrorsOnlyHeldBytes) {
+		if h := f.queue[0]; !h.done {
+			f.unhold(h)
+			dropped++
+		}
+		f.queue = f.queue[1:]
+	}
+	return dropped
+}
+
+// release drops every held capture, whose response will never come, and
+// returns how many it dropped
+func (f *errorsOnlyFilter) release() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dropped := 0
+	for _, h := range f.queue {
+		if !h.done {
+			dropped++
+		}
+	}
+	f.unanswered, f.request, f.response = nil, nil, nil
+	f.queue = nil
+	f.held = 0
+	return dropped
+}
+
+// filterErrorsOnly passes a read through http_capture_errors_only and stores
+// the captures it releases. capture is nil for a read that isn't stored.
+func (p *ProxyInstance) filterErrorsOnly(conn *Connection, capture *CapturedPacket, direction string, events []httpEvent) {
+	if capture == nil && len(events) == 0 {
+		return
+	}
+	r := conn.errorsOnly.filter(capture, direction, events)
+	if r.skipped > 0 || r.evicted > 0 {
+		p.Stats.mu.Lock()
+		p.Stats.NonErrorSkipped += int64(r.skipped)
+		p.Stats.HeldDropped += int64(r.evicted)
+		p.Stats.mu.Unlock()
+	}
+	for _, c := range r.stored {
+		p.addCapture(c)
+	}
+}
+
+// releaseHeldCaptures counts the captures http_capture_errors_only still
+// holds for a closing connection as dropped
+func (p *ProxyInstance) releaseHeldCaptures(conn *Connection) {
+	if dropped := conn.errorsOnly.release(); dropped > 0 {
+		p.Stats.mu.Lock()
+		p.Stats.HeldDropped += int64(dropped)
+		p.Stats.mu.Unlock()
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Eviction policies of a ring buffer under byte or slot pressure
+const (
+	EvictFIFO         = "fifo"          // Oldest first
+	EvictKeepProtocol = "keep_protocol" // Oldest packet of another protocol first
+	EvictKeepLargest  = "keep_largest"  // Smallest payload first, oldest among equals
+)
+
+// evictionPolicy decides which packet a full buffer drops
+type evictionPolicy struct {
+	kind     string
+	protocol string // Protocol kept by keep_protocol
+}
+
+// String renders the policy as accepted by parseEvictionPolicy
+func (e evictionPolicy) String() string {
+	if e.kind == EvictKeepProtocol {
+		return e.kind + ":" + e.protocol
+	}
+	if e.kind == "" {
+		return EvictFIFO
+	}
+	return e.kind
+}
+
+// parseEvictionPolicy parses "fifo", "keep_largest" or "keep_protocol:<name>",
+// where name is a detected protocol such as HTTP/1.x
+func parseEvictionPolicy(s string) (evictionPolicy, error) {
+	switch {
+	case s == "" || s == EvictFIFO:
+		return evictionPolicy{kind: EvictFIFO}, nil
+	case s == EvictKeepLargest:
+		return evictionPolicy{kind: EvictKeepLargest}, nil
+	case strings.HasPrefix(s, EvictKeepProtocol+":"):
+		protocol := strings.TrimPrefix(s, EvictKeepProtocol+":")
+		if protocol == "" {
+			return evictionPolicy{}, fmt.Errorf("keep_protocol needs a protocol, e.g. keep_protocol:HTTP/1.x")
+		}
+		return evictionPolicy{kind: EvictKeepProtocol, protocol: protocol}, nil
+	}
+	return evictionPolicy{}, fmt.Errorf("invalid eviction policy %q (expected fifo, keep_largest or keep_protocol:<name>)", s)
+}
+
+// SetEvictionPolicy sets which packets the buffer drops first when full
+func (rb *RingBuffer) SetEvictionPolicy(policy evictionPolicy) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.policy = policy
+}
+
+// evict drops one packet chosen by the eviction policy. Policies other than
+// FIFO scan the buffer, so eviction costs O(n) under them.
+// IMPORTANT: This assumes the mutex is already held by the caller
+func (rb *RingBuffer) evict() {
+	victim := 0 // Position counted from the oldest packet
+	switch rb.policy.kind {
+	case EvictKeepProtocol:
+		for i := 0; i < rb.count; i++ {
+			if rb.at(i).DetectedProtocol != rb.policy.protocol {
+				victim = i
+				break
+			}
+		}
+	case EvictKeepLargest:
+		for i := 1; i < rb.count; i++ {
+			if len(rb.at(i).RawData) < len(rb.at(victim).RawData) {
+				victim = i
+			}
+		}
+	}
+
+	if victim == 0 {
+		rb.evictOldest()
+		return
+	}
+	rb.removeAt(victim)
+}
+
+// refuses reports whether the policy would rather drop a new packet of the
+// given size than evict a stored one. Under keep_largest a packet no larger
+// than the smallest stored one is refused.
+// IMPORTANT: This assumes the mutex is already held by the caller
+func (rb *RingBuffer) refuses(size int) bool {
+	if rb.policy.kind != EvictKeepLargest || rb.count == 0 {
+		return false
+	}
+	for i := 0; i < rb.count; i++ {
+		if len(rb.at(i).RawData) < size {
+			return false
+		}
+	}
+	return true
+}
+
+// at returns the packet i positions after the oldest
+func (rb *RingBuffer) at(i int) *CapturedPacket {
+	return rb.data[(rb.tail+i)%len(rb.data)]
+}
+
+// removeAt drops the packet i positions after the oldest, shifting the
+// older packets up by one slot to close the gap
+func (rb *RingBuffer) removeAt(i int) {
+	size := len(rb.data)
+	pos := (rb.tail + i) % size
+	rb.currentSize -= len(rb.data[pos].RawData)
+	for ; i > 0; i-- {
+		prev := (pos - 1 + size) % size
+		rb.data[pos] = rb.data[prev]
+		pos = prev
+	}
+	rb.data[rb.tail] = nil
+	rb.tail = (rb.tail + 1) % size
+	rb.count--
+}
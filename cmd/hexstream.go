@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hexStreamTimeFormat is the timestamp layout written before each packet,
+// matching text2pcap -t "%Y-%m-%d %H:%M:%S."
+const hexStreamTimeFormat = "2006-01-02 15:04:05.000000"
+
+// buildHexStream renders captures in the hex dump format read by text2pcap
+// and Wireshark's "Import from Hex Dump". Each packet is preceded by a
+// comment and a line holding the direction (O for client to server, I for
+// server to client) and timestamp; offsets restart at zero for every packet.
+// Captures without stored bytes are skipped; the number written is returned.
+func buildHexStream(captures []*CapturedPacket) (string, int) {
+	var sb strings.Builder
+	packets := 0
+
+	for _, capture := range captures {
+		if len(capture.RawData) == 0 {
+			continue
+		}
+		packets++
+
+		indicator := "I"
+		if capture.Direction == DirectionClientToServer {
+			indicator = "O"
+		}
+		fmt.Fprintf(&sb, "# seq %d conn %d %s (%d bytes", capture.Seq, capture.ConnID, capture.Direction, capture.Bytes)
+		if len(capture.RawData) < capture.Bytes {
+			fmt.Fprintf(&sb, ", %d stored", len(capture.RawData))
+		}
+		sb.WriteString(")\n")
+		fmt.Fprintf(&sb, "%s %s\n", indicator, capture.Timestamp.UTC().Format(hexStreamTimeFormat))
+
+		for offset := 0; offset < len(capture.RawData); offset += 16 {
+			end := min(offset+16, len(capture.RawData))
+			fmt.Fprintf(&sb, "%06x", offset)
+			for _, b := range capture.RawData[offset:end] {
+				fmt.Fprintf(&sb, " %02x", b)
+			}
+			sb.WriteString("\n")
+		}
+		sb.WriteString("\n")
+	}
+
+	return sb.String(), packets
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExportHexStream tests that the export follows text2pcap conventions:
+// a timestamp line before each packet and offsets restarting per packet
+func TestExportHexStream(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19118, "localhost", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19118)
+
+	proxy, _ := manager.GetProxy(19118)
+	first := proxy.newConnection(nil, nil)
+	second := proxy.newConnection(nil, nil)
+	request := []byte("GET /a-long-enough-path HTTP/1.1\r\n\r\n")
+	response := []byte("HTTP/1.1 204 No Content\r\n\r\n")
+	proxy.captureData(first, request, DirectionClientToServer)
+	proxy.captureData(second, []byte("other"), DirectionClientToServer)
+	proxy.captureData(first, response, DirectionServerToClient)
+	// A capture with nothing stored isn't written and mustn't be counted
+	proxy.Buffer.Add(&CapturedPacket{ConnID: first.ID, Direction: DirectionServerToClient, Timestamp: time.Now()})
+
+	result := callTool(t, NewExportHexStreamHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19118),
+		"conn_id":     float64(first.ID),
+	})
+	if result["packets"] != float64(2) {
+		t.Fatalf("Expected 2 packets for connection %d, got %v", first.ID, result["packets"])
+	}
+
+	// Parse the dump the way text2pcap does
+	type packet struct {
+		indicator string
+		data      []byte
+	}
+	var packets []*packet
+	var current *packet
+	for _, line := range strings.Split(result["hexstream"].(string), "\n") {
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case line[0] == 'I' || line[0] == 'O':
+			fields := strings.SplitN(line, " ", 2)
+			if _, err := time.Parse(hexStreamTimeFormat, fields[1]); err != nil {
+				t.Errorf("Bad timestamp line %q: %v", line, err)
+			}
+			current = &packet{indicator: fields[0]}
+			packets = append(packets, current)
+		default:
+			if current == nil {
+				t.Fatalf("Hex line before any timestamp: %q", line)
+			}
+			fields := strings.Fields(line)
+			offset, err := strconv.ParseInt(fields[0], 16, 64)
+			if err != nil || int(offset) != len(current.data) {
+				t.Errorf("Expected offset %x, got %q", len(current.data), fields[0])
+			}
+			chunk, err := hex.DecodeString(strings.Join(fields[1:], ""))
+			if err != nil {
+				t.Fatalf("Bad hex line %q: %v", line, err)
+			}
+			current.data = append(current.data, chunk...)
+		}
+	}
+
+	if len(packets) != 2 {
+		t.Fatalf("Expected 2 packets, parsed %d", len(packets))
+	}
+	if packets[0].indicator != "O" || string(packets[0].data) != string(request) {
+		t.Errorf("Unexpected first packet: %s %q", packets[0].indicator, packets[0].data)
+	}
+	if packets[1].indicator != "I" || string(packets[1].data) != string(response) {
+		t.Errorf("Unexpected second packet: %s %q", packets[1].indicator, packets[1].data)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxProxyHistory bounds how many stopped proxies list_proxy_history keeps
+const maxProxyHistory = 100
+
+// StoppedProxy is the final state of a proxy that has been stopped
+type StoppedProxy struct {
+	ListenPort       int
+	ForwardTo        string
+	ForwardTargets   []string // Weighted backends, empty when unused
+	Label            string
+	BytesCaptured    int64
+	TotalConnections int64
+	StartedAt        time.Time
+	StoppedAt        time.Time
+}
+
+// recordHistoryLocked appends a stopped proxy's final stats to the history,
+// dropping the oldest entry once the history is full
+// IMPORTANT: This assumes pm.mu is already held by the caller
+func (pm *ProxyManager) recordHistoryLocked(proxy *ProxyInstance) {
+	proxy.Stats.mu.RLock()
+	entry := StoppedProxy{
+		ListenPort:       proxy.ListenPort,
+		ForwardTo:        fmt.Sprintf("%s:%d", proxy.ForwardHost, proxy.ForwardPort),
+		Label:            proxy.Label(),
+		BytesCaptured:    proxy.Stats.BytesCaptured,
+		TotalConnections: proxy.Stats.Connections,
+		StartedAt:        proxy.StartedAt,
+		StoppedAt:        time.Now(),
+	}
+	proxy.Stats.mu.RUnlock()
+	for _, target := range proxy.Options.ForwardTargets {
+		entry.ForwardTargets = append(entry.ForwardTargets, target.String())
+	}
+
+	if len(pm.history) == maxProxyHistory {
+		pm.history = pm.history[1:]
+	}
+	pm.history = append(pm.history, entry)
+}
+
+// History returns the stopped proxies, most recently stopped first
+func (pm *ProxyManager) History() []StoppedProxy {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	history := make([]StoppedProxy, 0, len(pm.history))
+	for i := len(pm.history) - 1; i >= 0; i-- {
+		history = append(history, pm.history[i])
+	}
+	return history
+}
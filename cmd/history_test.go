@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestListProxyHistory tests that a stopped proxy is listed with its final
+// stats and that the history is capped
+func TestListProxyHistory(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	response := callTool(t, NewStartProxyHandler(manager).Execute, map[string]interface{}{
+		"listen_port":  float64(19185),
+		"forward_host": "127.0.0.1",
+		"forward_port": float64(backendPort),
+		"label":        "history",
+	})
+	if response["error"] != nil {
+		t.Fatalf("Failed to start proxy: %v", response)
+	}
+
+	client, err := net.Dial("tcp", "127.0.0.1:19185")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	client.Write([]byte("hello"))
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(client, make([]byte, 5)); err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+	client.Close()
+
+	callTool(t, NewStopProxyHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19185),
+	})
+
+	result := callTool(t, NewListProxyHistoryHandler(manager).Execute, map[string]interface{}{})
+	history := result["history"].([]interface{})
+	if len(history) != 1 {
+		t.Fatalf("Expected 1 stopped proxy, got %v", result)
+	}
+	entry := history[0].(map[string]interface{})
+	expected := map[string]interface{}{
+		"listen_port":       float64(19185),
+		"forward_to":        "127.0.0.1:" + strconv.Itoa(backendPort),
+		"label":             "history",
+		"bytes_captured":    float64(10),
+		"total_connections": float64(1),
+	}
+	for field, want := range expected {
+		if entry[field] != want {
+			t.Errorf("Expected %s %v, got %v", field, want, entry[field])
+		}
+	}
+	if duration, _ := entry["duration_ms"].(float64); duration < 0 {
+		t.Errorf("Expected a non-negative duration, got %v", entry["duration_ms"])
+	}
+
+	// Only the most recent stops are kept, newest first
+	for i := 0; i < maxProxyHistory+5; i++ {
+		manager.mu.Lock()
+		manager.recordHistoryLocked(&ProxyInstance{ListenPort: 20000 + i, Stats: &ProxyStats{}})
+		manager.mu.Unlock()
+	}
+	stopped := manager.History()
+	if len(stopped) != maxProxyHistory {
+		t.Fatalf("Expected history capped at %d, got %d", maxProxyHistory, len(stopped))
+	}
+	if stopped[0].ListenPort != 20000+maxProxyHistory+4 {
+		t.Errorf("Expected the latest stop first, got port %d", stopped[0].ListenPort)
+	}
+
+	limited := callTool(t, NewListProxyHistoryHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19185),
+	})
+	if limited["count"] != float64(0) {
+		t.Errorf("Expected the oldest entry to have been dropped, got %v", limited)
+	}
+}
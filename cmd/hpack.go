@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// hpackDefaultTableSize is the initial dynamic table size of RFC 7541
+const hpackDefaultTableSize = 4096
+
+// HPACKField is a decoded HTTP/2 header field
+type HPACKField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// size is the field's size for dynamic table accounting (RFC 7541 Section 4.1)
+func (f HPACKField) size() int {
+	return len(f.Name) + len(f.Value) + 32
+}
+
+// hpackDecoder decodes header blocks for one direction of a connection.
+// The dynamic table persists across blocks, so every block of the
+// direction must be decoded in order.
+type hpackDecoder struct {
+	dynamic []HPACKField // Newest entry first
+	size    int          // Current dynamic table size
+	maxSize int          // Maximum dynamic table size
+}
+
+// newHPACKDecoder creates a decoder with the default table size
+func newHPACKDecoder() *hpackDecoder {
+	return &hpackDecoder{maxSize: hpackDefaultTableSize}
+}
+
+// decode decodes a complete header block
+func (d *hpackDecoder) decode(block []byte) ([]HPACKField, error) {
+	var fields []HPACKField
+
+	for len(block) > 0 {
+		b := block[0]
+		switch {
+		case b&0x80 != 0:
+			// Indexed header field
+			index, rest, err := hpackReadInt(block, 7)
+			if err != nil {
+				return fields, err
+			}
+			field, err := d.lookup(index)
+			if err != nil {
+				return fields, err
+			}
+			fields = append(fields, field)
+			block = rest
+
+		case b&0xc0 == 0x40:
+			// Literal with incremental indexing
+			field, rest, err := d.readLiteral(block, 6)
+			if err != nil {
+				return fields, err
+			}
+			d.add(field)
+			fields = append(fields, field)
+			block = rest
+
+		case b&0xe0 == 0x20:
+			// Dynamic table size update
+			size, rest, err := hpackReadInt(block, 5)
+			if err != nil {
+				return fields, err
+			}
+			d.maxSize = int(size)
+			d.evict()
+			block = rest
+
+		default:
+			// Literal without indexing (0000) or never indexed (0001)
+			field, rest, err := d.readLiteral(block, 4)
+			if err != nil {
+				return fields, err
+			}
+			fields = append(fields, field)
+			block = rest
+		}
+	}
+
+	return fields, nil
+}
+
+// lookup returns the field at a 1-based index into the static then dynamic table
+func (d *hpackDecoder) lookup(index uint64) (HPACKField, error) {
+	if index == 0 {
+		return HPACKField{}, errors.New("hpack: invalid index 0")
+	}
+	if index <= uint64(len(hpackStaticTable)) {
+		return hpackStaticTable[index-1], nil
+	}
+	dynamicIndex := index - uint64(len(hpackStaticTable)) - 1
+	if dynamicIndex >= uint64(len(d.dynamic)) {
+		return HPACKField{}, fmt.Errorf("hpack: index %d out of range", index)
+	}
+	return d.dynamic[dynamicIndex], nil
+}
+
+// readLiteral reads a literal field whose name index uses prefixBits bits
+func (d *hpackDecoder) readLiteral(block []byte, prefixBits uint8) (HPACKField, []byte, error) {
+	nameIndex, rest, err := hpackReadInt(block, prefixBits)
+	if err != nil {
+		return HPACKField{}, nil, err
+	}
+
+	var field HPACKField
+	if nameIndex > 0 {
+		indexed, err := d.lookup(nameIndex)
+		if err != nil {
+			return HPACKField{}, nil, err
+		}
+		field.Name = indexed.Name
+	} else {
+		if field.Name, rest, err = hpackReadString(rest); err != nil {
+			return HPACKField{}, nil, err
+		}
+	}
+
+	if field.Value, rest, err = hpackReadString(rest); err != nil {
+		return HPACKField{}, nil, err
+	}
+	return field, rest, nil
+}
+
+// add inserts a field into the dynamic table, evicting old entries as needed
+func (d *hpackDecoder) add(field HPACKField) {
+	d.dynamic = append([]HPACKField{field}, d.dynamic...)
+	d.size += field.size()
+	d.evict()
+}
+
+// evict drops the oldest entries until the table fits maxSize
+func (d *hpackDecoder) evict() {
+	for d.size > d.maxSize && len(d.dynamic) > 0 {
+		oldest := d.dynamic[len(d.dynamic)-1]
+		d.dynamic = d.dynamic[:len(d.dynamic)-1]
+		d.size -= oldest.size()
+	}
+}
+
+// hpackReadInt reads an integer with an N-bit prefix (RFC 7541 Section 5.1)
+func hpackReadInt(data []byte, prefixBits uint8) (uint64, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, errors.New("hpack: truncated integer")
+	}
+	mask := uint64(1)<<prefixBits - 1
+	value := uint64(data[0]) & mask
+	data = data[1:]
+	if value < mask {
+		return value, data, nil
+	}
+
+	for shift := uint(0); ; shift += 7 {
+		if len(data) == 0 {
+			return 0, nil, errors.New("hpack: truncated integer")
+		}
+		if shift > 56 {
+			return 0, nil, errors.New("hpack: integer overflow")
+		}
+		b := data[0]
+		data = data[1:]
+		value += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return value, data, nil
+		}
+	}
+}
+
+// hpackReadString reads a string literal, Huffman decoding it if flagged
+func hpackReadString(data []byte) (string, []byte, error) {
+	if len(data) == 0 {
+		return "", nil, errors.New("hpack: truncated string")
+	}
+	huffman := data[0]&0x80 != 0
+	length, rest, err := hpackReadInt(data, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(rest)) < length {
+		return "", nil, errors.New("hpack: truncated string")
+	}
+
+	raw := rest[:length]
+	rest = rest[length:]
+	if !huffman {
+		return string(raw), rest, nil
+	}
+	decoded, err := hpackHuffmanDecode(raw)
+	return decoded, rest, err
+}
+
+// hpackHuffmanSymbols maps (bit length << 32 | code) to the decoded symbol
+var hpackHuffmanSymbols = func() map[uint64]byte {
+	symbols := make(map[uint64]byte, 256)
+	for sym, code := range hpackHuffmanCodes {
+		symbols[uint64(hpackHuffmanCodeLen[sym])<<32|uint64(code)] = byte(sym)
+	}
+	return symbols
+}()
+
+// hpackHuffmanDecode decodes a Huffman encoded string bit by bit
+func hpackHuffmanDecode(data []byte) (string, error) {
+	var out []byte
+	var code uint64
+	var bits uint64
+
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			code = code<<1 | uint64(b>>uint(i)&1)
+			bits++
+			if sym, ok := hpackHuffmanSymbols[bits<<32|code]; ok {
+				out = append(out, sym)
+				code, bits = 0, 0
+			} else if bits > 30 {
+				return "", errors.New("hpack: invalid Huffman code")
+			}
+		}
+	}
+
+	// Padding must be fewer than 8 bits of the EOS prefix (all ones)
+	if bits > 7 || code != uint64(1)<<bits-1 {
+		return "", errors.New("hpack: invalid Huffman padding")
+	}
+	return string(out), nil
+}
+
+// hpackStaticTable is the static table of RFC 7541 Appendix A
+var hpackStaticTable = []HPACKField{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// hpackHuffmanCodes are the Huffman codes of RFC 7541 Appendix B, indexed by symbol
+var hpackHuffmanCodes = [256]uint32{
+	0x1ff8, 0x7fffd8, 0xfffffe2, 0xfffffe3, 0xfffffe4, 0xfffffe5, 0xfffffe6, 0xfffffe7,
+	0xfffffe8, 0xffffea, 0x3ffffffc, 0xfffffe9, 0xfffffea, 0x3ffffffd, 0xfffffeb, 0xfffffec,
+	0xfffffed, 0xfffffee, 0xfffffef, 0xffffff0, 0xffffff1, 0xffffff2, 0x3ffffffe, 0xffffff3,
+	0xffffff4, 0xffffff5, 0xffffff6, 0xffffff7, 0xffffff8, 0xffffff9, 0xffffffa, 0xffffffb,
+	0x14, 0x3f8, 0x3f9, 0xffa, 0x1ff9, 0x15, 0xf8, 0x7fa,
+	0x3fa, 0x3fb, 0xf9, 0x7fb, 0xfa, 0x16, 0x17, 0x18,
+	0x0, 0x1, 0x2, 0x19, 0x1a, 0x1b, 0x1c, 0x1d,
+	0x1e, 0x1f, 0x5c, 0xfb, 0x7ffc, 0x20, 0xffb, 0x3fc,
+	0x1ffa, 0x21, 0x5d, 0x5e, 0x5f, 0x60, 0x61, 0x62,
+	0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a,
+	0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70, 0x71, 0x72,
+	0xfc, 0x73, 0xfd, 0x1ffb, 0x7fff0, 0x1ffc, 0x3ffc, 0x22,
+	0x7ffd, 0x3, 0x23, 0x4, 0x24, 0x5, 0x25, 0x26,
+	0x27, 0x6, 0x74, 0x75, 0x28, 0x29, 0x2a, 0x7,
+	0x2b, 0x76, 0x2c, 0x8, 0x9, 0x2d, 0x77, 0x78,
+	0x79, 0x7a, 0x7b, 0x7ffe, 0x7fc, 0x3ffd, 0x1ffd, 0xffffffc,
+	0xfffe6, 0x3fffd2, 0xfffe7, 0xfffe8, 0x3fffd3, 0x3fffd4, 0x3fffd5, 0x7fffd9,
+	0x3fffd6, 0x7fffda, 0x7fffdb, 0x7fffdc, 0x7fffdd, 0x7fffde, 0xffffeb, 0x7fffdf,
+	0xffffec, 0xffffed, 0x3fffd7, 0x7fffe0, 0xffffee, 0x7fffe1, 0x7fffe2, 0x7fffe3,
+	0x7fffe4, 0x1fffdc, 0x3fffd8, 0x7fffe5, 0x3fffd9, 0x7fffe6, 0x7fffe7, 0xffffef,
+	0x3fffda, 0x1fffdd, 0xfffe9, 0x3fffdb, 0x3fffdc, 0x7fffe8, 0x7fffe9, 0x1fffde,
+	0x7fffea, 0x3fffdd, 0x3fffde, 0xfffff0, 0x1fffdf, 0x3fffdf, 0x7fffeb, 0x7fffec,
+	0x1fffe0, 0x1fffe1, 0x3fffe0, 0x1fffe2, 0x7fffed, 0x3fffe1, 0x7fffee, 0x7fffef,
+	0xfffea, 0x3fffe2, 0x3fffe3, 0x3fffe4, 0x7ffff0, 0x3fffe5, 0x3fffe6, 0x7ffff1,
+	0x3ffffe0, 0x3ffffe1, 0xfffeb, 0x7fff1, 0x3fffe7, 0x7ffff2, 0x3fffe8, 0x1ffffec,
+	0x3ffffe2, 0x3ffffe3, 0x3ffffe4, 0x7ffffde, 0x7ffffdf, 0x3ffffe5, 0xfffff1, 0x1ffffed,
+	0x7fff2, 0x1fffe3, 0x3ffffe6, 0x7ffffe0, 0x7ffffe1, 0x3ffffe7, 0x7ffffe2, 0xfffff2,
+	0x1fffe4, 0x1fffe5, 0x3ffffe8, 0x3ffffe9, 0xffffffd, 0x7ffffe3, 0x7ffffe4, 0x7ffffe5,
+	0xfffec, 0xfffff3, 0xfffed, 0x1fffe6, 0x3fffe9, 0x1fffe7, 0x1fffe8, 0x7ffff3,
+	0x3fffea, 0x3fffeb, 0x1ffffee, 0x1ffffef, 0xfffff4, 0xfffff5, 0x3ffffea, 0x7ffff4,
+	0x3ffffeb, 0x7ffffe6, 0x3ffffec, 0x3ffffed, 0x7ffffe7, 0x7ffffe8, 0x7ffffe9, 0x7ffffea,
+	0x7ffffeb, 0xffffffe, 0x7ffffec, 0x7ffffed, 0x7ffffee, 0x7ffffef, 0x7fffff0, 0x3ffffee,
+}
+
+// hpackHuffmanCodeLen are the bit lengths of hpackHuffmanCodes
+var hpackHuffmanCodeLen = [256]uint8{
+	13, 23, 28, 28, 28, 28, 28, 28, 28, 24, 30, 28, 28, 30, 28, 28,
+	28, 28, 28, 28, 28, 28, 30, 28, 28, 28, 28, 28, 28, 28, 28, 28,
+	6, 10, 10, 12, 13, 6, 8, 11, 10, 10, 8, 11, 8, 6, 6, 6,
+	5, 5, 5, 6, 6, 6, 6, 6, 6, 6, 7, 8, 15, 6, 12, 10,
+	13, 6, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7,
+	7, 7, 7, 7, 7, 7, 7, 7, 8, 7, 8, 13, 19, 13, 14, 6,
+	15, 5, 6, 5, 6, 5, 6, 6, 6, 5, 7, 7, 6, 6, 6, 5,
+	6, 7, 6, 5, 5, 6, 7, 7, 7, 7, 7, 15, 11, 14, 13, 28,
+	20, 22, 20, 20, 22, 22, 22, 23, 22, 23, 23, 23, 23, 23, 24, 23,
+	24, 24, 22, 23, 24, 23, 23, 23, 23, 21, 22, 23, 22, 23, 23, 24,
+	22, 21, 20, 22, 22, 23, 23, 21, 23, 22, 22, 24, 21, 22, 23, 23,
+	21, 21, 22, 21, 23, 22, 23, 23, 20, 22, 22, 22, 23, 22, 22, 23,
+	26, 26, 20, 19, 22, 23, 22, 25, 26, 26, 26, 27, 27, 26, 24, 25,
+	19, 21, 26, 27, 27, 26, 27, 24, 21, 21, 26, 26, 28, 27, 27, 27,
+	20, 24, 20, 21, 22, 21, 21, 23, 22, 22, 25, 25, 24, 24, 26, 23,
+	26, 27, 26, 26, 27, 27, 27, 27, 27, 28, 27, 27, 27, 27, 27, 26,
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPRequestSummary describes a parsed HTTP request
+type HTTPRequestSummary struct {
+	Method    string              `json:"method"`
+	URI       string              `json:"uri"`
+	Proto     string              `json:"proto"`
+	Host      string              `json:"host,omitempty"`
+	Headers   map[string][]string `json:"headers"`
+	BodyBytes int64               `json:"body_bytes"`
+	Timestamp time.Time           `json:"timestamp"`
+}
+
+// HTTPResponseSummary describes a parsed HTTP response
+type HTTPResponseSummary struct {
+	StatusCode int                 `json:"status_code"`
+	Status     string              `json:"status"`
+	Proto      string              `json:"proto"`
+	Headers    map[string][]string `json:"headers"`
+	BodyBytes  int64               `json:"body_bytes"`
+	Timestamp  time.Time           `json:"timestamp"`
+	Chunked    *HTTPChunkedBody    `json:"chunked,omitempty"` // Set for Transfer-Encoding: chunked
+}
+
+// HTTPChunkedBody describes a chunked body with the chunk framing removed
+type HTTPChunkedBody struct {
+	ChunkCount    int     `json:"chunk_count"`
+	ChunkSizes    []int64 `json:"chunk_sizes"`
+	Body          string  `json:"body"` // Dechunked body as printable text
+	BodyTruncated bool    `json:"body_truncated,omitempty"`
+	Complete      bool    `json:"complete"` // The terminating zero-size chunk was seen
+}
+
+// HTTPTransaction pairs a request with the response that followed it on
+// the same connection
+type HTTPTransaction struct {
+	ConnID     uint64               `json:"conn_id"`
+	Request    *HTTPRequestSummary  `json:"request"`
+	Response   *HTTPResponseSummary `json:"response,omitempty"`
+	DurationMs *float64             `json:"duration_ms,omitempty"`
+}
+
+// captureStream is one direction of a connection reassembled from captures
+type captureStream struct {
+	data    []byte
+	offsets []int       // Starting offset of each capture within data
+	times   []time.Time // Timestamp of each capture
+}
+
+// append adds a capture's payload to the stream
+func (s *captureStream) append(packet *CapturedPacket) {
+	s.offsets = append(s.offsets, len(s.data))
+	s.times = append(s.times, packet.Timestamp)
+	s.data = append(s.data, packet.RawData...)
+}
+
+// timeAt returns the timestamp of the capture containing the given offset
+func (s *captureStream) timeAt(offset int) time.Time {
+	i := sort.Search(len(s.offsets), func(i int) bool { return s.offsets[i] > offset }) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(s.times) {
+		return time.Time{}
+	}
+	return s.times[i]
+}
+
+// connectionStreams holds both directions of a reassembled connection
+type connectionStreams struct {
+	connID         uint64
+	clientToServer captureStream
+	serverToClient captureStream
+}
+
+// reassembleStreams groups captures by connection, preserving first-seen order
+func reassembleStreams(captures []*CapturedPacket) []*connectionStreams {
+	var order []*connectionStreams
+	byID := make(map[uint64]*connectionStreams)
+
+	for _, capture := range captures {
+		conn, exists := byID[capture.ConnID]
+		if !exists {
+			conn = &connectionStreams{connID: capture.ConnID}
+			byID[capture.ConnID] = conn
+			order = append(order, conn)
+		}
+
+		if capture.Direction == DirectionClientToServer {
+			conn.clientToServer.append(capture)
+		} else {
+			conn.serverToClient.append(capture)
+		}
+	}
+
+	return order
+}
+
+// extractHTTPTransactions parses the captures of each connection as HTTP/1.x
+// and pairs requests with responses in order. Connections whose client
+// stream does not start with an HTTP request are omitted.
+func extractHTTPTransactions(captures []*CapturedPacket) []HTTPTransaction {
+	var transactions []HTTPTransaction
+
+	for _, conn := range reassembleStreams(captures) {
+		transactions = append(transactions, parseConnectionHTTP(conn)...)
+	}
+
+	return transactions
+}
+
+// readChunkedBody reads a chunked body and its trailer from r, returning
+// the chunk sizes and the dechunked body. A body cut short returns what was
+// read along with the error.
+func readChunkedBody(r *bufio.Reader) (*HTTPChunkedBody, []byte, error) {
+	chunked := &HTTPChunkedBody{ChunkSizes: []int64{}}
+	var body bytes.Buffer
+	finish := func(err error) (*HTTPChunkedBody, []byte, error) {
+		chunked.ChunkCount = len(chunked.ChunkSizes)
+		chunked.Body, chunked.BodyTruncated = printableText(body.Bytes())
+		return chunked, body.Bytes(), err
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return finish(err)
+		}
+		// Drop chunk extensions
+		sizeText, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeText), 16, 64)
+		if err != nil || size < 0 {
+			return finish(fmt.Errorf("invalid chunk size %q", sizeText))
+		}
+
+		if size == 0 {
+			// Skip trailer fields up to the blank line
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return finish(err)
+				}
+				if strings.TrimSpace(line) == "" {
+					chunked.Complete = true
+					return finish(nil)
+				}
+			}
+		}
+
+		// Copy rather than allocate size up front, which may be bogus
+		if _, err := io.CopyN(&body, r, size); err != nil {
+			return finish(err)
+		}
+		chunked.ChunkSizes = append(chunked.ChunkSizes, size)
+
+		// The data must be followed by CRLF, or the size was wrong
+		var crlf [2]byte
+		if _, err := io.ReadFull(r, crlf[:]); err != nil {
+			return finish(err)
+		}
+		if crlf != [2]byte{'\r', '\n'} {
+			return finish(fmt.Errorf("missing CRLF after %d byte chunk, got %q", size, crlf[:]))
+		}
+	}
+}
+
+// parseConnectionHTTP parses the transactions of a single connection
+func parseConnectionHTTP(conn *connectionStreams) []HTTPTransaction {
+	var transactions []HTTPTransaction
+	var requests []*http.Request
+
+	// Parse requests from the client stream
+	client := &conn.clientToServer
+	reqReader := bytes.NewReader(client.data)
+	reqBuf := bufio.NewReader(reqReader)
+	for {
+		start := len(client.data) - reqReader.Len() - reqBuf.Buffered()
+		req, err := http.ReadRequest(reqBuf)
+		if err != nil {
+			break
+		}
+		bodyBytes, err := io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+
+		requests = append(requests, req)
+		transactions = append(transactions, HTTPTransaction{
+			ConnID: conn.connID,
+			Request: &HTTPRequestSummary{
+				Method:    req.Method,
+				URI:       req.RequestURI,
+				Proto:     req.Proto,
+				Host:      req.Host,
+				Headers:   req.Header,
+				BodyBytes: bodyBytes,
+				Timestamp: client.timeAt(start),
+			},
+		})
+
+		if err != nil {
+			break // Body was cut short, nothing more to parse
+		}
+	}
+
+	// Pair responses with requests in FIFO order
+	server := &conn.serverToClient
+	respReader := bytes.NewReader(server.data)
+	respBuf := bufio.NewReader(respReader)
+	for i := range transactions {
+		start := len(server.data) - respReader.Len() - respBuf.Buffered()
+		resp, err := http.ReadResponse(respBuf, requests[i])
+		if err != nil {
+			break
+		}
+
+		// Chunked bodies are read directly so the chunk sizes are kept
+		var bodyBytes int64
+		var chunked *HTTPChunkedBody
+		if len(resp.TransferEncoding) > 0 && resp.TransferEncoding[0] == "chunked" && resp.Body != http.NoBody {
+			var body []byte
+			chunked, body, err = readChunkedBody(respBuf)
+			bodyBytes = int64(len(body))
+		} else {
+			bodyBytes, err = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		txn := &transactions[i]
+		txn.Response = &HTTPResponseSummary{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Proto:      resp.Proto,
+			Headers:    resp.Header,
+			BodyBytes:  bodyBytes,
+			Timestamp:  server.timeAt(start),
+			Chunked:    chunked,
+		}
+		duration := float64(txn.Response.Timestamp.Sub(txn.Request.Timestamp)) / float64(time.Millisecond)
+		txn.DurationMs = &duration
+
+		if err != nil {
+			break
+		}
+	}
+
+	return transactions
+}
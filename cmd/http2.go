@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"sync"
+)
+
+// http2Preface is the client connection preface that starts every HTTP/2 connection
+var http2Preface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+// HTTP/2 frame types (RFC 9113 Section 6)
+const (
+	http2FrameData         = 0x0
+	http2FrameHeaders      = 0x1
+	http2FrameRSTStream    = 0x3
+	http2FrameSettings     = 0x4
+	http2FramePushPromise  = 0x5
+	http2FrameContinuation = 0x9
+)
+
+// HTTP/2 frame flags
+const (
+	http2FlagEndStream  = 0x1
+	http2FlagEndHeaders = 0x4
+	http2FlagPadded     = 0x8
+	http2FlagPriority   = 0x20
+)
+
+// http2FrameHeaderBytes is the size of the fixed frame header
+const http2FrameHeaderBytes = 9
+
+// http2FrameTypeNames maps frame types to their RFC names
+var http2FrameTypeNames = []string{
+	"DATA", "HEADERS", "PRIORITY", "RST_STREAM", "SETTINGS",
+	"PUSH_PROMISE", "PING", "GOAWAY", "WINDOW_UPDATE", "CONTINUATION",
+}
+
+// HTTP2FrameSummary describes one HTTP/2 frame within a capture
+type HTTP2FrameSummary struct {
+	Type        uint8                `json:"type"`
+	TypeName    string               `json:"type_name"`
+	Flags       uint8                `json:"flags"`
+	StreamID    uint32               `json:"stream_id"`
+	Length      int                  `json:"length"`
+	Headers     []HPACKField         `json:"headers,omitempty"`      // Decoded once the header block is complete
+	HeaderError string               `json:"header_error,omitempty"` // Set if HPACK decoding failed
+	Messages    []GRPCMessageSummary `json:"grpc_messages,omitempty"`
+
+	block uint64 // Header block the frame belongs to, for redaction
+}
+
+// http2Fragment is where a read holds bytes of a header block
+type http2Fragment struct {
+	start, end int
+	block      uint64
+	open       bool // The block continues past the read
+}
+
+// http2Frame is a parsed frame header along with its payload
+type http2Frame struct {
+	HTTP2FrameSummary
+	payload []byte
+}
+
+// newHTTP2FrameSummary summarizes a frame from its 9 byte header
+func newHTTP2FrameSummary(header []byte) HTTP2FrameSummary {
+	summary := HTTP2FrameSummary{
+		Type:     header[3],
+		Flags:    header[4],
+		StreamID: binary.BigEndian.Uint32(header[5:9]) & 0x7fffffff,
+		Length:   int(header[0])<<16 | int(header[1])<<8 | int(header[2]),
+	}
+	if int(summary.Type) < len(http2FrameTypeNames) {
+		summary.TypeName = http2FrameTypeNames[summary.Type]
+	} else {
+		summary.TypeName = "UNKNOWN"
+	}
+	return summary
+}
+
+// parseHTTP2FrameHeaders splits data into complete HTTP/2 frames. It reports
+// false if data does not look like a sequence of frames.
+func parseHTTP2FrameHeaders(data []byte) ([]http2Frame, bool) {
+	var frames []http2Frame
+	for len(data) > 0 {
+		if len(data) < http2FrameHeaderBytes {
+			return nil, false
+		}
+		summary := newHTTP2FrameSummary(data)
+		if summary.Type > http2FrameContinuation || len(data) < http2FrameHeaderBytes+summary.Length {
+			return nil, false
+		}
+		frames = append(frames, http2Frame{
+			HTTP2FrameSummary: summary,
+			payload:           data[http2FrameHeaderBytes : http2FrameHeaderBytes+summary.Length],
+		})
+		data = data[http2FrameHeaderBytes+summary.Length:]
+	}
+	return frames, true
+}
+
+// streamParserState tracks whether a direction has been recognised as
+// speaking the protocol a stream parser understands
+type streamParserState int
+
+const (
+	parserUndecided streamParserState = iota // Waiting for enough bytes to tell
+	parserActive                             // Parsing
+	parserInactive                           // Another protocol, ignore
+)
+
+// http2StreamParser follows the frames of one direction of a connection.
+// Frames may span reads, so partial frames are carried over between calls,
+// and the HPACK dynamic table persists for the life of the connection.
+// gRPC messages are followed per stream across DATA frames and reads.
+type http2StreamParser struct {
+	client  bool // Client side, which starts with the connection preface
+	state   streamParserState
+	pending []byte // Undecided bytes, or the incomplete frame being assembled
+	skip    int    // Payload bytes of the current frame still to skip
+	block   []byte // Header block fragments waiting for END_HEADERS
+	data    *http2DataFrame
+
+	blockSeq  uint64 // Numbers header blocks so their fragments can be told apart
+	blockOpen bool   // Whether block blockSeq is still waiting for END_HEADERS
+
+	grpc  map[uint32]*grpcStreamReader // Streams whose headers declare gRPC
+	hpack *hpackDecoder
+	mu    sync.Mutex // Injected bytes are parsed from another goroutine
+}
+
+// http2DataFrame is a DATA frame whose payload is being read
+type http2DataFrame struct {
+	summary HTTP2FrameSummary
+	left    int // Payload bytes still to read, padding included
+	pad     int // Trailing padding bytes, -1 until the pad length is read
+	offset  int // Data bytes read so far, excluding padding
+}
+
+// newHTTP2StreamParser creates the parser for one direction
+func newHTTP2StreamParser(client bool) *http2StreamParser {
+	return &http2StreamParser{client: client, hpack: newHPACKDecoder(), grpc: make(map[uint32]*grpcStreamReader)}
+}
+
+// parse consumes the next bytes of the direction and returns a summary of
+// each frame whose header ends within them. DATA frames are summarized once
+// their payload has been read, along with the gRPC messages they carry.
+// It also returns where the read holds header block bytes, so they can be
+// scrubbed when redaction is on.
+func (p *http2StreamParser) parse(data []byte) (summaries []HTTP2FrameSummary, fragments []http2Fragment) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	input := data
+	if p.state == parserUndecided {
+		data = p.detect(data)
+	}
+	if p.state != parserActive {
+		return nil, nil
+	}
+
+	// Fragments of a block that goes on past this read are marked open
+	defer func() {
+		for i := range fragments {
+			fragments[i].open = p.blockOpen && fragments[i].block == p.blockSeq
+		}
+	}()
+
+	for {
+		if p.data != nil {
+			var done bool
+			if data, done = p.readData(data); !done {
+				return summaries, fragments
+			}
+			summaries = append(summaries, p.data.summary)
+			p.data = nil
+			continue
+		}
+		if p.skip > 0 {
+			n := min(p.skip, len(data))
+			p.skip -= n
+			data = data[n:]
+		}
+		if len(data) == 0 && len(p.pending) < http2FrameHeaderBytes {
+			return summaries, fragments
+		}
+
+		// Assemble the frame header
+		if len(p.pending) < http2FrameHeaderBytes {
+			n := min(http2FrameHeaderBytes-len(p.pending), len(data))
+			p.pending = append(p.pending, data[:n]...)
+			data = data[n:]
+			if len(p.pending) < http2FrameHeaderBytes {
+				return summaries, fragments
+			}
+		}
+		summary := newHTTP2FrameSummary(p.pending)
+
+		if summary.Type == http2FrameData {
+			p.data = &http2DataFrame{summary: summary, left: summary.Length}
+			if summary.Flags&http2FlagPadded != 0 {
+				p.data.pad = -1
+			}
+			p.pending = p.pending[:0]
+			continue
+		}
+		if summary.Type == http2FrameRSTStream {
+			delete(p.grpc, summary.StreamID)
+		}
+
+		// Only header blocks need their payload, everything else is skipped
+		if summary.Type != http2FrameHeaders && summary.Type != http2FramePushPromise && summary.Type != http2FrameContinuation {
+			summaries = append(summaries, summary)
+			p.pending = p.pending[:0]
+			p.skip = summary.Length
+			continue
+		}
+
+		if len(p.pending) == http2FrameHeaderBytes && summary.Type != http2FrameContinuation {
+			p.blockSeq++
+			p.blockOpen = true
+		}
+		n := min(http2FrameHeaderBytes+summary.Length-len(p.pending), len(data))
+		if n > 0 {
+			offset := len(input) - len(data)
+			fragments = append(fragments, http2Fragment{start: offset, end: offset + n, block: p.blockSeq})
+		}
+		p.pending = append(p.pending, data[:n]...)
+		data = data[n:]
+		if len(p.pending) < http2FrameHeaderBytes+summary.Length {
+			return summaries, fragments
+		}
+		p.headerBlock(&summary, p.pending[http2FrameHeaderBytes:])
+		summary.block = p.blockSeq
+		if summary.Flags&http2FlagEndHeaders != 0 {
+			p.blockOpen = false
+		}
+		if summary.Type == http2FrameHeaders && summary.Flags&http2FlagEndStream != 0 {
+			delete(p.grpc, summary.StreamID)
+		}
+		summaries = append(summaries, summary)
+		p.pending = p.pending[:0]
+	}
+}
+
+// readData consumes payload bytes of the DATA frame being read, following
+// the gRPC messages of its stream. It returns the bytes left over and
+// whether the frame is complete.
+func (p *http2StreamParser) readData(data []byte) ([]byte, bool) {
+	f := p.data
+	if f.pad < 0 && f.left > 0 {
+		if len(data) == 0 {
+			return data, false
+		}
+		f.pad = min(int(data[0]), f.left-1)
+		f.left--
+		data = data[1:]
+	}
+
+	n := min(f.left-max(f.pad, 0), len(data))
+	if stream := p.grpc[f.summary.StreamID]; stream != nil && n > 0 {
+		f.summary.Messages = append(f.summary.Messages, stream.read(data[:n], f.offset)...)
+	}
+	f.offset += n
+	f.left -= n
+	data = data[n:]
+
+	n = min(f.left, len(data))
+	f.left -= n
+	data = data[n:]
+	if f.left > 0 {
+		return data, false
+	}
+
+	if stream := p.grpc[f.summary.StreamID]; stream != nil {
+		if last := len(f.summary.Messages) - 1; last >= 0 && stream.remaining > 0 {
+			f.summary.Messages[last].Incomplete = true
+		}
+		if f.summary.Flags&http2FlagEndStream != 0 {
+			delete(p.grpc, f.summary.StreamID)
+		}
+	}
+	return data, true
+}
+
+// detect decides whether the direction speaks HTTP/2 and returns the bytes
+// left to parse. Clients must start with the connection preface; servers
+// must start with a SETTINGS frame on stream 0.
+func (p *http2StreamParser) detect(data []byte) []byte {
+	if p.client {
+		n := min(len(http2Preface)-len(p.pending), len(data))
+		p.pending = append(p.pending, data[:n]...)
+		switch {
+		case !bytes.HasPrefix(http2Preface, p.pending):
+			p.state = parserInactive
+			p.pending = nil
+		case len(p.pending) == len(http2Preface):
+			p.state = parserActive
+			p.pending = p.pending[:0]
+		}
+		return data[n:]
+	}
+
+	// The server's first frame header stays in pending to be parsed normally
+	n := min(http2FrameHeaderBytes-len(p.pending), len(data))
+	p.pending = append(p.pending, data[:n]...)
+	if len(p.pending) == http2FrameHeaderBytes {
+		summary := newHTTP2FrameSummary(p.pending)
+		if summary.Type == http2FrameSettings && summary.StreamID == 0 && summary.Length%6 == 0 {
+			p.state = parserActive
+		} else {
+			p.state = parserInactive
+			p.pending = nil
+		}
+	}
+	return data[n:]
+}
+
+// headerBlock collects a header block fragment and decodes the block once
+// END_HEADERS is seen
+func (p *http2StreamParser) headerBlock(summary *HTTP2FrameSummary, payload []byte) {
+	if summary.Type != http2FrameContinuation {
+		if summary.Flags&http2FlagPadded != 0 {
+			if len(payload) == 0 || int(payload[0]) >= len(payload) {
+				summary.HeaderError = "invalid padding"
+				return
+			}
+			payload = payload[1 : len(payload)-int(payload[0])]
+		}
+		skip := 0
+		if summary.Type == http2FrameHeaders && summary.Flags&http2FlagPriority != 0 {
+			skip = 5 // Stream dependency and weight
+		} else if summary.Type == http2FramePushPromise {
+			skip = 4 // Promised stream ID
+		}
+		if len(payload) < skip {
+			summary.HeaderError = "frame too short"
+			return
+		}
+		p.block = append(p.block[:0], payload[skip:]...)
+	} else {
+		p.block = append(p.block, payload...)
+	}
+
+	if summary.Flags&http2FlagEndHeaders == 0 {
+		return
+	}
+	fields, err := p.hpack.decode(p.block)
+	summary.Headers = fields
+	if err != nil {
+		summary.HeaderError = err.Error()
+	}
+	p.block = p.block[:0]
+
+	for _, field := range fields {
+		if field.Name == "content-type" && strings.HasPrefix(field.Value, "application/grpc") && p.grpc[summary.StreamID] == nil {
+			p.grpc[summary.StreamID] = &grpcStreamReader{}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// TestHTTP2FrameParsing tests frame summaries and HPACK decoding for a
+// captured preface + SETTINGS + HEADERS sequence
+func TestHTTP2FrameParsing(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19112, "localhost", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19112)
+
+	proxy, _ := manager.GetProxy(19112)
+	conn := proxy.newConnection(nil, nil)
+
+	// Header blocks from RFC 7541 C.4.1 and C.4.2; the second one refers to
+	// the dynamic table entry added by the first
+	first, _ := hex.DecodeString("828684418cf1e3c2e5f23a6ba0ab90f4ff")
+	second, _ := hex.DecodeString("828684be5886a8eb10649cbf")
+
+	var client []byte
+	client = append(client, http2Preface...)
+	client = append(client, http2FrameBytes(http2FrameSettings, 0, 0, []byte{0, 3, 0, 0, 0, 100})...)
+	client = append(client, http2FrameBytes(http2FrameHeaders, http2FlagEndHeaders, 1, first)...)
+	client = append(client, http2FrameBytes(http2FrameHeaders, http2FlagEndHeaders, 3, second)...)
+
+	// Split mid-preface and mid-frame to exercise carrying state across reads
+	proxy.captureData(conn, client[:10], DirectionClientToServer)
+	proxy.captureData(conn, client[10:50], DirectionClientToServer)
+	proxy.captureData(conn, client[50:], DirectionClientToServer)
+
+	var server []byte
+	server = append(server, http2FrameBytes(http2FrameSettings, 0, 0, nil)...)
+	server = append(server, http2FrameBytes(http2FrameHeaders, http2FlagEndHeaders, 1, []byte{0x88})...)
+	server = append(server, http2FrameBytes(http2FrameData, 0x1, 1, []byte("hello"))...)
+	proxy.captureData(conn, server, DirectionServerToClient)
+
+	var frames []HTTP2FrameSummary
+	for _, capture := range proxy.Buffer.GetAll() {
+		frames = append(frames, capture.HTTP2Frames...)
+	}
+
+	expected := []struct {
+		typeName string
+		streamID uint32
+		headers  []HPACKField
+	}{
+		{"SETTINGS", 0, nil},
+		{"HEADERS", 1, []HPACKField{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}}},
+		{"HEADERS", 3, []HPACKField{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}, {"cache-control", "no-cache"}}},
+		{"SETTINGS", 0, nil},
+		{"HEADERS", 1, []HPACKField{{":status", "200"}}},
+		{"DATA", 1, nil},
+	}
+	if len(frames) != len(expected) {
+		t.Fatalf("Expected %d frames, got %d: %+v", len(expected), len(frames), frames)
+	}
+	for i, want := range expected {
+		got := frames[i]
+		if got.TypeName != want.typeName || got.StreamID != want.streamID {
+			t.Errorf("Frame %d: expected %s on stream %d, got %s on stream %d", i, want.typeName, want.streamID, got.TypeName, got.StreamID)
+		}
+		if got.HeaderError != "" {
+			t.Errorf("Frame %d: unexpected header error %q", i, got.HeaderError)
+		}
+		if len(got.Headers) != len(want.headers) {
+			t.Errorf("Frame %d: expected headers %v, got %v", i, want.headers, got.Headers)
+			continue
+		}
+		for j := range want.headers {
+			if got.Headers[j] != want.headers[j] {
+				t.Errorf("Frame %d header %d: expected %v, got %v", i, j, want.headers[j], got.Headers[j])
+			}
+		}
+	}
+}
+
+// TestHTTP2IgnoresOtherProtocols tests that non-HTTP/2 traffic yields no frames
+func TestHTTP2IgnoresOtherProtocols(t *testing.T) {
+	client := newHTTP2StreamParser(true)
+	if frames, _ := client.parse([]byte("GET / HTTP/1.1\r\n\r\n")); frames != nil {
+		t.Errorf("Expected no frames for HTTP/1.1 request, got %v", frames)
+	}
+
+	server := newHTTP2StreamParser(false)
+	if frames, _ := server.parse([]byte("HTTP/1.1 200 OK\r\n\r\n")); frames != nil {
+		t.Errorf("Expected no frames for HTTP/1.1 response, got %v", frames)
+	}
+}
+
+// TestHTTP2RedactHeaderBlocks tests that the HPACK bytes of a header block
+// with a redacted field are masked in the stored data, along with bytes of a
+// block that is still open at the end of a read
+func TestHTTP2RedactHeaderBlocks(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxyWithOptions(19225, "localhost", 18082, 1024*1024, ProxyOptions{Redact: true}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19225)
+
+	proxy, _ := manager.GetProxy(19225)
+	conn := proxy.newConnection(nil, nil)
+
+	// authorization: Bearer secret, a literal with the static table name
+	secret := append([]byte{0x82, 0x0f, 0x08, 0x0d}, "Bearer secret"...)
+	first := append([]byte(nil), http2Preface...)
+	first = append(first, http2FrameBytes(http2FrameHeaders, http2FlagEndHeaders, 1, secret)...)
+	first = append(first, http2FrameBytes(http2FrameHeaders, 0, 3, []byte{0x82})...)
+	proxy.captureData(conn, first, DirectionClientToServer)
+	proxy.captureData(conn, http2FrameBytes(http2FrameContinuation, http2FlagEndHeaders, 3, []byte{0x84}), DirectionClientToServer)
+
+	captures := proxy.Buffer.GetAll()
+	if len(captures) != 2 {
+		t.Fatalf("Expected 2 captures, got %d", len(captures))
+	}
+	stored := captures[0].RawData
+	if bytes.Contains(stored, []byte("secret")) {
+		t.Errorf("Expected the HPACK bytes of the redacted block masked, got %q", stored)
+	}
+	if open := stored[len(stored)-1]; open != '*' {
+		t.Errorf("Expected the open block's bytes masked, got %#x", open)
+	}
+	if value := captures[0].HTTP2Frames[0].Headers[1].Value; value != strings.Repeat("*", len("Bearer secret")) {
+		t.Errorf("Expected the decoded value masked, got %q", value)
+	}
+	if last := captures[1].RawData; last[len(last)-1] != 0x84 {
+		t.Errorf("Expected the block without secrets left alone, got %q", last)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestGetProxyOutputHTTPView tests that a request/response pair is returned
+// as a single parsed transaction
+func TestGetProxyOutputHTTPView(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19095, "localhost", 18083, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19095)
+
+	proxy, _ := manager.GetProxy(19095)
+	conn := proxy.newConnection(nil, nil)
+
+	// Request split across two reads, response body in a separate read
+	proxy.captureData(conn, []byte("GET /api/items?id=7 HTTP/1.1\r\nHost: example.com\r\n"), DirectionClientToServer)
+	proxy.captureData(conn, []byte("User-Agent: test\r\n\r\n"), DirectionClientToServer)
+	time.Sleep(5 * time.Millisecond)
+	proxy.captureData(conn, []byte("HTTP/1.1 404 Not Found\r\nContent-Type: text/plain\r\nContent-Length: 9\r\n\r\n"), DirectionServerToClient)
+	proxy.captureData(conn, []byte("not found"), DirectionServerToClient)
+
+	// A non-HTTP connection must be omitted
+	other := proxy.newConnection(nil, nil)
+	proxy.captureData(other, []byte{0x00, 0x01, 0x02, 0x03}, DirectionClientToServer)
+
+	response := callTool(t, NewGetProxyOutputHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19095),
+		"view":        "http",
+	})
+
+	proxies := response["proxies"].([]interface{})
+	result := proxies[0].(map[string]interface{})
+	if _, ok := result["captures"]; ok {
+		t.Error("HTTP view should not include packet captures")
+	}
+
+	transactions := result["transactions"].([]interface{})
+	if len(transactions) != 1 {
+		t.Fatalf("Expected 1 transaction, got %d", len(transactions))
+	}
+
+	txn := transactions[0].(map[string]interface{})
+	if txn["conn_id"] != float64(conn.ID) {
+		t.Errorf("Expected conn_id %d, got %v", conn.ID, txn["conn_id"])
+	}
+
+	req := txn["request"].(map[string]interface{})
+	if req["method"] != "GET" || req["uri"] != "/api/items?id=7" || req["host"] != "example.com" {
+		t.Errorf("Unexpected request summary: %v", req)
+	}
+	if ua := req["headers"].(map[string]interface{})["User-Agent"]; ua == nil || ua.([]interface{})[0] != "test" {
+		t.Errorf("Expected User-Agent header, got %v", ua)
+	}
+
+	resp := txn["response"].(map[string]interface{})
+	if resp["status_code"] != float64(404) || resp["body_bytes"] != float64(9) {
+		t.Errorf("Unexpected response summary: %v", resp)
+	}
+
+	if duration, ok := txn["duration_ms"].(float64); !ok || duration <= 0 {
+		t.Errorf("Expected positive duration_ms, got %v", txn["duration_ms"])
+	}
+}
+
+// TestHTTPChunkedResponse tests that a chunked response split across
+// captures is dechunked, with its chunk sizes reported, and that the next
+// response on the connection still parses
+func TestHTTPChunkedResponse(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+	if err := manager.StartProxy(19209, "localhost", 18083, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	proxy, _ := manager.GetProxy(19209)
+	conn := proxy.newConnection(nil, nil)
+
+	proxy.captureData(conn, []byte("GET /a HTTP/1.1\r\nHost: example.com\r\n\r\nGET /b HTTP/1.1\r\nHost: example.com\r\n\r\n"), DirectionClientToServer)
+	proxy.captureData(conn, []byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel"), DirectionServerToClient)
+	proxy.captureData(conn, []byte("lo\r\n7;ext=1\r\n, world\r\n1\r\n!\r\n0\r\nX-Trailer: t\r\n\r\n"), DirectionServerToClient)
+	proxy.captureData(conn, []byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"), DirectionServerToClient)
+
+	response := callTool(t, NewGetProxyOutputHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19209),
+		"view":        "http",
+	})
+	transactions := response["proxies"].([]interface{})[0].(map[string]interface{})["transactions"].([]interface{})
+	if len(transactions) != 2 {
+		t.Fatalf("Expected 2 transactions, got %d", len(transactions))
+	}
+
+	resp := transactions[0].(map[string]interface{})["response"].(map[string]interface{})
+	if resp["body_bytes"] != float64(13) {
+		t.Errorf("Expected 13 dechunked body bytes, got %v", resp["body_bytes"])
+	}
+	chunked, ok := resp["chunked"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected chunked details, got %v", resp)
+	}
+	if chunked["body"] != "hello, world!" || chunked["chunk_count"] != float64(3) || chunked["complete"] != true {
+		t.Errorf("Unexpected chunked details: %v", chunked)
+	}
+	sizes := chunked["chunk_sizes"].([]interface{})
+	if len(sizes) != 3 || sizes[0] != float64(5) || sizes[1] != float64(7) || sizes[2] != float64(1) {
+		t.Errorf("Expected chunk sizes [5 7 1], got %v", sizes)
+	}
+
+	// The response after the trailer is found, and has no chunked details
+	next := transactions[1].(map[string]interface{})["response"].(map[string]interface{})
+	if next["body_bytes"] != float64(2) || next["chunked"] != nil {
+		t.Errorf("Unexpected second response: %v", next)
+	}
+}
+
+// TestReadChunkedBody tests that a chunk whose data isn't followed by CRLF
+// is reported as an error instead of being silently realigned
+func TestReadChunkedBody(t *testing.T) {
+	chunked, body, err := readChunkedBody(bufio.NewReader(strings.NewReader("3\r\nabc\r\n2\r\nde\r\n0\r\n\r\n")))
+	if err != nil || string(body) != "abcde" || !chunked.Complete {
+		t.Errorf("Expected a complete body \"abcde\", got %q complete=%v (%v)", body, chunked.Complete, err)
+	}
+
+	// The declared size is one short, leaving "d\r" where CRLF belongs
+	chunked, body, err = readChunkedBody(bufio.NewReader(strings.NewReader("3\r\nabcd\r\n0\r\n\r\n")))
+	if err == nil || chunked.Complete || string(body) != "abc" {
+		t.Errorf("Expected a framing error after \"abc\", got %q complete=%v (%v)", body, chunked.Complete, err)
+	}
+}
+
+// TestGetTransactions tests that two sequential transactions on one
+// connection are paired in order with their latencies
+func TestGetTransactions(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+	if err := manager.StartProxy(19210, "localhost", 18083, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	proxy, _ := manager.GetProxy(19210)
+	conn := proxy.newConnection(nil, nil)
+
+	proxy.captureData(conn, []byte("GET /first HTTP/1.1\r\nHost: example.com\r\n\r\n"), DirectionClientToServer)
+	time.Sleep(20 * time.Millisecond)
+	proxy.captureData(conn, []byte("HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\na"), DirectionServerToClient)
+	proxy.captureData(conn, []byte("POST /second HTTP/1.1\r\nHost: example.com\r\nContent-Length: 0\r\n\r\n"), DirectionClientToServer)
+	time.Sleep(40 * time.Millisecond)
+	proxy.captureData(conn, []byte("HTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n"), DirectionServerToClient)
+
+	// Another connection is filtered out by conn_id
+	other := proxy.newConnection(nil, nil)
+	proxy.captureData(other, []byte("GET /other HTTP/1.1\r\nHost: example.com\r\n\r\n"), DirectionClientToServer)
+
+	result := callTool(t, NewGetTransactionsHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19210),
+		"conn_id":     float64(conn.ID),
+	})
+	if result["total_transactions"] != float64(2) || result["unanswered"] != float64(0) {
+		t.Fatalf("Expected 2 answered transactions, got %v", result)
+	}
+
+	transactions := result["transactions"].([]interface{})
+	for i, want := range []struct {
+		uri    string
+		status float64
+		minMs  float64
+	}{
+		{"/first", 200, 20},
+		{"/second", 201, 40},
+	} {
+		txn := transactions[i].(map[string]interface{})
+		req := txn["request"].(map[string]interface{})
+		resp := txn["response"].(map[string]interface{})
+		if req["uri"] != want.uri || resp["status_code"] != want.status {
+			t.Errorf("Transaction %d: expected %s -> %v, got %v -> %v", i, want.uri, want.status, req["uri"], resp["status_code"])
+		}
+		if ms := txn["duration_ms"].(float64); ms < want.minMs || ms > want.minMs+1000 {
+			t.Errorf("Transaction %d: expected latency of about %vms, got %v", i, want.minMs, ms)
+		}
+	}
+
+	// Without conn_id the other connection's request is unanswered
+	result = callTool(t, NewGetTransactionsHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19210),
+	})
+	if result["total_transactions"] != float64(3) || result["unanswered"] != float64(1) {
+		t.Errorf("Expected 3 transactions with 1 unanswered, got %v", result)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxHeaderBytes bounds how much of a message header block is buffered for
+// parsing the framing headers
+const maxHeaderBytes = 64 * 1024
+
+// httpFilterMode is the parsing state of one direction of an HTTP connection
+type httpFilterMode int
+
+const (
+	httpModeStart       httpFilterMode = iota // Expecting the start of a message
+	httpModeHeaders                           // Inside the header block
+	httpModeBody                              // Inside a body of known length
+	httpModeChunkSize                         // Expecting a chunk size line
+	httpModeChunkData                         // Inside a chunk and its trailing CRLF
+	httpModeTrailers                          // Inside the trailers after the last chunk
+	httpModeUntilNext                         // Inside a close-delimited body, or chunk framing was lost
+	httpModePassthrough                       // Not HTTP, keep everything
+)
+
+// httpMethodPrefixes are the request line prefixes recognised as HTTP/1.x
+var httpMethodPrefixes = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("DELETE "),
+	[]byte("HEAD "), []byte("OPTIONS "), []byte("PATCH "), []byte("CONNECT "),
+	[]byte("TRACE "),
+}
+
+// isHTTPMessageStart reports whether data begins an HTTP/1.x request or response
+func isHTTPMessageStart(data []byte) bool {
+	if bytes.HasPrefix(data, []byte("HTTP/1.")) {
+		return true
+	}
+	for _, prefix := range httpMethodPrefixes {
+		if bytes.HasPrefix(data, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPartialMessageStart reports whether data is too short to tell if it
+// begins an HTTP/1.x request or response
+func isPartialMessageStart(data []byte) bool {
+	if len(data) < len("HTTP/1.") && bytes.HasPrefix([]byte("HTTP/1."), data) {
+		return true
+	}
+	return isPartialRequestStart(data)
+}
+
+// httpHeaderFilter strips message bodies from one direction of an HTTP/1.x
+// connection, keeping header blocks intact even when they span several reads.
+// With a body limit the first bodyLimit bytes of each body are kept as well.
+type httpHeaderFilter struct {
+	mode      httpFilterMode
+	header    []byte // Header bytes of the current message seen so far
+	partial   []byte // Bytes of a message start too short to recognise yet
+	line      []byte // Chunk size or trailer line seen so far
+	remaining int64  // Body bytes left in httpModeBody or httpModeChunkData
+	started   bool   // Whether any message has been seen
+	methods   *httpMethodQueue
+
+	bodyLimit int64 // Body bytes kept per message (0 = headers only)
+	bodyKept  int64 // Body bytes of the current message kept so far
+
+	// Body sizes of the messages whose size became known in the latest
+	// filter call: the Content-Length where a header block ends, or the sum
+	// of the chunk sizes where a chunked body ends
+	lengths     []int64
+	chunkedSize int64 // Chunk sizes of the current chunked body so far
+
+	// Message starts and header block ends seen in the latest filter call
+	events []httpEvent
+}
+
+// httpEvent marks a message boundary within one filtered read
+type httpEvent struct {
+	offset int  // Position in the read
+	start  bool // A message starts at offset; otherwise its header block ends there
+	status int  // Response status code when a response header block ends
+}
+
+// httpMethodQueue records request methods so responses to HEAD requests are
+// known to carry no body. It is shared by both directions of a connection.
+type httpMethodQueue struct {
+	methods []string
+	mu      sync.Mutex
+}
+
+func (q *httpMethodQueue) push(method string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.methods = append(q.methods, method)
+}
+
+func (q *httpMethodQueue) pop() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.methods) == 0 {
+		return ""
+	}
+	method := q.methods[0]
+	q.methods = q.methods[1:]
+	return method
+}
+
+// filter returns the parts of data that belong to header blocks, and the
+// start of each body up to the body limit
+func (f *httpHeaderFilter) filter(data []byte) []byte {
+	return f.filterFrom(data, data)
+}
+
+// filterFrom parses data like filter but returns the kept parts from src,
+// a copy of data of the same length with some bytes masked
+func (f *httpHeaderFilter) filterFrom(data, src []byte) []byte {
+	var kept []byte
+	keep := func(start, end int) {
+		kept = append(kept, src[start:end]...)
+	}
+	f.lengths = f.lengths[:0]
+	f.events = f.events[:0]
+
+	pos := 0
+	for pos < len(data) {
+		rest := data[pos:]
+		switch f.mode {
+		case httpModeStart:
+			opening := rest
+			if len(f.partial) > 0 {
+				opening = append(f.partial, rest...)
+			}
+			if !isHTTPMessageStart(opening) {
+				f.partial = nil
+				if isPartialMessageStart(opening) {
+					// Too short to tell, decide once more bytes arrive
+					f.partial = append([]byte(nil), opening...)
+					keep(pos, len(data))
+					return kept
+				}
+				if !f.started {
+					// The connection does not speak HTTP/1.x, leave it alone
+					f.mode = httpModePassthrough
+					continue
+				}
+				// Unexpected bytes between messages, keep them for debugging
+				keep(pos, len(data))
+				return kept
+			}
+			f.started = true
+			f.header = append(f.header[:0], f.partial...)
+			f.partial = nil
+			f.mode = httpModeHeaders
+			f.events = append(f.events, httpEvent{offset: pos, start: true})
+
+		case httpModeHeaders:
+			// The terminator may straddle reads, so search the tail of the
+			// header seen so far together with the new data
+			overlap := len(f.header)
+			if overlap > 3 {
+				overlap = 3
+			}
+			window := append(append([]byte(nil), f.header[len(f.header)-overlap:]...), rest...)
+			idx := bytes.Index(window, []byte("\r\n\r\n"))
+			if idx < 0 {
+				keep(pos, len(data))
+				if len(f.header) < maxHeaderBytes {
+					f.header = append(f.header, rest...)
+				}
+				return kept
+			}
+
+			end := idx + 4 - overlap
+			keep(pos, pos+end)
+			f.header = append(f.header, rest[:end]...)
+			pos += end
+			f.events = append(f.events, httpEvent{offset: pos, status: f.startBody()})
+
+		case httpModeBody, httpModeChunkData:
+			skip := min(int64(len(rest)), f.remaining)
+			f.remaining -= skip
+			f.keepBody(keep, pos, pos+int(skip))
+			pos += int(skip)
+			if f.remaining == 0 {
+				if f.mode == httpModeBody {
+					f.mode = httpModeStart
+				} else {
+					f.mode = httpModeChunkSize
+				}
+			}
+
+		case httpModeChunkSize, httpModeTrailers:
+			end := bytes.IndexByte(rest, '\n')
+			if end < 0 {
+				f.keepBody(keep, pos, len(data))
+				if len(f.line)+len(rest) > maxHeaderBytes {
+					f.mode = httpModeUntilNext
+				} else {
+					f.line = append(f.line, rest...)
+				}
+				return kept
+			}
+			f.keepBody(keep, pos, pos+end+1)
+			line := bytes.TrimSuffix(append(f.line, rest[:end]...), []byte("\r"))
+			f.line = f.line[:0]
+			pos += end + 1
+
+			if f.mode == httpModeTrailers {
+				if len(line) == 0 {
+					f.mode = httpModeStart
+				}
+				continue
+			}
+			sizeField, _, _ := bytes.Cut(line, []byte(";"))
+			size, err := strconv.ParseInt(strings.TrimSpace(string(sizeField)), 16, 64)
+			switch {
+			case err != nil || size < 0:
+				// Framing lost, fall back to resyncing on a message start
+				f.mode = httpModeUntilNext
+			case size == 0:
+				f.mode = httpModeTrailers
+				f.lengths = append(f.lengths, f.chunkedSize)
+			default:
+				f.mode = httpModeChunkData
+				f.remaining = size + 2
+				f.chunkedSize += size
+			}
+
+		case httpModeUntilNext:
+			// Without framing we resync on the next read that starts a message
+			if isHTTPMessageStart(rest) {
+				f.mode = httpModeStart
+				continue
+			}
+			f.keepBody(keep, pos, len(data))
+			return kept
+
+		case httpModePassthrough:
+			keep(pos, len(data))
+			return kept
+		}
+	}
+
+	return kept
+}
+
+// keepBody keeps as much of the body bytes from start to end as the current
+// message's body limit still allows
+func (f *httpHeaderFilter) keepBody(keep func(start, end int), start, end int) {
+	n := min(int64(end-start), f.bodyLimit-f.bodyKept)
+	if n <= 0 {
+		return
+	}
+	f.bodyKept += n
+	keep(start, start+int(n))
+}
+
+// startBody inspects the completed header block and selects the body mode.
+// It returns the status code of a response, or 0 for a request.
+func (f *httpHeaderFilter) startBody() int {
+	f.bodyKept = 0
+
+	lines := strings.Split(string(f.header), "\r\n")
+	startLine := lines[0]
+
+	noBody := false
+	code := 0
+	if strings.HasPrefix(startLine, "HTTP/1.") {
+		// Responses to HEAD and 1xx/204/304 responses never carry a body.
+		// Interim 1xx responses do not complete the pending request.
+		if fields := strings.Fields(startLine); len(fields) >= 2 {
+			code, _ = strconv.Atoi(fields[1])
+		}
+		if (code >= 100 && code < 200) || code == 204 || code == 304 {
+			noBody = true
+		}
+		if code >= 200 && f.methods.pop() == "HEAD" {
+			noBody = true
+		}
+	} else if fields := strings.Fields(startLine); len(fields) > 0 {
+		f.methods.push(fields[0])
+	}
+
+	contentLength := int64(-1)
+	chunked := false
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "content-length":
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+				contentLength = n
+			}
+		case "transfer-encoding":
+			chunked = strings.Contains(strings.ToLower(value), "chunked")
+		}
+	}
+
+	if !noBody && !chunked && contentLength >= 0 {
+		f.lengths = append(f.lengths, contentLength)
+	}
+
+	isRequest := !strings.HasPrefix(startLine, "HTTP/1.")
+	switch {
+	case noBody:
+		f.mode = httpModeStart
+	case chunked:
+		f.mode = httpModeChunkSize
+		f.line = f.line[:0]
+		f.chunkedSize = 0
+	case contentLength > 0:
+		f.mode = httpModeBody
+		f.remaining = contentLength
+	case contentLength == 0 || isRequest:
+		// Requests without framing headers have no body
+		f.mode = httpModeStart
+	default:
+		// Responses without framing run until the connection closes
+		f.mode = httpModeUntilNext
+	}
+	return code
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestHTTPHeadersOnlyCapture tests that bodies are dropped from stored
+// captures but still counted in stats
+func TestHTTPHeadersOnlyCapture(t *testing.T) {
+	manager := NewProxyManager()
+	err := manager.StartProxyWithOptions(19098, "localhost", 18085, 1024*1024, ProxyOptions{HTTPHeadersOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19098)
+
+	proxy, _ := manager.GetProxy(19098)
+	conn := proxy.newConnection(nil, nil)
+
+	body := strings.Repeat("x", 5000)
+	reads := []string{
+		// Header block split mid-terminator across reads, body follows in the same read
+		"POST /upload HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5000\r",
+		"\n\r\n" + body[:1000],
+		body[1000:],
+		// A second request on the same connection
+		"GET /next HTTP/1.1\r\nHost: example.com\r\n\r\n",
+	}
+
+	total := 0
+	for _, read := range reads {
+		proxy.captureData(conn, []byte(read), DirectionClientToServer)
+		total += len(read)
+	}
+
+	var stored strings.Builder
+	for _, capture := range proxy.Buffer.GetAll() {
+		stored.Write(capture.RawData)
+	}
+
+	want := "POST /upload HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5000\r\n\r\n" +
+		"GET /next HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	if stored.String() != want {
+		t.Errorf("Stored bytes mismatch:\ngot:  %q\nwant: %q", stored.String(), want)
+	}
+
+	proxy.Stats.mu.RLock()
+	bytesCaptured := proxy.Stats.BytesCaptured
+	proxy.Stats.mu.RUnlock()
+	if bytesCaptured != int64(total) {
+		t.Errorf("Expected %d bytes counted in stats, got %d", total, bytesCaptured)
+	}
+}
+
+// TestHTTPHeadersOnlyCaptureToggle tests that body bytes read while capture
+// is off still advance the filter, so the next request is framed correctly
+func TestHTTPHeadersOnlyCaptureToggle(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxyWithOptions(19216, "localhost", 18085, 1024*1024, ProxyOptions{HTTPHeadersOnly: true}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19216)
+
+	proxy, _ := manager.GetProxy(19216)
+	conn := proxy.newConnection(nil, nil)
+	setCapture := func(enabled bool) {
+		callTool(t, NewSetCaptureHandler(manager).Execute, map[string]interface{}{
+			"listen_port": float64(19216),
+			"enabled":     enabled,
+		})
+	}
+
+	header := "POST /upload HTTP/1.1\r\nContent-Length: 20\r\n\r\n"
+	proxy.captureData(conn, []byte(header+"abcde"), DirectionClientToServer)
+	setCapture(false)
+	proxy.captureData(conn, []byte("GET /x HTTP/1.0"), DirectionClientToServer)
+	setCapture(true)
+	next := "GET /next HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	proxy.captureData(conn, []byte(next), DirectionClientToServer)
+
+	var stored strings.Builder
+	for _, capture := range proxy.Buffer.GetAll() {
+		stored.Write(capture.RawData)
+	}
+	if stored.String() != header+next {
+		t.Errorf("Stored bytes mismatch:\ngot:  %q\nwant: %q", stored.String(), header+next)
+	}
+}
+
+// TestHTTPHeaderFilterResponses tests body stripping for responses,
+// including responses to HEAD requests which carry no body
+func TestHTTPHeaderFilterResponses(t *testing.T) {
+	methods := &httpMethodQueue{}
+	requests := &httpHeaderFilter{methods: methods}
+	responses := &httpHeaderFilter{methods: methods}
+
+	requests.filter([]byte("HEAD / HTTP/1.1\r\nHost: a\r\n\r\nGET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+
+	headResp := "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n"
+	getResp := "HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\n"
+	kept := responses.filter([]byte(headResp + getResp + "body"))
+
+	if string(kept) != headResp+getResp {
+		t.Errorf("Unexpected kept bytes: %q", kept)
+	}
+
+	// Non-HTTP connections are passed through untouched
+	raw := &httpHeaderFilter{methods: &httpMethodQueue{}}
+	if kept := raw.filter([]byte{0x16, 0x03, 0x01}); len(kept) != 3 {
+		t.Errorf("Expected non-HTTP bytes to be kept, got %d bytes", len(kept))
+	}
+}
+
+// TestHTTPHeaderFilterFraming tests that a message starting after the last
+// chunk of a chunked body in the same read is found, and that a first read
+// too short to recognise is held open instead of passed through
+func TestHTTPHeaderFilterFraming(t *testing.T) {
+	methods := &httpMethodQueue{}
+	requests := &httpHeaderFilter{methods: methods}
+	responses := &httpHeaderFilter{methods: methods}
+
+	if kept := requests.filter([]byte("GE")); string(kept) != "GE" || requests.mode == httpModePassthrough {
+		t.Fatalf("Expected a short first read kept and undecided, got %q in mode %d", kept, requests.mode)
+	}
+	request := "T / HTTP/1.1\r\nHost: a\r\n\r\n"
+	if kept := requests.filter([]byte(request)); string(kept) != request {
+		t.Errorf("Expected the rest of the request kept, got %q", kept)
+	}
+	if len(requests.events) != 2 || !requests.events[0].start {
+		t.Errorf("Expected the request start and header end, got %+v", requests.events)
+	}
+
+	head := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"
+	if kept := responses.filter([]byte(head + "5\r\nhel")); string(kept) != head {
+		t.Errorf("Expected only the header block kept, got %q", kept)
+	}
+	next := "HTTP/1.1 500 Oops\r\nContent-Length: 0\r\n\r\n"
+	tail := "lo\r\n0\r\nX-Trailer: 1\r\n\r\n"
+	if kept := responses.filter([]byte(tail + next)); string(kept) != next {
+		t.Errorf("Expected the response after the last chunk kept, got %q", kept)
+	}
+	events := responses.events
+	if len(events) != 2 || events[0].offset != len(tail) || events[1].status != 500 {
+		t.Errorf("Expected the second response found after the trailers, got %+v", events)
+	}
+}
+
+// TestHTTPMaxBodyBytes tests that response bodies are capped in stored
+// captures while headers are kept whole and the declared length recorded
+func TestHTTPMaxBodyBytes(t *testing.T) {
+	manager := NewProxyManager()
+	response := callTool(t, NewStartProxyHandler(manager).Execute, map[string]interface{}{
+		"listen_port":         float64(19188),
+		"forward_host":        "localhost",
+		"forward_port":        float64(18085),
+		"http_max_body_bytes": float64(100),
+	})
+	if response["error"] != nil {
+		t.Fatalf("Failed to start proxy: %v", response)
+	}
+	defer manager.StopProxy(19188)
+	proxy, _ := manager.GetProxy(19188)
+	conn := proxy.newConnection(nil, nil)
+
+	header := "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 50000\r\n\r\n"
+	body := strings.Repeat("y", 50000)
+	proxy.captureData(conn, []byte("GET /big HTTP/1.1\r\nHost: example.com\r\n\r\n"), DirectionClientToServer)
+	chunked := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n4\r\ndefg\r\n0\r\n\r\n"
+	stream := header + body + "HTTP/1.1 204 No Content\r\n\r\n" + chunked
+	for i := 0; i < len(stream); i += 4096 {
+		proxy.captureData(conn, []byte(stream[i:min(i+4096, len(stream))]), DirectionServerToClient)
+	}
+
+	var stored strings.Builder
+	var lengths []int64
+	filtered, truncated := false, false
+	for _, capture := range proxy.Buffer.GetAll() {
+		if capture.Direction != DirectionServerToClient {
+			continue
+		}
+		stored.Write(capture.RawData)
+		lengths = append(lengths, capture.HTTPContentLengths...)
+		filtered = filtered || capture.BodyFiltered
+		truncated = truncated || capture.Truncated
+	}
+
+	if want := header + body[:100] + "HTTP/1.1 204 No Content\r\n\r\n" + chunked; stored.String() != want {
+		t.Errorf("Expected headers with a 100-byte body stored, got %d bytes: %.200q", stored.Len(), stored.String())
+	}
+	if len(lengths) != 2 || lengths[0] != 50000 || lengths[1] != 7 {
+		t.Errorf("Expected the declared length 50000 and the chunked size 7 recorded, got %v", lengths)
+	}
+	if !filtered || truncated {
+		t.Errorf("Expected captures marked body_filtered but not truncated, got %v and %v", filtered, truncated)
+	}
+
+	proxy.Stats.mu.RLock()
+	counted := proxy.Stats.BytesCaptured
+	proxy.Stats.mu.RUnlock()
+	if want := int64(len(stream) + len("GET /big HTTP/1.1\r\nHost: example.com\r\n\r\n")); counted != want {
+		t.Errorf("Expected all %d bytes counted, got %d", want, counted)
+	}
+}
+
+// TestHTTPCaptureErrorsOnly tests that only the transaction answered with
+// an error status is stored, while the successful one is counted
+func TestHTTPCaptureErrorsOnly(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+	if err := manager.StartProxyWithOptions(19211, "localhost", 18083, 1024*1024, ProxyOptions{HTTPErrorsOnly: true}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	proxy, _ := manager.GetProxy(19211)
+	conn := proxy.newConnection(nil, nil)
+
+	// Pipelined requests, answered in order
+	proxy.captureData(conn, []byte("GET /ok HTTP/1.1\r\nHost: example.com\r\n\r\n"), DirectionClientToServer)
+	proxy.captureData(conn, []byte("GET /fail HTTP/1.1\r\nHost: example.com\r\n\r\n"), DirectionClientToServer)
+	if captures := proxy.Buffer.GetAll(); len(captures) != 0 {
+		t.Fatalf("Expected requests to be held until their response, got %d captures", len(captures))
+	}
+	proxy.captureData(conn, []byte("HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\n"), DirectionServerToClient)
+	proxy.captureData(conn, []byte("fine"), DirectionServerToClient)
+	proxy.captureData(conn, []byte("HTTP/1.1 500 Internal Server Error\r\nContent-Length: 4\r\n\r\n"), DirectionServerToClient)
+	proxy.captureData(conn, []byte("oops"), DirectionServerToClient)
+
+	captures := proxy.Buffer.GetAll()
+	want := []string{"GET /fail", "HTTP/1.1 500", "oops"}
+	if len(captures) != len(want) {
+		t.Fatalf("Expected %d captures of the failed transaction, got %d", len(want), len(captures))
+	}
+	for i, prefix := range want {
+		if !bytes.HasPrefix(captures[i].RawData, []byte(prefix)) {
+			t.Errorf("Capture %d: expected %q, got %q", i, prefix, captures[i].RawData)
+		}
+	}
+
+	result := callTool(t, NewListProxiesHandler(manager).Execute, map[string]interface{}{})
+	info := result["proxies"].([]interface{})[0].(map[string]interface{})
+	if info["captures_non_error_skipped"] != float64(3) {
+		t.Errorf("Expected 3 skipped captures, got %v", info["captures_non_error_skipped"])
+	}
+	if conn.offsetToServer.Load() != 80 || conn.offsetToClient.Load() != 103 {
+		t.Errorf("Expected dropped traffic to still advance the streams, got %d and %d bytes",
+			conn.offsetToServer.Load(), conn.offsetToClient.Load())
+	}
+}
+
+// TestHTTPCaptureErrorsOnlyHeldLimit tests that request captures held for a
+// response are capped per connection and dropped when it closes
+func TestHTTPCaptureErrorsOnlyHeldLimit(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+	if err := manager.StartProxyWithOptions(19215, "localhost", 18083, 4*1024*1024, ProxyOptions{HTTPErrorsOnly: true}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	proxy, _ := manager.GetProxy(19215)
+	conn := proxy.newConnection(nil, nil)
+
+	// A request body that outgrows the cap loses its oldest captures
+	chunk := bytes.Repeat([]byte("x"), 400*1024)
+	proxy.captureData(conn, append([]byte("POST /upload HTTP/1.1\r\n\r\n"), chunk...), DirectionClientToServer)
+	proxy.captureData(conn, chunk, DirectionClientToServer)
+	proxy.captureData(conn, chunk, DirectionClientToServer)
+	if held := conn.errorsOnly.held; held > maxErrorsOnlyHeldBytes {
+		t.Errorf("Expected at most %d bytes held, got %d", maxErrorsOnlyHeldBytes, held)
+	}
+
+	heldDropped := func() interface{} {
+		result := callTool(t, NewListProxiesHandler(manager).Execute, map[string]interface{}{})
+		return result["proxies"].([]interface{})[0].(map[string]interface{})["captures_held_dropped"]
+	}
+	if dropped := heldDropped(); dropped != float64(1) {
+		t.Errorf("Expected 1 capture dropped over the cap, got %v", dropped)
+	}
+
+	// The backend never answers, so closing drops what is left
+	proxy.releaseHeldCaptures(conn)
+	if dropped := heldDropped(); dropped != float64(3) {
+		t.Errorf("Expected all 3 captures dropped after close, got %v", dropped)
+	}
+	if captures := proxy.Buffer.GetAll(); len(captures) != 0 {
+		t.Errorf("Expected nothing stored, got %d captures", len(captures))
+	}
+}
+
+// TestHTTPCaptureErrorsOnlyFraming tests that transactions are paired on
+// real message boundaries: a response starting mid-read, a body that looks
+// like a status line and a status line split across reads
+func TestHTTPCaptureErrorsOnlyFraming(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+	if err := manager.StartProxyWithOptions(19222, "localhost", 18083, 1024*1024, ProxyOptions{HTTPErrorsOnly: true}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	proxy, _ := manager.GetProxy(19222)
+	conn := proxy.newConnection(nil, nil)
+
+	// Both requests in one read, so the capture belongs to both transactions
+	proxy.captureData(conn, []byte("GET /a HTTP/1.1\r\nHost: example.com\r\n\r\nGET /b HTTP/1.1\r\nHost: example.com\r\n\r\n"), DirectionClientToServer)
+	// A successful response whose body reads like an error status line
+	proxy.captureData(conn, []byte("HTTP/1.1 200 OK\r\nContent-Length: 13\r\n\r\nHTTP/1.1 500 "), DirectionServerToClient)
+	if captures := proxy.Buffer.GetAll(); len(captures) != 0 {
+		t.Fatalf("Expected nothing stored for the successful response, got %d captures", len(captures))
+	}
+	// The second response's status line is split across reads
+	proxy.captureData(conn, []byte("HTTP/1.1 5"), DirectionServerToClient)
+	proxy.captureData(conn, []byte("03 Service Unavailable\r\nContent-Length: 0\r\n\r\nHTTP/1.1 200 OK\r\n"), DirectionServerToClient)
+
+	captures := proxy.Buffer.GetAll()
+	want := []string{"GET /a", "HTTP/1.1 5", "03 Service"}
+	if len(captures) != len(want) {
+		t.Fatalf("Expected %d captures of the failed transaction, got %d", len(want), len(captures))
+	}
+	for i, prefix := range want {
+		if !bytes.HasPrefix(captures[i].RawData, []byte(prefix)) {
+			t.Errorf("Capture %d: expected %q, got %q", i, prefix, captures[i].RawData)
+		}
+	}
+
+	proxy.Stats.mu.RLock()
+	skipped := proxy.Stats.NonErrorSkipped
+	proxy.Stats.mu.RUnlock()
+	if skipped != 1 {
+		t.Errorf("Expected only the successful response to be skipped, got %d", skipped)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import "bytes"
+
+// ingestMatcher finds the ingest_contains marker in one direction's reads.
+// The last len(marker)-1 bytes of each read are kept, so a marker split
+// across reads is found in the read where it completes. Only the
+// direction's copy loop feeds it.
+type ingestMatcher struct {
+	tail   []byte // End of the previous reads, shorter than the marker
+	window []byte // Reused buffer joining tail with the start of a read
+}
+
+// ingestMatcher returns the ingest_contains state of a direction
+func (c *Connection) ingestMatcher(direction string) *ingestMatcher {
+	if direction == DirectionClientToServer {
+		return &c.requestIngest
+	}
+	return &c.responseIngest
+}
+
+// match reports whether marker occurs in data, or starts in earlier reads
+// and completes in data
+func (m *ingestMatcher) match(marker, data []byte) bool {
+	keep := len(marker) - 1
+	found := bytes.Contains(data, marker)
+
+	// Only a match straddling the boundary needs the previous bytes
+	m.window = append(append(m.window[:0], m.tail...), data[:min(len(data), keep)]...)
+	if !found && len(m.tail) > 0 {
+		found = bytes.Contains(m.window, marker)
+	}
+
+	// The window ends with data whenever data is shorter than keep
+	if len(data) >= keep {
+		m.tail = append(m.tail[:0], data[len(data)-keep:]...)
+	} else {
+		m.tail = append(m.tail[:0], m.window[max(len(m.window)-keep, 0):]...)
+	}
+	return found
+}
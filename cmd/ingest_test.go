@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+// TestIngestMatcher tests that the marker is found within a read and across
+// read boundaries, however the reads are split
+func TestIngestMatcher(t *testing.T) {
+	marker := []byte("marker")
+	tests := []struct {
+		reads []string
+		want  []bool
+	}{
+		{[]string{"a marker here", "nothing"}, []bool{true, false}},
+		{[]string{"the mar", "ker"}, []bool{false, true}},
+		{[]string{"m", "a", "r", "k", "e", "r", "!"}, []bool{false, false, false, false, false, true, false}},
+		{[]string{"mark", "", "er"}, []bool{false, false, true}},
+		{[]string{"marke", "xmarker"}, []bool{false, true}},
+		{[]string{"mar", "kex", "er"}, []bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		var m ingestMatcher
+		for i, read := range tt.reads {
+			if got := m.match(marker, []byte(read)); got != tt.want[i] {
+				t.Errorf("%q read %d: expected %v, got %v", tt.reads, i, tt.want[i], got)
+			}
+		}
+	}
+
+	// Matching a read allocates nothing once the buffers have grown
+	var m ingestMatcher
+	data := []byte("a long read that ends with half a mar")
+	m.match(marker, data)
+	if allocs := testing.AllocsPerRun(100, func() { m.match(marker, data) }); allocs != 0 {
+		t.Errorf("Expected no allocations per read, got %v", allocs)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import "bytes"
+
+// maxCaptureLine bounds the partial line held back under line_mode; a longer
+// line is captured in pieces of this size
+const maxCaptureLine = 64 * 1024
+
+// lineSplitter holds back the partial line of one direction under line_mode.
+// Only the direction's copy loop feeds it, and it is flushed once the copy
+// loops have finished.
+type lineSplitter struct {
+	partial []byte
+}
+
+// lineSplitter returns the line_mode state of a direction
+func (c *Connection) lineSplitter(direction string) *lineSplitter {
+	if direction == DirectionClientToServer {
+		return &c.requestLines
+	}
+	return &c.responseLines
+}
+
+// captureLines captures each complete line of data, newline included, and
+// holds back a trailing partial line until the rest of it arrives. Forwarding
+// is not delayed; only the captures are aligned to lines.
+func (p *ProxyInstance) captureLines(conn *Connection, data []byte, direction string) {
+	s := conn.lineSplitter(direction)
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			s.partial = append(s.partial, data...)
+			for len(s.partial) >= maxCaptureLine {
+				p.recordCapture(conn, s.partial[:maxCaptureLine], direction, false)
+				s.partial = append(s.partial[:0], s.partial[maxCaptureLine:]...)
+			}
+			return
+		}
+
+		line := data[:i+1]
+		if len(s.partial) > 0 {
+			line = append(s.partial, line...)
+			s.partial = s.partial[:0]
+		}
+		p.recordCapture(conn, line, direction, false)
+		data = data[i+1:]
+	}
+}
+
+// flushLines captures the partial trailing lines of a connection that is closing
+func (p *ProxyInstance) flushLines(conn *Connection) {
+	if !p.Options.LineMode {
+		return
+	}
+	for _, direction := range []string{DirectionClientToServer, DirectionServerToClient} {
+		s := conn.lineSplitter(direction)
+		if len(s.partial) > 0 {
+			p.recordCapture(conn, s.partial, direction, false)
+			s.partial = nil
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestLineMode tests that line_mode aligns captures to lines across reads
+// while the echoed bytes come back unchanged
+func TestLineMode(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	response := callTool(t, NewStartProxyHandler(manager).Execute, map[string]interface{}{
+		"listen_port":  float64(19186),
+		"forward_host": "127.0.0.1",
+		"forward_port": float64(backendPort),
+		"line_mode":    true,
+	})
+	if response["error"] != nil {
+		t.Fatalf("Failed to start proxy: %v", response)
+	}
+	defer manager.StopProxy(19186)
+	proxy, _ := manager.GetProxy(19186)
+
+	client, err := net.Dial("tcp", "127.0.0.1:19186")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	sent := []string{"EHLO a\r\nMAIL FROM:<x>\r\nRCPT", " TO:<y>\r\nQUIT"}
+	var echoed []byte
+	for _, chunk := range sent {
+		client.Write([]byte(chunk))
+		reply := make([]byte, len(chunk))
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := io.ReadFull(client, reply); err != nil {
+			t.Fatalf("Failed to read echo: %v", err)
+		}
+		echoed = append(echoed, reply...)
+	}
+	client.Close()
+	if want := sent[0] + sent[1]; string(echoed) != want {
+		t.Errorf("Expected %q forwarded unchanged, got %q", want, echoed)
+	}
+
+	want := []string{"EHLO a\r\n", "MAIL FROM:<x>\r\n", "RCPT TO:<y>\r\n", "QUIT"}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(proxy.Buffer.GetAll()) < 2*len(want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	lines := map[string][]string{}
+	for _, capture := range proxy.Buffer.GetAll() {
+		lines[capture.Direction] = append(lines[capture.Direction], string(capture.RawData))
+	}
+	for _, direction := range []string{DirectionClientToServer, DirectionServerToClient} {
+		got := lines[direction]
+		if len(got) != len(want) {
+			t.Errorf("%s: expected captures %q, got %q", direction, want, got)
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: expected capture %d to be %q, got %q", direction, i, want[i], got[i])
+			}
+		}
+	}
+}
+
+// TestLineModeLongLine tests that a line longer than the hold-back limit is
+// captured in pieces without losing bytes
+func TestLineModeLongLine(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxyWithOptions(19187, "127.0.0.1", 18082, 1024*1024, ProxyOptions{LineMode: true}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19187)
+	proxy, _ := manager.GetProxy(19187)
+	conn := proxy.newConnection(nil, nil)
+
+	line := append(bytes.Repeat([]byte("x"), 2*maxCaptureLine+100), '\n')
+	for chunk := line; len(chunk) > 0; chunk = chunk[min(4096, len(chunk)):] {
+		proxy.captureData(conn, chunk[:min(4096, len(chunk))], DirectionClientToServer)
+	}
+	proxy.captureData(conn, []byte("next"), DirectionClientToServer)
+	proxy.flushLines(conn)
+
+	captures := proxy.Buffer.GetAll()
+	sizes := make([]int, len(captures))
+	var joined []byte
+	for i, capture := range captures {
+		sizes[i] = len(capture.RawData)
+		joined = append(joined, capture.RawData...)
+	}
+	if len(sizes) != 4 || sizes[0] != maxCaptureLine || sizes[1] != maxCaptureLine || sizes[2] != 101 || sizes[3] != 4 {
+		t.Errorf("Expected captures of %d, %d, 101 and 4 bytes, got %v", maxCaptureLine, maxCaptureLine, sizes)
+	}
+	if want := append(append([]byte(nil), line...), "next"...); !bytes.Equal(joined, want) {
+		t.Errorf("Expected the captures to add up to the stream")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// LogLevel orders log lines by severity, most verbose first
+type LogLevel int32
+
+// Log levels accepted by --log-level and set_log_level
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+// logLevelNames are the names of the levels, indexed by level
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+// String returns the level's name
+func (l LogLevel) String() string {
+	if l < LogDebug || l > LogError {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return logLevelNames[l]
+}
+
+// parseLogLevel looks up a level by name
+func parseLogLevel(name string) (LogLevel, error) {
+	for i, levelName := range logLevelNames {
+		if name == levelName {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid log level %q (expected debug, info, warn or error)", name)
+}
+
+// minLogLevel is the least severe level written, info until changed
+var minLogLevel atomic.Int32
+
+func init() {
+	minLogLevel.Store(int32(LogInfo))
+}
+
+// SetLogLevel changes the least severe level written and returns the
+// previous one. It takes effect immediately for all goroutines.
+func SetLogLevel(level LogLevel) LogLevel {
+	return LogLevel(minLogLevel.Swap(int32(level)))
+}
+
+// CurrentLogLevel returns the least severe level written
+func CurrentLogLevel() LogLevel {
+	return LogLevel(minLogLevel.Load())
+}
+
+// logEnabled reports whether lines of a level are written, so hot paths
+// can skip formatting
+func logEnabled(level LogLevel) bool {
+	return int32(level) >= minLogLevel.Load()
+}
+
+// logf writes a line tagged with its level if the level is enabled
+func logf(level LogLevel, format string, args ...interface{}) {
+	if !logEnabled(level) {
+		return
+	}
+	log.Output(3, level.String()+": "+fmt.Sprintf(format, args...))
+}
+
+// debugf logs detail useful only while reproducing an issue
+func debugf(format string, args ...interface{}) { logf(LogDebug, format, args...) }
+
+// infof logs proxy and connection lifecycle events
+func infof(format string, args ...interface{}) { logf(LogInfo, format, args...) }
+
+// warnf logs failures the proxy recovers from
+func warnf(format string, args ...interface{}) { logf(LogWarn, format, args...) }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncBuffer collects log output written from any goroutine
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestSetLogLevel tests that debug lines are suppressed at the default
+// level and written once set_log_level switches to debug
+func TestSetLogLevel(t *testing.T) {
+	var output syncBuffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&output)
+	defer SetLogLevel(SetLogLevel(LogInfo))
+
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19183, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19183)
+	proxy, _ := manager.GetProxy(19183)
+	conn := proxy.newConnection(nil, nil)
+
+	proxy.recordCapture(conn, []byte("before"), DirectionClientToServer, false)
+	if strings.Contains(output.String(), "debug: ") {
+		t.Fatalf("Expected no debug lines at info level, got:\n%s", output.String())
+	}
+
+	result := callTool(t, NewSetLogLevelHandler().Execute, map[string]interface{}{
+		"level": "debug",
+	})
+	if result["level"] != "debug" || result["previous"] != "info" {
+		t.Fatalf("Expected a switch from info to debug, got %v", result)
+	}
+
+	proxy.recordCapture(conn, []byte("after"), DirectionClientToServer, false)
+	if !strings.Contains(output.String(), "debug: Port 19183 captured #2: 5 bytes") {
+		t.Errorf("Expected a debug line for the capture, got:\n%s", output.String())
+	}
+
+	result = callTool(t, NewSetLogLevelHandler().Execute, map[string]interface{}{
+		"level": "verbose",
+	})
+	if result["error"] == nil {
+		t.Errorf("Expected an error for an unknown level, got %v", result)
+	}
+	if CurrentLogLevel() != LogDebug {
+		t.Errorf("Expected an invalid level to leave debug in place, got %s", CurrentLogLevel())
+	}
+}
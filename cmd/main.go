@@ -1,10 +1,11 @@
 package main
 
 import (
-	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
@@ -15,12 +16,74 @@ var (
 )
 
 func main() {
+	configPath := flag.String("config", "", "JSON file declaring proxies to start at boot")
+	defaultLimit := flag.String("default-capture-limit", "", "Default capture_limit for start_proxy, e.g. 50MB (default: $"+defaultCaptureLimitEnv+" or 10MB)")
+	maxTotal := flag.String("max-total-capture-bytes", "", "Bound on bytes stored across all proxies, e.g. 1GB; captures beyond it are dropped (default: unlimited)")
+	noPrivileged := flag.Bool("no-privileged-ports", false, "Refuse to start proxies listening on ports below 1024")
+	maxProxies := flag.Int("max-proxies", 0, "Refuse to start more than this many proxies at once (default: unlimited)")
+	controlSocket := flag.String("control-socket", "", "Unix socket path serving a line protocol (list, stats, stop <port>) for scripts (default: off)")
+	logLevel := flag.String("log-level", "info", "Least severe log level written: debug, info, warn or error (change at runtime with set_log_level)")
+	timezone := flag.String("timezone", "", "IANA time zone for rendered timestamps, e.g. Europe/Berlin or UTC (default: local time)")
+	flag.Parse()
+
 	// Configure logging to stderr to avoid interfering with stdio
 	log.SetOutput(os.Stderr)
 	log.SetPrefix("[mcp-nettools] ")
+	level, err := parseLogLevel(*logLevel)
+	if err != nil {
+		log.Fatalf("Invalid --log-level: %v", err)
+	}
+	SetLogLevel(level)
+
+	if *timezone != "" {
+		loc, err := time.LoadLocation(*timezone)
+		if err != nil {
+			log.Fatalf("Invalid --timezone: %v", err)
+		}
+		displayLocation = loc
+	}
+
+	if err := loadDefaultCaptureLimit(*defaultLimit); err != nil {
+		log.Fatalf("Invalid default capture limit: %v", err)
+	}
 
 	// Create the proxy manager
 	manager := NewProxyManager()
+	if *maxTotal != "" {
+		limit, err := parseSize(*maxTotal)
+		if err != nil {
+			log.Fatalf("Invalid --max-total-capture-bytes: %v", err)
+		}
+		manager.SetMaxTotalCaptureBytes(limit)
+	}
+	manager.SetNoPrivilegedPorts(*noPrivileged)
+	if *maxProxies < 0 {
+		log.Fatalf("Invalid --max-proxies: must not be negative")
+	}
+	manager.SetMaxProxies(*maxProxies)
+	snapshots := NewSnapshotStore()
+
+	// Start the proxies declared in the config file
+	var config *ConfigManager
+	if *configPath != "" {
+		config = NewConfigManager(manager, *configPath)
+		diff, err := config.Apply()
+		if err != nil {
+			warnf("Failed to load config: %v", err)
+		} else {
+			infof("Started %d proxies from %s", len(diff.Started), *configPath)
+		}
+	}
+
+	// Serve the control socket alongside MCP
+	var control *ControlServer
+	if *controlSocket != "" {
+		control, err = NewControlServer(manager, *controlSocket)
+		if err != nil {
+			log.Fatalf("Failed to open control socket: %v", err)
+		}
+		infof("Control socket listening on %s", *controlSocket)
+	}
 
 	// Create MCP server
 	mcpServer := server.NewMCPServer(
@@ -41,11 +104,144 @@ func main() {
 				mcp.Description("Host to forward connections to (default: localhost)"),
 			),
 			mcp.WithNumber("forward_port",
-				mcp.Required(),
-				mcp.Description("Port to forward connections to"),
+				mcp.Description("Port to forward connections to (required unless forward_targets is given)"),
+			),
+			mcp.WithArray("forward_targets",
+				mcp.Description("Backends to spread connections over instead of forward_host/forward_port, each picked at random in proportion to its weight, e.g. [{\"port\": 8081, \"weight\": 90}, {\"port\": 8082, \"weight\": 10}]"),
+				mcp.Items(map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"host":   map[string]interface{}{"type": "string", "description": "Backend host (default: localhost)"},
+						"port":   map[string]interface{}{"type": "number", "description": "Backend port"},
+						"weight": map[string]interface{}{"type": "number", "description": "Relative share of connections (default: 1)"},
+					},
+					"required": []string{"port"},
+				}),
 			),
 			mcp.WithNumber("capture_limit",
-				mcp.Description("Maximum bytes to capture (default: 10MB)"),
+				numberOrString(),
+				mcp.Description("Maximum bytes to capture, as a number of bytes or a size string such as 512KB, 10MB or 1GB (default: 10MB, or the server's --default-capture-limit)"),
+			),
+			mcp.WithString("label",
+				mcp.Description("Label used to group proxies for filtering and bulk stop"),
+			),
+			mcp.WithArray("tags",
+				mcp.Description("Additional tags; filters by label also match any tag"),
+				mcp.WithStringItems(),
+			),
+			mcp.WithString("if_exists",
+				mcp.Description("What to do if a proxy already runs on listen_port: \"error\" fails, \"return\" keeps it if the configuration matches, \"restart\" replaces it (default: error)"),
+				mcp.Enum(IfExistsError, IfExistsReturn, IfExistsRestart),
+			),
+			mcp.WithString("capture_dir",
+				mcp.Description("Directory to also write captures to as rotating JSON lines files (omit to keep captures in memory only)"),
+			),
+			mcp.WithNumber("rotate_bytes",
+				mcp.Description("Size in bytes at which to roll to a new capture file (default: 10MB)"),
+			),
+			mcp.WithNumber("max_files",
+				mcp.Description("Maximum number of capture files to keep, oldest are deleted (default: 10)"),
+			),
+			mcp.WithBoolean("http_headers_only",
+				mcp.Description("For HTTP/1.x connections, store only request/response headers and drop bodies; body bytes still count in stats (default: false)"),
+			),
+			mcp.WithNumber("http_max_body_bytes",
+				mcp.Description("For HTTP/1.x connections, store headers in full but only this many bytes of each body; everything is still forwarded (default: unlimited)"),
+			),
+			mcp.WithNumber("max_stored_bytes_per_packet",
+				mcp.Description("Truncate each stored capture to this many bytes; traffic is still forwarded in full (default: unlimited)"),
+			),
+			mcp.WithBoolean("redact",
+				mcp.Description("Mask Authorization, Cookie and Set-Cookie header values in stored captures; traffic is forwarded unmodified (default: false)"),
+			),
+			mcp.WithArray("redact_patterns",
+				mcp.Description("Regular expressions whose matches are masked in stored captures"),
+				mcp.WithStringItems(),
+			),
+			mcp.WithBoolean("capture",
+				mcp.Description("Set to false for a pure pass-through proxy that never captures and forwards at full speed (default: true)"),
+			),
+			mcp.WithBoolean("detect_protocol",
+				mcp.Description("Set to false to skip protocol detection on every packet and label captures \"Disabled\" (default: true)"),
+			),
+			mcp.WithArray("exclude_cidrs",
+				mcp.Description("Source addresses or CIDR ranges whose connections are proxied but not captured, e.g. a monitoring client"),
+				mcp.WithStringItems(),
+			),
+			mcp.WithNumber("max_conns_per_ip",
+				mcp.Description("Maximum concurrent connections from a single source IP; further connections are closed immediately (default: unlimited)"),
+			),
+			mcp.WithNumber("max_concurrent_connections",
+				mcp.Description("Maximum connections handled at once; further connections wait in the listen backlog until one finishes (default: 1024)"),
+			),
+			mcp.WithNumber("tcp_keepalive_ms",
+				mcp.Description("TCP keepalive period in milliseconds for client and backend connections; 0 disables keepalive (default: Go's default of 15s)"),
+			),
+			mcp.WithBoolean("tcp_nodelay",
+				mcp.Description("Disable Nagle's algorithm on client and backend connections for lower latency; false batches small writes (default: true)"),
+			),
+			mcp.WithNumber("read_buffer_size",
+				mcp.Description("Bytes read from a socket at a time, which caps the size of one capture; buffers are pooled across connections (default: 4096, max: 1048576)"),
+			),
+			mcp.WithString("eviction_policy",
+				mcp.Description("Which captures a full buffer evicts first: fifo (oldest), keep_largest (smallest payloads) or keep_protocol:<name> (oldest of other protocols, e.g. keep_protocol:HTTP/1.x) (default: fifo)"),
+			),
+			mcp.WithNumber("stop_capture_at_percent",
+				mcp.Description("Once the capture buffer reaches this percent of capture_limit, drop new captures instead of evicting old ones, preserving the start of a session; 100 disables eviction (default: off)"),
+			),
+			mcp.WithNumber("retain_seconds",
+				mcp.Description("Keep only captures from the last this many seconds, evicting older ones regardless of size, for a sliding time window; capture_limit still applies (default: no time limit)"),
+			),
+			mcp.WithBoolean("adaptive_sampling",
+				mcp.Description("Capture fewer packets as the buffer fills past 50% so it holds a sample spread over time; bytes are always counted (default: false)"),
+			),
+			mcp.WithNumber("max_captures_per_sec",
+				mcp.Description("Store at most this many captures per second, dropping the rest of a burst regardless of packet size; bytes are always counted (default: unlimited)"),
+			),
+			mcp.WithBoolean("text_only_capture",
+				mcp.Description("Store only packets that are mostly printable text; binary packets are counted but not stored (default: false)"),
+			),
+			mcp.WithNumber("text_min_printable_ratio",
+				mcp.Description("Share of printable bytes (0-1) a packet needs to be stored under text_only_capture (default: 0.8)"),
+			),
+			mcp.WithString("ingest_contains",
+				mcp.Description("Store only packets whose bytes contain this literal substring, e.g. a request ID marker; other packets are counted but not stored (default: store all)"),
+			),
+			mcp.WithBoolean("first_packet_only",
+				mcp.Description("Store only the first packet in each direction of every connection, e.g. for protocol fingerprinting; later packets are counted but not stored (default: false)"),
+			),
+			mcp.WithBoolean("http_capture_errors_only",
+				mcp.Description("Store an HTTP/1.x transaction's request and response captures only when the response status is 400 or above; successful transactions are counted but not stored, and captures wait until the response status arrives (default: false)"),
+			),
+			mcp.WithBoolean("line_mode",
+				mcp.Description("Split captures on newlines for line-oriented protocols (SMTP, IRC, Redis); forwarding is unchanged (default: false)"),
+			),
+			mcp.WithNumber("coalesce_window_ms",
+				mcp.Description("Merge consecutive same-direction reads of a connection that arrive within this many milliseconds into one capture (default: 0, off)"),
+			),
+			mcp.WithString("trace_header",
+				mcp.Description("Correlation header to add to HTTP requests that lack one and record, so trace_requests can follow a request through chained proxies; true uses X-MCP-Trace-Id (default: off)"),
+			),
+			mcp.WithString("mirror_target",
+				mcp.Description("host:port of a shadow backend that also receives the client's bytes; its responses are discarded and its failures never affect the primary"),
+			),
+			mcp.WithString("listen_network",
+				mcp.Description("IP version to bind: tcp4 for IPv4 only, tcp6 for IPv6 only, or tcp for dual-stack where the platform supports it; also applies to a udp listener (default: tcp)"),
+				mcp.Enum(ProtocolTCP, NetworkTCP4, NetworkTCP6),
+			),
+			mcp.WithString("listen_protocol",
+				mcp.Description("Protocol to listen on; udp bridges each client address's datagrams to a TCP backend as 2-byte length-prefixed frames (default: tcp)"),
+				mcp.Enum(ProtocolTCP, ProtocolUDP),
+			),
+			mcp.WithString("forward_protocol",
+				mcp.Description("Protocol to forward with; udp bridges 2-byte length-prefixed frames from TCP clients to datagrams (default: tcp)"),
+				mcp.Enum(ProtocolTCP, ProtocolUDP),
+			),
+			mcp.WithString("webhook_url",
+				mcp.Description("http(s) URL to POST a JSON summary to whenever a stored capture matches webhook_pattern; delivery is asynchronous and retried"),
+			),
+			mcp.WithString("webhook_pattern",
+				mcp.Description("Regular expression matched against stored captures for webhook_url"),
 			),
 		),
 		NewStartProxyHandler(manager).Execute,
@@ -59,8 +255,38 @@ func main() {
 			mcp.WithNumber("listen_port",
 				mcp.Description("Specific proxy port to get output from (omit for all proxies)"),
 			),
+			mcp.WithString("label",
+				mcp.Description("Only include proxies with this label or tag (ignored when listen_port is set)"),
+			),
 			mcp.WithBoolean("clear_buffer",
-				mcp.Description("Whether to clear the buffer after reading (default: true)"),
+				mcp.Description("Whether to remove the returned captures from the buffer; captures not returned are kept (default: true, false when offset or limit is set)"),
+			),
+			mcp.WithBoolean("dedup",
+				mcp.Description("Collapse consecutive captures with identical payloads into one entry with a repeat_count (default: false)"),
+			),
+			mcp.WithString("view",
+				mcp.Description("Output view: \"packets\" for raw captures or \"http\" for parsed HTTP/1.x transactions (default: packets)"),
+				mcp.Enum("packets", "http"),
+			),
+			mcp.WithString("order",
+				mcp.Description("\"asc\" for oldest first or \"desc\" for newest first; applied before offset/limit (default: asc)"),
+				mcp.Enum("asc", "desc"),
+			),
+			mcp.WithNumber("offset",
+				mcp.Description("Number of captures (or transactions in the http view) to skip after ordering (default: 0)"),
+			),
+			mcp.WithNumber("limit",
+				mcp.Description("Maximum number of captures (or transactions in the http view) to return after ordering (default: all)"),
+			),
+			mcp.WithString("format",
+				mcp.Description("Output encoding: json or cbor-base64 (base64 of a CBOR map with the same fields as json) (default: json)"),
+				mcp.Enum("json", "cbor-base64"),
+			),
+			mcp.WithNumber("hexdump_width",
+				mcp.Description("Bytes per hex dump line: 8, 16 or 32 (default: 16)"),
+			),
+			mcp.WithBoolean("hexdump_ascii",
+				mcp.Description("Whether hex dump lines end with an ASCII gutter (default: true)"),
 			),
 		),
 		NewGetProxyOutputHandler(manager).Execute,
@@ -75,27 +301,654 @@ func main() {
 				mcp.Required(),
 				mcp.Description("Port of the proxy to stop"),
 			),
+			mcp.WithBoolean("ignore_missing",
+				mcp.Description("Succeed with was_running: false instead of failing when no proxy is running on the port (default: false)"),
+			),
 		),
 		NewStopProxyHandler(manager).Execute,
 	)
 
+	// Register stop_proxies tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"stop_proxies",
+			mcp.WithDescription("Stop every running proxy with the given label or tag"),
+			mcp.WithString("label",
+				mcp.Required(),
+				mcp.Description("Label or tag of the proxies to stop"),
+			),
+		),
+		NewStopProxiesHandler(manager).Execute,
+	)
+
 	// Register list_proxies tool
 	mcpServer.AddTool(
 		mcp.NewTool(
 			"list_proxies",
 			mcp.WithDescription("List all running proxies with their status"),
+			mcp.WithString("label",
+				mcp.Description("Only list proxies with this label or tag"),
+			),
+			mcp.WithString("sort_by",
+				mcp.Description("Sort highest first by bytes captured, total connections, buffer usage or uptime (default: unsorted)"),
+				mcp.Enum("bytes", "connections", "buffer_usage", "uptime"),
+			),
+			mcp.WithNumber("limit",
+				mcp.Description("Maximum number of proxies to list (default: all)"),
+			),
 		),
 		NewListProxiesHandler(manager).Execute,
 	)
 
+	// Register list_capture_files tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"list_capture_files",
+			mcp.WithDescription("List the on-disk capture files of a proxy started with capture_dir"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+		),
+		NewListCaptureFilesHandler(manager).Execute,
+	)
+
+	// Register to_curl tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"to_curl",
+			mcp.WithDescription("Generate a curl command reproducing a captured HTTP request"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+			mcp.WithNumber("seq",
+				mcp.Required(),
+				mcp.Description("Sequence number of the capture where the request starts (from get_proxy_output)"),
+			),
+		),
+		NewToCurlHandler(manager).Execute,
+	)
+
+	// Register decode_capture tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"decode_capture",
+			mcp.WithDescription("Decode a capture by protocol: HTTP/2 frames with gRPC message boundaries, or a full hex dump for anything else"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+			mcp.WithNumber("seq",
+				mcp.Required(),
+				mcp.Description("Sequence number of the capture to decode (from get_proxy_output)"),
+			),
+		),
+		NewDecodeCaptureHandler(manager).Execute,
+	)
+
+	// Register export_hexstream tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"export_hexstream",
+			mcp.WithDescription("Export captures as a text2pcap-compatible hex dump for Wireshark's \"Import from Hex Dump\""),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+			mcp.WithNumber("conn_id",
+				mcp.Description("Only export captures of this connection (default: all)"),
+			),
+		),
+		NewExportHexStreamHandler(manager).Execute,
+	)
+
+	// Register inject_bytes tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"inject_bytes",
+			mcp.WithDescription("Write raw bytes into a live proxied connection. WARNING: this corrupts the stream as seen by the receiving peer; use only for protocol fuzzing"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+			mcp.WithNumber("conn_id",
+				mcp.Required(),
+				mcp.Description("ID of the live connection (conn_id in get_proxy_output)"),
+			),
+			mcp.WithString("direction",
+				mcp.Required(),
+				mcp.Description("\"Client->Server\" to send to the backend or \"Server->Client\" to send to the client"),
+				mcp.Enum(DirectionClientToServer, DirectionServerToClient),
+			),
+			mcp.WithString("data",
+				mcp.Required(),
+				mcp.Description("Base64 encoded bytes to inject"),
+			),
+		),
+		NewInjectBytesHandler(manager).Execute,
+	)
+
+	// Register set_capture tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"set_capture",
+			mcp.WithDescription("Turn traffic capture on or off for a proxy without affecting forwarding; bytes are still counted while off"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+			mcp.WithBoolean("enabled",
+				mcp.Required(),
+				mcp.Description("Whether captured traffic should be stored"),
+			),
+		),
+		NewSetCaptureHandler(manager).Execute,
+	)
+
+	// Register reload_config tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"reload_config",
+			mcp.WithDescription("Re-read the --config file: start new proxies, stop removed ones, relabel changed ones in place and restart ones whose other settings changed"),
+		),
+		NewReloadConfigHandler(config).Execute,
+	)
+
+	// Register query_captures tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"query_captures",
+			mcp.WithDescription("Filter, sort and limit a proxy's stored captures with a small SQL-like query, e.g. select where proto='HTTP/1.x' and dir='client' order by bytes desc limit 5"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+			mcp.WithString("query",
+				mcp.Required(),
+				mcp.Description("select [where <cond>] [order by <field> [asc|desc]] [limit <n>]; fields: seq, conn_id, dir, proto, bytes, hash, ascii, injected, truncated, retry; operators: = != < <= > >= ~ (contains), and/or/not"),
+			),
+		),
+		NewQueryCapturesHandler(manager).Execute,
+	)
+
+	// Register snapshot_captures tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"snapshot_captures",
+			mcp.WithDescription("Take a stable copy of a proxy's captures that several readers can page through, or read an existing snapshot by id"),
+			mcp.WithNumber("listen_port",
+				mcp.Description("Port of the proxy to snapshot (required unless snapshot_id is set)"),
+			),
+			mcp.WithString("snapshot_id",
+				mcp.Description("Read this existing snapshot instead of taking a new one"),
+			),
+			mcp.WithBoolean("clear_buffer",
+				mcp.Description("Clear the live buffer in the same step as the copy, so no capture is lost or read twice (default: false)"),
+			),
+			mcp.WithNumber("offset",
+				mcp.Description("Number of captures to skip (default: 0)"),
+			),
+			mcp.WithNumber("limit",
+				mcp.Description("Maximum number of captures to return (default: all)"),
+			),
+		),
+		NewSnapshotCapturesHandler(manager, snapshots).Execute,
+	)
+
+	// Register clear_snapshot tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"clear_snapshot",
+			mcp.WithDescription("Release a snapshot taken with snapshot_captures"),
+			mcp.WithString("snapshot_id",
+				mcp.Required(),
+				mcp.Description("ID of the snapshot to release"),
+			),
+		),
+		NewClearSnapshotHandler(snapshots).Execute,
+	)
+
+	// Register probe_backend tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"probe_backend",
+			mcp.WithDescription("Measure how long a backend takes to accept TCP connections: dials it several times and reports min/avg/max/p95 connect time and success rate"),
+			mcp.WithString("host",
+				mcp.Description("Host to probe (default: localhost)"),
+			),
+			mcp.WithNumber("port",
+				mcp.Description("Port to probe (required unless listen_port is set)"),
+			),
+			mcp.WithNumber("listen_port",
+				mcp.Description("Probe the backend of the proxy on this port instead of host/port"),
+			),
+			mcp.WithNumber("count",
+				mcp.Description("Number of sequential dials, 1-100 (default: 5)"),
+			),
+			mcp.WithNumber("timeout_ms",
+				mcp.Description("Timeout for each dial in milliseconds (default: 2000)"),
+			),
+		),
+		NewProbeBackendHandler(manager).Execute,
+	)
+
+	// Register export_sessions tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"export_sessions",
+			mcp.WithDescription("Write each captured connection to its own set of files: the reassembled client->server and server->client streams plus a JSON metadata file"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+			mcp.WithString("dir",
+				mcp.Required(),
+				mcp.Description("Directory to write the files to (created if missing)"),
+			),
+			mcp.WithNumber("conn_id",
+				mcp.Description("Only export this connection (default: all)"),
+			),
+		),
+		NewExportSessionsHandler(manager).Execute,
+	)
+
+	// Register get_connection tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"get_connection",
+			mcp.WithDescription("Show everything about one connection: endpoints, open/close times, per-direction byte totals, both reassembled sides as text and the timing of each capture"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+			mcp.WithNumber("conn_id",
+				mcp.Required(),
+				mcp.Description("ID of the connection (conn_id in get_proxy_output)"),
+			),
+		),
+		NewGetConnectionHandler(manager).Execute,
+	)
+
+	// Register get_tail tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"get_tail",
+			mcp.WithDescription("Get the last N bytes of the captured conversation as one blob of text and base64, without per-packet structure"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+			mcp.WithNumber("conn_id",
+				mcp.Description("Only use captures from this connection (default: all connections)"),
+			),
+			mcp.WithNumber("bytes",
+				mcp.Description("Number of trailing bytes to return, at most 65536 (default: 1024)"),
+			),
+			mcp.WithString("direction",
+				mcp.Description("Only use one side of the conversation (default: both, interleaved in capture order)"),
+				mcp.Enum(DirectionClientToServer, DirectionServerToClient),
+			),
+		),
+		NewGetTailHandler(manager).Execute,
+	)
+
+	// Register get_memory_usage tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"get_memory_usage",
+			mcp.WithDescription("Estimate the memory a proxy's captures actually retain: stored payloads plus per-packet and buffer overhead"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+		),
+		NewGetMemoryUsageHandler(manager).Execute,
+	)
+
+	// Register get_capture_budget tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"get_capture_budget",
+			mcp.WithDescription("Show the global capture budget set with --max-total-capture-bytes: bytes used across all proxies, bytes remaining and captures dropped"),
+		),
+		NewGetCaptureBudgetHandler(manager).Execute,
+	)
+
+	// Register get_connections tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"get_connections",
+			mcp.WithDescription("List a proxy's live and recently closed connections with endpoints, byte totals and why each closed connection ended"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+			mcp.WithString("state",
+				mcp.Description("Only list open or closed connections (default: both)"),
+				mcp.Enum("open", "closed"),
+			),
+		),
+		NewGetConnectionsHandler(manager).Execute,
+	)
+
+	// Register trace_requests tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"trace_requests",
+			mcp.WithDescription("Follow HTTP requests through chained proxies started with trace_header, grouping the captures of each request by trace ID"),
+			mcp.WithString("trace_id",
+				mcp.Description("Only show this trace (default: all traces)"),
+			),
+		),
+		NewTraceRequestsHandler(manager).Execute,
+	)
+
+	// Register wait_for tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"wait_for",
+			mcp.WithDescription("Block until a proxy has seen enough bytes or connections, or captured a pattern, instead of polling; returns early on timeout with satisfied false. Sends progress notifications every 500ms when the request has a progressToken"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+			mcp.WithNumber("min_bytes",
+				mcp.Description("Wait until the proxy has seen at least this many bytes in total"),
+			),
+			mcp.WithNumber("min_connections",
+				mcp.Description("Wait until the proxy has accepted at least this many connections in total"),
+			),
+			mcp.WithString("pattern",
+				mcp.Description("Wait until a stored capture matches this regular expression"),
+			),
+			mcp.WithNumber("timeout_ms",
+				mcp.Description("Give up after this many milliseconds, at most 300000 (default: 10000)"),
+			),
+		),
+		NewWaitForHandler(manager).Execute,
+	)
+
+	// Register compare_to_baseline tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"compare_to_baseline",
+			mcp.WithDescription("Diff a proxy's captures against a baseline capture file written with capture_dir, reporting where the reassembled streams or HTTP transactions diverge"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("The port of the proxy to compare"),
+			),
+			mcp.WithString("baseline",
+				mcp.Required(),
+				mcp.Description("Path of a .jsonl capture file to compare against"),
+			),
+			mcp.WithString("mode",
+				mcp.Description("What to compare: stream (reassembled bytes per connection) or http (transactions) (default: stream)"),
+			),
+		),
+		NewCompareToBaselineHandler(manager).Execute,
+	)
+
+	// Register get_global_stats tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"get_global_stats",
+			mcp.WithDescription("Get totals across all running proxies: proxy count, bytes, connections and buffered captures"),
+		),
+		NewGetGlobalStatsHandler(manager).Execute,
+	)
+
+	// Register set_log_level tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"set_log_level",
+			mcp.WithDescription("Change the server's log verbosity without restarting; debug adds a line per capture"),
+			mcp.WithString("level",
+				mcp.Required(),
+				mcp.Description("Least severe level written to stderr"),
+				mcp.Enum("debug", "info", "warn", "error"),
+			),
+		),
+		NewSetLogLevelHandler().Execute,
+	)
+
+	// Register list_proxy_history tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"list_proxy_history",
+			mcp.WithDescription("List recently stopped proxies with their final bytes, connections and run time, most recently stopped first"),
+			mcp.WithNumber("listen_port",
+				mcp.Description("Only list proxies that listened on this port"),
+			),
+			mcp.WithNumber("limit",
+				mcp.Description("Maximum number of entries to return (default: all, up to the last 100 stopped)"),
+			),
+		),
+		NewListProxyHistoryHandler(manager).Execute,
+	)
+
+	// Register clone_proxy tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"clone_proxy",
+			mcp.WithDescription("Start a new proxy on another port with all the settings of a running proxy (forward target, limits, filters, label and tags) and its own empty buffer"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the running proxy to copy"),
+			),
+			mcp.WithNumber("new_listen_port",
+				mcp.Required(),
+				mcp.Description("Port for the new proxy to listen on"),
+			),
+		),
+		NewCloneProxyHandler(manager).Execute,
+	)
+
+	// Register get_socket_info tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"get_socket_info",
+			mcp.WithDescription("Show low-level details of a proxy's listening socket: bound address, deadline support, socket options, keepalive settings and accept loop health"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+		),
+		NewGetSocketInfoHandler(manager).Execute,
+	)
+
+	// Register peek_captures tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"peek_captures",
+			mcp.WithDescription("Read captured traffic like get_proxy_output without ever clearing the buffer"),
+			mcp.WithNumber("listen_port",
+				mcp.Description("Specific proxy port to read (omit for all proxies)"),
+			),
+			mcp.WithString("label",
+				mcp.Description("Only include proxies with this label or tag (ignored when listen_port is set)"),
+			),
+			mcp.WithBoolean("dedup",
+				mcp.Description("Collapse consecutive captures with identical payloads into one entry with a repeat_count (default: false)"),
+			),
+			mcp.WithString("view",
+				mcp.Description("Output view: \"packets\" for raw captures or \"http\" for parsed HTTP/1.x transactions (default: packets)"),
+				mcp.Enum("packets", "http"),
+			),
+			mcp.WithString("order",
+				mcp.Description("\"asc\" for oldest first or \"desc\" for newest first; applied before offset/limit (default: asc)"),
+				mcp.Enum("asc", "desc"),
+			),
+			mcp.WithNumber("offset",
+				mcp.Description("Number of captures (or transactions in the http view) to skip after ordering (default: 0)"),
+			),
+			mcp.WithNumber("limit",
+				mcp.Description("Maximum number of captures (or transactions in the http view) to return after ordering (default: all)"),
+			),
+			mcp.WithString("format",
+				mcp.Description("Output encoding: json or cbor-base64 (default: json)"),
+				mcp.Enum("json", "cbor-base64"),
+			),
+			mcp.WithNumber("hexdump_width",
+				mcp.Description("Bytes per hex dump line: 8, 16 or 32 (default: 16)"),
+			),
+			mcp.WithBoolean("hexdump_ascii",
+				mcp.Description("Whether hex dump lines end with an ASCII gutter (default: true)"),
+			),
+		),
+		NewPeekCapturesHandler(manager).Execute,
+	)
+
+	// Register close_connection tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"close_connection",
+			mcp.WithDescription("Close one live connection of a proxy on both sides without stopping the proxy; its close_reason becomes manual"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+			mcp.WithNumber("conn_id",
+				mcp.Required(),
+				mcp.Description("ID of the connection, as listed by get_connections"),
+			),
+		),
+		NewCloseConnectionHandler(manager).Execute,
+	)
+
+	// Register export_captures tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"export_captures",
+			mcp.WithDescription("Write all of a proxy's captures to a JSON Lines file on disk instead of returning them, without clearing the buffer; returns the capture count and file size"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+			mcp.WithString("path",
+				mcp.Required(),
+				mcp.Description("File to write"),
+			),
+			mcp.WithBoolean("overwrite",
+				mcp.Description("Replace the file if it already exists (default: false)"),
+			),
+		),
+		NewExportCapturesHandler(manager).Execute,
+	)
+
+	// Register benchmark_proxy tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"benchmark_proxy",
+			mcp.WithDescription("Measure the latency a proxy adds: times payload round trips through the proxy and straight to its backend and reports both plus the overhead; the proxied round trips are captured like any other traffic"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy to benchmark"),
+			),
+			mcp.WithNumber("samples",
+				mcp.Description("Round trips on each path, 1-100 (default: 10)"),
+			),
+			mcp.WithString("payload",
+				mcp.Description("Text sent on each round trip; the backend must answer it (default: \"ping\\n\")"),
+			),
+			mcp.WithNumber("timeout_ms",
+				mcp.Description("Timeout for each round trip in milliseconds (default: 2000)"),
+			),
+		),
+		NewBenchmarkProxyHandler(manager).Execute,
+	)
+
+	// Register set_label tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"set_label",
+			mcp.WithDescription("Change the label and/or tags of a running proxy without restarting it; list_proxies, stop_proxies and other label filters use the new values right away"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+			mcp.WithString("label",
+				mcp.Description("New label; an empty string clears it (default: unchanged)"),
+			),
+			mcp.WithArray("tags",
+				mcp.Description("New tags, replacing the current ones; an empty list clears them (default: unchanged)"),
+				mcp.WithStringItems(),
+			),
+		),
+		NewSetLabelHandler(manager).Execute,
+	)
+
+	// Register get_transactions tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"get_transactions",
+			mcp.WithDescription("Group a proxy's HTTP/1.x captures into request/response transactions: each request is paired with the next response on its connection in FIFO order, so pipelined requests pair correctly; returns both summaries and the latency between them without clearing the buffer"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+			mcp.WithNumber("conn_id",
+				mcp.Description("Only return transactions of this connection (default: all)"),
+			),
+		),
+		NewGetTransactionsHandler(manager).Execute,
+	)
+
+	// Register reset_stats tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"reset_stats",
+			mcp.WithDescription("Zero a proxy's counters (bytes, connections, skipped and dropped captures) and its capture rate window to measure a fresh interval; captures in the buffer and live connections are kept"),
+			mcp.WithNumber("listen_port",
+				mcp.Required(),
+				mcp.Description("Port of the proxy"),
+			),
+		),
+		NewResetStatsHandler(manager).Execute,
+	)
+
+	// Register check_backend_protocol tool
+	mcpServer.AddTool(
+		mcp.NewTool(
+			"check_backend_protocol",
+			mcp.WithDescription("Check what protocol a backend speaks before proxying to it: dials it, optionally sends a small probe, and labels the response with the same protocol detection used for captures"),
+			mcp.WithString("host",
+				mcp.Description("Host to check (default: localhost)"),
+			),
+			mcp.WithNumber("port",
+				mcp.Description("Port to check (required unless listen_port is set)"),
+			),
+			mcp.WithNumber("listen_port",
+				mcp.Description("Check the backend of the proxy on this port instead of host/port"),
+			),
+			mcp.WithString("probe",
+				mcp.Description("What to send first: \"http\" for a minimal HEAD request, or \"none\" to wait for servers that speak first (default: http)"),
+				mcp.Enum(protocolProbeHTTP, protocolProbeNone),
+			),
+			mcp.WithString("payload",
+				mcp.Description("Text to send instead of the probe"),
+			),
+			mcp.WithString("expected_protocol",
+				mcp.Description("Protocol the backend should speak, e.g. HTTP/1.x; the result reports whether it matches"),
+			),
+			mcp.WithNumber("timeout_ms",
+				mcp.Description("Timeout for the dial and for the response in milliseconds (default: 2000)"),
+			),
+		),
+		NewCheckBackendProtocolHandler(manager).Execute,
+	)
+
+	// Start the server using stdio transport; this returns once the client
+	// disconnects or the process receives SIGINT/SIGTERM
+	err = server.ServeStdio(mcpServer)
+
 	// Handle graceful shutdown
-	go func() {
-		<-context.Background().Done()
-		manager.StopAll()
-	}()
+	if control != nil {
+		control.Close()
+	}
+	manager.StopAll()
 
-	// Start the server using stdio transport
-	if err := server.ServeStdio(mcpServer); err != nil {
+	if err != nil {
 		log.Fatalf("Failed to start MCP server: %v", err)
 	}
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// mirrorQueueLen bounds the client chunks waiting to be sent to a mirror
+const mirrorQueueLen = 64
+
+// mirrorConn tees a connection's client bytes to a shadow backend. Chunks
+// are queued and written from their own goroutine so the primary path never
+// waits on the mirror; a mirror that fails or falls behind is dropped for
+// the rest of the connection. Its responses are discarded.
+type mirrorConn struct {
+	proxy  *ProxyInstance
+	target string
+	queue  chan []byte
+	failed atomic.Bool
+}
+
+// openMirror starts mirroring for a new connection, or returns nil when the
+// proxy has no mirror_target. The dial happens in the background.
+func (p *ProxyInstance) openMirror(ctx context.Context) *mirrorConn {
+	if p.Options.MirrorTarget == "" {
+		return nil
+	}
+	m := &mirrorConn{
+		proxy:  p,
+		target: p.Options.MirrorTarget,
+		queue:  make(chan []byte, mirrorQueueLen),
+	}
+	go m.run(ctx)
+	return m
+}
+
+// send queues a copy of data for the mirror without blocking
+func (m *mirrorConn) send(data []byte) {
+	if m.failed.Load() {
+		return
+	}
+	select {
+	case m.queue <- append([]byte(nil), data...):
+	default:
+		m.fail("queue full, mirror is too slow")
+	}
+}
+
+// close ends mirroring once the connection is done; no send may follow
+func (m *mirrorConn) close() {
+	close(m.queue)
+}
+
+// run dials the mirror and writes queued chunks until the queue is closed
+func (m *mirrorConn) run(ctx context.Context) {
+	conn, err := dialBackend(ctx, m.target)
+	if err != nil {
+		if ctx.Err() == nil {
+			m.fail(err.Error())
+		}
+		for range m.queue {
+			// Drain until the connection closes the queue
+		}
+		return
+	}
+	defer conn.Close()
+	go io.Copy(io.Discard, conn)
+
+	for data := range m.queue {
+		if m.failed.Load() {
+			continue
+		}
+		if _, err := conn.Write(data); err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				m.fail(err.Error())
+			}
+			conn.Close()
+		}
+	}
+}
+
+// fail drops the mirror for the rest of the connection, logging and counting
+// the first failure only
+func (m *mirrorConn) fail(reason string) {
+	if m.failed.Swap(true) {
+		return
+	}
+	warnf("Mirror %s failed: %s", m.target, reason)
+	m.proxy.Stats.mu.Lock()
+	m.proxy.Stats.MirrorFailures++
+	m.proxy.Stats.mu.Unlock()
+}
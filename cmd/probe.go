@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"time"
+)
+
+// Limits for probe_backend
+const (
+	defaultProbeCount   = 5
+	maxProbeCount       = 100
+	defaultProbeTimeout = 2 * time.Second
+)
+
+// Defaults for benchmark_proxy
+const (
+	defaultBenchmarkSamples = 10
+	maxBenchmarkSamples     = 100
+	defaultBenchmarkPayload = "ping\n"
+)
+
+// Probes check_backend_protocol can send before reading
+const (
+	protocolProbeHTTP   = "http"   // A minimal HTTP/1.1 HEAD request
+	protocolProbeNone   = "none"   // Send nothing, for servers that speak first
+	protocolProbeCustom = "custom" // The caller's payload
+)
+
+// protocolPreviewBytes is how much of the response check_backend_protocol shows
+const protocolPreviewBytes = 200
+
+// LatencyStats summarizes a set of latencies in milliseconds
+type LatencyStats struct {
+	MinMs float64 `json:"min_ms,omitempty"`
+	AvgMs float64 `json:"avg_ms,omitempty"`
+	MaxMs float64 `json:"max_ms,omitempty"`
+	P95Ms float64 `json:"p95_ms,omitempty"`
+}
+
+// ProbeResult summarizes repeated connect attempts to a backend. Latencies
+// are in milliseconds and only cover successful dials.
+type ProbeResult struct {
+	Target      string  `json:"target"`
+	Attempts    int     `json:"attempts"`
+	Successes   int     `json:"successes"`
+	SuccessRate float64 `json:"success_rate"`
+	LatencyStats
+	Errors []string `json:"errors,omitempty"` // Distinct dial errors
+}
+
+// probeBackend dials target count times one after another, closing each
+// connection as soon as it is established, and measures the connect time
+func probeBackend(ctx context.Context, target string, count int, timeout time.Duration) ProbeResult {
+	result := ProbeResult{Target: target, Attempts: count}
+
+	var latencies []float64
+	seen := make(map[string]bool)
+	for i := 0; i < count && ctx.Err() == nil; i++ {
+		dialCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		conn, err := dialBackend(dialCtx, target)
+		elapsed := time.Since(start)
+		cancel()
+
+		if err != nil {
+			if !seen[err.Error()] {
+				seen[err.Error()] = true
+				result.Errors = append(result.Errors, err.Error())
+			}
+			continue
+		}
+		conn.Close()
+		latencies = append(latencies, float64(elapsed)/float64(time.Millisecond))
+	}
+
+	result.Successes = len(latencies)
+	if count > 0 {
+		result.SuccessRate = float64(result.Successes) / float64(count)
+	}
+	result.LatencyStats = summarizeLatencies(latencies)
+	return result
+}
+
+// summarizeLatencies computes the stats of latencies, sorting them in place
+func summarizeLatencies(latencies []float64) LatencyStats {
+	if len(latencies) == 0 {
+		return LatencyStats{}
+	}
+
+	sort.Float64s(latencies)
+	sum := 0.0
+	for _, l := range latencies {
+		sum += l
+	}
+	// Nearest-rank percentile
+	rank := int(math.Ceil(0.95*float64(len(latencies)))) - 1
+	return LatencyStats{
+		MinMs: latencies[0],
+		AvgMs: sum / float64(len(latencies)),
+		MaxMs: latencies[len(latencies)-1],
+		P95Ms: latencies[rank],
+	}
+}
+
+// ProtocolCheckResult reports what a backend answered to a probe
+type ProtocolCheckResult struct {
+	Target           string `json:"target"`
+	Probe            string `json:"probe"`
+	BytesReceived    int    `json:"bytes_received"`
+	DetectedProtocol string `json:"detected_protocol,omitempty"`
+	ExpectedProtocol string `json:"expected_protocol,omitempty"`
+	Matches          *bool  `json:"matches,omitempty"` // Set when an expected protocol is given
+	ResponsePreview  string `json:"response_preview,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// httpProbe returns the minimal HTTP request sent to target
+func httpProbe(target string) []byte {
+	return []byte("HEAD / HTTP/1.1\r\nHost: " + target + "\r\nConnection: close\r\n\r\n")
+}
+
+// checkBackendProtocol dials target, sends payload if any and labels the
+// response the way captures are labeled, reading until a detector matches,
+// sniffWindow bytes arrived or the backend stops sending
+func checkBackendProtocol(ctx context.Context, target string, payload []byte, timeout time.Duration) ProtocolCheckResult {
+	result := ProtocolCheckResult{Target: target}
+
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	conn, err := dialBackend(dialCtx, target)
+	cancel()
+	if err != nil {
+		result.Error = fmt.Sprintf("dial failed: %v", err)
+		return result
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if len(payload) > 0 {
+		if _, err := conn.Write(payload); err != nil {
+			result.Error = fmt.Sprintf("sending probe failed: %v", err)
+			return result
+		}
+	}
+
+	var sniffer protocolSniffer
+	var response []byte
+	protocol := "Unknown"
+	buf := make([]byte, 4096)
+	for len(response) < sniffWindow {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			response = append(response, buf[:n]...)
+			var identified bool
+			if protocol, identified = sniffer.detect(buf[:n], DirectionServerToClient); identified {
+				break
+			}
+		}
+		if err != nil {
+			var netErr net.Error
+			switch {
+			case len(response) > 0:
+				// Label whatever arrived
+			case errors.Is(err, io.EOF):
+				result.Error = "backend closed the connection without responding"
+				return result
+			case errors.As(err, &netErr) && netErr.Timeout():
+				result.Error = fmt.Sprintf("no response within %v", timeout)
+				return result
+			default:
+				result.Error = fmt.Sprintf("read failed: %v", err)
+				return result
+			}
+			break
+		}
+	}
+
+	// An opening that never matched is labeled as a whole
+	if protocol == "Unknown" {
+		protocol = detectProtocol(response, DirectionServerToClient)
+	}
+	result.BytesReceived = len(response)
+	result.DetectedProtocol = protocol
+	result.ResponsePreview, _ = printableText(response[:min(len(response), protocolPreviewBytes)])
+	return result
+}
+
+// BenchmarkResult compares payload round trips through a proxy with round
+// trips straight to its backend. Overheads are proxied minus direct.
+type BenchmarkResult struct {
+	Proxy         string       `json:"proxy"`
+	Backend       string       `json:"backend"`
+	Samples       int          `json:"samples"`
+	PayloadBytes  int          `json:"payload_bytes"`
+	Direct        LatencyStats `json:"direct"`
+	Proxied       LatencyStats `json:"proxied"`
+	OverheadAvgMs float64      `json:"overhead_avg_ms"`
+	OverheadMinMs float64      `json:"overhead_min_ms"`
+	Error         string       `json:"error,omitempty"`
+}
+
+// benchmarkProxy measures samples round trips of payload both through the
+// proxy and directly to the backend, alternating between the two so drift
+// affects both alike
+func benchmarkProxy(ctx context.Context, proxyAddr, backendAddr string, samples int, payload []byte, timeout time.Duration) BenchmarkResult {
+	result := BenchmarkResult{Proxy: proxyAddr, Backend: backendAddr, Samples: samples, PayloadBytes: len(payload)}
+
+	var direct, proxied []float64
+	for i := 0; i < samples; i++ {
+		ms, err := measureRoundTrip(ctx, backendAddr, payload, timeout)
+		if err != nil {
+			result.Error = fmt.Sprintf("direct round trip failed: %v", err)
+			return result
+		}
+		direct = append(direct, ms)
+
+		ms, err = measureRoundTrip(ctx, proxyAddr, payload, timeout)
+		if err != nil {
+			result.Error = fmt.Sprintf("proxied round trip failed: %v", err)
+			return result
+		}
+		proxied = append(proxied, ms)
+	}
+
+	result.Direct = summarizeLatencies(direct)
+	result.Proxied = summarizeLatencies(proxied)
+	result.OverheadAvgMs = result.Proxied.AvgMs - result.Direct.AvgMs
+	result.OverheadMinMs = result.Proxied.MinMs - result.Direct.MinMs
+	return result
+}
+
+// measureRoundTrip connects to target and times how long it takes from
+// writing payload to the first response byte. The connect is not timed, and
+// each sample uses a fresh connection so any request/response backend works.
+func measureRoundTrip(ctx context.Context, target string, payload []byte, timeout time.Duration) (float64, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	conn, err := dialBackend(dialCtx, target)
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	buf := make([]byte, 4096)
+	start := time.Now()
+	if _, err := conn.Write(payload); err != nil {
+		return 0, err
+	}
+	if _, err := conn.Read(buf); err != nil {
+		return 0, err
+	}
+	return float64(time.Since(start)) / float64(time.Millisecond), nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// TestProbeBackend tests connect latency measurements against a local
+// listener and a closed port
+func TestProbeBackend(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	manager := NewProxyManager()
+	result := callTool(t, NewProbeBackendHandler(manager).Execute, map[string]interface{}{
+		"host":  "127.0.0.1",
+		"port":  float64(port),
+		"count": float64(10),
+	})
+	if result["attempts"] != float64(10) || result["successes"] != float64(10) || result["success_rate"] != float64(1) {
+		t.Fatalf("Expected 10 successful dials, got %v", result)
+	}
+	minMs, avgMs, maxMs, p95Ms := result["min_ms"].(float64), result["avg_ms"].(float64), result["max_ms"].(float64), result["p95_ms"].(float64)
+	if minMs <= 0 || minMs > avgMs || avgMs > maxMs || p95Ms < minMs || p95Ms > maxMs {
+		t.Errorf("Inconsistent latencies: min=%v avg=%v max=%v p95=%v", minMs, avgMs, maxMs, p95Ms)
+	}
+	if maxMs > 1000 {
+		t.Errorf("Expected local connects well under a second, got max %vms", maxMs)
+	}
+
+	// A closed port fails every dial and reports the error once
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	result = callTool(t, NewProbeBackendHandler(manager).Execute, map[string]interface{}{
+		"host":       "127.0.0.1",
+		"port":       float64(closedPort),
+		"count":      float64(3),
+		"timeout_ms": float64(500),
+	})
+	if result["successes"] != float64(0) || result["success_rate"] != float64(0) {
+		t.Errorf("Expected no successful dials to a closed port, got %v", result)
+	}
+	if errs, _ := result["errors"].([]interface{}); len(errs) != 1 {
+		t.Errorf("Expected one distinct error, got %v", result["errors"])
+	}
+	if _, ok := result["min_ms"]; ok {
+		t.Errorf("Expected no latencies without a successful dial, got %v", result)
+	}
+
+	// listen_port probes the proxy's backend
+	if err := manager.StartProxy(19132, "127.0.0.1", port, 1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19132)
+	result = callTool(t, NewProbeBackendHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19132),
+		"count":       float64(2),
+	})
+	if result["target"] != net.JoinHostPort("127.0.0.1", strconv.Itoa(port)) || result["successes"] != float64(2) {
+		t.Errorf("Expected 2 successful dials to the proxy's backend, got %v", result)
+	}
+}
+
+// TestBenchmarkProxy tests that round trips through a proxy to an echo
+// server are compared with direct ones and a small overhead is reported
+func TestBenchmarkProxy(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	defer manager.StopAll()
+	if err := manager.StartProxy(19203, "127.0.0.1", backendPort, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+
+	result := callTool(t, NewBenchmarkProxyHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19203),
+		"samples":     float64(20),
+	})
+	if result["error"] != nil {
+		t.Fatalf("Benchmark failed: %v", result["error"])
+	}
+	if result["samples"] != float64(20) || result["payload_bytes"] != float64(5) {
+		t.Errorf("Unexpected benchmark parameters: %v", result)
+	}
+	direct := result["direct"].(map[string]interface{})
+	proxied := result["proxied"].(map[string]interface{})
+	if direct["min_ms"].(float64) <= 0 || proxied["min_ms"].(float64) <= 0 {
+		t.Fatalf("Expected measured round trips, got direct %v proxied %v", direct, proxied)
+	}
+
+	// The extra hop costs little on loopback; it can even measure below the
+	// direct path when scheduling noise favours the proxied samples
+	if overhead := result["overhead_min_ms"].(float64); overhead > 100 {
+		t.Errorf("Expected a small overhead, got %vms", overhead)
+	}
+	if avg := result["overhead_avg_ms"].(float64); avg > 100 {
+		t.Errorf("Expected a small average overhead, got %vms", avg)
+	}
+
+	// Proxied round trips are captured
+	proxy, _ := manager.GetProxy(19203)
+	if len(proxy.Buffer.GetAll()) == 0 {
+		t.Error("Expected the proxied round trips to be captured")
+	}
+
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{
+		"listen_port": float64(19203),
+		"samples":     float64(maxBenchmarkSamples + 1),
+	}}}
+	if _, err := NewBenchmarkProxyHandler(manager).Execute(context.Background(), request); err == nil {
+		t.Error("Expected an error for samples out of range")
+	}
+}
+
+// TestCheckBackendProtocol tests that an HTTP server is detected from its
+// answer to the HTTP probe, and that a silent backend reports an error
+func TestCheckBackendProtocol(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer backend.Close()
+	port := backend.Listener.Addr().(*net.TCPAddr).Port
+
+	manager := NewProxyManager()
+	handler := NewCheckBackendProtocolHandler(manager)
+	result := callTool(t, handler.Execute, map[string]interface{}{
+		"host":              "127.0.0.1",
+		"port":              float64(port),
+		"expected_protocol": "http/1.x",
+	})
+	if result["error"] != nil {
+		t.Fatalf("Check failed: %v", result["error"])
+	}
+	if result["probe"] != "http" || result["detected_protocol"] != "HTTP/1.x" || result["matches"] != true {
+		t.Errorf("Expected the HTTP probe to detect HTTP/1.x, got %v", result)
+	}
+	if preview, _ := result["response_preview"].(string); !strings.HasPrefix(preview, "HTTP/1.1 204") {
+		t.Errorf("Expected a preview of the status line, got %q", preview)
+	}
+
+	// Without a probe the HTTP server stays silent
+	result = callTool(t, handler.Execute, map[string]interface{}{
+		"host":       "127.0.0.1",
+		"port":       float64(port),
+		"probe":      "none",
+		"timeout_ms": float64(200),
+	})
+	if errText, _ := result["error"].(string); !strings.Contains(errText, "no response") {
+		t.Errorf("Expected a silent backend to time out, got %v", result)
+	}
+}
@@ -1,41 +1,187 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math/rand"
 	"net"
+	"net/netip"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // ProxyManager manages all proxy instances
 type ProxyManager struct {
 	proxies map[int]*ProxyInstance
+	budget  *captureBudget // Bytes stored across all proxies, nil when unlimited
 	mu      sync.RWMutex
+
+	noPrivilegedPorts bool // Refuse listen ports below 1024
+	maxProxies        int  // Running proxies allowed at once (0 = unlimited)
+
+	history []StoppedProxy // Final stats of stopped proxies, oldest first
 }
 
 // ProxyInstance represents a single proxy
 type ProxyInstance struct {
-	ListenPort  int
-	ForwardHost string
-	ForwardPort int
-	Listener    net.Listener
-	Buffer      *RingBuffer
-	Stats       *ProxyStats
-	Done        chan struct{}
-	StartedAt   time.Time
-	connections int32 // atomic counter
+	ListenPort   int
+	ForwardHost  string
+	ForwardPort  int
+	CaptureLimit int
+	Listener     net.Listener
+	PacketConn   net.PacketConn // Listening socket instead of Listener when listen_protocol is udp
+	Buffer       *RingBuffer
+	Files        *CaptureFileWriter // Optional on-disk capture, nil when disabled
+	Options      ProxyOptions
+	Stats        *ProxyStats
+	StartedAt    time.Time
+	connections  int32 // atomic counter
+	goroutines   int32 // atomic counter of live copy goroutines
+	nextConnID   uint64
+	nextSeq      uint64
+	captureOff   atomic.Bool         // Set by set_capture to skip capture processing
+	redactor     *redactor           // Masks sensitive data in stored captures, nil when disabled
+	excluded     []netip.Prefix      // Sources whose connections are proxied but not captured
+	ingestMarker []byte              // Options.IngestContains, converted once
+	targetConns  []atomic.Int64      // Connections sent to each of Options.ForwardTargets
+	webhook      *webhookNotifier    // Posts captures matching webhook_pattern, nil when disabled
+	handlerSlots chan struct{}       // Semaphore bounding connections handled at once
+	captureRate  *captureRateLimiter // Token bucket for max_captures_per_sec, nil when unlimited
+	accepts      acceptHealth        // Accept loop counters for get_socket_info
+	readBuffers  *readBufferPool     // Copy loop read buffers shared by all connections
+
+	label   string
+	tags    []string
+	labelMu sync.RWMutex
+
+	conns       map[uint64]*Connection // Live connections by ID
+	closedConns []ConnectionInfo       // Recently closed connections, oldest first
+	connsMu     sync.Mutex
+
+	connsPerIP   map[string]int // Live connections by source IP, when MaxConnsPerIP is set
+	connsPerIPMu sync.Mutex
+
+	activityCh chan struct{} // Closed on the next capture or connection, nil when nobody waits
+	activityMu sync.Mutex
+
+	// ctx is cancelled when the proxy is stopped; every connection derives
+	// its own context from it so shutdown tears everything down at once.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup // tracks the accept loop and connection handlers
 }
 
+// ProxyOptions holds optional per-proxy settings
+type ProxyOptions struct {
+	CaptureDir  string // Directory for rotating capture files (empty disables)
+	RotateBytes int64  // Size at which to roll to a new capture file
+	MaxFiles    int    // Maximum number of capture files to keep
+
+	Label string   // Free-form label for grouping proxies
+	Tags  []string // Additional grouping tags
+
+	HTTPHeadersOnly         bool // Drop HTTP message bodies from stored captures
+	HTTPMaxBodyBytes        int  // Keep only this much of each HTTP message body (0 = unlimited)
+	HTTPErrorsOnly          bool // Store only HTTP transactions whose response status is 400 or above
+	MaxStoredBytesPerPacket int  // Truncate each stored payload to this size (0 = unlimited)
+
+	Redact         bool     // Mask Authorization, Cookie and Set-Cookie header values in stored captures
+	RedactPatterns []string // Regular expressions whose matches are masked in stored captures
+
+	AdaptiveSampling  bool // Randomly skip captures as the buffer fills up
+	MaxCapturesPerSec int  // Store at most this many captures per second (0 = unlimited)
+
+	StopCaptureAtPercent float64 // Buffer usage past which new captures are dropped instead of evicting old ones (0 = evict)
+	RetainSeconds        int     // Evict captures older than this many seconds (0 = no time limit)
+	EvictionPolicy       string  // fifo (default), keep_largest or keep_protocol:<name>
+
+	PassThrough bool // Forward without capturing, letting the kernel splice data where possible
+
+	DisableDetection bool // Skip protocol detection, labeling captures "Disabled"
+
+	MaxConnsPerIP      int // Maximum concurrent connections from one source IP (0 = unlimited)
+	MaxConcurrentConns int // Connections handled at once; further ones wait to be accepted (0 = default)
+
+	TCPKeepAlive time.Duration // Keepalive period for both sides (0 = Go default, negative = disabled)
+	TCPNagle     bool          // Re-enable Nagle batching (tcp_nodelay: false) on both sides
+
+	ReadBufferSize int // Bytes read from a socket per copy (0 = default)
+
+	MirrorTarget string // host:port that also receives the client's bytes (empty disables)
+
+	CoalesceWindow time.Duration // Merge same-direction reads this close together into one capture (0 = off)
+
+	LineMode bool // Split captures on newlines for line-oriented text protocols
+
+	TextOnly         bool    // Skip storing packets that are mostly binary
+	TextMinPrintable float64 // Printable byte ratio a packet needs under TextOnly (0 = default)
+
+	IngestContains string // Store only packets containing this literal substring (empty disables)
+
+	FirstPacketOnly bool // Store only the first packet in each direction of a connection
+
+	TraceHeader string // Correlation header added to HTTP requests lacking it and recorded (empty disables)
+
+	ExcludeCIDRs []string // Source ranges or addresses whose connections are not captured
+
+	ForwardTargets []ForwardTarget // Weighted backends replacing the single forward target (empty = unused)
+
+	ListenProtocol  string // tcp (default) or udp; udp bridges datagrams to a TCP backend
+	ForwardProtocol string // tcp (default) or udp; udp bridges a TCP client to a UDP backend
+	ListenNetwork   string // tcp (default), tcp4 or tcp6 to bind a single IP version
+
+	WebhookURL     string // URL receiving a POST for each capture matching WebhookPattern (empty disables)
+	WebhookPattern string // Regular expression matched against stored captures
+}
+
+// Adaptive sampling keeps every capture below the threshold buffer usage and
+// then keeps a linearly shrinking share, never less than the minimum
+const (
+	adaptiveSamplingThreshold = 50.0 // Percent
+	adaptiveSamplingMinKeep   = 0.05
+)
+
+// defaultMaxConcurrentConns bounds the connections a proxy handles at once
+// when max_concurrent_connections is not set
+const defaultMaxConcurrentConns = 1024
+
+// defaultTextMinPrintable is the printable byte ratio text_only_capture
+// requires when no threshold is given
+const defaultTextMinPrintable = 0.8
+
 // ProxyStats tracks proxy statistics
 type ProxyStats struct {
-	BytesCaptured int64
-	Connections   int64
-	mu            sync.RWMutex
+	BytesCaptured       int64
+	Connections         int64
+	SampledOut          int64     // Captures skipped by adaptive sampling
+	RateLimited         int64     // Captures skipped by max_captures_per_sec
+	BinarySkipped       int64     // Captures skipped by text_only_capture
+	NoMatchSkipped      int64     // Captures skipped by ingest_contains
+	LaterPacketsSkipped int64     // Captures skipped by first_packet_only
+	NonErrorSkipped     int64     // Captures of successful transactions skipped by http_capture_errors_only
+	HeldDropped         int64     // Captures held by http_capture_errors_only dropped over the cap or unanswered at close
+	Rejected            int64     // Connections refused by connection limits
+	MirrorFailures      int64     // Connections whose mirror was dropped
+	BudgetDropped       int64     // Captures dropped by the global capture budget
+	WatermarkDropped    int64     // Captures refused past stop_capture_at_percent
+	PolicyDropped       int64     // Captures refused by keep_largest as smaller than every stored one
+	Excluded            int64     // Connections not captured because of exclude_cidrs
+	WebhookSent         int64     // Webhook notifications delivered
+	WebhookFailures     int64     // Webhook notifications that failed every attempt
+	WebhookDropped      int64     // Webhook notifications dropped because the queue was full
+	AcceptsQueued       int64     // Connections that waited for a free handler slot
+	ResetAt             time.Time // Last reset_stats, zero if never reset
+	mu                  sync.RWMutex
 }
 
 // NewProxyManager creates a new proxy manager
@@ -45,41 +191,309 @@ func NewProxyManager() *ProxyManager {
 	}
 }
 
+// SetNoPrivilegedPorts makes proxies started from now on refuse listen
+// ports below 1024
+func (pm *ProxyManager) SetNoPrivilegedPorts(refuse bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.noPrivilegedPorts = refuse
+}
+
+// SetMaxProxies caps how many proxies may run at once (0 = unlimited)
+func (pm *ProxyManager) SetMaxProxies(max int) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.maxProxies = max
+}
+
+// MaxProxies returns the cap on running proxies (0 = unlimited)
+func (pm *ProxyManager) MaxProxies() int {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return pm.maxProxies
+}
+
 // StartProxy starts a new proxy instance
 func (pm *ProxyManager) StartProxy(listenPort int, forwardHost string, forwardPort int, captureLimit int) error {
+	return pm.StartProxyWithOptions(listenPort, forwardHost, forwardPort, captureLimit, ProxyOptions{})
+}
+
+// StartProxyWithOptions starts a new proxy instance with optional settings
+func (pm *ProxyManager) StartProxyWithOptions(listenPort int, forwardHost string, forwardPort int, captureLimit int, opts ProxyOptions) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	return pm.startProxyLocked(listenPort, forwardHost, forwardPort, captureLimit, opts)
+}
+
+// Policies for starting a proxy on a port that already has one
+const (
+	IfExistsError   = "error"   // Fail with "already running"
+	IfExistsReturn  = "return"  // Keep the existing proxy if its config matches
+	IfExistsRestart = "restart" // Replace the existing proxy with the new config
+)
+
+// CloneProxy starts a proxy on listenPort with the settings of the proxy on
+// sourcePort, including its current label, tags and capture switch, but with
+// an empty buffer and fresh stats
+func (pm *ProxyManager) CloneProxy(sourcePort, listenPort int) (*ProxyInstance, error) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
+	source, exists := pm.proxies[sourcePort]
+	if !exists {
+		return nil, fmt.Errorf("no proxy running on port %d", sourcePort)
+	}
+	opts := source.Options
+	opts.Label = source.Label()
+	opts.Tags = source.Tags()
+
+	if err := pm.startProxyLocked(listenPort, source.ForwardHost, source.ForwardPort, source.CaptureLimit, opts); err != nil {
+		return nil, err
+	}
+	clone := pm.proxies[listenPort]
+	clone.captureOff.Store(source.captureOff.Load())
+	return clone, nil
+}
+
+// EnsureProxy starts a proxy, applying the ifExists policy when one is already
+// running on listenPort. It returns "started", "existing" or "restarted". A
+// restart that fails to start the new configuration brings the previous one
+// back, with an empty buffer.
+func (pm *ProxyManager) EnsureProxy(listenPort int, forwardHost string, forwardPort int, captureLimit int, opts ProxyOptions, ifExists string) (string, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	existing, exists := pm.proxies[listenPort]
+	if !exists {
+		return "started", pm.startProxyLocked(listenPort, forwardHost, forwardPort, captureLimit, opts)
+	}
+
+	switch ifExists {
+	case "", IfExistsError:
+		return "", fmt.Errorf("proxy already running on port %d", listenPort)
+	case IfExistsReturn:
+		if !existing.matchesConfig(forwardHost, forwardPort, captureLimit, opts) {
+			return "", fmt.Errorf("proxy already running on port %d with a different configuration (forwarding to %s:%d)",
+				listenPort, existing.ForwardHost, existing.ForwardPort)
+		}
+		return "existing", nil
+	case IfExistsRestart:
+		previous := existing.Options
+		previous.Label = existing.Label()
+		previous.Tags = existing.Tags()
+		pm.stopProxyLocked(listenPort)
+		if err := pm.startProxyLocked(listenPort, forwardHost, forwardPort, captureLimit, opts); err != nil {
+			if rerr := pm.startProxyLocked(listenPort, existing.ForwardHost, existing.ForwardPort, existing.CaptureLimit, previous); rerr != nil {
+				return "", fmt.Errorf("%v; restoring the previous configuration also failed: %v", err, rerr)
+			}
+			pm.proxies[listenPort].captureOff.Store(existing.captureOff.Load())
+			return "", fmt.Errorf("%v; the previous configuration was restored", err)
+		}
+		return "restarted", nil
+	default:
+		return "", fmt.Errorf("invalid if_exists %q (expected %q, %q or %q)", ifExists, IfExistsError, IfExistsReturn, IfExistsRestart)
+	}
+}
+
+// matchesConfig reports whether the proxy runs with the given settings. The
+// label and tags are compared as they are now, since set_label changes them.
+func (p *ProxyInstance) matchesConfig(forwardHost string, forwardPort int, captureLimit int, opts ProxyOptions) bool {
+	return p.ForwardHost == forwardHost &&
+		p.ForwardPort == forwardPort &&
+		p.CaptureLimit == captureLimit &&
+		p.Label() == opts.Label &&
+		slices.Equal(p.Tags(), opts.Tags) &&
+		p.Options.sameSettings(opts)
+}
+
+// sameSettings reports whether two sets of options configure a proxy alike,
+// apart from the label and tags. Nil and empty lists are equal, as are an
+// unset protocol or network and tcp.
+func (o ProxyOptions) sameSettings(other ProxyOptions) bool {
+	return o.CaptureDir == other.CaptureDir &&
+		o.RotateBytes == other.RotateBytes &&
+		o.MaxFiles == other.MaxFiles &&
+		o.HTTPHeadersOnly == other.HTTPHeadersOnly &&
+		o.HTTPMaxBodyBytes == other.HTTPMaxBodyBytes &&
+		o.HTTPErrorsOnly == other.HTTPErrorsOnly &&
+		o.MaxStoredBytesPerPacket == other.MaxStoredBytesPerPacket &&
+		o.Redact == other.Redact &&
+		slices.Equal(o.RedactPatterns, other.RedactPatterns) &&
+		o.AdaptiveSampling == other.AdaptiveSampling &&
+		o.MaxCapturesPerSec == other.MaxCapturesPerSec &&
+		o.StopCaptureAtPercent == other.StopCaptureAtPercent &&
+		o.RetainSeconds == other.RetainSeconds &&
+		o.EvictionPolicy == other.EvictionPolicy &&
+		o.PassThrough == other.PassThrough &&
+		o.DisableDetection == other.DisableDetection &&
+		o.MaxConnsPerIP == other.MaxConnsPerIP &&
+		o.MaxConcurrentConns == other.MaxConcurrentConns &&
+		o.TCPKeepAlive == other.TCPKeepAlive &&
+		o.TCPNagle == other.TCPNagle &&
+		o.ReadBufferSize == other.ReadBufferSize &&
+		o.MirrorTarget == other.MirrorTarget &&
+		o.CoalesceWindow == other.CoalesceWindow &&
+		o.LineMode == other.LineMode &&
+		o.TextOnly == other.TextOnly &&
+		o.TextMinPrintable == other.TextMinPrintable &&
+		o.IngestContains == other.IngestContains &&
+		o.FirstPacketOnly == other.FirstPacketOnly &&
+		o.TraceHeader == other.TraceHeader &&
+		slices.Equal(o.ExcludeCIDRs, other.ExcludeCIDRs) &&
+		slices.Equal(o.ForwardTargets, other.ForwardTargets) &&
+		orTCP(o.ListenProtocol) == orTCP(other.ListenProtocol) &&
+		orTCP(o.ForwardProtocol) == orTCP(other.ForwardProtocol) &&
+		orTCP(o.ListenNetwork) == orTCP(other.ListenNetwork) &&
+		o.WebhookURL == other.WebhookURL &&
+		o.WebhookPattern == other.WebhookPattern
+}
+
+// orTCP returns value, or tcp when it is unset
+func orTCP(value string) string {
+	if value == "" {
+		return ProtocolTCP
+	}
+	return value
+}
+
+// startProxyLocked creates, starts and registers a proxy
+// IMPORTANT: This assumes pm.mu is already held by the caller
+func (pm *ProxyManager) startProxyLocked(listenPort int, forwardHost string, forwardPort int, captureLimit int, opts ProxyOptions) error {
 	// Check if proxy already exists on this port
 	if _, exists := pm.proxies[listenPort]; exists {
 		return fmt.Errorf("proxy already running on port %d", listenPort)
 	}
+	if pm.noPrivilegedPorts && listenPort > 0 && listenPort < 1024 {
+		return fmt.Errorf("listen_port %d is a privileged port, refused by --no-privileged-ports", listenPort)
+	}
+	if pm.maxProxies > 0 && len(pm.proxies) >= pm.maxProxies {
+		return fmt.Errorf("%d proxies already running, the limit set by --max-proxies; stop one first", len(pm.proxies))
+	}
+
+	// Refuse configurations that would forward back into this proxy
+	if err := pm.checkForwardLoopLocked(listenPort, forwardHost, forwardPort); err != nil {
+		return err
+	}
+	for _, target := range opts.ForwardTargets {
+		if err := pm.checkForwardLoopLocked(listenPort, target.Host, target.Port); err != nil {
+			return fmt.Errorf("invalid forward_targets: %v", err)
+		}
+	}
+	if opts.MirrorTarget != "" {
+		mirrorHost, mirrorPort, err := splitTarget(opts.MirrorTarget)
+		if err != nil {
+			return fmt.Errorf("invalid mirror_target: %v", err)
+		}
+		if err := pm.checkForwardLoopLocked(listenPort, mirrorHost, mirrorPort); err != nil {
+			return fmt.Errorf("invalid mirror_target: %v", err)
+		}
+	}
+
+	// Compile redaction patterns before binding so bad patterns fail cleanly
+	redactor, err := newRedactor(opts.Redact, opts.RedactPatterns)
+	if err != nil {
+		return err
+	}
+	policy, err := parseEvictionPolicy(opts.EvictionPolicy)
+	if err != nil {
+		return err
+	}
+	excluded, err := parseCIDRs(opts.ExcludeCIDRs)
+	if err != nil {
+		return fmt.Errorf("invalid exclude_cidrs: %v", err)
+	}
+	webhookPattern, err := validateWebhook(opts.WebhookURL, opts.WebhookPattern)
+	if err != nil {
+		return err
+	}
+	if err := validateListenNetwork(opts.ListenNetwork); err != nil {
+		return err
+	}
 
-	// Try to create listener
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", listenPort))
+	// Try to create listener, a UDP socket when bridging from UDP
+	var listener net.Listener
+	var packetConn net.PacketConn
+	var socket io.Closer
+	if opts.ListenProtocol == ProtocolUDP {
+		packetConn, err = net.ListenPacket(opts.socketNetwork(), fmt.Sprintf(":%d", listenPort))
+		socket = packetConn
+	} else {
+		listener, err = net.Listen(opts.socketNetwork(), fmt.Sprintf(":%d", listenPort))
+		socket = listener
+	}
 	if err != nil {
 		return fmt.Errorf("failed to bind to port %d: %v", listenPort, err)
 	}
 
+	// Open rotating capture files if requested
+	var files *CaptureFileWriter
+	if opts.CaptureDir != "" {
+		files, err = NewCaptureFileWriter(opts.CaptureDir, fmt.Sprintf("proxy-%d", listenPort), opts.RotateBytes, opts.MaxFiles)
+		if err != nil {
+			socket.Close()
+			return err
+		}
+	}
+
+	buffer := NewRingBuffer(captureLimit)
+	buffer.budget = pm.budget
+	if opts.StopCaptureAtPercent > 0 {
+		buffer.SetStopAtPercent(opts.StopCaptureAtPercent)
+	}
+	buffer.SetEvictionPolicy(policy)
+	buffer.SetRetention(time.Duration(opts.RetainSeconds) * time.Second)
+
+	maxConcurrent := opts.MaxConcurrentConns
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultMaxConcurrentConns
+	}
+
 	// Create proxy instance
+	ctx, cancel := context.WithCancel(context.Background())
 	proxy := &ProxyInstance{
-		ListenPort:  listenPort,
-		ForwardHost: forwardHost,
-		ForwardPort: forwardPort,
-		Listener:    listener,
-		Buffer:      NewRingBuffer(captureLimit),
-		Stats:       &ProxyStats{},
-		Done:        make(chan struct{}),
-		StartedAt:   time.Now(),
+		ListenPort:   listenPort,
+		ForwardHost:  forwardHost,
+		ForwardPort:  forwardPort,
+		CaptureLimit: captureLimit,
+		Listener:     listener,
+		PacketConn:   packetConn,
+		Buffer:       buffer,
+		Files:        files,
+		Options:      opts,
+		Stats:        &ProxyStats{},
+		StartedAt:    time.Now(),
+		conns:        make(map[uint64]*Connection),
+		label:        opts.Label,
+		tags:         append([]string(nil), opts.Tags...),
+		redactor:     redactor,
+		excluded:     excluded,
+		ingestMarker: []byte(opts.IngestContains),
+		targetConns:  make([]atomic.Int64, len(opts.ForwardTargets)),
+		handlerSlots: make(chan struct{}, maxConcurrent),
+		captureRate:  newCaptureRateLimiter(opts.MaxCapturesPerSec),
+		readBuffers:  newReadBufferPool(opts.ReadBufferSize),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	proxy.webhook = proxy.startWebhook(webhookPattern)
+	if opts.RetainSeconds > 0 {
+		proxy.wg.Add(1)
+		go proxy.expireCapturesPeriodically()
 	}
 
 	// Start proxy goroutine
-	go proxy.run()
+	proxy.wg.Add(1)
+	if packetConn != nil {
+		go proxy.runUDP()
+	} else {
+		go proxy.run()
+	}
 
 	// Store proxy
 	pm.proxies[listenPort] = proxy
 
-	log.Printf("Started proxy on port %d forwarding to %s:%d", listenPort, forwardHost, forwardPort)
+	infof("Started proxy on port %d forwarding to %s:%d", listenPort, forwardHost, forwardPort)
 	return nil
 }
 
@@ -88,16 +502,20 @@ func (pm *ProxyManager) StopProxy(listenPort int) (int64, error) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
-	proxy, exists := pm.proxies[listenPort]
-	if !exists {
+	if _, exists := pm.proxies[listenPort]; !exists {
 		return 0, fmt.Errorf("no proxy running on port %d", listenPort)
 	}
 
-	// Signal shutdown
-	close(proxy.Done)
+	return pm.stopProxyLocked(listenPort), nil
+}
+
+// stopProxyLocked stops and unregisters a proxy, returning its captured byte count
+// IMPORTANT: This assumes pm.mu is already held by the caller
+func (pm *ProxyManager) stopProxyLocked(listenPort int) int64 {
+	proxy := pm.proxies[listenPort]
 
-	// Close listener
-	proxy.Listener.Close()
+	// Signal shutdown and wait for all connections to drain
+	proxy.stop()
 
 	// Get final stats
 	proxy.Stats.mu.RLock()
@@ -106,9 +524,64 @@ func (pm *ProxyManager) StopProxy(listenPort int) (int64, error) {
 
 	// Remove from map
 	delete(pm.proxies, listenPort)
+	pm.recordHistoryLocked(proxy)
 
-	log.Printf("Stopped proxy on port %d (captured %d bytes)", listenPort, bytesCaptured)
-	return bytesCaptured, nil
+	infof("Stopped proxy on port %d (captured %d bytes)", listenPort, bytesCaptured)
+	return bytesCaptured
+}
+
+// checkForwardLoopLocked returns an error if forwarding to forwardHost:forwardPort
+// would lead back to listenPort, either directly or through a chain of
+// running proxies
+// IMPORTANT: This assumes pm.mu is already held by the caller
+func (pm *ProxyManager) checkForwardLoopLocked(listenPort int, forwardHost string, forwardPort int) error {
+	if !isLocalHost(forwardHost) {
+		return nil
+	}
+	if forwardPort == listenPort {
+		return fmt.Errorf("forward target %s:%d is the proxy itself (listen_port %d); this would loop forever", forwardHost, forwardPort, listenPort)
+	}
+
+	chain := []string{fmt.Sprintf(":%d", listenPort)}
+	visited := map[int]bool{listenPort: true}
+	for port := forwardPort; ; {
+		next, exists := pm.proxies[port]
+		if !exists || !isLocalHost(next.ForwardHost) {
+			return nil
+		}
+		chain = append(chain, fmt.Sprintf(":%d", port))
+		if next.ForwardPort == listenPort {
+			chain = append(chain, fmt.Sprintf(":%d", listenPort))
+			return fmt.Errorf("forwarding loop detected through running proxies: %s", strings.Join(chain, " -> "))
+		}
+		if visited[next.ForwardPort] {
+			return nil // An existing loop not involving this proxy
+		}
+		visited[port] = true
+		port = next.ForwardPort
+	}
+}
+
+// splitTarget splits a host:port target
+func splitTarget(target string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in %q", target)
+	}
+	return host, port, nil
+}
+
+// isLocalHost reports whether host refers to this machine
+func isLocalHost(host string) bool {
+	if host == "" || strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
 }
 
 // GetProxy returns a proxy instance by port
@@ -131,199 +604,784 @@ func (pm *ProxyManager) GetAllProxies() []*ProxyInstance {
 	return result
 }
 
+// GetProxiesByLabel returns the proxies whose label or tags match label
+func (pm *ProxyManager) GetProxiesByLabel(label string) []*ProxyInstance {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	var result []*ProxyInstance
+	for _, proxy := range pm.proxies {
+		if proxy.HasLabel(label) {
+			result = append(result, proxy)
+		}
+	}
+	return result
+}
+
+// StopProxiesByLabel stops every proxy whose label or tags match label and
+// returns the captured byte count of each stopped proxy by port
+func (pm *ProxyManager) StopProxiesByLabel(label string) map[int]int64 {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	stopped := make(map[int]int64)
+	for port, proxy := range pm.proxies {
+		if proxy.HasLabel(label) {
+			stopped[port] = pm.stopProxyLocked(port)
+		}
+	}
+	return stopped
+}
+
 // StopAll stops all proxies
 func (pm *ProxyManager) StopAll() {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
 	for port, proxy := range pm.proxies {
-		close(proxy.Done)
-		proxy.Listener.Close()
-		log.Printf("Stopped proxy on port %d", port)
+		proxy.stop()
+		pm.recordHistoryLocked(proxy)
+		infof("Stopped proxy on port %d", port)
 	}
 	pm.proxies = make(map[int]*ProxyInstance)
 }
 
+// stop cancels the proxy context, closes the listener and waits for the
+// accept loop and all connection handlers to exit
+func (p *ProxyInstance) stop() {
+	p.cancel()
+	if p.PacketConn != nil {
+		p.PacketConn.Close()
+	} else {
+		p.Listener.Close()
+	}
+	p.wg.Wait()
+
+	if p.Files != nil {
+		p.Files.Close()
+	}
+	p.Buffer.releaseBudget()
+}
+
+// acceptBackoff paces a listening loop after failed accepts. Errors such as
+// EMFILE persist until descriptors are freed, so retrying immediately would
+// spin; like net/http.Server the delay doubles from 5ms up to 1s and resets
+// after the next success.
+type acceptBackoff struct {
+	delay time.Duration
+}
+
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+)
+
+// wait sleeps for the next delay, returning false if ctx is cancelled first
+func (b *acceptBackoff) wait(ctx context.Context) bool {
+	if b.delay == 0 {
+		b.delay = minAcceptBackoff
+	} else if b.delay *= 2; b.delay > maxAcceptBackoff {
+		b.delay = maxAcceptBackoff
+	}
+
+	timer := time.NewTimer(b.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (b *acceptBackoff) reset() {
+	b.delay = 0
+}
+
 // run is the main proxy loop
 func (p *ProxyInstance) run() {
-	log.Printf("Proxy listening on :%d, forwarding to %s:%d", p.ListenPort, p.ForwardHost, p.ForwardPort)
+	defer p.wg.Done()
 
-	for {
-		select {
-		case <-p.Done:
-			return
-		default:
-			// Set accept deadline to check for shutdown periodically
-			p.Listener.(*net.TCPListener).SetDeadline(time.Now().Add(1 * time.Second))
+	infof("Proxy listening on :%d, forwarding to %s:%d", p.ListenPort, p.ForwardHost, p.ForwardPort)
 
-			clientConn, err := p.Listener.Accept()
-			if err != nil {
-				if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
-					continue // Timeout is expected, check for shutdown
-				}
-				if !strings.Contains(err.Error(), "use of closed network connection") {
-					log.Printf("Accept error on port %d: %v", p.ListenPort, err)
-				}
-				continue
+	var backoff acceptBackoff
+	for {
+		// Accept blocks until a client arrives or the listener is closed by stop()
+		clientConn, err := p.Listener.Accept()
+		if err != nil {
+			if p.ctx.Err() != nil {
+				return // Proxy is shutting down
 			}
+			warnf("Accept error on port %d: %v", p.ListenPort, err)
+			p.accepts.recordAccept(err)
+			if !backoff.wait(p.ctx) {
+				return
+			}
+			continue
+		}
+		backoff.reset()
+		p.accepts.recordAccept(nil)
 
-			// Increment connection counter
-			atomic.AddInt32(&p.connections, 1)
+		// Enforce the per-source-IP connection cap
+		if !p.acquireIPSlot(remoteIP(clientConn)) {
+			warnf("Rejected connection from %s on port %d: per-IP limit of %d reached",
+				clientConn.RemoteAddr(), p.ListenPort, p.Options.MaxConnsPerIP)
+			clientConn.Close()
 			p.Stats.mu.Lock()
-			p.Stats.Connections++
+			p.Stats.Rejected++
 			p.Stats.mu.Unlock()
+			continue
+		}
+
+		// Wait for a handler slot, so a flood queues in the listen backlog
+		// instead of each connection spawning a goroutine and dialing
+		if !p.acquireHandlerSlot() {
+			clientConn.Close()
+			p.releaseIPSlot(remoteIP(clientConn))
+			return // Proxy is shutting down
+		}
+
+		// Increment connection counter
+		atomic.AddInt32(&p.connections, 1)
+		p.Stats.mu.Lock()
+		p.Stats.Connections++
+		p.Stats.mu.Unlock()
+		p.notifyActivity()
+
+		// Handle connection in goroutine
+		p.wg.Add(1)
+		go p.handleConnection(clientConn)
+	}
+}
+
+// acquireHandlerSlot takes a slot of the concurrency semaphore, waiting
+// while all are in use. It reports false if the proxy stops meanwhile.
+func (p *ProxyInstance) acquireHandlerSlot() bool {
+	select {
+	case p.handlerSlots <- struct{}{}:
+		return true
+	default:
+	}
+
+	p.Stats.mu.Lock()
+	p.Stats.AcceptsQueued++
+	p.Stats.mu.Unlock()
+	select {
+	case p.handlerSlots <- struct{}{}:
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// remoteIP returns the source IP of a connection
+func remoteIP(conn net.Conn) string {
+	return addrIP(conn.RemoteAddr())
+}
+
+// addrIP returns the IP of a network address
+func addrIP(addr net.Addr) string {
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+// parseCIDRs parses CIDR ranges, accepting a bare address as a range of one
+func parseCIDRs(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if addr, err := netip.ParseAddr(cidr); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an address or CIDR range", cidr)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// excludedSource reports whether the client's address is in exclude_cidrs
+func (p *ProxyInstance) excludedSource(conn net.Conn) bool {
+	return p.excludedAddr(conn.RemoteAddr().String())
+}
 
-			// Handle connection in goroutine
-			go p.handleConnection(clientConn)
+// excludedAddr reports whether a client address (host:port) is in exclude_cidrs
+func (p *ProxyInstance) excludedAddr(clientAddr string) bool {
+	if len(p.excluded) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddrPort(clientAddr)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range p.excluded {
+		if prefix.Contains(addr.Addr().Unmap()) {
+			return true
 		}
 	}
+	return false
+}
+
+// acquireIPSlot reserves a connection slot for a client's source IP,
+// reporting false if the IP is already at MaxConnsPerIP
+func (p *ProxyInstance) acquireIPSlot(ip string) bool {
+	if p.Options.MaxConnsPerIP <= 0 {
+		return true
+	}
+
+	p.connsPerIPMu.Lock()
+	defer p.connsPerIPMu.Unlock()
+
+	if p.connsPerIP == nil {
+		p.connsPerIP = make(map[string]int)
+	}
+	if p.connsPerIP[ip] >= p.Options.MaxConnsPerIP {
+		return false
+	}
+	p.connsPerIP[ip]++
+	return true
+}
+
+// releaseIPSlot frees the slot taken by acquireIPSlot
+func (p *ProxyInstance) releaseIPSlot(ip string) {
+	if p.Options.MaxConnsPerIP <= 0 {
+		return
+	}
+
+	p.connsPerIPMu.Lock()
+	defer p.connsPerIPMu.Unlock()
+
+	if p.connsPerIP[ip] <= 1 {
+		delete(p.connsPerIP, ip)
+	} else {
+		p.connsPerIP[ip]--
+	}
+}
+
+// tuneTCP applies the TCP socket options to one side of a connection
+func (p *ProxyInstance) tuneTCP(conn net.Conn) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	// Go disables Nagle by default, so only batching needs setting
+	tcpConn.SetNoDelay(!p.Options.TCPNagle)
+
+	if p.Options.TCPKeepAlive < 0 {
+		tcpConn.SetKeepAlive(false)
+	} else if p.Options.TCPKeepAlive > 0 {
+		tcpConn.SetKeepAlive(true)
+		tcpConn.SetKeepAlivePeriod(p.Options.TCPKeepAlive)
+	}
 }
 
 // handleConnection handles a single client connection
 func (p *ProxyInstance) handleConnection(clientConn net.Conn) {
+	defer p.wg.Done()
 	defer clientConn.Close()
 	defer atomic.AddInt32(&p.connections, -1)
+	defer p.releaseIPSlot(remoteIP(clientConn))
+	defer func() { <-p.handlerSlots }()
 
-	// Connect to target server
-	serverConn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", p.ForwardHost, p.ForwardPort))
+	// Connect to target server, picking one of the weighted targets if set
+	target := net.JoinHostPort(p.ForwardHost, strconv.Itoa(p.ForwardPort))
+	if i := p.pickTarget(); i >= 0 {
+		target = p.Options.ForwardTargets[i].String()
+		p.targetConns[i].Add(1)
+	}
+	if p.Options.ForwardProtocol == ProtocolUDP {
+		p.bridgeToUDP(clientConn, target)
+		return
+	}
+	serverConn, err := dialBackend(p.ctx, target)
 	if err != nil {
-		log.Printf("Failed to connect to %s:%d: %v", p.ForwardHost, p.ForwardPort, err)
+		if p.ctx.Err() == nil {
+			warnf("Failed to connect to %s: %v", target, err)
+			p.recordDropped(p.newConnection(clientConn, nil))
+		}
 		return
 	}
 	defer serverConn.Close()
 
-	log.Printf("New connection from %s -> %s:%d", clientConn.RemoteAddr(), p.ForwardHost, p.ForwardPort)
+	p.tuneTCP(clientConn)
+	p.tuneTCP(serverConn)
+
+	conn := p.newConnection(clientConn, serverConn)
+	if p.excludedSource(clientConn) {
+		conn.excluded = true
+		p.Stats.mu.Lock()
+		p.Stats.Excluded++
+		p.Stats.mu.Unlock()
+	}
+	conn.mirror = p.openMirror(p.ctx)
+	if conn.mirror != nil {
+		defer conn.mirror.close()
+	}
+	p.registerConnection(conn)
+	defer p.unregisterConnection(conn)
+	defer p.releaseHeldCaptures(conn)
+	defer p.flushCoalesced(conn)
+	defer p.releaseUndetected(conn)
+	defer p.flushLines(conn)
+	infof("New connection #%d from %s -> %s", conn.ID, conn.ClientAddr, target)
 
-	// Create done channel for this connection
-	connDone := make(chan struct{})
+	// The connection context is cancelled when either side finishes or the
+	// proxy stops; closing both conns then unblocks any pending Read.
+	connCtx, connCancel := context.WithCancel(p.ctx)
+	defer connCancel()
+	stopClose := context.AfterFunc(connCtx, func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+	defer stopClose()
+
+	if p.Options.TCPKeepAlive >= 0 {
+		go p.sampleRTTPeriodically(connCtx, conn)
+	}
 
 	// Proxy data in both directions
-	go p.copyWithCapture(serverConn, clientConn, "Client->Server", connDone)
-	p.copyWithCapture(clientConn, serverConn, "Server->Client", connDone)
+	var copies sync.WaitGroup
+	copies.Add(1)
+	go func() {
+		defer copies.Done()
+		p.copyWithCapture(conn, DirectionClientToServer, connCancel)
+	}()
+	p.copyWithCapture(conn, DirectionServerToClient, connCancel)
+	copies.Wait()
 
-	log.Printf("Connection closed: %s", clientConn.RemoteAddr())
+	infof("Connection closed: %s", clientConn.RemoteAddr())
 }
 
-// copyWithCapture copies data between connections while capturing to buffer
-func (p *ProxyInstance) copyWithCapture(dst, src net.Conn, direction string, done chan struct{}) {
-	buf := make([]byte, 4096)
+// dialBackend opens a TCP connection to a backend
+func dialBackend(ctx context.Context, target string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", target)
+}
 
-	for {
-		// Check for shutdown first, without holding any locks
-		select {
-		case <-p.Done:
-			return
-		case <-done:
-			return
-		default:
+// copyWithCapture copies data between connections while capturing to buffer.
+// It returns when src is exhausted or either side fails, cancelling the
+// connection so the opposite direction is torn down as well.
+func (p *ProxyInstance) copyWithCapture(conn *Connection, direction string, cancel context.CancelFunc) {
+	atomic.AddInt32(&p.goroutines, 1)
+	defer atomic.AddInt32(&p.goroutines, -1)
+	defer cancel()
+
+	src := conn.source(direction)
+
+	// Pass-through proxies never look at the data, so hand the copy to
+	// io.Copy which splices between TCP sockets on Linux. Mirrored client
+	// bytes need the read loop below.
+	if p.Options.PassThrough && (direction != DirectionClientToServer || conn.mirror == nil) {
+		n, err := io.Copy(conn.sink(direction), src)
+		conn.countBytes(direction, n)
+		p.Stats.mu.Lock()
+		p.Stats.BytesCaptured += n
+		p.Stats.mu.Unlock()
+		p.notifyActivity()
+		p.recordCloseReason(conn, direction, err, false)
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			warnf("%s copy error: %v", direction, err)
 		}
+		return
+	}
 
-		// Set read deadline to check for shutdown periodically
-		src.SetReadDeadline(time.Now().Add(1 * time.Second))
+	// Buffers are pooled; everything that keeps read bytes copies them
+	bufp := p.readBuffers.get()
+	defer p.readBuffers.put(bufp)
+	buf := *bufp
 
+	for {
 		n, err := src.Read(buf)
-		if err != nil {
-			if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
-				continue // Timeout is expected, check for shutdown
-			}
-			if err != io.EOF && !strings.Contains(err.Error(), "use of closed network connection") {
-				log.Printf("%s read error: %v", direction, err)
-			}
-			// Only close done once
-			select {
-			case <-done:
-				// Already closed
-			default:
-				close(done)
+
+		data := buf[:n]
+
+		// Tag requests so their journey across proxies can be stitched.
+		// Held back header bytes go out unchanged once the client stops.
+		if direction == DirectionClientToServer && p.Options.TraceHeader != "" {
+			data = conn.tracer.inject(data, p.Options.TraceHeader)
+			if err != nil {
+				data = append(data, conn.tracer.flush()...)
 			}
-			return
 		}
 
-		if n > 0 {
-			data := buf[:n]
-
+		if len(data) > 0 {
 			// Capture to buffer
-			p.captureData(data, direction)
+			conn.countBytes(direction, int64(len(data)))
+			p.captureData(conn, data, direction)
 
 			// Forward the data
-			_, err = dst.Write(data)
-			if err != nil {
-				log.Printf("%s write error: %v", direction, err)
-				// Only close done once
-				select {
-				case <-done:
-					// Already closed
-				default:
-					close(done)
+			if _, werr := conn.Write(direction, data, 0); werr != nil {
+				p.recordCloseReason(conn, direction, werr, true)
+				if !errors.Is(werr, net.ErrClosed) {
+					warnf("%s write error: %v", direction, werr)
 				}
 				return
 			}
+
+			// Tee client bytes to the mirror after the primary has them
+			if direction == DirectionClientToServer && conn.mirror != nil {
+				conn.mirror.send(data)
+			}
+		}
+
+		if err != nil {
+			p.recordCloseReason(conn, direction, err, false)
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				warnf("%s read error: %v", direction, err)
+			}
+			return
+		}
+	}
+}
+
+// recordCloseReason classifies the error that ended one direction's copy.
+// A closed conn is the proxy stopping or the other direction's teardown,
+// which records its own reason first.
+func (p *ProxyInstance) recordCloseReason(conn *Connection, direction string, err error, writing bool) {
+	switch {
+	case p.ctx.Err() != nil:
+		conn.setCloseReason(CloseProxyStopped)
+	case errors.Is(err, net.ErrClosed):
+		return
+	case writing:
+		conn.setCloseReason(CloseWriteError)
+	case err == nil || err == io.EOF:
+		// io.Copy reports a clean EOF as a nil error
+		if direction == DirectionClientToServer {
+			conn.setCloseReason(CloseClientEOF)
+		} else {
+			conn.setCloseReason(CloseBackendEOF)
 		}
+	default:
+		conn.setCloseReason(CloseReadError)
 	}
 }
 
 // captureData captures data to the ring buffer
-func (p *ProxyInstance) captureData(data []byte, direction string) {
+func (p *ProxyInstance) captureData(conn *Connection, data []byte, direction string) {
+	if p.Options.LineMode {
+		p.captureLines(conn, data, direction)
+		return
+	}
+	p.recordCapture(conn, data, direction, false)
+}
+
+// recordCapture builds a capture for data and stores it; injected marks
+// bytes written by inject_bytes rather than read from a peer
+func (p *ProxyInstance) recordCapture(conn *Connection, data []byte, direction string, injected bool) {
 	// Update stats
 	p.Stats.mu.Lock()
 	p.Stats.BytesCaptured += int64(len(data))
 	p.Stats.mu.Unlock()
+	offset := conn.streamOffset(direction, len(data))
+	defer p.notifyActivity()
+
+	// HTTP/2 frames and TLS records are followed even while capture is off
+	// so the parsers stay in sync with the connection
+	afterUpgrade, upgradedNow := conn.starttls.observe(direction, data)
+	frames, fragments := conn.http2Parser(direction).parse(data)
+	tlsRecords := conn.tlsParser(direction).parse(data)
+	conn.tlsTiming.observe(direction, tlsRecords, time.Now())
+
+	// Once a STARTTLS upgrade completes the TLS parsers start over, since
+	// the connection so far was plaintext
+	if upgradedNow {
+		conn.requestTLS.reset()
+		conn.responseTLS.reset()
+	}
+
+	// Mask credentials; the original bytes are still forwarded. Reads are
+	// masked as one stream, even ones skipped below, so matches that span
+	// reads are caught. Injected bytes stand alone.
+	clean := data
+	if p.redactor != nil {
+		if injected {
+			clean = p.redactor.redact(data)
+		} else {
+			clean = p.redactor.redactStream(conn.redactState(direction), data)
+		}
+		clean = p.redactor.redactHTTP2Headers(frames, fragments, clean)
+	}
+
+	// Strip HTTP bodies, keeping only the header blocks and, with
+	// http_max_body_bytes, the start of each body. The filter is fed every
+	// read, even ones skipped below, so it keeps track of message boundaries,
+	// which http_capture_errors_only pairs transactions on.
+	stored := clean
+	var contentLengths []int64 // Body sizes, recorded under http_max_body_bytes
+	bodyFiltered := false
+	stripBodies := p.Options.HTTPHeadersOnly || p.Options.HTTPMaxBodyBytes > 0
+	if (stripBodies || p.Options.HTTPErrorsOnly) && !injected {
+		filter := conn.headerFilter(direction)
+		kept := filter.filterFrom(data, clean)
+		if stripBodies {
+			stored = kept
+			bodyFiltered = len(kept) < len(data)
+		}
+		if p.Options.HTTPMaxBodyBytes > 0 && len(filter.lengths) > 0 {
+			contentLengths = append([]int64(nil), filter.lengths...)
+		}
+	}
+
+	// Detect protocol once per connection; everything after a STARTTLS
+	// upgrade is TLS. Skipped reads are sniffed too, so a connection whose
+	// opening is skipped is still labeled.
+	protocol, settled := protocolDisabled, protocolDisabled
+	if !p.Options.DisableDetection {
+		protocol, settled = conn.detectProtocol(data, direction, afterUpgrade)
+	}
+
+	// Reads skipped below still move http_capture_errors_only's pairing
+	// along, and release reads held for detection once it settles
+	errorsOnly := p.Options.HTTPErrorsOnly && !injected
+	skipped := true
+	defer func() {
+		if skipped {
+			read := heldRead{}
+			if errorsOnly {
+				read.events = conn.headerFilter(direction).events
+			}
+			p.holdForDetection(conn, read, direction, settled)
+		}
+	}()
+
+	// Skip all capture processing while capture is switched off or the
+	// connection comes from an excluded source
+	if !p.CaptureEnabled() || conn.excluded {
+		return
+	}
+
+	// An identical request sent again shortly after is likely an app-level
+	// retry. Reads skipped below still count, so requests keep their bounds.
+	possibleRetry := false
+	if !injected {
+		possibleRetry = conn.retries.observe(direction, clean, time.Now())
+	}
 
-	// Detect protocol
-	protocol := detectProtocol(data)
+	// Every read is searched for the ingest_contains marker, even ones
+	// skipped below, so a marker split across reads is still found
+	ingestMatched := true
+	if len(p.ingestMarker) > 0 && !injected {
+		ingestMatched = conn.ingestMatcher(direction).match(p.ingestMarker, data)
+	}
+
+	// Keep only the opening packet of each direction
+	if p.Options.FirstPacketOnly && !injected && !conn.firstPacket(direction) {
+		p.Stats.mu.Lock()
+		p.Stats.LaterPacketsSkipped++
+		p.Stats.mu.Unlock()
+		return
+	}
+
+	// Keep the buffer for human-readable traffic
+	if p.Options.TextOnly && !injected && printableRatio(data) < p.textMinPrintable() {
+		p.Stats.mu.Lock()
+		p.Stats.BinarySkipped++
+		p.Stats.mu.Unlock()
+		return
+	}
 
-	// Extract ASCII strings
-	asciiStrings := extractAsciiStrings(data)
+	// Zero in on packets carrying a marker
+	if !ingestMatched {
+		p.Stats.mu.Lock()
+		p.Stats.NoMatchSkipped++
+		p.Stats.mu.Unlock()
+		return
+	}
 
-	// Create hex dump (limit to first 200 bytes for display)
-	hexDumpData := data
-	if len(data) > 200 {
-		hexDumpData = data[:200]
+	// Back off as the buffer fills so it keeps a sample spread over time
+	if p.Options.AdaptiveSampling && !injected && !p.sampleCapture() {
+		p.Stats.mu.Lock()
+		p.Stats.SampledOut++
+		p.Stats.mu.Unlock()
+		return
 	}
-	hexDump := hex.Dump(hexDumpData)
 
-	// Add to buffer
+	// Keep bursts readable by capping how many captures are stored per second
+	if p.captureRate != nil && !injected && !p.captureRate.allow(time.Now()) {
+		p.Stats.mu.Lock()
+		p.Stats.RateLimited++
+		p.Stats.mu.Unlock()
+		return
+	}
+
+	if len(stored) == 0 {
+		return // Body bytes only, counted above but not stored
+	}
+
+	// Bound per-packet memory; only the stored copy is cut, the full payload
+	// is still forwarded
+	truncated := false
+	if limit := p.Options.MaxStoredBytesPerPacket; limit > 0 && len(stored) > limit {
+		stored = stored[:limit]
+		truncated = true
+	}
+
+	var traceID string
+	if p.Options.TraceHeader != "" && direction == DirectionClientToServer {
+		traceID, _ = requestHeaderValue(data, p.Options.TraceHeader)
+	}
+
+	src, dst := conn.endpoints(direction)
 	capture := &CapturedPacket{
-		Timestamp:        time.Now(),
-		Direction:        direction,
-		Bytes:            len(data),
-		HexDump:          hexDump,
-		AsciiStrings:     asciiStrings,
-		DetectedProtocol: protocol,
-		RawData:          append([]byte(nil), data...), // Copy data
+		Timestamp:          time.Now(),
+		ConnID:             conn.ID,
+		Direction:          direction,
+		Src:                src,
+		Dst:                dst,
+		Bytes:              len(data),
+		DetectedProtocol:   protocol,
+		Hash:               hashPayload(clean),
+		Injected:           injected,
+		PossibleRetry:      possibleRetry,
+		TraceID:            traceID,
+		StreamOffset:       offset,
+		Truncated:          truncated,
+		BodyFiltered:       bodyFiltered,
+		HTTPContentLengths: contentLengths,
+		HTTP2Frames:        frames,
+		TLSRecords:         tlsRecords,
+		RawData:            append([]byte(nil), stored...), // Copy data
 	}
 
-	p.Buffer.Add(capture)
+	skipped = false
+	read := heldRead{capture: capture, clean: clean}
+	if errorsOnly {
+		read.events = conn.headerFilter(direction).events
+	}
+	p.holdForDetection(conn, read, direction, settled)
 }
 
-// detectProtocol attempts to detect the protocol from packet data
-func detectProtocol(data []byte) string {
-	dataStr := string(data)
+// storeCapture passes a finished read through http_capture_errors_only and
+// coalescing to the buffer. A read that isn't stored only moves
+// http_capture_errors_only's pairing along.
+func (p *ProxyInstance) storeCapture(conn *Connection, read heldRead, direction string) {
+	capture := read.capture
 
-	// HTTP/1.x detection
-	if strings.HasPrefix(dataStr, "GET ") || strings.HasPrefix(dataStr, "POST ") ||
-		strings.HasPrefix(dataStr, "PUT ") || strings.HasPrefix(dataStr, "DELETE ") ||
-		strings.HasPrefix(dataStr, "HEAD ") || strings.HasPrefix(dataStr, "OPTIONS ") ||
-		strings.HasPrefix(dataStr, "HTTP/1.") {
-		return "HTTP/1.x"
+	// Hold each HTTP transaction back until its response shows an error
+	if p.Options.HTTPErrorsOnly && (capture == nil || !capture.Injected) {
+		p.filterErrorsOnly(conn, capture, direction, read.events)
+		return
+	}
+	if capture == nil {
+		return
 	}
 
-	// HTTP/2 preface
-	if strings.HasPrefix(dataStr, "PRI * HTTP/2.0") {
-		return "HTTP/2"
+	// Merge with the previous read of the direction if coalescing
+	if p.Options.CoalesceWindow > 0 {
+		p.coalesceCapture(conn, capture, read.clean)
+		return
 	}
+	p.addCapture(capture)
+}
+
+// addCapture numbers a finished capture and stores it
+func (p *ProxyInstance) addCapture(capture *CapturedPacket) {
+	capture.Seq = atomic.AddUint64(&p.nextSeq, 1)
 
-	// gRPC paths (common pattern)
-	if strings.Contains(dataStr, "/grpc.") || strings.Contains(dataStr, ".proto.") {
-		return "gRPC"
+	// Persist to disk before the buffer may truncate RawData
+	if p.Files != nil {
+		if err := p.Files.Write(capture); err != nil {
+			warnf("Failed to write capture file for port %d: %v", p.ListenPort, err)
+		}
 	}
 
-	// TLS handshake (simplified detection)
-	if len(data) > 5 && data[0] == 0x16 && data[1] == 0x03 {
-		return "TLS"
+	reason := p.Buffer.Add(capture)
+
+	// Only stored captures are posted, so the event's seq can be fetched
+	if p.webhook != nil && reason == dropNone {
+		p.webhook.match(capture)
+	}
+	if logEnabled(LogDebug) {
+		debugf("Port %d captured #%d: %d bytes %s on connection #%d (%s)",
+			p.ListenPort, capture.Seq, capture.Bytes, capture.Direction, capture.ConnID, capture.DetectedProtocol)
+	}
+	switch reason {
+	case dropBudget:
+		p.Stats.mu.Lock()
+		p.Stats.BudgetDropped++
+		p.Stats.mu.Unlock()
+	case dropWatermark:
+		p.Stats.mu.Lock()
+		p.Stats.WatermarkDropped++
+		p.Stats.mu.Unlock()
+	case dropPolicy:
+		p.Stats.mu.Lock()
+		p.Stats.PolicyDropped++
+		p.Stats.mu.Unlock()
 	}
+}
 
-	return "Unknown"
+// sampleCapture decides whether adaptive sampling keeps the next capture
+func (p *ProxyInstance) sampleCapture() bool {
+	usage := p.Buffer.GetUsagePercent()
+	if usage < adaptiveSamplingThreshold {
+		return true
+	}
+	keep := (100 - usage) / (100 - adaptiveSamplingThreshold)
+	if keep < adaptiveSamplingMinKeep {
+		keep = adaptiveSamplingMinKeep
+	}
+	return rand.Float64() < keep
+}
+
+// textMinPrintable returns the printable ratio text_only_capture requires
+func (p *ProxyInstance) textMinPrintable() float64 {
+	if p.Options.TextMinPrintable > 0 {
+		return p.Options.TextMinPrintable
+	}
+	return defaultTextMinPrintable
+}
+
+// printableRatio returns the share of printable characters and whitespace.
+// Valid UTF-8 is measured per character, so multibyte text counts as text
+// and only control characters don't; a character split at the end of the
+// read is ignored. Anything else is measured per byte against printable ASCII.
+func printableRatio(data []byte) float64 {
+	if len(data) == 0 {
+		return 1
+	}
+	if text := trimPartialRune(data); len(text) > 0 && utf8.Valid(text) {
+		chars, controls := 0, 0
+		for _, r := range string(text) {
+			chars++
+			if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+				controls++
+			}
+		}
+		return float64(chars-controls) / float64(chars)
+	}
+	printable := 0
+	for _, b := range data {
+		if (b >= 32 && b <= 126) || b == '\t' || b == '\n' || b == '\r' {
+			printable++
+		}
+	}
+	return float64(printable) / float64(len(data))
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence from the end of data
+func trimPartialRune(data []byte) []byte {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return data[:i]
+			}
+			break
+		}
+	}
+	return data
+}
+
+// hashPayload returns the hex encoded SHA-256 of a payload
+func hashPayload(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
 
 // extractAsciiStrings extracts readable ASCII strings from binary data
@@ -359,3 +1417,101 @@ func extractAsciiStrings(data []byte) []string {
 func (p *ProxyInstance) GetConnectionCount() int {
 	return int(atomic.LoadInt32(&p.connections))
 }
+
+// Label returns the proxy's label
+func (p *ProxyInstance) Label() string {
+	p.labelMu.RLock()
+	defer p.labelMu.RUnlock()
+	return p.label
+}
+
+// Tags returns a copy of the proxy's tags
+func (p *ProxyInstance) Tags() []string {
+	p.labelMu.RLock()
+	defer p.labelMu.RUnlock()
+	return append([]string{}, p.tags...)
+}
+
+// SetLabel replaces the proxy's label
+func (p *ProxyInstance) SetLabel(label string) {
+	p.labelMu.Lock()
+	defer p.labelMu.Unlock()
+	p.label = label
+}
+
+// SetTags replaces the proxy's tags
+func (p *ProxyInstance) SetTags(tags []string) {
+	p.labelMu.Lock()
+	defer p.labelMu.Unlock()
+	p.tags = append([]string{}, tags...)
+}
+
+// HasLabel reports whether label equals the proxy's label or one of its tags
+func (p *ProxyInstance) HasLabel(label string) bool {
+	p.labelMu.RLock()
+	defer p.labelMu.RUnlock()
+
+	if p.label == label {
+		return true
+	}
+	for _, tag := range p.tags {
+		if tag == label {
+			return true
+		}
+	}
+	return false
+}
+
+// ResetStats zeroes the proxy's counters and refills the capture rate
+// bucket, leaving captures and live connections alone
+func (p *ProxyInstance) ResetStats() time.Time {
+	now := time.Now()
+
+	p.Stats.mu.Lock()
+	p.Stats.BytesCaptured = 0
+	p.Stats.Connections = 0
+	p.Stats.SampledOut = 0
+	p.Stats.RateLimited = 0
+	p.Stats.BinarySkipped = 0
+	p.Stats.NoMatchSkipped = 0
+	p.Stats.LaterPacketsSkipped = 0
+	p.Stats.NonErrorSkipped = 0
+	p.Stats.HeldDropped = 0
+	p.Stats.Rejected = 0
+	p.Stats.MirrorFailures = 0
+	p.Stats.BudgetDropped = 0
+	p.Stats.WatermarkDropped = 0
+	p.Stats.PolicyDropped = 0
+	p.Stats.Excluded = 0
+	p.Stats.WebhookSent = 0
+	p.Stats.WebhookFailures = 0
+	p.Stats.WebhookDropped = 0
+	p.Stats.AcceptsQueued = 0
+	p.Stats.ResetAt = now
+	p.Stats.mu.Unlock()
+
+	if p.captureRate != nil {
+		p.captureRate.reset(now)
+	}
+	return now
+}
+
+// SetCaptureEnabled turns capture processing on or off without affecting forwarding
+func (p *ProxyInstance) SetCaptureEnabled(enabled bool) error {
+	if enabled && p.Options.PassThrough {
+		return fmt.Errorf("proxy on port %d was started with capture: false and cannot capture", p.ListenPort)
+	}
+	p.captureOff.Store(!enabled)
+	return nil
+}
+
+// CaptureEnabled reports whether captured traffic is being stored
+func (p *ProxyInstance) CaptureEnabled() bool {
+	return !p.Options.PassThrough && !p.captureOff.Load()
+}
+
+// GetGoroutineCount returns the number of live copy goroutines. Each active
+// connection runs two, so this should drop back to zero once traffic drains.
+func (p *ProxyInstance) GetGoroutineCount() int {
+	return int(atomic.LoadInt32(&p.goroutines))
+}
@@ -1,9 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -20,8 +30,6 @@ func TestBufferGetStatsDeadlock(t *testing.T) {
 		Timestamp:        time.Now(),
 		Direction:        "Test",
 		Bytes:            100,
-		HexDump:          "test hex",
-		AsciiStrings:     []string{"test"},
 		DetectedProtocol: "Test",
 		RawData:          make([]byte, 100),
 	}
@@ -180,6 +188,68 @@ func TestListProxiesHandler(t *testing.T) {
 	}
 }
 
+// TestListProxiesSortBy tests sorting list_proxies by each metric and
+// limiting the result
+func TestListProxiesSortBy(t *testing.T) {
+	manager := NewProxyManager()
+	stats := []struct {
+		port        int
+		bytes       int64
+		connections int64
+		stored      int
+		age         time.Duration
+	}{
+		{19149, 500, 9, 100, time.Minute},
+		{19150, 9000, 1, 300, time.Hour},
+		{19151, 40, 4, 200, time.Second},
+	}
+	for _, s := range stats {
+		if err := manager.StartProxy(s.port, "127.0.0.1", 18082, 1000); err != nil {
+			t.Fatalf("Failed to start proxy: %v", err)
+		}
+		defer manager.StopProxy(s.port)
+		proxy, _ := manager.GetProxy(s.port)
+		proxy.Buffer.Add(&CapturedPacket{RawData: make([]byte, s.stored)})
+		proxy.Stats.BytesCaptured = s.bytes
+		proxy.Stats.Connections = s.connections
+		proxy.StartedAt = time.Now().Add(-s.age)
+	}
+
+	tests := []struct {
+		sortBy string
+		want   []float64
+	}{
+		{"bytes", []float64{19150, 19149, 19151}},
+		{"connections", []float64{19149, 19151, 19150}},
+		{"buffer_usage", []float64{19150, 19151, 19149}},
+		{"uptime", []float64{19150, 19149, 19151}},
+	}
+	for _, tt := range tests {
+		for _, limit := range []int{0, 2} {
+			args := map[string]interface{}{"sort_by": tt.sortBy}
+			want := tt.want
+			if limit > 0 {
+				args["limit"] = float64(limit)
+				want = want[:limit]
+			}
+			result := callTool(t, NewListProxiesHandler(manager).Execute, args)
+			proxies := result["proxies"].([]interface{})
+			var got []float64
+			for _, p := range proxies {
+				got = append(got, p.(map[string]interface{})["listen_port"].(float64))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("sort_by %s, limit %d: expected %v, got %v", tt.sortBy, limit, want, got)
+			}
+		}
+	}
+
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{"sort_by": "latency"}}}
+	if _, err := NewListProxiesHandler(manager).Execute(context.Background(), request); err == nil {
+		t.Error("Expected an error for an unknown sort_by")
+	}
+}
+
 // TestConcurrentBufferOperations tests concurrent access doesn't deadlock
 func TestConcurrentBufferOperations(t *testing.T) {
 	buffer := NewRingBuffer(1024 * 1024)
@@ -228,3 +298,2862 @@ func TestConcurrentBufferOperations(t *testing.T) {
 		}
 	}
 }
+
+// startEchoServer starts a loopback TCP server that echoes everything it
+// receives and returns its port
+func startEchoServer(t testing.TB) int {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start echo server: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// waitForGoroutines waits until the goroutine count drops to at most want
+func waitForGoroutines(t *testing.T, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > want {
+		if time.Now().After(deadline) {
+			t.Fatalf("Goroutine leak: have %d, want at most %d", runtime.NumGoroutine(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestEvictionPolicies tests which packets survive under byte and slot
+// pressure with each eviction policy
+func TestEvictionPolicies(t *testing.T) {
+	type add struct {
+		size     int
+		protocol string
+	}
+	http, other := "HTTP/1.x", "Unknown"
+
+	alternating := make([]add, 40)
+	for i := range alternating {
+		alternating[i] = add{50, other}
+		if i%2 == 0 {
+			alternating[i].protocol = http
+		}
+	}
+	// Slot pressure (16 slots) leaves the newest odd (HTTP) packets and the last one
+	var alternatingSurvivors []uint64
+	for seq := uint64(11); seq < 40; seq += 2 {
+		alternatingSurvivors = append(alternatingSurvivors, seq)
+	}
+	alternatingSurvivors = append(alternatingSurvivors, 40)
+
+	tests := []struct {
+		policy string
+		adds   []add
+		want   []uint64 // Seqs left, oldest first
+	}{
+		{"fifo", []add{{200, http}, {200, http}, {200, http}, {200, http}, {200, http}, {200, http}}, []uint64{2, 3, 4, 5, 6}},
+		{"keep_largest", []add{{300, other}, {100, other}, {300, other}, {100, other}, {200, other}, {150, other}}, []uint64{1, 3, 5, 6}},
+		// A packet no larger than the smallest stored one is refused
+		{"keep_largest", []add{{300, other}, {300, other}, {200, other}, {200, other}, {200, other}, {150, other}}, []uint64{1, 2, 3, 4}},
+		{"keep_protocol:HTTP/1.x", []add{{200, http}, {200, other}, {200, http}, {200, other}, {200, http}, {200, other}, {400, other}}, []uint64{1, 3, 5, 7}},
+		{"keep_protocol:HTTP/1.x", alternating, alternatingSurvivors},
+	}
+
+	for _, tc := range tests {
+		policy, err := parseEvictionPolicy(tc.policy)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", tc.policy, err)
+		}
+		buffer := NewRingBuffer(1000)
+		buffer.SetEvictionPolicy(policy)
+		for i, a := range tc.adds {
+			buffer.Add(&CapturedPacket{Seq: uint64(i + 1), DetectedProtocol: a.protocol, RawData: make([]byte, a.size)})
+		}
+
+		var got []uint64
+		for _, packet := range buffer.GetAll() {
+			got = append(got, packet.Seq)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: expected %v to survive, got %v", tc.policy, tc.want, got)
+		}
+	}
+
+	if _, err := parseEvictionPolicy("keep_protocol:"); err == nil {
+		t.Error("Expected keep_protocol without a protocol to be rejected")
+	}
+}
+
+// TestRingBufferSmallPacketChurn tests that sustained churn of small and
+// empty packets under byte pressure does not grow the slot slice without
+// bound, and that slots are given back once packets get large again
+func TestRingBufferSmallPacketChurn(t *testing.T) {
+	const limit = 64 * 1024
+	buffer := NewRingBuffer(limit)
+
+	small := make([]byte, 8)
+	var sizes []int
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 50000; i++ {
+			payload := small
+			if i%2 == 0 {
+				payload = nil
+			}
+			buffer.Add(&CapturedPacket{RawData: payload})
+		}
+		sizes = append(sizes, len(buffer.data))
+	}
+
+	if sizes[len(sizes)-1] > limit/minSlotBytes {
+		t.Errorf("Slot slice grew to %d, beyond the cap of %d", sizes[len(sizes)-1], limit/minSlotBytes)
+	}
+	for _, size := range sizes[1:] {
+		if size != sizes[0] {
+			t.Errorf("Slot slice did not stabilize: %v", sizes)
+			break
+		}
+	}
+	if packets, bytes, _ := buffer.GetStats(); packets != limit/minSlotBytes || bytes > limit {
+		t.Errorf("Unexpected stats after churn: %d packets, %d bytes", packets, bytes)
+	}
+
+	// Large packets leave most slots idle, which are given back
+	large := make([]byte, 8*1024)
+	for i := 1; i <= 100; i++ {
+		buffer.Add(&CapturedPacket{Seq: uint64(i), RawData: large})
+	}
+	if len(buffer.data) != buffer.minSlots {
+		t.Errorf("Expected the slot slice to shrink to %d, got %d", buffer.minSlots, len(buffer.data))
+	}
+	if packets, bytes, _ := buffer.GetStats(); packets != limit/len(large) || bytes != limit {
+		t.Errorf("Unexpected stats after large packets: %d packets, %d bytes", packets, bytes)
+	}
+	for i, packet := range buffer.GetAll() {
+		if want := uint64(100 - limit/len(large) + 1 + i); packet.Seq != want {
+			t.Errorf("Packet %d: expected seq %d, got %d", i, want, packet.Seq)
+		}
+	}
+}
+
+// TestGetMemoryUsage tests that the reported usage tracks added packets
+func TestGetMemoryUsage(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19144, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19144)
+	proxy, _ := manager.GetProxy(19144)
+	conn := proxy.newConnection(nil, nil)
+
+	payload := []byte(strings.Repeat("GET /index.html HTTP/1.1\r\n", 10))
+	lastTotal := -1.0
+	for i := 1; i <= 3; i++ {
+		proxy.recordCapture(conn, payload, DirectionClientToServer, false)
+
+		result := callTool(t, NewGetMemoryUsageHandler(manager).Execute, map[string]interface{}{
+			"listen_port": float64(19144),
+		})
+		usage := result["usage"].(map[string]interface{})
+		if usage["packets"] != float64(i) {
+			t.Errorf("Expected %d packets, got %v", i, usage["packets"])
+		}
+		if usage["raw_data_bytes"].(float64) < float64(i*len(payload)) {
+			t.Errorf("Expected at least %d raw bytes, got %v", i*len(payload), usage["raw_data_bytes"])
+		}
+		if usage["packet_struct_bytes"].(float64) <= 0 || usage["slot_bytes"].(float64) <= 0 {
+			t.Errorf("Expected packet and slot overhead, got %v", usage)
+		}
+		total := usage["total_bytes"].(float64)
+		if total <= lastTotal {
+			t.Errorf("Expected the total to grow past %v, got %v", lastTotal, total)
+		}
+		lastTotal = total
+	}
+}
+
+// TestLazyHexDump tests that get_proxy_output still renders the hex dump of
+// the first 200 bytes and the ASCII strings of each capture
+func TestLazyHexDump(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19145, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19145)
+	proxy, _ := manager.GetProxy(19145)
+	conn := proxy.newConnection(nil, nil)
+
+	long := []byte("GET / HTTP/1.1\r\nHost: example.com\r\n" + strings.Repeat("x", 300))
+	proxy.recordCapture(conn, []byte("GET / HTTP/1.1\r\n"), DirectionClientToServer, false)
+	proxy.recordCapture(conn, long, DirectionClientToServer, false)
+
+	result := callTool(t, NewGetProxyOutputHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19145),
+	})
+	proxies := result["proxies"].([]interface{})
+	captures := proxies[0].(map[string]interface{})["captures"].([]interface{})
+
+	first := captures[0].(map[string]interface{})
+	wantDump := "00000000  47 45 54 20 2f 20 48 54  54 50 2f 31 2e 31 0d 0a  |GET / HTTP/1.1..|\n"
+	if first["hex_dump"] != wantDump {
+		t.Errorf("Expected hex dump %q, got %q", wantDump, first["hex_dump"])
+	}
+	if strs, _ := first["ascii_strings"].([]interface{}); len(strs) != 1 || strs[0] != "GET / HTTP/1.1" {
+		t.Errorf("Unexpected ASCII strings %v", first["ascii_strings"])
+	}
+
+	second := captures[1].(map[string]interface{})
+	if second["hex_dump"] != hex.Dump(long[:200]) {
+		t.Errorf("Expected the hex dump of the first 200 bytes, got %q", second["hex_dump"])
+	}
+	if strs, _ := second["ascii_strings"].([]interface{}); len(strs) != 3 || strs[1] != "Host: example.com" || len(strs[2].(string)) != 300 {
+		t.Errorf("Unexpected ASCII strings %v", second["ascii_strings"])
+	}
+}
+
+// TestCaptureFourTuple tests that captures carry the client connection's
+// addresses, swapped between directions
+func TestCaptureFourTuple(t *testing.T) {
+	backendPort := startEchoServer(t)
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19164, "127.0.0.1", backendPort, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19164)
+	proxy, _ := manager.GetProxy(19164)
+
+	client, err := net.Dial("tcp", "127.0.0.1:19164")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+	client.Write([]byte("ping"))
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(proxy.Buffer.GetAll()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	result := callTool(t, NewGetProxyOutputHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19164),
+	})
+	proxies := result["proxies"].([]interface{})
+	captures := proxies[0].(map[string]interface{})["captures"].([]interface{})
+	if len(captures) != 2 {
+		t.Fatalf("Expected 2 captures, got %d", len(captures))
+	}
+
+	clientAddr := client.LocalAddr().String()
+	proxyAddr := client.RemoteAddr().String()
+	for _, c := range captures {
+		capture := c.(map[string]interface{})
+		wantSrc, wantDst := clientAddr, proxyAddr
+		if capture["direction"] == DirectionServerToClient {
+			wantSrc, wantDst = proxyAddr, clientAddr
+		}
+		if capture["src"] != wantSrc || capture["dst"] != wantDst {
+			t.Errorf("%s: expected %s -> %s, got %v -> %v", capture["direction"], wantSrc, wantDst, capture["src"], capture["dst"])
+		}
+	}
+}
+
+// TestBackendLocalAddr tests that get_connections reports the source port
+// the proxy dialed the backend from
+func TestBackendLocalAddr(t *testing.T) {
+	backendPort := startEchoServer(t)
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19180, "127.0.0.1", backendPort, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19180)
+	proxy, _ := manager.GetProxy(19180)
+
+	client, err := net.Dial("tcp", "127.0.0.1:19180")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+	conn := waitForConnection(t, proxy)
+
+	result := callTool(t, NewGetConnectionsHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19180),
+	})
+	conns := result["connections"].([]interface{})
+	if len(conns) != 1 {
+		t.Fatalf("Expected 1 connection, got %v", result)
+	}
+	addr, _ := conns[0].(map[string]interface{})["backend_local_addr"].(string)
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "0" {
+		t.Fatalf("Expected a backend local address with a port, got %q", addr)
+	}
+	if want := conn.ServerConn.LocalAddr().String(); addr != want {
+		t.Errorf("Expected backend_local_addr %s, got %s", want, addr)
+	}
+}
+
+// TestStreamOffsets tests that each capture records where it starts within
+// its connection's stream in its direction
+func TestStreamOffsets(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19172, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19172)
+	proxy, _ := manager.GetProxy(19172)
+	conn := proxy.newConnection(nil, nil)
+	other := proxy.newConnection(nil, nil)
+
+	proxy.recordCapture(conn, []byte("hello"), DirectionClientToServer, false)
+	proxy.recordCapture(conn, []byte("resp"), DirectionServerToClient, false)
+	proxy.recordCapture(conn, []byte("abc"), DirectionClientToServer, false)
+	proxy.recordCapture(other, []byte("zz"), DirectionClientToServer, false)
+	proxy.recordCapture(conn, []byte("defghij"), DirectionClientToServer, false)
+	proxy.recordCapture(conn, []byte("more"), DirectionServerToClient, false)
+
+	result := callTool(t, NewGetProxyOutputHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19172),
+	})
+	proxies := result["proxies"].([]interface{})
+	captures := proxies[0].(map[string]interface{})["captures"].([]interface{})
+
+	want := []float64{0, 0, 5, 0, 8, 4}
+	if len(captures) != len(want) {
+		t.Fatalf("Expected %d captures, got %d", len(want), len(captures))
+	}
+	for i, c := range captures {
+		if offset := c.(map[string]interface{})["stream_offset"]; offset != want[i] {
+			t.Errorf("Capture %d: expected stream_offset %v, got %v", i, want[i], offset)
+		}
+	}
+}
+
+// TestHexDumpWidth tests that hexdump_width sets the bytes per line of
+// get_proxy_output's hex dumps and hexdump_ascii drops the gutter
+func TestHexDumpWidth(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19177, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19177)
+	proxy, _ := manager.GetProxy(19177)
+	payload := []byte(strings.Repeat("0123456789", 7))
+	proxy.recordCapture(proxy.newConnection(nil, nil), payload, DirectionClientToServer, false)
+
+	for _, tc := range []struct {
+		width int
+		ascii bool
+	}{
+		{8, true},
+		{16, false},
+		{32, true},
+		{32, false},
+	} {
+		result := callTool(t, NewGetProxyOutputHandler(manager).Execute, map[string]interface{}{
+			"listen_port":   float64(19177),
+			"clear_buffer":  false,
+			"hexdump_width": float64(tc.width),
+			"hexdump_ascii": tc.ascii,
+		})
+		captures := result["proxies"].([]interface{})[0].(map[string]interface{})["captures"].([]interface{})
+		dump := captures[0].(map[string]interface{})["hex_dump"].(string)
+
+		lines := strings.Split(strings.TrimSuffix(dump, "\n"), "\n")
+		if want := (len(payload) + tc.width - 1) / tc.width; len(lines) != want {
+			t.Fatalf("width %d: expected %d lines, got %d:\n%s", tc.width, want, len(lines), dump)
+		}
+		for i, line := range lines {
+			hexPart, gutter, hasGutter := strings.Cut(line[10:], "|")
+			if hasGutter != tc.ascii {
+				t.Errorf("width %d ascii %v: unexpected gutter in %q", tc.width, tc.ascii, line)
+			}
+			want := min(tc.width, len(payload)-i*tc.width)
+			if got := len(strings.Fields(hexPart)); got != want {
+				t.Errorf("width %d: expected %d bytes on line %d, got %d in %q", tc.width, want, i, got, line)
+			}
+			if tc.ascii && len(gutter) != want+1 {
+				t.Errorf("width %d: expected a %d-character gutter, got %q", tc.width, want, gutter)
+			}
+		}
+	}
+
+	// The default layout is unchanged from hex.Dump
+	result := callTool(t, NewGetProxyOutputHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19177),
+	})
+	captures := result["proxies"].([]interface{})[0].(map[string]interface{})["captures"].([]interface{})
+	want := (&CapturedPacket{RawData: payload}).HexDump()
+	if dump := captures[0].(map[string]interface{})["hex_dump"]; dump != want {
+		t.Errorf("Expected the default dump to match hex.Dump, got:\n%s", dump)
+	}
+	if formatted := formatHexDump(payload, hexDumpLayout{width: 16, ascii: true}); formatted != want {
+		t.Errorf("Expected a 16-byte layout to match hex.Dump, got:\n%s", formatted)
+	}
+}
+
+// TestStopCaptureAtPercent tests that captures past the watermark are
+// dropped while the earliest captures stay in the buffer
+func TestStopCaptureAtPercent(t *testing.T) {
+	for _, tc := range []struct {
+		percent float64
+		stored  int
+	}{
+		{50, 2},  // 500 of 1000 bytes: two 200-byte packets fit
+		{100, 5}, // No eviction at all
+	} {
+		manager := NewProxyManager()
+		opts := ProxyOptions{StopCaptureAtPercent: tc.percent}
+		if err := manager.StartProxyWithOptions(19173, "127.0.0.1", 18082, 1000, opts); err != nil {
+			t.Fatalf("Failed to start proxy: %v", err)
+		}
+		proxy, _ := manager.GetProxy(19173)
+		conn := proxy.newConnection(nil, nil)
+
+		for i := 0; i < 8; i++ {
+			payload := []byte(fmt.Sprintf("%03d", i) + strings.Repeat("x", 197))
+			proxy.recordCapture(conn, payload, DirectionClientToServer, false)
+		}
+
+		captures := proxy.Buffer.GetAll()
+		if len(captures) != tc.stored {
+			t.Errorf("At %v%%: expected %d captures, got %d", tc.percent, tc.stored, len(captures))
+		}
+		for i, capture := range captures {
+			if want := fmt.Sprintf("%03d", i); string(capture.RawData[:3]) != want {
+				t.Errorf("At %v%%: capture %d starts with %q, expected the early capture %s", tc.percent, i, capture.RawData[:3], want)
+			}
+		}
+		proxy.Stats.mu.RLock()
+		dropped := proxy.Stats.WatermarkDropped
+		proxy.Stats.mu.RUnlock()
+		if dropped != int64(8-tc.stored) {
+			t.Errorf("At %v%%: expected %d watermark drops, got %d", tc.percent, 8-tc.stored, dropped)
+		}
+		manager.StopAll()
+	}
+}
+
+// BenchmarkRecordCapture measures allocation on the capture path, against
+// rendering the hex dump and strings up front as captures used to
+func BenchmarkRecordCapture(b *testing.B) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19146, "127.0.0.1", 18082, 1024*1024); err != nil {
+		b.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19146)
+	proxy, _ := manager.GetProxy(19146)
+	conn := proxy.newConnection(nil, nil)
+	payload := []byte(strings.Repeat("GET /index.html HTTP/1.1\r\n", 20))
+
+	b.Run("lazy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			proxy.recordCapture(conn, payload, DirectionClientToServer, false)
+		}
+	})
+	b.Run("eager", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			proxy.recordCapture(conn, payload, DirectionClientToServer, false)
+			capture := &CapturedPacket{RawData: payload}
+			_, _ = capture.HexDump(), capture.AsciiStrings()
+		}
+	})
+}
+
+// BenchmarkDetectProtocolOption measures the capture path with protocol
+// detection on and off
+func BenchmarkDetectProtocolOption(b *testing.B) {
+	payload := bytes.Repeat([]byte{0x00, 0x17, 0xff, 0x42}, 4096)
+	for _, detect := range []bool{true, false} {
+		b.Run(fmt.Sprintf("detect=%v", detect), func(b *testing.B) {
+			manager := NewProxyManager()
+			opts := ProxyOptions{DisableDetection: !detect}
+			if err := manager.StartProxyWithOptions(19181, "127.0.0.1", 18082, 1024*1024, opts); err != nil {
+				b.Fatalf("Failed to start proxy: %v", err)
+			}
+			defer manager.StopProxy(19181)
+			proxy, _ := manager.GetProxy(19181)
+			conn := proxy.newConnection(nil, nil)
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				proxy.recordCapture(conn, payload, DirectionClientToServer, false)
+			}
+		})
+	}
+}
+
+// TestDetectProtocolDisabled tests that detect_protocol false labels every
+// capture "Disabled", even ones detection would recognise
+func TestDetectProtocolDisabled(t *testing.T) {
+	manager := NewProxyManager()
+	response := callTool(t, NewStartProxyHandler(manager).Execute, map[string]interface{}{
+		"listen_port":     float64(19182),
+		"forward_host":    "127.0.0.1",
+		"forward_port":    float64(18082),
+		"detect_protocol": false,
+	})
+	if response["error"] != nil {
+		t.Fatalf("Failed to start proxy: %v", response)
+	}
+	defer manager.StopProxy(19182)
+	proxy, _ := manager.GetProxy(19182)
+	conn := proxy.newConnection(nil, nil)
+
+	proxy.recordCapture(conn, []byte("GET / HTTP/1.1\r\n\r\n"), DirectionClientToServer, false)
+	proxy.recordCapture(conn, []byte{0x16, 0x03, 0x01, 0x00, 0x05, 0x01}, DirectionServerToClient, false)
+	for _, capture := range proxy.Buffer.GetAll() {
+		if capture.DetectedProtocol != "Disabled" {
+			t.Errorf("Capture %d: expected protocol Disabled, got %s", capture.Seq, capture.DetectedProtocol)
+		}
+	}
+
+	listed := callTool(t, NewListProxiesHandler(manager).Execute, map[string]interface{}{})
+	if detect := listed["proxies"].([]interface{})[0].(map[string]interface{})["detect_protocol"]; detect != false {
+		t.Errorf("Expected list_proxies to show detect_protocol false, got %v", detect)
+	}
+}
+
+// TestStopProxyImmediateShutdown tests that stopping a proxy with a live
+// connection returns promptly and leaves no goroutines behind
+func TestStopProxyImmediateShutdown(t *testing.T) {
+	backendPort := startEchoServer(t)
+	baseline := runtime.NumGoroutine()
+
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19092, "127.0.0.1", backendPort, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+
+	// Open an idle connection through the proxy
+	conn, err := net.Dial("tcp", "127.0.0.1:19092")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	start := time.Now()
+	if _, err := manager.StopProxy(19092); err != nil {
+		t.Fatalf("Failed to stop proxy: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("StopProxy took %v, expected near-immediate shutdown", elapsed)
+	}
+
+	// The client side must observe the close
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(reply); err == nil {
+		t.Error("Expected client connection to be closed after stop")
+	}
+
+	conn.Close()
+	waitForGoroutines(t, baseline)
+}
+
+// TestCopyGoroutinesDrain tests that the copy goroutine counter returns to
+// zero after many connections open and close
+func TestCopyGoroutinesDrain(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19093, "127.0.0.1", backendPort, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19093)
+
+	proxy, _ := manager.GetProxy(19093)
+
+	for i := 0; i < 25; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:19093")
+		if err != nil {
+			t.Fatalf("Failed to connect to proxy: %v", err)
+		}
+		msg := []byte(fmt.Sprintf("hello-%02d", i))
+		conn.Write(msg)
+		if _, err := io.ReadFull(conn, make([]byte, len(msg))); err != nil {
+			t.Fatalf("Read failed on connection %d: %v", i, err)
+		}
+		conn.Close()
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for proxy.GetGoroutineCount() != 0 || proxy.GetConnectionCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Copy goroutines did not drain: %d goroutines, %d connections",
+				proxy.GetGoroutineCount(), proxy.GetConnectionCount())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// callTool runs a tool handler with the given arguments and decodes its JSON result
+func callTool(t *testing.T, execute func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error), args map[string]interface{}) map[string]interface{} {
+	t.Helper()
+
+	result, err := execute(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: args},
+	})
+	if err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+	if result == nil || len(result.Content) == 0 {
+		t.Fatal("Handler returned empty result")
+	}
+
+	textContent, ok := result.Content[0].(mcp.TextContent)
+	if !ok {
+		t.Fatal("Handler result is not text content")
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal([]byte(textContent.Text), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	return response
+}
+
+// proxyCaptures extracts the captures of the first proxy in a get_proxy_output response
+func proxyCaptures(t *testing.T, response map[string]interface{}) []interface{} {
+	t.Helper()
+
+	proxies, ok := response["proxies"].([]interface{})
+	if !ok || len(proxies) == 0 {
+		t.Fatalf("No proxies in response: %v", response)
+	}
+	captures, _ := proxies[0].(map[string]interface{})["captures"].([]interface{})
+	return captures
+}
+
+// TestGetProxyOutputDedup tests that consecutive identical payloads collapse
+func TestGetProxyOutputDedup(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19094, "localhost", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19094)
+
+	proxy, _ := manager.GetProxy(19094)
+	conn := proxy.newConnection(nil, nil)
+	proxy.captureData(conn, []byte("keepalive"), DirectionClientToServer)
+	proxy.captureData(conn, []byte("keepalive"), DirectionClientToServer)
+	proxy.captureData(conn, []byte("keepalive"), DirectionClientToServer)
+	proxy.captureData(conn, []byte("request"), DirectionClientToServer)
+	// An echo of the request and the same bytes on another connection are
+	// not repeats
+	proxy.captureData(conn, []byte("request"), DirectionServerToClient)
+	proxy.captureData(proxy.newConnection(nil, nil), []byte("request"), DirectionServerToClient)
+
+	captures := proxy.Buffer.GetAll()
+	if captures[0].Hash == "" || captures[0].Hash != captures[1].Hash || captures[0].Hash == captures[3].Hash {
+		t.Fatalf("Unexpected hashes: %q %q %q", captures[0].Hash, captures[1].Hash, captures[3].Hash)
+	}
+
+	handler := NewGetProxyOutputHandler(manager)
+
+	response := callTool(t, handler.Execute, map[string]interface{}{
+		"listen_port":  float64(19094),
+		"clear_buffer": false,
+	})
+	if got := len(proxyCaptures(t, response)); got != 6 {
+		t.Errorf("Expected 6 captures without dedup, got %d", got)
+	}
+
+	response = callTool(t, handler.Execute, map[string]interface{}{
+		"listen_port":  float64(19094),
+		"clear_buffer": false,
+		"dedup":        true,
+	})
+	deduped := proxyCaptures(t, response)
+	if len(deduped) != 4 {
+		t.Fatalf("Expected 4 captures with dedup, got %d", len(deduped))
+	}
+	if count := deduped[0].(map[string]interface{})["repeat_count"]; count != float64(3) {
+		t.Errorf("Expected repeat_count 3, got %v", count)
+	}
+	if count := deduped[1].(map[string]interface{})["repeat_count"]; count != float64(1) {
+		t.Errorf("Expected repeat_count 1, got %v", count)
+	}
+}
+
+// waitForConnection waits until the proxy has registered a live connection
+// and returns it
+func waitForConnection(t *testing.T, proxy *ProxyInstance) *Connection {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		proxy.connsMu.Lock()
+		for _, conn := range proxy.conns {
+			proxy.connsMu.Unlock()
+			return conn
+		}
+		proxy.connsMu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for a proxied connection")
+	return nil
+}
+
+// TestInjectBytes tests injecting bytes into a live connection in both directions
+func TestInjectBytes(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19097, "127.0.0.1", backendPort, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19097)
+
+	client, err := net.Dial("tcp", "127.0.0.1:19097")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+
+	proxy, _ := manager.GetProxy(19097)
+	conn := waitForConnection(t, proxy)
+	handler := NewInjectBytesHandler(manager)
+
+	// Towards the client: delivered directly
+	response := callTool(t, handler.Execute, map[string]interface{}{
+		"listen_port": float64(19097),
+		"conn_id":     float64(conn.ID),
+		"direction":   DirectionServerToClient,
+		"data":        "aGVsbG8=", // "hello"
+	})
+	if response["status"] != "injected" {
+		t.Fatalf("Injection failed: %v", response)
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(client, got); err != nil || string(got) != "hello" {
+		t.Fatalf("Expected client to receive \"hello\", got %q (%v)", got, err)
+	}
+
+	// Towards the backend: the echo server reflects it back to the client
+	callTool(t, handler.Execute, map[string]interface{}{
+		"listen_port": float64(19097),
+		"conn_id":     float64(conn.ID),
+		"direction":   DirectionClientToServer,
+		"data":        "ZnV6eg==", // "fuzz"
+	})
+	got = make([]byte, 4)
+	if _, err := io.ReadFull(client, got); err != nil || string(got) != "fuzz" {
+		t.Fatalf("Expected echoed \"fuzz\", got %q (%v)", got, err)
+	}
+
+	injected := 0
+	for _, capture := range proxy.Buffer.GetAll() {
+		if capture.Injected {
+			injected++
+		}
+	}
+	if injected != 2 {
+		t.Errorf("Expected 2 injected captures, got %d", injected)
+	}
+
+	// Unknown connections are reported as errors
+	response = callTool(t, handler.Execute, map[string]interface{}{
+		"listen_port": float64(19097),
+		"conn_id":     float64(9999),
+		"direction":   DirectionClientToServer,
+		"data":        "AA==",
+	})
+	if _, ok := response["error"]; !ok {
+		t.Errorf("Expected error for unknown connection, got %v", response)
+	}
+}
+
+// TestConnectionWriteTimeout tests that a write with a timeout gives up on
+// a peer that stopped reading and leaves no deadline behind
+func TestConnectionWriteTimeout(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19228, "localhost", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19228)
+	proxy, _ := manager.GetProxy(19228)
+
+	proxySide, backend := net.Pipe()
+	defer proxySide.Close()
+	defer backend.Close()
+	conn := proxy.newConnection(nil, proxySide)
+
+	if _, err := conn.Write(DirectionClientToServer, []byte("stuck"), 50*time.Millisecond); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected the write to time out, got %v", err)
+	}
+
+	// The deadline is gone, so a later write waits for the reader
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		io.ReadFull(backend, make([]byte, 4))
+	}()
+	if _, err := conn.Write(DirectionClientToServer, []byte("late"), 0); err != nil {
+		t.Errorf("Expected a write without timeout to wait, got %v", err)
+	}
+}
+
+// TestStartProxyRejectsForwardLoop tests that a proxy forwarding to itself,
+// directly or through another proxy, is rejected
+func TestStartProxyRejectsForwardLoop(t *testing.T) {
+	manager := NewProxyManager()
+
+	for _, host := range []string{"localhost", "127.0.0.1", "::1", "0.0.0.0"} {
+		if err := manager.StartProxy(19099, host, 19099, 1024); err == nil {
+			manager.StopProxy(19099)
+			t.Errorf("Expected self-loop via %s to be rejected", host)
+		}
+	}
+
+	// A remote host on the same port is not a loop
+	if err := manager.checkForwardLoopLocked(19099, "10.1.2.3", 19099); err != nil {
+		t.Errorf("Unexpected loop error for remote host: %v", err)
+	}
+
+	// Indirect loop: 19100 -> 19101 -> 19100
+	if err := manager.StartProxy(19100, "localhost", 19101, 1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19100)
+
+	if err := manager.StartProxy(19101, "127.0.0.1", 19100, 1024); err == nil {
+		manager.StopProxy(19101)
+		t.Error("Expected indirect loop to be rejected")
+	}
+}
+
+// TestNoPrivilegedPorts tests that --no-privileged-ports refuses listen ports
+// below 1024 and that they are attempted otherwise
+func TestNoPrivilegedPorts(t *testing.T) {
+	manager := NewProxyManager()
+	manager.SetNoPrivilegedPorts(true)
+
+	err := manager.StartProxy(1023, "127.0.0.1", 18082, 1024)
+	if err == nil || !strings.Contains(err.Error(), "privileged") {
+		manager.StopProxy(1023)
+		t.Fatalf("Expected port 1023 to be refused, got %v", err)
+	}
+	if err := manager.StartProxy(19178, "127.0.0.1", 18082, 1024); err != nil {
+		t.Fatalf("Expected an unprivileged port to be allowed: %v", err)
+	}
+	manager.StopProxy(19178)
+
+	// Without the flag the bind is attempted; it may still fail without root
+	manager.SetNoPrivilegedPorts(false)
+	if err := manager.StartProxy(1023, "127.0.0.1", 18082, 1024); err == nil {
+		manager.StopProxy(1023)
+	} else if strings.Contains(err.Error(), "privileged") {
+		t.Errorf("Expected port 1023 to be allowed without the flag, got %v", err)
+	}
+}
+
+// TestMaxProxies tests that starts beyond --max-proxies are refused until a
+// proxy is stopped, and that get_global_stats reports the limit
+func TestMaxProxies(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+	manager.SetMaxProxies(2)
+
+	for _, port := range []int{19204, 19205} {
+		if err := manager.StartProxy(port, "127.0.0.1", 18082, 1024); err != nil {
+			t.Fatalf("Expected proxy on %d to start: %v", port, err)
+		}
+	}
+	err := manager.StartProxy(19206, "127.0.0.1", 18082, 1024)
+	if err == nil || !strings.Contains(err.Error(), "--max-proxies") {
+		t.Fatalf("Expected the third proxy to be refused, got %v", err)
+	}
+	if _, exists := manager.GetProxy(19206); exists {
+		t.Error("Expected no proxy on the refused port")
+	}
+
+	result := callTool(t, NewGetGlobalStatsHandler(manager).Execute, map[string]interface{}{})
+	if result["proxies"] != float64(2) || result["max_proxies"] != float64(2) {
+		t.Errorf("Expected 2 of max 2 proxies, got %v", result)
+	}
+
+	// Stopping one frees a slot
+	manager.StopProxy(19204)
+	if err := manager.StartProxy(19206, "127.0.0.1", 18082, 1024); err != nil {
+		t.Errorf("Expected a start after a stop to succeed: %v", err)
+	}
+}
+
+// TestSetCaptureToggle tests that disabling capture stops new captures while
+// traffic still flows and bytes are still counted
+func TestSetCaptureToggle(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19102, "127.0.0.1", backendPort, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19102)
+
+	proxy, _ := manager.GetProxy(19102)
+	handler := NewSetCaptureHandler(manager)
+
+	response := callTool(t, handler.Execute, map[string]interface{}{
+		"listen_port": float64(19102),
+		"enabled":     false,
+	})
+	if response["capture_enabled"] != false {
+		t.Fatalf("Unexpected response: %v", response)
+	}
+
+	client, err := net.Dial("tcp", "127.0.0.1:19102")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+
+	client.Write([]byte("quiet"))
+	if _, err := io.ReadFull(client, make([]byte, 5)); err != nil {
+		t.Fatalf("Traffic did not flow with capture disabled: %v", err)
+	}
+
+	if packets, _, _ := proxy.Buffer.GetStats(); packets != 0 {
+		t.Errorf("Expected no captures while disabled, got %d", packets)
+	}
+	proxy.Stats.mu.RLock()
+	bytesCaptured := proxy.Stats.BytesCaptured
+	proxy.Stats.mu.RUnlock()
+	if bytesCaptured != 10 {
+		t.Errorf("Expected 10 bytes counted while disabled, got %d", bytesCaptured)
+	}
+
+	listed := callTool(t, NewListProxiesHandler(manager).Execute, map[string]interface{}{})
+	if enabled := listed["proxies"].([]interface{})[0].(map[string]interface{})["capture_enabled"]; enabled != false {
+		t.Errorf("Expected list_proxies to show capture disabled, got %v", enabled)
+	}
+
+	// Turn capture back on
+	callTool(t, handler.Execute, map[string]interface{}{
+		"listen_port": float64(19102),
+		"enabled":     true,
+	})
+	client.Write([]byte("loud"))
+	if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if packets, _, _ := proxy.Buffer.GetStats(); packets != 2 {
+		t.Errorf("Expected 2 captures after re-enabling, got %d", packets)
+	}
+}
+
+// TestMaxStoredBytesPerPacket tests that stored payloads are truncated while
+// the full payload is forwarded
+func TestMaxStoredBytesPerPacket(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	err := manager.StartProxyWithOptions(19103, "127.0.0.1", backendPort, 1024*1024, ProxyOptions{MaxStoredBytesPerPacket: 100})
+	if err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19103)
+
+	client, err := net.Dial("tcp", "127.0.0.1:19103")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+
+	payload := make([]byte, 3000)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	client.Write(payload)
+
+	echoed := make([]byte, len(payload))
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(client, echoed); err != nil {
+		t.Fatalf("Expected full payload to be forwarded: %v", err)
+	}
+	for i := range payload {
+		if echoed[i] != payload[i] {
+			t.Fatalf("Forwarded payload differs at byte %d", i)
+		}
+	}
+
+	proxy, _ := manager.GetProxy(19103)
+	sawTruncated := false
+	for _, capture := range proxy.Buffer.GetAll() {
+		if len(capture.RawData) > 100 {
+			t.Errorf("Stored %d bytes, expected at most 100", len(capture.RawData))
+		}
+		if capture.Bytes > 100 {
+			if !capture.Truncated {
+				t.Errorf("Capture of %d bytes not marked truncated", capture.Bytes)
+			}
+			sawTruncated = true
+		}
+	}
+	if !sawTruncated {
+		t.Error("Expected at least one truncated capture")
+	}
+}
+
+// TestGetProxyOutputOrderAndLimit tests that descending order is applied
+// before offset and limit
+func TestGetProxyOutputOrderAndLimit(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19104, "localhost", 18086, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19104)
+
+	proxy, _ := manager.GetProxy(19104)
+	conn := proxy.newConnection(nil, nil)
+	for i := 1; i <= 5; i++ {
+		proxy.captureData(conn, []byte(fmt.Sprintf("packet-%d", i)), DirectionClientToServer)
+	}
+
+	seqs := func(args map[string]interface{}) []float64 {
+		args["listen_port"] = float64(19104)
+		args["clear_buffer"] = false
+		var result []float64
+		for _, c := range proxyCaptures(t, callTool(t, NewGetProxyOutputHandler(manager).Execute, args)) {
+			result = append(result, c.(map[string]interface{})["seq"].(float64))
+		}
+		return result
+	}
+
+	if got := seqs(map[string]interface{}{"order": "desc", "limit": float64(2)}); fmt.Sprint(got) != "[5 4]" {
+		t.Errorf("Expected newest two captures [5 4], got %v", got)
+	}
+	if got := seqs(map[string]interface{}{"order": "desc", "offset": float64(2), "limit": float64(2)}); fmt.Sprint(got) != "[3 2]" {
+		t.Errorf("Expected second page [3 2], got %v", got)
+	}
+	if got := seqs(map[string]interface{}{"limit": float64(2)}); fmt.Sprint(got) != "[1 2]" {
+		t.Errorf("Expected oldest two captures [1 2], got %v", got)
+	}
+	if got := seqs(map[string]interface{}{"order": "desc", "offset": float64(10)}); len(got) != 0 {
+		t.Errorf("Expected no captures past the end, got %v", got)
+	}
+}
+
+// TestGetProxyOutputClearsReturnedOnly tests that paginated reads keep the
+// buffer by default and an explicit clear removes only the returned page
+func TestGetProxyOutputClearsReturnedOnly(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19219, "localhost", 18086, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19219)
+
+	proxy, _ := manager.GetProxy(19219)
+	conn := proxy.newConnection(nil, nil)
+	for i := 1; i <= 5; i++ {
+		proxy.captureData(conn, []byte(fmt.Sprintf("packet-%d", i)), DirectionClientToServer)
+	}
+	read := func(args map[string]interface{}) {
+		args["listen_port"] = float64(19219)
+		callTool(t, NewGetProxyOutputHandler(manager).Execute, args)
+	}
+	remaining := func() []uint64 {
+		var seqs []uint64
+		for _, capture := range proxy.Buffer.GetAll() {
+			seqs = append(seqs, capture.Seq)
+		}
+		return seqs
+	}
+
+	read(map[string]interface{}{"limit": float64(2)})
+	if got := remaining(); len(got) != 5 {
+		t.Errorf("Expected a paginated read to keep the buffer, got %v", got)
+	}
+
+	read(map[string]interface{}{"offset": float64(1), "limit": float64(2), "clear_buffer": true})
+	if got := fmt.Sprint(remaining()); got != "[1 4 5]" {
+		t.Errorf("Expected only the returned page removed, got %s", got)
+	}
+	_, bytes, _ := proxy.Buffer.GetStats()
+	if bytes != 3*len("packet-1") {
+		t.Errorf("Expected the buffer size to drop with the removed captures, got %d", bytes)
+	}
+
+	read(map[string]interface{}{})
+	if got := remaining(); len(got) != 0 {
+		t.Errorf("Expected a full read to clear the buffer, got %v", got)
+	}
+}
+
+// TestStartProxyIfExists tests each if_exists policy against a running proxy
+func TestStartProxyIfExists(t *testing.T) {
+	manager := NewProxyManager()
+	handler := NewStartProxyHandler(manager)
+	defer manager.StopAll()
+
+	start := func(forwardPort int, ifExists string) map[string]interface{} {
+		args := map[string]interface{}{
+			"listen_port":  float64(19105),
+			"forward_port": float64(forwardPort),
+		}
+		if ifExists != "" {
+			args["if_exists"] = ifExists
+		}
+		return callTool(t, handler.Execute, args)
+	}
+
+	if response := start(18087, ""); response["status"] != "started" {
+		t.Fatalf("Failed to start proxy: %v", response)
+	}
+	original, _ := manager.GetProxy(19105)
+
+	// Default policy errors
+	if response := start(18087, ""); response["error"] == nil {
+		t.Errorf("Expected error with default policy, got %v", response)
+	}
+	if response := start(18087, "error"); response["error"] == nil {
+		t.Errorf("Expected error with if_exists=error, got %v", response)
+	}
+
+	// Matching config is returned as-is
+	if response := start(18087, "return"); response["status"] != "existing" {
+		t.Errorf("Expected existing proxy to be returned, got %v", response)
+	}
+	if current, _ := manager.GetProxy(19105); current != original {
+		t.Error("if_exists=return replaced the proxy")
+	}
+
+	// Mismatched config still errors with return
+	if response := start(18088, "return"); response["error"] == nil {
+		t.Errorf("Expected error for mismatched config, got %v", response)
+	}
+
+	// Restart reconfigures
+	if response := start(18088, "restart"); response["status"] != "restarted" {
+		t.Fatalf("Expected restart, got %v", response)
+	}
+	current, exists := manager.GetProxy(19105)
+	if !exists || current == original || current.ForwardPort != 18088 {
+		t.Errorf("Expected proxy to be reconfigured to forward to 18088")
+	}
+
+	// A restart that can't start brings the previous configuration back
+	if response := start(19105, "restart"); response["error"] == nil {
+		t.Errorf("Expected error for a restart forwarding to itself, got %v", response)
+	}
+	if restored, exists := manager.GetProxy(19105); !exists || restored.ForwardPort != 18088 {
+		t.Errorf("Expected the proxy forwarding to 18088 restored")
+	}
+
+	// Unknown policies are rejected up front
+	if _, err := handler.Execute(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]interface{}{"listen_port": float64(19105), "forward_port": float64(18088), "if_exists": "replace"}},
+	}); err == nil {
+		t.Error("Expected an error for an unknown if_exists")
+	}
+}
+
+// TestMatchesConfig tests that identical requests match a running proxy even
+// with an empty tag list or after set_label, and that every other option
+// takes part in the comparison
+func TestMatchesConfig(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+	if err := manager.StartProxyWithOptions(19217, "localhost", 18082, 1024, ProxyOptions{Label: "a"}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	proxy, _ := manager.GetProxy(19217)
+
+	if !proxy.matchesConfig("localhost", 18082, 1024, ProxyOptions{Label: "a", Tags: []string{}}) {
+		t.Error("Expected an empty tag list to match no tags")
+	}
+	if !proxy.matchesConfig("localhost", 18082, 1024, ProxyOptions{Label: "a", ListenProtocol: ProtocolTCP, ListenNetwork: ProtocolTCP}) {
+		t.Error("Expected an explicit tcp to match the default")
+	}
+	proxy.SetLabel("b")
+	if proxy.matchesConfig("localhost", 18082, 1024, ProxyOptions{Label: "a"}) {
+		t.Error("Expected the label set by set_label to be compared")
+	}
+	if status, err := manager.EnsureProxy(19217, "localhost", 18082, 1024, ProxyOptions{Label: "b"}, IfExistsReturn); err != nil || status != "existing" {
+		t.Errorf("Expected the relabeled proxy to be reused, got %q, %v", status, err)
+	}
+
+	// A new option that sameSettings forgets would make this fail
+	options := reflect.TypeOf(ProxyOptions{})
+	for i := 0; i < options.NumField(); i++ {
+		field := options.Field(i)
+		if field.Name == "Label" || field.Name == "Tags" {
+			continue
+		}
+		var changed ProxyOptions
+		value := reflect.ValueOf(&changed).Elem().Field(i)
+		switch value.Kind() {
+		case reflect.Bool:
+			value.SetBool(true)
+		case reflect.Int, reflect.Int64:
+			value.SetInt(1)
+		case reflect.Float64:
+			value.SetFloat(1)
+		case reflect.String:
+			value.SetString("x")
+		case reflect.Slice:
+			value.Set(reflect.MakeSlice(field.Type, 1, 1))
+		default:
+			t.Fatalf("Unhandled option kind %s for %s", value.Kind(), field.Name)
+		}
+		if (ProxyOptions{}).sameSettings(changed) {
+			t.Errorf("Expected a change to %s to be detected", field.Name)
+		}
+	}
+}
+
+// TestStopProxiesByLabel tests label filtering and bulk stop by label
+func TestStopProxiesByLabel(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+
+	startHandler := NewStartProxyHandler(manager)
+	for _, args := range []map[string]interface{}{
+		{"listen_port": float64(19106), "forward_port": float64(18082), "label": "session-a"},
+		{"listen_port": float64(19107), "forward_port": float64(18082), "label": "other", "tags": []interface{}{"session-a"}},
+		{"listen_port": float64(19108), "forward_port": float64(18082), "label": "other"},
+	} {
+		if response := callTool(t, startHandler.Execute, args); response["error"] != nil {
+			t.Fatalf("Failed to start proxy: %v", response)
+		}
+	}
+
+	listHandler := NewListProxiesHandler(manager)
+	response := callTool(t, listHandler.Execute, map[string]interface{}{"label": "session-a"})
+	if proxies, _ := response["proxies"].([]interface{}); len(proxies) != 2 {
+		t.Errorf("Expected 2 proxies labelled session-a, got %v", response["proxies"])
+	}
+
+	stopHandler := NewStopProxiesHandler(manager)
+	callTool(t, stopHandler.Execute, map[string]interface{}{"label": "session-a"})
+
+	if _, exists := manager.GetProxy(19106); exists {
+		t.Error("Proxy 19106 should have been stopped")
+	}
+	if _, exists := manager.GetProxy(19107); exists {
+		t.Error("Proxy 19107 should have been stopped (matched by tag)")
+	}
+	if _, exists := manager.GetProxy(19108); !exists {
+		t.Error("Proxy 19108 should still be running")
+	}
+}
+
+// TestSetLabel tests that set_label changes a running proxy's label and tags
+// as seen by list_proxies, leaving out parameters unchanged
+func TestSetLabel(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+
+	callTool(t, NewStartProxyHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19208), "forward_port": float64(18082), "label": "old", "tags": []interface{}{"keep"},
+	})
+
+	handler := NewSetLabelHandler(manager)
+	result := callTool(t, handler.Execute, map[string]interface{}{
+		"listen_port": float64(19208),
+		"label":       "new",
+	})
+	if result["status"] != "updated" || result["label"] != "new" {
+		t.Fatalf("Expected label to be updated, got %v", result)
+	}
+	if tags, _ := result["tags"].([]interface{}); len(tags) != 1 || tags[0] != "keep" {
+		t.Errorf("Expected tags to be unchanged, got %v", result["tags"])
+	}
+
+	listHandler := NewListProxiesHandler(manager)
+	if proxies, _ := callTool(t, listHandler.Execute, map[string]interface{}{"label": "new"})["proxies"].([]interface{}); len(proxies) != 1 {
+		t.Errorf("Expected list_proxies to find the new label, got %v", proxies)
+	}
+	if proxies, _ := callTool(t, listHandler.Execute, map[string]interface{}{"label": "old"})["proxies"].([]interface{}); len(proxies) != 0 {
+		t.Errorf("Expected the old label to be gone, got %v", proxies)
+	}
+
+	// Tags alone are replaced
+	callTool(t, handler.Execute, map[string]interface{}{
+		"listen_port": float64(19208),
+		"tags":        []interface{}{"focus"},
+	})
+	proxies, _ := callTool(t, listHandler.Execute, map[string]interface{}{"label": "focus"})["proxies"].([]interface{})
+	if len(proxies) != 1 || proxies[0].(map[string]interface{})["label"] != "new" {
+		t.Errorf("Expected the proxy to be found by its new tag with its label kept, got %v", proxies)
+	}
+
+	// Neither parameter is an error
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"listen_port": float64(19208)}
+	if _, err := handler.Execute(context.Background(), request); err == nil {
+		t.Error("Expected an error without label or tags")
+	}
+}
+
+// TestGetGlobalStats tests that totals are summed across proxies
+func TestGetGlobalStats(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+
+	for i, port := range []int{19174, 19175} {
+		if err := manager.StartProxy(port, "127.0.0.1", 18082, 1000*(i+1)); err != nil {
+			t.Fatalf("Failed to start proxy: %v", err)
+		}
+		proxy, _ := manager.GetProxy(port)
+		for c := 0; c <= i; c++ {
+			conn := proxy.newConnection(nil, nil)
+			proxy.Stats.mu.Lock()
+			proxy.Stats.Connections++
+			proxy.Stats.mu.Unlock()
+			proxy.recordCapture(conn, make([]byte, 100*(i+1)), DirectionClientToServer, false)
+		}
+	}
+
+	result := callTool(t, NewGetGlobalStatsHandler(manager).Execute, map[string]interface{}{})
+	want := map[string]float64{
+		"proxies":           2,
+		"total_connections": 3,         // 1 + 2
+		"bytes_captured":    100 + 400, // 1x100 + 2x200
+		"buffer_packets":    3,
+		"buffer_bytes":      500,
+		"capture_limit":     3000,
+	}
+	for key, value := range want {
+		if result[key] != value {
+			t.Errorf("Expected %s %v, got %v", key, value, result[key])
+		}
+	}
+}
+
+// TestStopProxyIgnoreMissing tests that ignore_missing makes stop_proxy
+// idempotent while the default stays strict
+func TestStopProxyIgnoreMissing(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+
+	if err := manager.StartProxy(19163, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+
+	handler := NewStopProxyHandler(manager)
+	args := map[string]interface{}{"listen_port": float64(19163), "ignore_missing": true}
+
+	response := callTool(t, handler.Execute, args)
+	if response["status"] != "stopped" || response["was_running"] != true {
+		t.Errorf("Expected first stop to stop the proxy, got %v", response)
+	}
+
+	for i := 0; i < 2; i++ {
+		response = callTool(t, handler.Execute, args)
+		if response["error"] != nil || response["status"] != "not_running" || response["was_running"] != false {
+			t.Errorf("Expected repeated stop to succeed with was_running false, got %v", response)
+		}
+	}
+
+	response = callTool(t, handler.Execute, map[string]interface{}{"listen_port": float64(19163)})
+	if response["error"] == nil {
+		t.Errorf("Expected strict stop of a missing proxy to fail, got %v", response)
+	}
+}
+
+// shortWriteConn accepts at most three bytes per Write without an error
+type shortWriteConn struct {
+	net.Conn
+}
+
+func (c *shortWriteConn) Write(b []byte) (int, error) {
+	return c.Conn.Write(b[:min(len(b), 3)])
+}
+
+// TestShortWritesForwardEverything tests that the relay keeps writing after
+// short writes so the whole stream reaches the destination
+func TestShortWritesForwardEverything(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19168, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19168)
+	proxy, _ := manager.GetProxy(19168)
+
+	client, clientProxySide := net.Pipe()
+	backendProxySide, backend := net.Pipe()
+	defer client.Close()
+	defer backend.Close()
+	conn := proxy.newConnection(clientProxySide, &shortWriteConn{Conn: backendProxySide})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go proxy.copyWithCapture(conn, DirectionClientToServer, cancel)
+
+	payload := []byte("GET /short-writes HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	go client.Write(payload)
+
+	received := make([]byte, len(payload))
+	backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(backend, received); err != nil {
+		t.Fatalf("Backend got %q before failing: %v", received, err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Errorf("Expected %q, got %q", payload, received)
+	}
+
+	// The count is recorded once the final write returns
+	deadline := time.Now().Add(time.Second)
+	for conn.shortWrites.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if conn.shortWrites.Load() == 0 {
+		t.Error("Expected short writes to be recorded")
+	}
+	if ctx.Err() != nil {
+		t.Error("Connection should stay open after short writes")
+	}
+}
+
+// TestRedactSensitiveHeaders tests that stored captures are masked while the
+// backend receives the original bytes
+func TestRedactSensitiveHeaders(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	opts := ProxyOptions{Redact: true, RedactPatterns: []string{`api_key=[0-9a-f]+`}}
+	if err := manager.StartProxyWithOptions(19110, "127.0.0.1", backendPort, 1024*1024, opts); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19110)
+
+	client, err := net.Dial("tcp", "127.0.0.1:19110")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+
+	request := "GET /data?api_key=deadbeef HTTP/1.1\r\nHost: example\r\nAuthorization: Bearer secret-token\r\nCookie: session=abc123\r\n\r\n"
+	client.Write([]byte(request))
+
+	// The echo backend returns exactly what it received
+	echoed := make([]byte, len(request))
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(client, echoed); err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+	if string(echoed) != request {
+		t.Errorf("Backend received a modified request: %q", echoed)
+	}
+
+	proxy, _ := manager.GetProxy(19110)
+	captures := proxy.Buffer.GetAll()
+	if len(captures) == 0 {
+		t.Fatal("Expected captures")
+	}
+	for _, capture := range captures {
+		stored := string(capture.RawData)
+		for _, secret := range []string{"secret-token", "abc123", "deadbeef"} {
+			if strings.Contains(stored, secret) || strings.Contains(strings.Join(capture.AsciiStrings(), " "), secret) {
+				t.Errorf("Capture %d still contains %q", capture.Seq, secret)
+			}
+		}
+		if !strings.Contains(stored, "Authorization: ******") {
+			t.Errorf("Expected masked Authorization header, got %q", stored)
+		}
+		if !strings.Contains(stored, "Host: example") {
+			t.Errorf("Expected other headers to be untouched, got %q", stored)
+		}
+	}
+}
+
+// TestRedactAcrossReads tests that secrets split between reads are masked in
+// the later read and that capture hashes cover the masked payload
+func TestRedactAcrossReads(t *testing.T) {
+	manager := NewProxyManager()
+	opts := ProxyOptions{Redact: true, RedactPatterns: []string{`api_key=[0-9a-f]+`}, HTTPHeadersOnly: true}
+	if err := manager.StartProxyWithOptions(19221, "localhost", 18082, 1024*1024, opts); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19221)
+
+	proxy, _ := manager.GetProxy(19221)
+	conn := proxy.newConnection(nil, nil)
+	reads := []string{
+		"GET /data?api_k",
+		"ey=deadbeef HTTP/1.1\r\nAuthori",
+		"zation: Bearer secret-token\r\nCookie: session=abc",
+		"123\r\nHost: example\r\n\r\n",
+	}
+	for _, read := range reads {
+		proxy.captureData(conn, []byte(read), DirectionClientToServer)
+	}
+
+	var stored strings.Builder
+	for _, capture := range proxy.Buffer.GetAll() {
+		stored.Write(capture.RawData)
+		if capture.Hash != hashPayload(capture.RawData) {
+			t.Errorf("Capture %d hash does not cover the masked payload", capture.Seq)
+		}
+	}
+	want := "GET /data?api_k***********" + " HTTP/1.1\r\nAuthori" +
+		"zation: *******************\r\nCookie: ***********" +
+		"***\r\nHost: example\r\n\r\n"
+	if stored.String() != want {
+		t.Errorf("Stored bytes mismatch:\ngot:  %q\nwant: %q", stored.String(), want)
+	}
+}
+
+// TestAdaptiveSampling tests that capture frequency drops as the buffer fills
+func TestAdaptiveSampling(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxyWithOptions(19113, "localhost", 18082, 100*1000, ProxyOptions{AdaptiveSampling: true}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19113)
+
+	proxy, _ := manager.GetProxy(19113)
+	conn := proxy.newConnection(nil, nil)
+	payload := make([]byte, 1000)
+
+	// Below the threshold everything is captured
+	for i := 0; i < 45; i++ {
+		proxy.captureData(conn, payload, DirectionClientToServer)
+	}
+	if len(proxy.Buffer.GetAll()) != 45 || proxy.Stats.SampledOut != 0 {
+		t.Fatalf("Expected all 45 captures below threshold, got %d stored and %d skipped",
+			len(proxy.Buffer.GetAll()), proxy.Stats.SampledOut)
+	}
+
+	// Under sustained load most captures are skipped
+	for i := 0; i < 1000; i++ {
+		proxy.captureData(conn, payload, DirectionClientToServer)
+	}
+	if proxy.Stats.SampledOut < 500 {
+		t.Errorf("Expected most captures to be skipped near full buffer, only %d of 1000 were", proxy.Stats.SampledOut)
+	}
+	if proxy.Stats.BytesCaptured != 1045*1000 {
+		t.Errorf("Expected all bytes to be counted, got %d", proxy.Stats.BytesCaptured)
+	}
+}
+
+// TestPassThroughProxy tests forwarding and byte counting with capture: false
+func TestPassThroughProxy(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	if err := manager.StartProxyWithOptions(19114, "127.0.0.1", backendPort, 1024*1024, ProxyOptions{PassThrough: true}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19114)
+	proxy, _ := manager.GetProxy(19114)
+
+	client, err := net.Dial("tcp", "127.0.0.1:19114")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	client.Write([]byte("spliced"))
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(client, make([]byte, 7)); err != nil {
+		t.Fatalf("Traffic did not flow through pass-through proxy: %v", err)
+	}
+	client.Close()
+
+	// Counters are updated when the copies finish
+	deadline := time.Now().Add(2 * time.Second)
+	for proxy.GetGoroutineCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	proxy.Stats.mu.RLock()
+	bytesCaptured := proxy.Stats.BytesCaptured
+	proxy.Stats.mu.RUnlock()
+	if bytesCaptured != 14 {
+		t.Errorf("Expected 14 bytes counted, got %d", bytesCaptured)
+	}
+	if packets, _, _ := proxy.Buffer.GetStats(); packets != 0 {
+		t.Errorf("Expected no captures, got %d", packets)
+	}
+	if proxy.SetCaptureEnabled(true) == nil {
+		t.Error("Expected enabling capture on a pass-through proxy to fail")
+	}
+}
+
+// BenchmarkForwardNoCapture compares forwarding throughput of the io.Copy
+// (splice) path against the manual read loop with capture switched off
+func BenchmarkForwardNoCapture(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		port int
+		opts ProxyOptions
+	}{
+		{"splice", 19115, ProxyOptions{PassThrough: true}},
+		{"loop", 19116, ProxyOptions{}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			backendPort := startEchoServer(b)
+
+			manager := NewProxyManager()
+			if err := manager.StartProxyWithOptions(bench.port, "127.0.0.1", backendPort, 1024*1024, bench.opts); err != nil {
+				b.Fatalf("Failed to start proxy: %v", err)
+			}
+			defer manager.StopProxy(bench.port)
+			proxy, _ := manager.GetProxy(bench.port)
+			if !bench.opts.PassThrough {
+				proxy.SetCaptureEnabled(false)
+			}
+
+			client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", bench.port))
+			if err != nil {
+				b.Fatalf("Failed to connect to proxy: %v", err)
+			}
+			defer client.Close()
+
+			chunk := make([]byte, 64*1024)
+			b.SetBytes(int64(len(chunk)))
+			b.ResetTimer()
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := io.CopyN(io.Discard, client, int64(b.N)*int64(len(chunk)))
+				done <- err
+			}()
+			for i := 0; i < b.N; i++ {
+				if _, err := client.Write(chunk); err != nil {
+					b.Fatalf("Write failed: %v", err)
+				}
+			}
+			if err := <-done; err != nil {
+				b.Fatalf("Read failed: %v", err)
+			}
+		})
+	}
+}
+
+// BenchmarkCopyBuffers measures short-lived proxied connections, each
+// echoing one payload, with several connections open at once, to show the
+// copy loop's pooled read buffers under concurrency
+func BenchmarkCopyBuffers(b *testing.B) {
+	backendPort := startEchoServer(b)
+
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19230, "127.0.0.1", backendPort, 1024*1024); err != nil {
+		b.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19230)
+	proxy, _ := manager.GetProxy(19230)
+	proxy.SetCaptureEnabled(false)
+
+	payload := bytes.Repeat([]byte("x"), 1024)
+	for _, conns := range []int{1, 16, 64} {
+		b.Run(fmt.Sprintf("conns=%d", conns), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(payload)))
+
+			var next atomic.Int64
+			errs := make(chan error, conns)
+			b.ResetTimer()
+			for w := 0; w < conns; w++ {
+				go func() {
+					reply := make([]byte, len(payload))
+					for next.Add(1) <= int64(b.N) {
+						client, err := net.Dial("tcp", "127.0.0.1:19230")
+						if err != nil {
+							errs <- err
+							return
+						}
+						_, err = client.Write(payload)
+						if err == nil {
+							_, err = io.ReadFull(client, reply)
+						}
+						client.Close()
+						if err != nil {
+							errs <- err
+							return
+						}
+					}
+					errs <- nil
+				}()
+			}
+			for w := 0; w < conns; w++ {
+				if err := <-errs; err != nil {
+					b.Fatalf("Connection failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRingBufferLargeLimit compares slot reallocations of a 64MB
+// buffer filled with small packets, presized by the limit versus the old
+// fixed capacity of 1000
+func BenchmarkRingBufferLargeLimit(b *testing.B) {
+	const limit = 64 * 1024 * 1024
+	for _, bench := range []struct {
+		name   string
+		buffer func() *RingBuffer
+	}{
+		{"presized", func() *RingBuffer { return NewRingBuffer(limit) }},
+		{"fixed1000", func() *RingBuffer {
+			return &RingBuffer{data: make([]*CapturedPacket, 1000), maxSize: limit, minSlots: 1000, maxSlots: limit / minSlotBytes}
+		}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			payload := make([]byte, 512)
+			var grows int
+			for i := 0; i < b.N; i++ {
+				buffer := bench.buffer()
+				for j := 0; j < 100000; j++ {
+					buffer.Add(&CapturedPacket{RawData: payload})
+				}
+				grows += buffer.grows
+			}
+			b.ReportMetric(float64(grows)/float64(b.N), "grows/op")
+		})
+	}
+}
+
+// TestMaxConnsPerIP tests that connections beyond the per-IP cap are refused
+func TestMaxConnsPerIP(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	if err := manager.StartProxyWithOptions(19117, "127.0.0.1", backendPort, 1024*1024, ProxyOptions{MaxConnsPerIP: 1}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19117)
+
+	first, err := net.Dial("tcp", "127.0.0.1:19117")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer first.Close()
+	first.Write([]byte("one"))
+	first.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(first, make([]byte, 3)); err != nil {
+		t.Fatalf("First connection should be served: %v", err)
+	}
+
+	second, err := net.Dial("tcp", "127.0.0.1:19117")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer second.Close()
+	second.Write([]byte("two"))
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := second.Read(make([]byte, 3))
+	if netErr, ok := err.(net.Error); err == nil || n > 0 || (ok && netErr.Timeout()) {
+		t.Errorf("Expected second connection from the same IP to be closed, read %d bytes (err %v)", n, err)
+	}
+
+	response := callTool(t, NewListProxiesHandler(manager).Execute, map[string]interface{}{})
+	info := response["proxies"].([]interface{})[0].(map[string]interface{})
+	if info["rejected_connections"] != float64(1) {
+		t.Errorf("Expected 1 rejected connection, got %v", info["rejected_connections"])
+	}
+}
+
+// TestMaxConcurrentConnections tests that a connection flood is handled at
+// most max_concurrent_connections at a time, with the rest served later
+func TestMaxConcurrentConnections(t *testing.T) {
+	// A backend that holds every connection open until the client leaves
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start backend: %v", err)
+	}
+	defer backend.Close()
+	var backendConns atomic.Int32
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			backendConns.Add(1)
+			go func() {
+				io.Copy(io.Discard, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	manager := NewProxyManager()
+	opts := ProxyOptions{MaxConcurrentConns: 4}
+	if err := manager.StartProxyWithOptions(19179, "127.0.0.1", backend.Addr().(*net.TCPAddr).Port, 1024*1024, opts); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19179)
+	proxy, _ := manager.GetProxy(19179)
+
+	const flood = 20
+	clients := make([]net.Conn, 0, flood)
+	for i := 0; i < flood; i++ {
+		client, err := net.Dial("tcp", "127.0.0.1:19179")
+		if err != nil {
+			t.Fatalf("Failed to connect to proxy: %v", err)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if active := proxy.GetConnectionCount(); active > 4 {
+		t.Errorf("Expected at most 4 connections handled at once, got %d", active)
+	}
+	if dialed := backendConns.Load(); dialed != 4 {
+		t.Errorf("Expected 4 backend dials while the rest wait, got %d", dialed)
+	}
+
+	// Closing the handled clients lets the queued ones through
+	for _, client := range clients {
+		client.Close()
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for backendConns.Load() < flood && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if dialed := backendConns.Load(); dialed != flood {
+		t.Errorf("Expected all %d connections to reach the backend eventually, got %d", flood, dialed)
+	}
+
+	response := callTool(t, NewListProxiesHandler(manager).Execute, map[string]interface{}{})
+	info := response["proxies"].([]interface{})[0].(map[string]interface{})
+	if info["max_concurrent_connections"] != float64(4) {
+		t.Errorf("Expected max_concurrent_connections 4, got %v", info["max_concurrent_connections"])
+	}
+	if queued, _ := info["accepts_queued"].(float64); queued < 1 {
+		t.Errorf("Expected queued accepts to be counted, got %v", info["accepts_queued"])
+	}
+}
+
+// TestTimestampOffset tests that timestamps carry the display zone's real
+// offset instead of a hardcoded Z
+func TestTimestampOffset(t *testing.T) {
+	defer func(loc *time.Location) { displayLocation = loc }(displayLocation)
+
+	captured := time.Date(2024, 3, 1, 12, 0, 0, 250*int(time.Millisecond), time.UTC)
+	captures := []*CapturedPacket{{Seq: 1, Timestamp: captured}}
+
+	tests := []struct {
+		loc  *time.Location
+		want string
+	}{
+		{time.FixedZone("IST", 5*3600+1800), "2024-03-01T17:30:00.250+05:30"},
+		{time.FixedZone("PST", -8*3600), "2024-03-01T04:00:00.250-08:00"},
+		{time.UTC, "2024-03-01T12:00:00.250Z"},
+	}
+	for _, tt := range tests {
+		displayLocation = tt.loc
+		got := renderCaptures(captures, false, defaultHexDump)[0]["timestamp"]
+		if got != tt.want {
+			t.Errorf("%s: expected %s, got %v", tt.loc, tt.want, got)
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, got.(string))
+		if err != nil || !parsed.Equal(captured) {
+			t.Errorf("%s: %v does not parse back to the capture time: %v", tt.loc, got, err)
+		}
+	}
+}
+
+// TestMirrorTarget tests that a mirror receives the client's bytes while the
+// client only sees the primary's responses, and that a dead mirror is harmless
+func TestMirrorTarget(t *testing.T) {
+	primaryPort := startEchoServer(t)
+
+	// The mirror records what it receives and answers with junk
+	mirror, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start mirror: %v", err)
+	}
+	defer mirror.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := mirror.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("FROM MIRROR"))
+		data, _ := io.ReadAll(conn)
+		received <- data
+	}()
+
+	manager := NewProxyManager()
+	opts := ProxyOptions{MirrorTarget: mirror.Addr().String()}
+	if err := manager.StartProxyWithOptions(19133, "127.0.0.1", primaryPort, 1024*1024, opts); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19133)
+
+	message := "shadow me please"
+	client, err := net.Dial("tcp", "localhost:19133")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	client.Write([]byte(message))
+	reply := make([]byte, len(message))
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	if string(reply) != message {
+		t.Errorf("Expected the primary's echo %q, got %q", message, reply)
+	}
+	client.Close()
+
+	select {
+	case data := <-received:
+		if string(data) != message {
+			t.Errorf("Expected the mirror to receive %q, got %q", message, data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Mirror never finished receiving the client's bytes")
+	}
+
+	// An unreachable mirror must not affect the primary path
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	deadMirror := closed.Addr().String()
+	closed.Close()
+	opts = ProxyOptions{MirrorTarget: deadMirror}
+	if err := manager.StartProxyWithOptions(19134, "127.0.0.1", primaryPort, 1024*1024, opts); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19134)
+
+	client, err = net.Dial("tcp", "localhost:19134")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		client.Write([]byte(message))
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := io.ReadFull(client, reply); err != nil || string(reply) != message {
+			t.Fatalf("Round %d through a proxy with a dead mirror failed: %q, %v", i, reply, err)
+		}
+	}
+
+	proxy, _ := manager.GetProxy(19134)
+	proxy.Stats.mu.RLock()
+	failures := proxy.Stats.MirrorFailures
+	proxy.Stats.mu.RUnlock()
+	if failures != 1 {
+		t.Errorf("Expected 1 mirror failure, got %d", failures)
+	}
+
+	// A mirror pointing back at the proxy would loop
+	opts = ProxyOptions{MirrorTarget: "localhost:19135"}
+	if err := manager.StartProxyWithOptions(19135, "127.0.0.1", primaryPort, 1024, opts); err == nil {
+		manager.StopProxy(19135)
+		t.Error("Expected a mirror_target pointing at the proxy itself to be refused")
+	}
+}
+
+// TestCoalesceWindow tests that rapid same-direction reads merge into one
+// capture without merging across a direction change
+func TestCoalesceWindow(t *testing.T) {
+	manager := NewProxyManager()
+	opts := ProxyOptions{CoalesceWindow: 100 * time.Millisecond}
+	if err := manager.StartProxyWithOptions(19137, "localhost", 18082, 1024*1024, opts); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19137)
+
+	proxy, _ := manager.GetProxy(19137)
+	conn := proxy.newConnection(nil, nil)
+	proxy.captureData(conn, []byte("GET / HTTP/1.1\r\n"), DirectionClientToServer)
+	proxy.captureData(conn, []byte("Host: example.com\r\n"), DirectionClientToServer)
+	proxy.captureData(conn, []byte("\r\n"), DirectionClientToServer)
+	proxy.captureData(conn, []byte("HTTP/1.1 204 No Content\r\n\r\n"), DirectionServerToClient)
+	proxy.captureData(conn, []byte("next"), DirectionClientToServer)
+
+	// The latest capture is held back until the window passes
+	if got := len(proxy.Buffer.GetAll()); got != 2 {
+		t.Fatalf("Expected 2 captures before the window passes, got %d", got)
+	}
+	time.Sleep(250 * time.Millisecond)
+
+	captures := proxy.Buffer.GetAll()
+	if len(captures) != 3 {
+		t.Fatalf("Expected 3 captures after coalescing, got %d", len(captures))
+	}
+
+	request := "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	merged := captures[0]
+	if merged.Direction != DirectionClientToServer || string(merged.RawData) != request || merged.Bytes != len(request) {
+		t.Errorf("Expected the three request reads merged, got %s %q (%d bytes)", merged.Direction, merged.RawData, merged.Bytes)
+	}
+	if merged.Hash != hashPayload([]byte(request)) {
+		t.Error("Expected the merged capture to hash the combined payload")
+	}
+	if !strings.Contains(merged.HexDump(), "48 6f 73 74") || len(merged.AsciiStrings()) != 2 {
+		t.Errorf("Expected hex dump and ASCII strings of the merged data, got %q", merged.AsciiStrings())
+	}
+	if captures[1].Direction != DirectionServerToClient || captures[2].Direction != DirectionClientToServer || string(captures[2].RawData) != "next" {
+		t.Errorf("Expected the response and the next request as separate captures, got %q and %q", captures[1].RawData, captures[2].RawData)
+	}
+	for i := 1; i < len(captures); i++ {
+		if captures[i].Seq <= captures[i-1].Seq {
+			t.Errorf("Expected increasing seq numbers, got %d after %d", captures[i].Seq, captures[i-1].Seq)
+		}
+	}
+}
+
+// TestIngestContains tests that only packets containing the marker are
+// stored while every packet is counted
+func TestIngestContains(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+	if err := manager.StartProxyWithOptions(19213, "localhost", 18082, 1024*1024, ProxyOptions{IngestContains: "X-Flow: checkout"}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	proxy, _ := manager.GetProxy(19213)
+	conn := proxy.newConnection(nil, nil)
+
+	packets := [][]byte{
+		[]byte("GET /cart HTTP/1.1\r\nX-Flow: browse\r\n\r\n"),
+		[]byte("POST /pay HTTP/1.1\r\nX-Flow: checkout\r\n\r\n"),
+		[]byte("HTTP/1.1 200 OK\r\n\r\n"),
+		[]byte("x-flow: checkout"), // Matching is case-sensitive
+	}
+	total := 0
+	for _, packet := range packets {
+		proxy.captureData(conn, packet, DirectionClientToServer)
+		total += len(packet)
+	}
+
+	captures := proxy.Buffer.GetAll()
+	if len(captures) != 1 || string(captures[0].RawData) != string(packets[1]) {
+		t.Fatalf("Expected only the packet with the marker to be stored, got %d captures", len(captures))
+	}
+	proxy.Stats.mu.RLock()
+	skipped, counted := proxy.Stats.NoMatchSkipped, proxy.Stats.BytesCaptured
+	proxy.Stats.mu.RUnlock()
+	if skipped != 3 || counted != int64(total) {
+		t.Errorf("Expected 3 skipped packets and %d bytes counted, got %d and %d", total, skipped, counted)
+	}
+
+	// A marker split across reads stores the read where it completes
+	proxy.captureData(conn, []byte("HTTP/1.1 200 OK\r\nX-Flow: chec"), DirectionServerToClient)
+	proxy.captureData(conn, []byte("kout\r\n\r\n"), DirectionServerToClient)
+	captures = proxy.Buffer.GetAll()
+	if len(captures) != 2 || string(captures[1].RawData) != "kout\r\n\r\n" {
+		t.Errorf("Expected the read completing a split marker to be stored, got %d captures", len(captures))
+	}
+}
+
+// TestResetStats tests that reset_stats zeroes the counters, which then
+// restart from new traffic, while captures stay in the buffer
+func TestResetStats(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	defer manager.StopAll()
+	if err := manager.StartProxy(19214, "127.0.0.1", backendPort, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	proxy, _ := manager.GetProxy(19214)
+
+	exchange := func(message string) {
+		t.Helper()
+		client, err := net.Dial("tcp", "127.0.0.1:19214")
+		if err != nil {
+			t.Fatalf("Failed to connect to proxy: %v", err)
+		}
+		defer client.Close()
+		client.Write([]byte(message))
+		if _, err := io.ReadFull(client, make([]byte, len(message))); err != nil {
+			t.Fatalf("Failed to read echo: %v", err)
+		}
+	}
+	exchange("before reset")
+
+	captured := len(proxy.Buffer.GetAll())
+	if captured == 0 {
+		t.Fatal("Expected captures before the reset")
+	}
+
+	result := callTool(t, NewResetStatsHandler(manager).Execute, map[string]interface{}{"listen_port": float64(19214)})
+	if result["status"] != "reset" || result["buffer_captures"] != float64(captured) {
+		t.Fatalf("Unexpected reset result: %v", result)
+	}
+	if got := len(proxy.Buffer.GetAll()); got != captured {
+		t.Errorf("Expected %d captures to remain, got %d", captured, got)
+	}
+
+	info := callTool(t, NewListProxiesHandler(manager).Execute, map[string]interface{}{})["proxies"].([]interface{})[0].(map[string]interface{})
+	if info["bytes_captured"] != float64(0) || info["total_connections"] != float64(0) {
+		t.Errorf("Expected zeroed counters, got %v bytes and %v connections", info["bytes_captured"], info["total_connections"])
+	}
+	if info["stats_reset_at"] != result["stats_reset_at"] {
+		t.Errorf("Expected stats_reset_at %v, got %v", result["stats_reset_at"], info["stats_reset_at"])
+	}
+
+	// New traffic counts from zero
+	exchange("after")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		proxy.Stats.mu.RLock()
+		bytesCaptured, connections := proxy.Stats.BytesCaptured, proxy.Stats.Connections
+		proxy.Stats.mu.RUnlock()
+		if bytesCaptured == 10 && connections == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 10 bytes on 1 connection after the reset, got %d bytes on %d", bytesCaptured, connections)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestTextOnlyCapture tests that binary packets are counted but not stored
+func TestTextOnlyCapture(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxyWithOptions(19138, "localhost", 18082, 1024*1024, ProxyOptions{TextOnly: true}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19138)
+
+	proxy, _ := manager.GetProxy(19138)
+	conn := proxy.newConnection(nil, nil)
+	mostlyText := append([]byte("USER alice\r\n"), 0x00)
+	unicodeText := []byte("Grüße, привет, 世界\n")
+	splitRune := []byte("привет")[:11] // Ends partway through the last letter
+	packets := [][]byte{
+		[]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"),
+		{0x16, 0x03, 0x01, 0x00, 0xa5, 0x01, 0x00, 0x00, 0xa1},
+		mostlyText,
+		{0x00, 0x00, 0x00, 0x05, 0x0a, 'h', 'e', 'l', 'l', 'o'},
+		unicodeText,
+		splitRune,
+	}
+	total := 0
+	for _, packet := range packets {
+		proxy.captureData(conn, packet, DirectionClientToServer)
+		total += len(packet)
+	}
+
+	captures := proxy.Buffer.GetAll()
+	if len(captures) != 4 || string(captures[0].RawData) != string(packets[0]) || string(captures[1].RawData) != string(mostlyText) ||
+		string(captures[2].RawData) != string(unicodeText) || string(captures[3].RawData) != string(splitRune) {
+		t.Fatalf("Expected only the 4 text packets to be stored, got %d captures", len(captures))
+	}
+	proxy.Stats.mu.RLock()
+	skipped, counted := proxy.Stats.BinarySkipped, proxy.Stats.BytesCaptured
+	proxy.Stats.mu.RUnlock()
+	if skipped != 2 || counted != int64(total) {
+		t.Errorf("Expected 2 skipped packets and %d bytes counted, got %d and %d", total, skipped, counted)
+	}
+
+	// A stricter threshold also drops the packet with one binary byte but
+	// keeps multibyte UTF-8 text
+	if err := manager.StartProxyWithOptions(19139, "localhost", 18082, 1024*1024, ProxyOptions{TextOnly: true, TextMinPrintable: 1}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19139)
+	strict, _ := manager.GetProxy(19139)
+	strictConn := strict.newConnection(nil, nil)
+	for _, packet := range packets {
+		strict.captureData(strictConn, packet, DirectionClientToServer)
+	}
+	if got := len(strict.Buffer.GetAll()); got != 3 {
+		t.Errorf("Expected 3 captures with a ratio of 1, got %d", got)
+	}
+}
+
+// TestFirstPacketOnly tests that only the first packet in each direction of
+// a connection is stored while every byte is counted
+func TestFirstPacketOnly(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxyWithOptions(19152, "localhost", 18082, 1024*1024, ProxyOptions{FirstPacketOnly: true}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19152)
+
+	proxy, _ := manager.GetProxy(19152)
+	packets := []struct {
+		direction string
+		data      string
+	}{
+		{DirectionClientToServer, "\x16\x03\x01 client hello"},
+		{DirectionServerToClient, "\x16\x03\x03 server hello"},
+		{DirectionClientToServer, "finished"},
+		{DirectionServerToClient, "application data"},
+		{DirectionClientToServer, "more application data"},
+	}
+	total := 0
+	for _, conn := range []*Connection{proxy.newConnection(nil, nil), proxy.newConnection(nil, nil)} {
+		for _, packet := range packets {
+			proxy.captureData(conn, []byte(packet.data), packet.direction)
+			total += len(packet.data)
+		}
+	}
+
+	captures := proxy.Buffer.GetAll()
+	if len(captures) != 4 {
+		t.Fatalf("Expected 2 captures per connection, got %d", len(captures))
+	}
+	for i, capture := range captures {
+		want := packets[i%2]
+		if capture.ConnID != uint64(i/2+1) || capture.Direction != want.direction || string(capture.RawData) != want.data {
+			t.Errorf("Capture %d: expected conn %d %s %q, got conn %d %s %q", i, i/2+1, want.direction, want.data, capture.ConnID, capture.Direction, capture.RawData)
+		}
+	}
+
+	proxy.Stats.mu.RLock()
+	skipped, counted := proxy.Stats.LaterPacketsSkipped, proxy.Stats.BytesCaptured
+	proxy.Stats.mu.RUnlock()
+	if skipped != 6 || counted != int64(total) {
+		t.Errorf("Expected 6 skipped packets and %d bytes counted, got %d and %d", total, skipped, counted)
+	}
+}
+
+// TestExcludeCIDRs tests that connections from an excluded source are
+// proxied but not captured while other sources still are
+func TestExcludeCIDRs(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	opts := ProxyOptions{ExcludeCIDRs: []string{"127.0.0.2/32"}}
+	if err := manager.StartProxyWithOptions(19157, "127.0.0.1", backendPort, 1024*1024, opts); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19157)
+	proxy, _ := manager.GetProxy(19157)
+
+	for _, source := range []string{"127.0.0.2", "127.0.0.1"} {
+		dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(source)}}
+		client, err := dialer.Dial("tcp", "127.0.0.1:19157")
+		if err != nil {
+			t.Fatalf("Failed to connect from %s: %v", source, err)
+		}
+		msg := "hello from " + source
+		client.Write([]byte(msg))
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := io.ReadFull(client, make([]byte, len(msg))); err != nil {
+			t.Fatalf("Expected %s to be proxied: %v", source, err)
+		}
+		client.Close()
+	}
+
+	captures := proxy.Buffer.GetAll()
+	if len(captures) != 2 {
+		t.Fatalf("Expected 2 captures from the included source, got %d", len(captures))
+	}
+	for _, capture := range captures {
+		if string(capture.RawData) != "hello from 127.0.0.1" {
+			t.Errorf("Unexpected capture %q", capture.RawData)
+		}
+	}
+	proxy.Stats.mu.RLock()
+	excluded := proxy.Stats.Excluded
+	proxy.Stats.mu.RUnlock()
+	if excluded != 1 {
+		t.Errorf("Expected 1 excluded connection, got %d", excluded)
+	}
+
+	if err := manager.StartProxyWithOptions(19158, "127.0.0.1", backendPort, 1024, ProxyOptions{ExcludeCIDRs: []string{"10.0.0.0/33"}}); err == nil {
+		manager.StopProxy(19158)
+		t.Error("Expected an invalid CIDR to fail")
+	}
+}
+
+// TestWeightedForwardTargets tests that a 90/10 weighting splits many
+// connections roughly 90/10 and that list_proxies reports the split
+func TestWeightedForwardTargets(t *testing.T) {
+	// Backends that answer with their name and hang up
+	var ports []int
+	for _, name := range []string{"A", "B"} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to start backend: %v", err)
+		}
+		defer listener.Close()
+		go func(name string) {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				conn.Write([]byte(name))
+				conn.Close()
+			}
+		}(name)
+		ports = append(ports, listener.Addr().(*net.TCPAddr).Port)
+	}
+
+	cfg, err := parseProxyConfig(map[string]interface{}{
+		"listen_port": float64(19160),
+		"forward_targets": []interface{}{
+			map[string]interface{}{"host": "127.0.0.1", "port": float64(ports[0]), "weight": float64(90)},
+			map[string]interface{}{"host": "127.0.0.1", "port": float64(ports[1]), "weight": float64(10)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	manager := NewProxyManager()
+	if err := manager.StartProxyWithOptions(cfg.ListenPort, cfg.ForwardHost, cfg.ForwardPort, cfg.CaptureLimit, cfg.Options); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19160)
+
+	const total = 300
+	counts := make(map[string]int)
+	for i := 0; i < total; i++ {
+		client, err := net.Dial("tcp", "127.0.0.1:19160")
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		name := make([]byte, 1)
+		if _, err := io.ReadFull(client, name); err != nil {
+			t.Fatalf("Failed to read backend name: %v", err)
+		}
+		client.Close()
+		counts[string(name)]++
+	}
+	if share := float64(counts["A"]) / total; share < 0.8 || share > 0.97 {
+		t.Errorf("Expected about 90%% of connections on A, got %d of %d", counts["A"], total)
+	}
+
+	result := callTool(t, NewListProxiesHandler(manager).Execute, map[string]interface{}{})
+	info := result["proxies"].([]interface{})[0].(map[string]interface{})
+	targets, _ := info["forward_targets"].([]interface{})
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 forward targets in list_proxies, got %v", info["forward_targets"])
+	}
+	for i, name := range []string{"A", "B"} {
+		target := targets[i].(map[string]interface{})
+		if target["connections"] != float64(counts[name]) || target["weight_share"] != []string{"90.0%", "10.0%"}[i] {
+			t.Errorf("Target %s: unexpected %v (counted %d)", name, target, counts[name])
+		}
+	}
+
+	if _, err := parseProxyConfig(map[string]interface{}{
+		"listen_port":     float64(19160),
+		"forward_targets": []interface{}{map[string]interface{}{"port": float64(8080), "weight": float64(0)}},
+	}); err == nil {
+		t.Error("Expected a zero weight to be rejected")
+	}
+}
+
+// TestCloneProxy tests that a clone copies the source's settings but not its captures
+func TestCloneProxy(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+
+	response := callTool(t, NewStartProxyHandler(manager).Execute, map[string]interface{}{
+		"listen_port":                 float64(19189),
+		"forward_host":                "127.0.0.1",
+		"forward_port":                float64(18082),
+		"capture_limit":               "2MB",
+		"http_headers_only":           true,
+		"max_stored_bytes_per_packet": float64(512),
+		"label":                       "original",
+		"tags":                        []interface{}{"api"},
+	})
+	if response["error"] != nil {
+		t.Fatalf("Failed to start proxy: %v", response)
+	}
+	source, _ := manager.GetProxy(19189)
+	source.SetCaptureEnabled(false)
+
+	result := callTool(t, NewCloneProxyHandler(manager).Execute, map[string]interface{}{
+		"listen_port":     float64(19189),
+		"new_listen_port": float64(19190),
+	})
+	if result["status"] != "cloned" || result["listen_port"] != float64(19190) || result["cloned_from"] != float64(19189) {
+		t.Fatalf("Unexpected clone result: %v", result)
+	}
+	if result["forward_to"] != "127.0.0.1:18082" || result["capture_limit"] != float64(2*1024*1024) || result["label"] != "original" {
+		t.Errorf("Clone result does not match the source: %v", result)
+	}
+
+	clone, exists := manager.GetProxy(19190)
+	if !exists {
+		t.Fatal("Clone is not running")
+	}
+	if !reflect.DeepEqual(clone.Options, source.Options) {
+		t.Errorf("Clone options differ:\n  source: %+v\n  clone:  %+v", source.Options, clone.Options)
+	}
+	if clone.CaptureEnabled() {
+		t.Error("Clone should copy the source's disabled capture switch")
+	}
+	source.SetCaptureEnabled(true)
+
+	conn := source.newConnection(nil, nil)
+	source.registerConnection(conn)
+	source.recordCapture(conn, []byte("source only"), DirectionClientToServer, false)
+	if len(source.Buffer.GetAll()) != 1 {
+		t.Fatal("Expected a capture on the source")
+	}
+	if captures := clone.Buffer.GetAll(); len(captures) != 0 {
+		t.Errorf("Clone buffer should be independent, got %d captures", len(captures))
+	}
+
+	// Cloning a missing proxy or onto a used port fails inline
+	result = callTool(t, NewCloneProxyHandler(manager).Execute, map[string]interface{}{
+		"listen_port":     float64(19189),
+		"new_listen_port": float64(19190),
+	})
+	if result["error"] == nil {
+		t.Errorf("Expected an error cloning onto a used port, got %v", result)
+	}
+}
+
+// TestMaxCapturesPerSec tests that a burst stores no more than the rate allows
+func TestMaxCapturesPerSec(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxyWithOptions(19191, "localhost", 18082, 1024*1024, ProxyOptions{MaxCapturesPerSec: 20}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19191)
+
+	proxy, _ := manager.GetProxy(19191)
+	conn := proxy.newConnection(nil, nil)
+	payload := []byte("burst")
+
+	start := time.Now()
+	for i := 0; i < 500; i++ {
+		proxy.captureData(conn, payload, DirectionClientToServer)
+	}
+	elapsed := time.Since(start)
+	if elapsed >= time.Second {
+		t.Skipf("Burst took %v, too slow to check a per-second cap", elapsed)
+	}
+
+	// A full bucket plus whatever refilled during the burst
+	stored := len(proxy.Buffer.GetAll())
+	allowed := 20 + int(elapsed.Seconds()*20) + 1
+	if stored < 20 || stored > allowed {
+		t.Errorf("Expected between 20 and %d stored captures, got %d", allowed, stored)
+	}
+	if proxy.Stats.RateLimited != int64(500-stored) {
+		t.Errorf("Expected %d rate limited captures, got %d", 500-stored, proxy.Stats.RateLimited)
+	}
+	if proxy.Stats.BytesCaptured != int64(500*len(payload)) {
+		t.Errorf("Expected all bytes to be counted, got %d", proxy.Stats.BytesCaptured)
+	}
+
+	// The bucket refills over time
+	time.Sleep(100 * time.Millisecond)
+	proxy.captureData(conn, payload, DirectionClientToServer)
+	if len(proxy.Buffer.GetAll()) != stored+1 {
+		t.Error("Expected a capture to be stored after the bucket refilled")
+	}
+}
+
+// TestListenNetwork tests binding IPv4 only with listen_network
+func TestListenNetwork(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	defer manager.StopAll()
+
+	response := callTool(t, NewStartProxyHandler(manager).Execute, map[string]interface{}{
+		"listen_port":    float64(19192),
+		"forward_host":   "127.0.0.1",
+		"forward_port":   float64(backendPort),
+		"listen_network": "tcp4",
+	})
+	if response["error"] != nil {
+		t.Fatalf("Failed to start proxy: %v", response)
+	}
+
+	client, err := net.Dial("tcp4", "127.0.0.1:19192")
+	if err != nil {
+		t.Fatalf("IPv4 client failed to connect: %v", err)
+	}
+	client.Write([]byte("ping"))
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+	client.Close()
+
+	// An IPv6 client is refused, where the host has IPv6 loopback at all
+	if probe, err := net.Listen("tcp6", "[::1]:0"); err == nil {
+		probe.Close()
+		if conn, err := net.DialTimeout("tcp6", "[::1]:19192", time.Second); err == nil {
+			conn.Close()
+			t.Error("Expected an IPv6 client to be refused by a tcp4 listener")
+		}
+	}
+
+	result := callTool(t, NewListProxiesHandler(manager).Execute, map[string]interface{}{})
+	proxies := result["proxies"].([]interface{})
+	if network := proxies[0].(map[string]interface{})["listen_network"]; network != "tcp4" {
+		t.Errorf("Expected listen_network tcp4 in list_proxies, got %v", network)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{
+		"listen_port":    float64(19193),
+		"forward_host":   "127.0.0.1",
+		"forward_port":   float64(backendPort),
+		"listen_network": "ip4",
+	}
+	if _, err := NewStartProxyHandler(manager).Execute(context.Background(), request); err == nil {
+		t.Error("Expected an error for an invalid listen_network")
+	}
+}
+
+// TestGetSocketInfo tests the listener details reported by get_socket_info
+func TestGetSocketInfo(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	defer manager.StopAll()
+	if err := manager.StartProxyWithOptions(19195, "127.0.0.1", backendPort, 1024*1024, ProxyOptions{TCPKeepAlive: -1}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+
+	client, err := net.Dial("tcp", "127.0.0.1:19195")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+	proxy, _ := manager.GetProxy(19195)
+	waitForConnection(t, proxy)
+
+	result := callTool(t, NewGetSocketInfoHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19195),
+	})
+	if address, _ := result["bound_address"].(string); !strings.HasSuffix(address, ":19195") {
+		t.Errorf("Expected a bound address on port 19195, got %v", result["bound_address"])
+	}
+	if result["network"] != "tcp" || result["deadlines_supported"] != true || result["tcp_nodelay"] != true {
+		t.Errorf("Unexpected socket info: %v", result)
+	}
+	keepAlive := result["tcp_keepalive"].(map[string]interface{})
+	if keepAlive["enabled"] != false || keepAlive["period_ms"] != float64(0) {
+		t.Errorf("Expected keepalive to be reported disabled, got %v", keepAlive)
+	}
+	accept := result["accept_loop"].(map[string]interface{})
+	if accept["running"] != true || accept["accepts"] != float64(1) || accept["accept_errors"] != float64(0) || accept["last_accept"] == nil {
+		t.Errorf("Unexpected accept loop health: %v", accept)
+	}
+	if runtime.GOOS == "linux" {
+		options, _ := result["socket_options"].(map[string]interface{})
+		if options["reuseaddr"] != true {
+			t.Errorf("Expected SO_REUSEADDR on the listener, got %v", result["socket_options"])
+		}
+	}
+
+	result = callTool(t, NewGetSocketInfoHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19196),
+	})
+	if result["error"] == nil {
+		t.Error("Expected an error for a port without a proxy")
+	}
+}
+
+// TestRetainSeconds tests that the buffer keeps only packets inside the
+// retention window, both as packets arrive and when swept, including a
+// packet stored after newer ones
+func TestRetainSeconds(t *testing.T) {
+	buffer := NewRingBuffer(1024 * 1024)
+	buffer.SetRetention(30 * time.Second)
+
+	base := time.Now().Add(-50 * time.Second)
+	for i, offset := range []int{0, 10, 25, 40, 50, 5} {
+		buffer.Add(&CapturedPacket{
+			Seq:       uint64(i + 1),
+			Timestamp: base.Add(time.Duration(offset) * time.Second),
+			RawData:   []byte("packet"),
+		})
+	}
+
+	seqs := func() []uint64 {
+		var seqs []uint64
+		for _, packet := range buffer.GetAll() {
+			seqs = append(seqs, packet.Seq)
+		}
+		return seqs
+	}
+	if got := seqs(); !reflect.DeepEqual(got, []uint64{3, 4, 5, 6}) {
+		t.Errorf("Expected packets 1 and 2 expired as packets arrived, got %v", got)
+	}
+
+	// The sweep finds the late packet behind newer ones
+	buffer.Expire(time.Now())
+	if got := seqs(); !reflect.DeepEqual(got, []uint64{3, 4, 5}) {
+		t.Errorf("Expected packets 3-5 inside the window, got %v", got)
+	}
+	if _, bytes, _ := buffer.GetStats(); bytes != 3*len("packet") {
+		t.Errorf("Expected expired bytes to be released, got %d", bytes)
+	}
+
+	// A sweep with no new packets moves the window on
+	buffer.Expire(base.Add(75 * time.Second))
+	packets := buffer.GetAll()
+	if len(packets) != 1 || packets[0].Seq != 5 {
+		t.Errorf("Expected only packet 5 after the sweep, got %d packets", len(packets))
+	}
+}
+
+// TestPeekCaptures tests that peek_captures leaves the buffer unchanged
+func TestPeekCaptures(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19197, "localhost", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19197)
+
+	proxy, _ := manager.GetProxy(19197)
+	conn := proxy.newConnection(nil, nil)
+	proxy.captureData(conn, []byte("first"), DirectionClientToServer)
+	proxy.captureData(conn, []byte("second"), DirectionServerToClient)
+	before := proxy.Buffer.GetAll()
+
+	// Even an explicit clear_buffer is ignored
+	args := map[string]interface{}{"listen_port": float64(19197), "clear_buffer": true}
+	for i := 0; i < 2; i++ {
+		result := callTool(t, NewPeekCapturesHandler(manager).Execute, args)
+		proxyResult := result["proxies"].([]interface{})[0].(map[string]interface{})
+		if proxyResult["total_captures"] != float64(2) {
+			t.Fatalf("Peek %d: expected 2 captures, got %v", i+1, proxyResult["total_captures"])
+		}
+	}
+	if args["clear_buffer"] != true {
+		t.Error("peek_captures modified the caller's arguments")
+	}
+
+	after := proxy.Buffer.GetAll()
+	if len(after) != len(before) {
+		t.Fatalf("Expected %d captures after peeking, got %d", len(before), len(after))
+	}
+	for i := range before {
+		if after[i] != before[i] {
+			t.Errorf("Capture %d changed after peeking", i)
+		}
+	}
+}
+
+// TestCloseConnection tests closing one connection with close_connection
+func TestCloseConnection(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	defer manager.StopAll()
+	if err := manager.StartProxy(19200, "127.0.0.1", backendPort, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	proxy, _ := manager.GetProxy(19200)
+
+	client, err := net.Dial("tcp", "127.0.0.1:19200")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+	conn := waitForConnection(t, proxy)
+
+	result := callTool(t, NewCloseConnectionHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19200),
+		"conn_id":     float64(conn.ID),
+	})
+	if result["status"] != "closed" || result["close_reason"] != CloseManual {
+		t.Fatalf("Unexpected close_connection result: %v", result)
+	}
+
+	// The client sees the close
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, err := client.Read(make([]byte, 16)); err == nil {
+		t.Fatalf("Expected the client to see the close, read %d bytes", n)
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("Timed out waiting for the client to see the close")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		info, _ := proxy.LookupConnection(conn.ID)
+		if !info.ClosedAt.IsZero() && info.CloseReason == CloseManual {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected close reason %q, got %+v", CloseManual, info)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The proxy keeps serving new connections
+	other, err := net.Dial("tcp", "127.0.0.1:19200")
+	if err != nil {
+		t.Fatalf("Proxy stopped accepting after close_connection: %v", err)
+	}
+	defer other.Close()
+
+	// A connection already closing keeps and reports its own reason
+	closing := waitForConnection(t, proxy)
+	closing.setCloseReason(CloseClientEOF)
+	result = callTool(t, NewCloseConnectionHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19200),
+		"conn_id":     float64(closing.ID),
+	})
+	if result["close_reason"] != CloseClientEOF {
+		t.Errorf("Expected close reason %q reported, got %v", CloseClientEOF, result)
+	}
+
+	result = callTool(t, NewCloseConnectionHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19200),
+		"conn_id":     float64(conn.ID),
+	})
+	if result["error"] == nil {
+		t.Error("Expected an error closing a connection that is no longer live")
+	}
+}
+
+// TestPossibleRetry tests that only a byte-identical repeat of the previous
+// whole request from the same direction of a connection is flagged
+// possible_retry, and that repeated reads within one request are not
+func TestPossibleRetry(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+
+	if err := manager.StartProxy(19202, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	proxy, _ := manager.GetProxy(19202)
+
+	conn := proxy.newConnection(nil, nil)
+	headers := []byte("POST /pay HTTP/1.1\r\nContent-Length: 4\r\n\r\n")
+	response := []byte("HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\n\r\n")
+	chunk := []byte("ping")
+	steps := []struct {
+		data      []byte
+		direction string
+		want      bool
+	}{
+		{headers, DirectionClientToServer, false},
+		{chunk, DirectionClientToServer, false},
+		{response, DirectionServerToClient, false},
+		// Heartbeats and identical chunks within one request are not retries
+		{chunk, DirectionServerToClient, false},
+		{chunk, DirectionServerToClient, false},
+		// The whole request again; only its last read completes the repeat
+		{headers, DirectionClientToServer, false},
+		{chunk, DirectionClientToServer, true},
+		{response, DirectionServerToClient, false},
+		// Reads after the copy is complete are not flagged
+		{headers, DirectionClientToServer, false},
+		{chunk, DirectionClientToServer, true},
+		{[]byte("more"), DirectionClientToServer, false},
+	}
+	for _, step := range steps {
+		proxy.recordCapture(conn, step.data, step.direction, false)
+	}
+
+	// The same request on another connection is not a retry
+	other := proxy.newConnection(nil, nil)
+	proxy.recordCapture(other, headers, DirectionClientToServer, false)
+	proxy.recordCapture(other, chunk, DirectionClientToServer, false)
+
+	captures := proxy.Buffer.GetAll()
+	if len(captures) != len(steps)+2 {
+		t.Fatalf("Expected %d captures, got %d", len(steps)+2, len(captures))
+	}
+	for i, capture := range captures {
+		want := i < len(steps) && steps[i].want
+		if capture.PossibleRetry != want {
+			t.Errorf("Capture %d: expected possible_retry %v, got %v", i, want, capture.PossibleRetry)
+		}
+	}
+
+	// Outside the window a repeat is no longer flagged
+	var detector retryDetector
+	start := time.Now()
+	detector.observe(DirectionClientToServer, []byte("abc"), start)
+	detector.observe(DirectionServerToClient, []byte("ok"), start)
+	if detector.observe(DirectionClientToServer, []byte("abc"), start.Add(retryWindow+time.Millisecond)) {
+		t.Error("Expected a repeat after the window not to be flagged")
+	}
+}
+
+// TestCoalesceWindowLimit tests that a merged capture is stored once the
+// next read would take it past the per-capture limit
+func TestCoalesceWindowLimit(t *testing.T) {
+	manager := NewProxyManager()
+	opts := ProxyOptions{CoalesceWindow: time.Minute, MaxStoredBytesPerPacket: 10}
+	if err := manager.StartProxyWithOptions(19223, "localhost", 18082, 1024*1024, opts); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19223)
+
+	proxy, _ := manager.GetProxy(19223)
+	conn := proxy.newConnection(nil, nil)
+	for _, read := range []string{"aaaa", "bbbb", "cccc", "dd"} {
+		proxy.captureData(conn, []byte(read), DirectionClientToServer)
+	}
+
+	captures := proxy.Buffer.GetAll()
+	if len(captures) != 1 || string(captures[0].RawData) != "aaaabbbb" || captures[0].Truncated {
+		t.Fatalf("Expected the first two reads stored once the limit was reached, got %d captures", len(captures))
+	}
+	proxy.flushCoalesced(conn)
+	captures = proxy.Buffer.GetAll()
+	if len(captures) != 2 || string(captures[1].RawData) != "ccccdd" {
+		t.Errorf("Expected the remaining reads merged into a new capture, got %d captures", len(captures))
+	}
+}
+
+// failingListener returns an error from every Accept, as a listener does
+// while the process is out of file descriptors
+type failingListener struct {
+	accepts atomic.Int32
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts.Add(1)
+	return nil, errors.New("accept: too many open files")
+}
+
+func (l *failingListener) Close() error   { return nil }
+func (l *failingListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+// TestAcceptBackoff verifies that persistent accept errors are retried with
+// a growing delay instead of spinning, and that stopping isn't held up by it
+func TestAcceptBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	listener := &failingListener{}
+	p := &ProxyInstance{Listener: listener, ctx: ctx}
+
+	p.wg.Add(1)
+	go p.run()
+	time.Sleep(300 * time.Millisecond)
+
+	// 5+10+20+40+80+160ms covers 300ms, so only a handful of retries fit
+	if n := listener.accepts.Load(); n < 2 || n > 10 {
+		t.Errorf("Expected a few backed-off accepts, got %d", n)
+	}
+
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Accept loop did not stop while backing off")
+	}
+}
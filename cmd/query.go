@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// captureQuery is a parsed query_captures statement:
+//
+//	select [where <condition>] [order by <field> [asc|desc]] [limit <n>]
+//
+// Conditions compare fields with =, !=, <, <=, >, >= or ~ (contains) and
+// combine with and, or, not and parentheses.
+type captureQuery struct {
+	where     func(*CapturedPacket) bool // nil matches everything
+	orderBy   string
+	orderDesc bool
+	limit     int // 0 = no limit
+}
+
+// queryFieldKind is the type of a queryable field
+type queryFieldKind int
+
+const (
+	queryNumber queryFieldKind = iota
+	queryString
+	queryBool
+)
+
+// queryField describes a queryable CapturedPacket field
+type queryField struct {
+	kind   queryFieldKind
+	number func(*CapturedPacket) float64
+	text   func(*CapturedPacket) string
+	flag   func(*CapturedPacket) bool
+}
+
+// queryFields maps field names (and aliases) to their accessors
+var queryFields = map[string]queryField{
+	"seq":           {kind: queryNumber, number: func(c *CapturedPacket) float64 { return float64(c.Seq) }},
+	"conn_id":       {kind: queryNumber, number: func(c *CapturedPacket) float64 { return float64(c.ConnID) }},
+	"bytes":         {kind: queryNumber, number: func(c *CapturedPacket) float64 { return float64(c.Bytes) }},
+	"offset":        {kind: queryNumber, number: func(c *CapturedPacket) float64 { return float64(c.StreamOffset) }},
+	"stream_offset": {kind: queryNumber, number: func(c *CapturedPacket) float64 { return float64(c.StreamOffset) }},
+	"dir":           {kind: queryString, text: func(c *CapturedPacket) string { return c.Direction }},
+	"direction":     {kind: queryString, text: func(c *CapturedPacket) string { return c.Direction }},
+	"proto":         {kind: queryString, text: func(c *CapturedPacket) string { return c.DetectedProtocol }},
+	"protocol":      {kind: queryString, text: func(c *CapturedPacket) string { return c.DetectedProtocol }},
+	"hash":          {kind: queryString, text: func(c *CapturedPacket) string { return c.Hash }},
+	"ascii":         {kind: queryString, text: func(c *CapturedPacket) string { return strings.Join(c.AsciiStrings(), " ") }},
+	"injected":      {kind: queryBool, flag: func(c *CapturedPacket) bool { return c.Injected }},
+	"truncated":     {kind: queryBool, flag: func(c *CapturedPacket) bool { return c.Truncated }},
+	"retry":         {kind: queryBool, flag: func(c *CapturedPacket) bool { return c.PossibleRetry }},
+}
+
+// queryDirectionAliases lets dir comparisons use short names
+var queryDirectionAliases = map[string]string{
+	"client": DirectionClientToServer,
+	"server": DirectionServerToClient,
+}
+
+// queryOperators is the set of comparison operators
+var queryOperators = map[string]bool{
+	"=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "~": true,
+}
+
+// queryToken is a lexical token of a query
+type queryToken struct {
+	text   string
+	quoted bool // String literal
+	pos    int  // Byte offset in the query, for error messages
+}
+
+// tokenizeQuery splits a query into words, operators, parentheses and
+// single-quoted strings
+func tokenizeQuery(query string) ([]queryToken, error) {
+	var tokens []queryToken
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '\'':
+			end := strings.IndexByte(query[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, queryToken{text: query[i+1 : i+1+end], quoted: true, pos: i})
+			i += end + 2
+		case c == '(' || c == ')' || c == '~':
+			tokens = append(tokens, queryToken{text: string(c), pos: i})
+			i++
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			op := string(c)
+			if i+1 < len(query) && query[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected '!' at position %d", i)
+			}
+			tokens = append(tokens, queryToken{text: op, pos: i})
+			i += len(op)
+		default:
+			start := i
+			for i < len(query) && !unicode.IsSpace(rune(query[i])) && !strings.ContainsRune("()'~=!<>", rune(query[i])) {
+				i++
+			}
+			tokens = append(tokens, queryToken{text: query[start:i], pos: start})
+		}
+	}
+	return tokens, nil
+}
+
+// queryParser is a recursive descent parser over query tokens
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+	length int // Length of the query, for errors at the end
+}
+
+// parseCaptureQuery parses a query_captures statement
+func parseCaptureQuery(query string) (*captureQuery, error) {
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens, length: len(query)}
+
+	if !p.keyword("select") {
+		return nil, p.errorf("expected 'select'")
+	}
+	q := &captureQuery{}
+
+	if p.keyword("where") {
+		if q.where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.keyword("order") {
+		if !p.keyword("by") {
+			return nil, p.errorf("expected 'by' after 'order'")
+		}
+		field, ok := p.next()
+		if !ok || field.quoted {
+			return nil, p.errorf("expected field name after 'order by'")
+		}
+		if _, exists := queryFields[strings.ToLower(field.text)]; !exists {
+			return nil, fmt.Errorf("unknown field %q at position %d", field.text, field.pos)
+		}
+		q.orderBy = strings.ToLower(field.text)
+		if p.keyword("desc") {
+			q.orderDesc = true
+		} else {
+			p.keyword("asc")
+		}
+	}
+
+	if p.keyword("limit") {
+		token, ok := p.next()
+		n, err := strconv.Atoi(token.text)
+		if !ok || token.quoted || err != nil || n < 0 {
+			return nil, p.errorf("expected a non-negative number after 'limit'")
+		}
+		q.limit = n
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return q, nil
+}
+
+// next consumes and returns the next token
+func (p *queryParser) next() (queryToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return queryToken{}, false
+	}
+	p.pos++
+	return p.tokens[p.pos-1], true
+}
+
+// keyword consumes the next token if it is the given unquoted keyword
+func (p *queryParser) keyword(word string) bool {
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// errorf reports a parse error at the current token
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	pos := p.length
+	if p.pos < len(p.tokens) {
+		pos = p.tokens[p.pos].pos
+	}
+	return fmt.Errorf("parse error at position %d: %s", pos, fmt.Sprintf(format, args...))
+}
+
+// parseOr parses: and_expr (or and_expr)*
+func (p *queryParser) parseOr() (func(*CapturedPacket) bool, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(c *CapturedPacket) bool { return l(c) || right(c) }
+	}
+	return left, nil
+}
+
+// parseAnd parses: unary (and unary)*
+func (p *queryParser) parseAnd() (func(*CapturedPacket) bool, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(c *CapturedPacket) bool { return l(c) && right(c) }
+	}
+	return left, nil
+}
+
+// parseUnary parses: not unary | ( or_expr ) | comparison
+func (p *queryParser) parseUnary() (func(*CapturedPacket) bool, error) {
+	if p.keyword("not") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(c *CapturedPacket) bool { return !inner(c) }, nil
+	}
+
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && p.tokens[p.pos].text == "(" {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if token, ok := p.next(); !ok || token.quoted || token.text != ")" {
+			p.pos--
+			return nil, p.errorf("expected ')'")
+		}
+		return inner, nil
+	}
+
+	return p.parseComparison()
+}
+
+// parseComparison parses: field op value
+func (p *queryParser) parseComparison() (func(*CapturedPacket) bool, error) {
+	name, ok := p.next()
+	if !ok || name.quoted {
+		p.pos--
+		return nil, p.errorf("expected field name")
+	}
+	field, exists := queryFields[strings.ToLower(name.text)]
+	if !exists {
+		return nil, fmt.Errorf("unknown field %q at position %d", name.text, name.pos)
+	}
+
+	op, ok := p.next()
+	if !ok || op.quoted || !queryOperators[op.text] {
+		p.pos--
+		return nil, p.errorf("expected comparison operator after %q", name.text)
+	}
+
+	value, ok := p.next()
+	if !ok {
+		return nil, p.errorf("expected value after %q", op.text)
+	}
+
+	switch field.kind {
+	case queryNumber:
+		n, err := strconv.ParseFloat(value.text, 64)
+		if value.quoted || err != nil {
+			return nil, fmt.Errorf("field %q needs a number at position %d", name.text, value.pos)
+		}
+		if op.text == "~" {
+			return nil, fmt.Errorf("operator '~' is only valid for text fields (position %d)", op.pos)
+		}
+		return func(c *CapturedPacket) bool { return compareQueryValues(op.text, field.number(c), n) }, nil
+
+	case queryBool:
+		b, err := strconv.ParseBool(value.text)
+		if value.quoted || err != nil || (op.text != "=" && op.text != "!=") {
+			return nil, fmt.Errorf("field %q only supports = or != with true/false (position %d)", name.text, value.pos)
+		}
+		return func(c *CapturedPacket) bool { return (field.flag(c) == b) == (op.text == "=") }, nil
+
+	default:
+		want := value.text
+		if key := strings.ToLower(name.text); key == "dir" || key == "direction" {
+			if alias, ok := queryDirectionAliases[strings.ToLower(want)]; ok {
+				want = alias
+			}
+		}
+		if op.text == "~" {
+			return func(c *CapturedPacket) bool { return strings.Contains(field.text(c), want) }, nil
+		}
+		return func(c *CapturedPacket) bool { return compareQueryValues(op.text, field.text(c), want) }, nil
+	}
+}
+
+// compareQueryValues applies a comparison operator
+func compareQueryValues[T float64 | string](op string, a, b T) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	default:
+		return a >= b
+	}
+}
+
+// run evaluates the query over captures
+func (q *captureQuery) run(captures []*CapturedPacket) []*CapturedPacket {
+	var matched []*CapturedPacket
+	for _, capture := range captures {
+		if q.where == nil || q.where(capture) {
+			matched = append(matched, capture)
+		}
+	}
+
+	if q.orderBy != "" {
+		field := queryFields[q.orderBy]
+		less := func(a, b *CapturedPacket) bool {
+			switch field.kind {
+			case queryNumber:
+				return field.number(a) < field.number(b)
+			case queryBool:
+				return !field.flag(a) && field.flag(b)
+			default:
+				return field.text(a) < field.text(b)
+			}
+		}
+		sort.SliceStable(matched, func(i, j int) bool {
+			if q.orderDesc {
+				return less(matched[j], matched[i])
+			}
+			return less(matched[i], matched[j])
+		})
+	}
+
+	if q.limit > 0 && q.limit < len(matched) {
+		matched = matched[:q.limit]
+	}
+	return matched
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestQueryCaptures tests filtering, ordering and limiting captures with
+// query_captures
+func TestQueryCaptures(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19129, "localhost", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19129)
+
+	proxy, _ := manager.GetProxy(19129)
+	conn := proxy.newConnection(nil, nil)
+	proxy.captureData(conn, []byte("GET /small HTTP/1.1\r\n\r\n"), DirectionClientToServer)
+	proxy.captureData(conn, []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"), DirectionServerToClient)
+	proxy.captureData(conn, []byte("POST /a-much-longer-request-path HTTP/1.1\r\n\r\n"), DirectionClientToServer)
+	binary := proxy.newConnection(nil, nil)
+	proxy.captureData(binary, []byte{0x00, 0x01, 0x02, 0x03}, DirectionClientToServer)
+
+	query := func(q string) map[string]interface{} {
+		return callTool(t, NewQueryCapturesHandler(manager).Execute, map[string]interface{}{
+			"listen_port": float64(19129),
+			"query":       q,
+		})
+	}
+	seqs := func(result map[string]interface{}) []float64 {
+		var seqs []float64
+		captures, _ := result["captures"].([]interface{})
+		for _, capture := range captures {
+			seqs = append(seqs, capture.(map[string]interface{})["seq"].(float64))
+		}
+		return seqs
+	}
+	captures := proxy.Buffer.GetAll()
+	seqOf := func(i int) float64 { return float64(captures[i].Seq) }
+
+	tests := []struct {
+		query string
+		want  []float64
+	}{
+		{"select", []float64{seqOf(0), seqOf(1), seqOf(2), seqOf(3)}},
+		{"select where proto='HTTP/1.x' and dir='client'", []float64{seqOf(0), seqOf(2)}},
+		{"SELECT WHERE dir = 'server' or bytes < 5", []float64{seqOf(1), seqOf(3)}},
+		{"select where not (proto='HTTP/1.x') ", []float64{seqOf(3)}},
+		{"select where ascii ~ 'POST'", []float64{seqOf(2)}},
+		{"select where injected = false and bytes >= 20 order by bytes desc", []float64{seqOf(2), seqOf(1), seqOf(0)}},
+		{"select order by bytes asc limit 2", []float64{seqOf(3), seqOf(0)}},
+		{"select where proto='HTTP/1.x' order by bytes desc limit 1", []float64{seqOf(2)}},
+		{"select where stream_offset > 0", []float64{seqOf(2)}},
+		{"select where dir='client' order by offset desc limit 1", []float64{seqOf(2)}},
+	}
+	for _, tt := range tests {
+		result := query(tt.query)
+		if errMsg, ok := result["error"]; ok {
+			t.Errorf("%q: unexpected error: %v", tt.query, errMsg)
+			continue
+		}
+		got := seqs(result)
+		if len(got) != len(tt.want) || result["matches"] != float64(len(tt.want)) {
+			t.Errorf("%q: expected seqs %v, got %v", tt.query, tt.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q: expected seqs %v, got %v", tt.query, tt.want, got)
+				break
+			}
+		}
+	}
+
+	// Querying must not clear the buffer
+	if remaining := len(proxy.Buffer.GetAll()); remaining != 4 {
+		t.Errorf("Expected 4 captures to remain after querying, got %d", remaining)
+	}
+
+	// Invalid queries report where parsing failed
+	invalid := []struct {
+		query string
+		want  string
+	}{
+		{"where bytes > 5", "expected 'select'"},
+		{"select where size > 5", "unknown field \"size\""},
+		{"select where bytes >", "expected value"},
+		{"select where bytes > 'big'", "needs a number"},
+		{"select where proto = 'HTTP", "unterminated string"},
+		{"select where (bytes > 5", "expected ')'"},
+		{"select limit -1", "non-negative number"},
+		{"select where bytes > 5 extra", "unexpected \"extra\""},
+	}
+	for _, tt := range invalid {
+		result := query(tt.query)
+		errMsg, _ := result["error"].(string)
+		if !strings.Contains(errMsg, tt.want) {
+			t.Errorf("%q: expected error containing %q, got %q", tt.query, tt.want, errMsg)
+		}
+	}
+}
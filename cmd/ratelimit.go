@@ -0,0 +1,47 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// captureRateLimiter is a token bucket allowing up to rate captures per
+// second, with bursts of up to one second's worth
+type captureRateLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+// newCaptureRateLimiter returns a full bucket, or nil when perSec is not positive
+func newCaptureRateLimiter(perSec int) *captureRateLimiter {
+	if perSec <= 0 {
+		return nil
+	}
+	return &captureRateLimiter{rate: float64(perSec), tokens: float64(perSec), last: time.Now()}
+}
+
+// reset refills the bucket, starting a fresh rate window
+func (l *captureRateLimiter) reset(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = l.rate
+	l.last = now
+}
+
+// allow takes a token if one is available
+func (l *captureRateLimiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elapsed := now.Sub(l.last).Seconds(); elapsed > 0 {
+		l.tokens = min(l.rate, l.tokens+elapsed*l.rate)
+		l.last = now
+	}
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// redactTailBytes is how much of the previous read is searched again with
+// the next one, so pattern matches that straddle reads are still masked
+const redactTailBytes = 256
+
+// sensitiveHeaderPattern matches HTTP header lines carrying credentials;
+// submatch 1 is the header value
+var sensitiveHeaderPattern = regexp.MustCompile(`(?im)^(?:authorization|proxy-authorization|cookie|set-cookie)[ \t]*:[ \t]*([^\r\n]*)`)
+
+// redactor masks sensitive data in stored captures. Masking replaces each
+// byte with '*' so payload sizes and offsets are preserved.
+type redactor struct {
+	headers  bool             // Mask credential-bearing HTTP header values
+	patterns []*regexp.Regexp // User-supplied patterns to mask
+}
+
+// newRedactor compiles the redaction settings, returning nil if nothing is
+// to be redacted
+func newRedactor(headers bool, patterns []string) (*redactor, error) {
+	if !headers && len(patterns) == 0 {
+		return nil, nil
+	}
+
+	r := &redactor{headers: headers}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %v", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// redact returns data with sensitive parts masked. data itself is never
+// modified since it is also the buffer being forwarded.
+func (r *redactor) redact(data []byte) []byte {
+	var masked []byte
+	mask := func(start, end int) {
+		if masked == nil {
+			masked = append([]byte(nil), data...)
+		}
+		for i := start; i < end; i++ {
+			masked[i] = '*'
+		}
+	}
+
+	if r.headers {
+		for _, match := range sensitiveHeaderPattern.FindAllSubmatchIndex(data, -1) {
+			mask(match[2], match[3])
+		}
+	}
+	for _, re := range r.patterns {
+		for _, match := range re.FindAllIndex(data, -1) {
+			mask(match[0], match[1])
+		}
+	}
+
+	if masked == nil {
+		return data
+	}
+	return masked
+}
+
+// redactStream carries one direction's redaction state across reads
+type redactStream struct {
+	tail     []byte // Last bytes of the previous read
+	inHeader bool   // The previous read ended inside a sensitive header value
+}
+
+// redactStream masks data as the next read of stream s. Matches are searched
+// over the end of the previous read together with data, so the part of a
+// match that falls in data is masked even when it started a read earlier.
+func (r *redactor) redactStream(s *redactStream, data []byte) []byte {
+	offset := len(s.tail)
+	window := append(append([]byte(nil), s.tail...), data...)
+
+	var masked []byte
+	mask := func(start, end int) {
+		start, end = max(start-offset, 0), end-offset
+		if end <= start {
+			return
+		}
+		if masked == nil {
+			masked = append([]byte(nil), data...)
+		}
+		for i := start; i < end; i++ {
+			masked[i] = '*'
+		}
+	}
+
+	if r.headers {
+		// A header value cut off by the previous read continues to the end
+		// of its line
+		continued := s.inHeader
+		s.inHeader = false
+		if continued {
+			end := bytes.IndexAny(data, "\r\n")
+			if end < 0 {
+				end = len(data)
+				s.inHeader = true
+			}
+			mask(offset, offset+end)
+		}
+		for _, match := range sensitiveHeaderPattern.FindAllSubmatchIndex(window, -1) {
+			mask(match[2], match[3])
+			if match[3] == len(window) {
+				s.inHeader = true
+			}
+		}
+	}
+	for _, re := range r.patterns {
+		for _, match := range re.FindAllIndex(window, -1) {
+			mask(match[0], match[1])
+		}
+	}
+
+	s.tail = append(s.tail[:0], window[max(len(window)-redactTailBytes, 0):]...)
+	if masked == nil {
+		return data
+	}
+	return masked
+}
+
+// redactHTTP2Headers masks decoded HTTP/2 header values in place, and
+// returns data with the HPACK bytes of the header blocks they came from
+// masked too, since those still hold the secrets. Bytes of a block that
+// continues past the read are masked as well, as the rest of it can't be
+// checked yet.
+func (r *redactor) redactHTTP2Headers(frames []HTTP2FrameSummary, fragments []http2Fragment, data []byte) []byte {
+	redacted := make(map[uint64]bool)
+	for i := range frames {
+		for j := range frames[i].Headers {
+			field := &frames[i].Headers[j]
+			value := field.Value
+			switch field.Name {
+			case "authorization", "proxy-authorization", "cookie", "set-cookie":
+				if r.headers {
+					field.Value = strings.Repeat("*", len(field.Value))
+					break
+				}
+				fallthrough
+			default:
+				field.Value = string(r.redact([]byte(field.Value)))
+			}
+			if field.Value != value {
+				redacted[frames[i].block] = true
+			}
+		}
+	}
+
+	var masked []byte
+	for _, fragment := range fragments {
+		if !fragment.open && !redacted[fragment.block] {
+			continue
+		}
+		if masked == nil {
+			masked = append([]byte(nil), data...)
+		}
+		for i := fragment.start; i < fragment.end; i++ {
+			masked[i] = '*'
+		}
+	}
+	if masked == nil {
+		return data
+	}
+	return masked
+}
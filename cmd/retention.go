@@ -0,0 +1,85 @@
+package main
+
+import (
+	"time"
+)
+
+// retentionSweepInterval is how often captures past retain_seconds are
+// evicted while no new packets arrive
+const retentionSweepInterval = time.Second
+
+// SetRetention makes the buffer evict packets older than window. Zero keeps
+// packets until byte pressure evicts them.
+func (rb *RingBuffer) SetRetention(window time.Duration) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.retain = window
+}
+
+// Expire evicts every packet older than the retention window as of now.
+// Coalesced and held captures are stored after newer ones, so the whole
+// buffer is scanned.
+func (rb *RingBuffer) Expire(now time.Time) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if rb.retain <= 0 {
+		return
+	}
+
+	before := rb.currentSize
+	cutoff := now.Add(-rb.retain)
+	kept := 0
+	for i := 0; i < rb.count; i++ {
+		packet := rb.at(i)
+		if packet.Timestamp.Before(cutoff) {
+			rb.currentSize -= len(packet.RawData)
+			continue
+		}
+		rb.data[(rb.tail+kept)%len(rb.data)] = packet
+		kept++
+	}
+	for i := kept; i < rb.count; i++ {
+		rb.data[(rb.tail+i)%len(rb.data)] = nil
+	}
+	rb.count = kept
+	rb.head = (rb.tail + kept) % len(rb.data)
+	if rb.budget != nil {
+		rb.budget.release(before - rb.currentSize)
+	}
+}
+
+// expireLocked evicts the oldest packets while they are outside the window
+// as of now, returning their bytes to the global budget. It stops at the
+// first packet inside the window, so it is cheap enough for every Add;
+// packets stored out of order wait for the next sweep.
+// IMPORTANT: This assumes the mutex is already held by the caller
+func (rb *RingBuffer) expireLocked(now time.Time) {
+	if rb.retain <= 0 {
+		return
+	}
+	before := rb.currentSize
+	cutoff := now.Add(-rb.retain)
+	for rb.count > 0 && rb.data[rb.tail].Timestamp.Before(cutoff) {
+		rb.evictOldest()
+	}
+	if rb.budget != nil {
+		rb.budget.release(before - rb.currentSize)
+	}
+}
+
+// expireCapturesPeriodically sweeps the buffer so captures leave the
+// retention window even when traffic stops
+func (p *ProxyInstance) expireCapturesPeriodically() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(retentionSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.Buffer.Expire(now)
+		}
+	}
+}
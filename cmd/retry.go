@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"sync"
+	"time"
+)
+
+// retryWindow is how soon a byte-identical request must follow the one it
+// repeats to be flagged possible_retry
+const retryWindow = 2 * time.Second
+
+// retryRequest is one request a direction sent: everything it sent before
+// the other direction replied
+type retryRequest struct {
+	sum  []byte // SHA-256 of the whole request
+	size int
+	last time.Time // Arrival of the request's latest read
+}
+
+// retryDetector compares each request a connection sends with the previous
+// request of the same direction. Requests are compared whole rather than
+// per read, so identical reads within one request, such as heartbeats sent
+// without a reply or chunks of a bulk transfer, are not flagged.
+type retryDetector struct {
+	direction string    // Direction of the request in progress
+	running   hash.Hash // SHA-256 of the request in progress so far
+	current   retryRequest
+	previous  map[string]retryRequest // Latest finished request of each direction
+	mu        sync.Mutex
+}
+
+// observe adds a read to the request in progress, starting a new request
+// when the direction changes, and reports whether the read completes a
+// byte-identical copy of the direction's previous request sent within
+// retryWindow
+func (d *retryDetector) observe(direction string, data []byte, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if direction != d.direction {
+		if d.running != nil {
+			if d.previous == nil {
+				d.previous = make(map[string]retryRequest)
+			}
+			d.current.sum = d.running.Sum(nil)
+			d.previous[d.direction] = d.current
+		}
+		d.direction = direction
+		d.running = sha256.New()
+		d.current = retryRequest{}
+	}
+	d.running.Write(data)
+	d.current.size += len(data)
+	d.current.last = now
+
+	previous, exists := d.previous[direction]
+	return exists && d.current.size == previous.size && now.Sub(previous.last) <= retryWindow &&
+		bytes.Equal(d.running.Sum(nil), previous.sum)
+}
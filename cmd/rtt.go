@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// defaultKeepAlivePeriod is Go's keepalive period when tcp_keepalive_ms is unset
+const defaultKeepAlivePeriod = 15 * time.Second
+
+// sampleBackendRTT records the kernel's current RTT estimate of the backend
+// connection. It does nothing where the estimate is unavailable.
+func (c *Connection) sampleBackendRTT() {
+	if c.ServerConn == nil {
+		return
+	}
+	if rtt, ok := tcpRTT(c.ServerConn); ok {
+		c.backendRTT.Store(int64(rtt / time.Microsecond))
+		c.rttSampled.Store(true)
+	}
+}
+
+// sampleRTTPeriodically samples the backend RTT once per keepalive period,
+// so closed connections keep a recent value. Keepalive probes keep the
+// estimate fresh while the connection is idle.
+func (p *ProxyInstance) sampleRTTPeriodically(ctx context.Context, conn *Connection) {
+	period := p.Options.TCPKeepAlive
+	if period == 0 {
+		period = defaultKeepAlivePeriod
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	conn.sampleBackendRTT()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			conn.sampleBackendRTT()
+		}
+	}
+}
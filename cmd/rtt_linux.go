@@ -0,0 +1,34 @@
+//go:build linux
+
+package main
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// tcpRTT reads the smoothed RTT of a TCP connection from TCP_INFO
+func tcpRTT(conn net.Conn) (time.Duration, bool) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return 0, false
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+
+	var info syscall.TCPInfo
+	size := uint32(unsafe.Sizeof(info))
+	var errno syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil || errno != 0 {
+		return 0, false
+	}
+	return time.Duration(info.Rtt) * time.Microsecond, true
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"net"
+	"time"
+)
+
+// tcpRTT is unavailable without TCP_INFO, so backend_rtt_ms is omitted
+func tcpRTT(conn net.Conn) (time.Duration, bool) {
+	return 0, false
+}
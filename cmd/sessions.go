@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sessionMetadata is written next to the stream files of each exported session
+type sessionMetadata struct {
+	ConnID              uint64 `json:"conn_id"`
+	ListenPort          int    `json:"listen_port"`
+	ClientAddr          string `json:"client_addr"`
+	BackendAddr         string `json:"backend_addr"`
+	OpenedAt            string `json:"opened_at,omitempty"`
+	ClosedAt            string `json:"closed_at,omitempty"` // Empty while open
+	FirstCapture        string `json:"first_capture"`
+	LastCapture         string `json:"last_capture"`
+	Packets             int    `json:"packets"`
+	ClientToServerBytes int    `json:"client_to_server_bytes"`
+	ServerToClientBytes int    `json:"server_to_client_bytes"`
+	Incomplete          bool   `json:"incomplete,omitempty"` // Some captures were truncated or not stored
+}
+
+// exportSessions writes each connection in captures to dir as a client to
+// server stream file, a server to client stream file and a metadata file,
+// and returns the paths written
+func exportSessions(proxy *ProxyInstance, captures []*CapturedPacket, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+
+	// Packet counts and capture times per connection
+	metas := make(map[uint64]*sessionMetadata)
+	for _, capture := range captures {
+		meta, exists := metas[capture.ConnID]
+		if !exists {
+			meta = &sessionMetadata{FirstCapture: formatTimestamp(capture.Timestamp)}
+			metas[capture.ConnID] = meta
+		}
+		meta.LastCapture = formatTimestamp(capture.Timestamp)
+		meta.Packets++
+		if capture.Truncated || len(capture.RawData) < capture.Bytes {
+			meta.Incomplete = true
+		}
+	}
+
+	var files []string
+	for _, streams := range reassembleStreams(captures) {
+		meta := metas[streams.connID]
+		meta.ConnID = streams.connID
+		meta.ListenPort = proxy.ListenPort
+		meta.ClientAddr = "unknown"
+		meta.BackendAddr = fmt.Sprintf("%s:%d", proxy.ForwardHost, proxy.ForwardPort)
+		if info, ok := proxy.LookupConnection(streams.connID); ok {
+			if info.ClientAddr != "" {
+				meta.ClientAddr = info.ClientAddr
+			}
+			if info.BackendAddr != "" {
+				meta.BackendAddr = info.BackendAddr
+			}
+			meta.OpenedAt = formatTimestamp(info.OpenedAt)
+			if !info.ClosedAt.IsZero() {
+				meta.ClosedAt = formatTimestamp(info.ClosedAt)
+			}
+		}
+		meta.ClientToServerBytes = len(streams.clientToServer.data)
+		meta.ServerToClientBytes = len(streams.serverToClient.data)
+
+		base := filepath.Join(dir, fmt.Sprintf("conn-%d_%s_%s", streams.connID, sanitizeFileName(meta.ClientAddr), sanitizeFileName(meta.BackendAddr)))
+		metaBytes, _ := json.MarshalIndent(meta, "", "  ")
+		for _, file := range []struct {
+			path string
+			data []byte
+		}{
+			{base + ".c2s.bin", streams.clientToServer.data},
+			{base + ".s2c.bin", streams.serverToClient.data},
+			{base + ".json", metaBytes},
+		} {
+			if err := os.WriteFile(file.path, file.data, 0644); err != nil {
+				return files, fmt.Errorf("failed to write %s: %v", file.path, err)
+			}
+			files = append(files, file.path)
+		}
+	}
+	return files, nil
+}
+
+// sanitizeFileName replaces characters that are awkward in file names, such
+// as the colons and brackets of addresses
+func sanitizeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '-'
+	}, name)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExportSessions tests that two connections are exported as two file
+// sets holding each side's reassembled stream
+func TestExportSessions(t *testing.T) {
+	echoPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19136, "127.0.0.1", echoPort, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19136)
+	proxy, _ := manager.GetProxy(19136)
+
+	// Two sessions, each sending two messages and reading the echoes
+	var clientAddrs []string
+	for i := 1; i <= 2; i++ {
+		client, err := net.Dial("tcp", "127.0.0.1:19136")
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		clientAddrs = append(clientAddrs, client.LocalAddr().String())
+		for _, part := range []string{"first part ", "second part"} {
+			msg := fmt.Sprintf("session %d %s", i, part)
+			client.Write([]byte(msg))
+			client.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if _, err := io.ReadFull(client, make([]byte, len(msg))); err != nil {
+				t.Fatalf("Failed to read echo: %v", err)
+			}
+		}
+		client.Close()
+	}
+	for deadline := time.Now().Add(2 * time.Second); proxy.GetConnectionCount() > 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	dir := t.TempDir()
+	result := callTool(t, NewExportSessionsHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19136),
+		"dir":         dir,
+	})
+	if result["sessions"] != float64(2) {
+		t.Fatalf("Expected 2 sessions, got %v", result)
+	}
+	files, _ := result["files"].([]interface{})
+	if len(files) != 6 {
+		t.Fatalf("Expected 6 files, got %v", files)
+	}
+
+	for i, clientAddr := range clientAddrs {
+		want := fmt.Sprintf("session %d first part session %d second part", i+1, i+1)
+		base := filepath.Join(dir, fmt.Sprintf("conn-%d_%s_%s", i+1, sanitizeFileName(clientAddr), sanitizeFileName(fmt.Sprintf("127.0.0.1:%d", echoPort))))
+
+		for _, suffix := range []string{".c2s.bin", ".s2c.bin"} {
+			data, err := os.ReadFile(base + suffix)
+			if err != nil {
+				t.Fatalf("Missing session file: %v", err)
+			}
+			if string(data) != want {
+				t.Errorf("%s: expected %q, got %q", suffix, want, data)
+			}
+		}
+
+		metaBytes, err := os.ReadFile(base + ".json")
+		if err != nil {
+			t.Fatalf("Missing metadata file: %v", err)
+		}
+		var meta sessionMetadata
+		if err := json.Unmarshal(metaBytes, &meta); err != nil {
+			t.Fatalf("Bad metadata: %v", err)
+		}
+		if meta.ConnID != uint64(i+1) || meta.ClientAddr != clientAddr || meta.Packets != 4 ||
+			meta.ClientToServerBytes != len(want) || meta.ServerToClientBytes != len(want) ||
+			meta.OpenedAt == "" || meta.ClosedAt == "" || meta.Incomplete {
+			t.Errorf("Unexpected metadata: %+v", meta)
+		}
+	}
+
+	// conn_id limits the export to one session
+	result = callTool(t, NewExportSessionsHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19136),
+		"dir":         t.TempDir(),
+		"conn_id":     float64(2),
+	})
+	files, _ = result["files"].([]interface{})
+	if len(files) != 3 || !strings.Contains(files[0].(string), "conn-2_") {
+		t.Errorf("Expected only connection 2's files, got %v", files)
+	}
+}
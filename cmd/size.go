@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultCaptureLimitEnv names the environment variable holding the default
+// capture_limit
+const defaultCaptureLimitEnv = "MCP_NETTOOLS_DEFAULT_CAPTURE_LIMIT"
+
+// defaultCaptureLimit is used when start_proxy is given no capture_limit
+var defaultCaptureLimit = 10 * 1024 * 1024
+
+// sizeUnits maps size suffixes to their multiplier, in powers of 1024
+var sizeUnits = map[string]int64{
+	"":   1,
+	"B":  1,
+	"KB": 1024,
+	"MB": 1024 * 1024,
+	"GB": 1024 * 1024 * 1024,
+}
+
+// parseSize parses a byte count such as "4096", "512KB" or "50MB"
+func parseSize(s string) (int, error) {
+	trimmed := strings.ToUpper(strings.TrimSpace(s))
+	split := strings.IndexFunc(trimmed, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split < 0 {
+		split = len(trimmed)
+	}
+	number, unit := trimmed[:split], strings.TrimSpace(trimmed[split:])
+
+	multiplier, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q (expected B, KB, MB or GB)", s, unit)
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: expected a number followed by B, KB, MB or GB", s)
+	}
+	bytes := value * float64(multiplier)
+	if bytes <= 0 || bytes > float64(int(^uint(0)>>1)) {
+		return 0, fmt.Errorf("invalid size %q: must be positive", s)
+	}
+	if bytes < 1 {
+		return 0, fmt.Errorf("invalid size %q: must be at least 1 byte", s)
+	}
+	return int(bytes), nil
+}
+
+// formatSize renders a byte count in the largest unit it reaches, e.g. 10MB
+// or 1.5KB
+func formatSize(bytes int) string {
+	for _, unit := range []string{"GB", "MB", "KB"} {
+		if multiplier := sizeUnits[unit]; int64(bytes) >= multiplier {
+			value := strconv.FormatFloat(float64(bytes)/float64(multiplier), 'f', 1, 64)
+			return strings.TrimSuffix(value, ".0") + unit
+		}
+	}
+	return fmt.Sprintf("%dB", bytes)
+}
+
+// loadDefaultCaptureLimit sets defaultCaptureLimit from the flag value, or
+// from the environment when the flag is empty
+func loadDefaultCaptureLimit(flagValue string) error {
+	value, source := flagValue, "--default-capture-limit"
+	if value == "" {
+		value, source = os.Getenv(defaultCaptureLimitEnv), defaultCaptureLimitEnv
+	}
+	if value == "" {
+		return nil
+	}
+	limit, err := parseSize(value)
+	if err != nil {
+		return fmt.Errorf("%s: %v", source, err)
+	}
+	defaultCaptureLimit = limit
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCaptureLimitSizes tests capture_limit given as each size unit, as a
+// number and as an invalid string
+func TestCaptureLimitSizes(t *testing.T) {
+	tests := []struct {
+		limit interface{}
+		want  int
+		human string
+	}{
+		{float64(4096), 4096, "4KB"},
+		{"100", 100, "100B"},
+		{"100B", 100, "100B"},
+		{"512KB", 512 * 1024, "512KB"},
+		{"10MB", 10 * 1024 * 1024, "10MB"},
+		{"1.5mb", 1536 * 1024, "1.5MB"},
+		{"1GB", 1024 * 1024 * 1024, "1GB"},
+		{" 2 KB ", 2048, "2KB"},
+	}
+	for _, tt := range tests {
+		cfg, err := parseProxyConfig(map[string]interface{}{
+			"listen_port":   float64(19142),
+			"forward_port":  float64(18082),
+			"capture_limit": tt.limit,
+		})
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tt.limit, err)
+			continue
+		}
+		if cfg.CaptureLimit != tt.want {
+			t.Errorf("%v: expected %d bytes, got %d", tt.limit, tt.want, cfg.CaptureLimit)
+		}
+		if human := formatSize(cfg.CaptureLimit); human != tt.human {
+			t.Errorf("%v: expected %s, got %s", tt.limit, tt.human, human)
+		}
+	}
+
+	for _, invalid := range []interface{}{"10XB", "MB", "-5MB", "ten", "0.5", "0.5B", float64(0.5)} {
+		_, err := parseProxyConfig(map[string]interface{}{
+			"listen_port":   float64(19142),
+			"forward_port":  float64(18082),
+			"capture_limit": invalid,
+		})
+		if err == nil || !strings.Contains(err.Error(), "capture_limit: invalid size") {
+			t.Errorf("%v: expected an invalid size error, got %v", invalid, err)
+		}
+	}
+}
+
+// TestDefaultCaptureLimit tests that the environment default applies when
+// capture_limit is omitted and an explicit argument overrides it
+func TestDefaultCaptureLimit(t *testing.T) {
+	defer func(limit int) { defaultCaptureLimit = limit }(defaultCaptureLimit)
+
+	t.Setenv(defaultCaptureLimitEnv, "50MB")
+	if err := loadDefaultCaptureLimit(""); err != nil {
+		t.Fatalf("Failed to load default: %v", err)
+	}
+
+	args := map[string]interface{}{
+		"listen_port":  float64(19141),
+		"forward_port": float64(18082),
+	}
+	cfg, err := parseProxyConfig(args)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if cfg.CaptureLimit != 50*1024*1024 {
+		t.Errorf("Expected the env default of 50MB, got %d", cfg.CaptureLimit)
+	}
+
+	args["capture_limit"] = float64(4096)
+	cfg, err = parseProxyConfig(args)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if cfg.CaptureLimit != 4096 {
+		t.Errorf("Expected the explicit 4096, got %d", cfg.CaptureLimit)
+	}
+
+	// The flag wins over the environment
+	if err := loadDefaultCaptureLimit("2KB"); err != nil || defaultCaptureLimit != 2048 {
+		t.Errorf("Expected the flag's 2048, got %d (%v)", defaultCaptureLimit, err)
+	}
+
+	t.Setenv(defaultCaptureLimitEnv, "lots")
+	if err := loadDefaultCaptureLimit(""); err == nil {
+		t.Error("Expected an error for an invalid size")
+	}
+}
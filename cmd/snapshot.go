@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// CaptureSnapshot is a stable copy of a proxy's buffer that readers can page
+// through while the live buffer keeps changing
+type CaptureSnapshot struct {
+	ID         string
+	ListenPort int
+	CreatedAt  time.Time
+	Captures   []*CapturedPacket
+}
+
+// maxSnapshots bounds how many snapshots are kept; taking another evicts the
+// oldest so repeated snapshots cannot grow memory without bound
+const maxSnapshots = 16
+
+// SnapshotStore holds snapshots until they are cleared or evicted by newer
+// ones. Snapshots outlive the proxy they were taken from.
+type SnapshotStore struct {
+	snapshots map[string]*CaptureSnapshot
+	order     []string // Snapshot IDs, oldest first
+	nextID    uint64
+	mu        sync.RWMutex
+}
+
+// NewSnapshotStore creates an empty snapshot store
+func NewSnapshotStore() *SnapshotStore {
+	return &SnapshotStore{snapshots: make(map[string]*CaptureSnapshot)}
+}
+
+// Take snapshots the proxy's buffer, clearing it in the same step if clear
+// is set. The oldest snapshot is evicted once maxSnapshots are held.
+func (s *SnapshotStore) Take(proxy *ProxyInstance, clear bool) *CaptureSnapshot {
+	var captures []*CapturedPacket
+	if clear {
+		captures = proxy.Buffer.Drain()
+	} else {
+		captures = proxy.Buffer.GetAll()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	snapshot := &CaptureSnapshot{
+		ID:         fmt.Sprintf("snap-%d", s.nextID),
+		ListenPort: proxy.ListenPort,
+		CreatedAt:  time.Now(),
+		Captures:   captures,
+	}
+	s.snapshots[snapshot.ID] = snapshot
+	s.order = append(s.order, snapshot.ID)
+	for len(s.order) > maxSnapshots {
+		delete(s.snapshots, s.order[0])
+		s.order = s.order[1:]
+	}
+	return snapshot
+}
+
+// Get returns a snapshot by ID
+func (s *SnapshotStore) Get(id string) (*CaptureSnapshot, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot, exists := s.snapshots[id]
+	return snapshot, exists
+}
+
+// Remove releases a snapshot and returns how many captures it held
+func (s *SnapshotStore) Remove(id string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot, exists := s.snapshots[id]
+	if !exists {
+		return 0, fmt.Errorf("no snapshot with id %q", id)
+	}
+	delete(s.snapshots, id)
+	s.order = slices.DeleteFunc(s.order, func(other string) bool { return other == id })
+	return len(snapshot.Captures), nil
+}
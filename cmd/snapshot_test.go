@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// TestSnapshotCaptures tests that concurrent readers of one snapshot get
+// identical, complete data while the live buffer keeps changing
+func TestSnapshotCaptures(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19131, "localhost", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19131)
+
+	proxy, _ := manager.GetProxy(19131)
+	conn := proxy.newConnection(nil, nil)
+	for i := 0; i < 20; i++ {
+		proxy.captureData(conn, []byte(fmt.Sprintf("packet %02d", i)), DirectionClientToServer)
+	}
+
+	snapshots := NewSnapshotStore()
+	taken := callTool(t, NewSnapshotCapturesHandler(manager, snapshots).Execute, map[string]interface{}{
+		"listen_port":  float64(19131),
+		"clear_buffer": true,
+	})
+	id, _ := taken["snapshot_id"].(string)
+	if id == "" {
+		t.Fatalf("Expected a snapshot_id, got %v", taken)
+	}
+	if taken["total_captures"] != float64(20) {
+		t.Fatalf("Expected 20 captures in the snapshot, got %v", taken["total_captures"])
+	}
+	if remaining := len(proxy.Buffer.GetAll()); remaining != 0 {
+		t.Errorf("Expected the live buffer to be cleared, got %d captures", remaining)
+	}
+
+	// New traffic must not show up in the snapshot
+	proxy.captureData(conn, []byte("after the snapshot"), DirectionClientToServer)
+
+	var wg sync.WaitGroup
+	results := make([]map[string]interface{}, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = callTool(t, NewSnapshotCapturesHandler(manager, snapshots).Execute, map[string]interface{}{
+				"snapshot_id": id,
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		captures, _ := result["captures"].([]interface{})
+		if len(captures) != 20 {
+			t.Fatalf("Reader %d: expected 20 captures, got %d", i, len(captures))
+		}
+	}
+	if !reflect.DeepEqual(results[0]["captures"], results[1]["captures"]) {
+		t.Error("Concurrent readers got different snapshot data")
+	}
+
+	// Pagination works on the snapshot
+	page := callTool(t, NewSnapshotCapturesHandler(manager, snapshots).Execute, map[string]interface{}{
+		"snapshot_id": id,
+		"offset":      float64(15),
+		"limit":       float64(10),
+	})
+	if captures, _ := page["captures"].([]interface{}); len(captures) != 5 {
+		t.Errorf("Expected 5 captures after offset 15, got %d", len(captures))
+	}
+
+	// Clearing releases the snapshot
+	cleared := callTool(t, NewClearSnapshotHandler(snapshots).Execute, map[string]interface{}{
+		"snapshot_id": id,
+	})
+	if cleared["released_captures"] != float64(20) {
+		t.Errorf("Expected 20 released captures, got %v", cleared["released_captures"])
+	}
+	missing := callTool(t, NewSnapshotCapturesHandler(manager, snapshots).Execute, map[string]interface{}{
+		"snapshot_id": id,
+	})
+	if _, ok := missing["error"]; !ok {
+		t.Errorf("Expected an error reading a cleared snapshot, got %v", missing)
+	}
+}
+
+// TestSnapshotLimit tests that taking more than maxSnapshots snapshots
+// evicts the oldest ones
+func TestSnapshotLimit(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19220, "localhost", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19220)
+	proxy, _ := manager.GetProxy(19220)
+
+	snapshots := NewSnapshotStore()
+	first := snapshots.Take(proxy, false)
+	var last *CaptureSnapshot
+	for i := 0; i < maxSnapshots; i++ {
+		last = snapshots.Take(proxy, false)
+	}
+
+	if _, exists := snapshots.Get(first.ID); exists {
+		t.Error("Expected the oldest snapshot to be evicted")
+	}
+	if _, exists := snapshots.Get(last.ID); !exists {
+		t.Error("Expected the newest snapshot to be kept")
+	}
+	if len(snapshots.snapshots) != maxSnapshots || len(snapshots.order) != maxSnapshots {
+		t.Errorf("Expected %d snapshots held, got %d", maxSnapshots, len(snapshots.snapshots))
+	}
+	if _, err := snapshots.Remove(last.ID); err != nil || len(snapshots.order) != maxSnapshots-1 {
+		t.Errorf("Expected removal to update the order, got %v with %d left", err, len(snapshots.order))
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// acceptHealth tracks the accept loop of a proxy for get_socket_info
+type acceptHealth struct {
+	accepts   int64
+	errors    int64
+	last      time.Time // Time of the last accepted connection or UDP session
+	lastError string
+	mu        sync.Mutex
+}
+
+// recordAccept counts an accept, or a failed one when err is set
+func (h *acceptHealth) recordAccept(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.errors++
+		h.lastError = err.Error()
+		return
+	}
+	h.accepts++
+	h.last = time.Now()
+}
+
+// info returns the accept loop state as reported by get_socket_info
+func (h *acceptHealth) info() map[string]interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	info := map[string]interface{}{
+		"accepts":       h.accepts,
+		"accept_errors": h.errors,
+		"last_accept":   nil,
+	}
+	if !h.last.IsZero() {
+		info["last_accept"] = formatTimestamp(h.last)
+	}
+	if h.lastError != "" {
+		info["last_accept_error"] = h.lastError
+	}
+	return info
+}
+
+// listeningSocket returns the proxy's listening socket and its bound address
+func (p *ProxyInstance) listeningSocket() (interface{}, net.Addr) {
+	if p.PacketConn != nil {
+		return p.PacketConn, p.PacketConn.LocalAddr()
+	}
+	return p.Listener, p.Listener.Addr()
+}
+
+// supportsDeadlines reports whether the listening socket accepts deadlines
+func supportsDeadlines(socket interface{}) bool {
+	_, ok := socket.(interface{ SetDeadline(time.Time) error })
+	return ok
+}
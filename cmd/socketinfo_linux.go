@@ -0,0 +1,39 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+)
+
+// socketOptions reads the kernel's socket options of a listening socket
+func socketOptions(socket interface{}) (map[string]interface{}, bool) {
+	sc, ok := socket.(syscall.Conn)
+	if !ok {
+		return nil, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, false
+	}
+
+	var reuseAddr, rcvBuf, sndBuf int
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if reuseAddr, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR); sockErr != nil {
+			return
+		}
+		if rcvBuf, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF); sockErr != nil {
+			return
+		}
+		sndBuf, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	if err != nil || sockErr != nil {
+		return nil, false
+	}
+	return map[string]interface{}{
+		"reuseaddr":            reuseAddr != 0,
+		"receive_buffer_bytes": rcvBuf,
+		"send_buffer_bytes":    sndBuf,
+	}, true
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// socketOptions is unavailable off Linux, so socket_options is omitted
+func socketOptions(socket interface{}) (map[string]interface{}, bool) {
+	return nil, false
+}
@@ -0,0 +1,162 @@
+//go:build linux
+
+package main
+
+import (
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// socketOption reads an integer socket option from a TCP connection
+func socketOption(t *testing.T, conn net.Conn, level, opt int) int {
+	t.Helper()
+
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn failed: %v", err)
+	}
+	var value int
+	var sockErr error
+	raw.Control(func(fd uintptr) {
+		value, sockErr = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	if sockErr != nil {
+		t.Fatalf("getsockopt failed: %v", sockErr)
+	}
+	return value
+}
+
+// TestTCPKeepAlive tests that tcp_keepalive_ms is applied to both sides of a
+// proxied connection
+func TestTCPKeepAlive(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	handler := NewStartProxyHandler(manager)
+	response := callTool(t, handler.Execute, map[string]interface{}{
+		"listen_port":      float64(19119),
+		"forward_host":     "127.0.0.1",
+		"forward_port":     float64(backendPort),
+		"tcp_keepalive_ms": float64(7000),
+	})
+	if response["error"] != nil {
+		t.Fatalf("Failed to start proxy: %v", response)
+	}
+	defer manager.StopProxy(19119)
+
+	client, err := net.Dial("tcp", "127.0.0.1:19119")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+
+	proxy, _ := manager.GetProxy(19119)
+	conn := waitForConnection(t, proxy)
+	for name, side := range map[string]net.Conn{"client": conn.ClientConn, "backend": conn.ServerConn} {
+		if socketOption(t, side, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE) == 0 {
+			t.Errorf("Expected keepalive enabled on %s connection", name)
+		}
+		if idle := socketOption(t, side, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE); idle != 7 {
+			t.Errorf("Expected %s keepalive idle of 7s, got %ds", name, idle)
+		}
+	}
+
+	if _, err := parseProxyConfig(map[string]interface{}{
+		"listen_port":      float64(19119),
+		"forward_host":     "127.0.0.1",
+		"forward_port":     float64(backendPort),
+		"tcp_keepalive_ms": float64(-1),
+	}); err == nil {
+		t.Error("Expected a negative tcp_keepalive_ms to be rejected")
+	}
+}
+
+// TestTCPNoDelay tests that tcp_nodelay is applied to both sides without
+// breaking forwarding
+func TestTCPNoDelay(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	response := callTool(t, NewStartProxyHandler(manager).Execute, map[string]interface{}{
+		"listen_port":  float64(19120),
+		"forward_host": "127.0.0.1",
+		"forward_port": float64(backendPort),
+		"tcp_nodelay":  false,
+	})
+	if response["error"] != nil {
+		t.Fatalf("Failed to start proxy: %v", response)
+	}
+	defer manager.StopProxy(19120)
+
+	client, err := net.Dial("tcp", "127.0.0.1:19120")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+
+	client.Write([]byte("batched"))
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(client, make([]byte, 7)); err != nil {
+		t.Fatalf("Forwarding failed with tcp_nodelay=false: %v", err)
+	}
+
+	proxy, _ := manager.GetProxy(19120)
+	conn := waitForConnection(t, proxy)
+	for name, side := range map[string]net.Conn{"client": conn.ClientConn, "backend": conn.ServerConn} {
+		if socketOption(t, side, syscall.IPPROTO_TCP, syscall.TCP_NODELAY) != 0 {
+			t.Errorf("Expected TCP_NODELAY off on %s connection", name)
+		}
+	}
+
+	listed := callTool(t, NewListProxiesHandler(manager).Execute, map[string]interface{}{})
+	if noDelay := listed["proxies"].([]interface{})[0].(map[string]interface{})["tcp_nodelay"]; noDelay != false {
+		t.Errorf("Expected list_proxies to show tcp_nodelay false, got %v", noDelay)
+	}
+}
+
+// TestBackendRTT tests that get_connections reports the backend RTT read
+// from TCP_INFO on loopback
+func TestBackendRTT(t *testing.T) {
+	backendPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	response := callTool(t, NewStartProxyHandler(manager).Execute, map[string]interface{}{
+		"listen_port":      float64(19176),
+		"forward_host":     "127.0.0.1",
+		"forward_port":     float64(backendPort),
+		"tcp_keepalive_ms": float64(1000),
+	})
+	if response["error"] != nil {
+		t.Fatalf("Failed to start proxy: %v", response)
+	}
+	defer manager.StopProxy(19176)
+
+	client, err := net.Dial("tcp", "127.0.0.1:19176")
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+	client.Write([]byte("ping"))
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+
+	listed := callTool(t, NewGetConnectionsHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19176),
+	})
+	conns := listed["connections"].([]interface{})
+	if len(conns) != 1 {
+		t.Fatalf("Expected 1 connection, got %v", listed)
+	}
+	rtt, ok := conns[0].(map[string]interface{})["backend_rtt_ms"].(float64)
+	if !ok {
+		t.Fatalf("Expected backend_rtt_ms, got %v", conns[0])
+	}
+	if rtt < 0 || rtt > 1000 {
+		t.Errorf("Expected a small non-negative loopback RTT, got %vms", rtt)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// starttlsMaxCommandBytes bounds the client packets inspected for an upgrade
+// command, so bulk traffic of other protocols is never scanned
+const starttlsMaxCommandBytes = 64
+
+// starttlsTracker follows a plaintext protocol upgrading to TLS in the middle
+// of a connection. The client asks with STARTTLS (SMTP, IMAP) or STLS (POP3);
+// once the server accepts, every later byte in both directions is TLS.
+type starttlsTracker struct {
+	requested bool   // Upgrade command sent, waiting for the server's reply
+	tag       string // IMAP command tag the reply must carry
+	upgraded  bool
+	mu        sync.Mutex
+}
+
+// observe follows the next bytes of a direction. It reports whether data was
+// sent after the upgrade, and whether data is the reply that completed it.
+func (t *starttlsTracker) observe(direction string, data []byte) (afterUpgrade, upgradedNow bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.upgraded {
+		return true, false
+	}
+
+	if direction == DirectionClientToServer {
+		if len(data) <= starttlsMaxCommandBytes && bytes.HasSuffix(data, []byte("\n")) {
+			t.requested, t.tag = parseSTARTTLSCommand(lastLine(data))
+		}
+		return false, false
+	}
+
+	if !t.requested {
+		return false, false
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\r\n"), "\n") {
+		accepted, done := t.reply(strings.TrimRight(line, "\r"))
+		if !done {
+			continue
+		}
+		t.requested = false
+		t.upgraded = accepted
+		return false, accepted
+	}
+	return false, false
+}
+
+// reply classifies one line of the server's answer to the upgrade command.
+// done is false for lines that do not answer it yet, such as SMTP
+// continuation lines and IMAP untagged responses.
+func (t *starttlsTracker) reply(line string) (accepted, done bool) {
+	if t.tag != "" {
+		status, ok := strings.CutPrefix(line, t.tag+" ")
+		if !ok {
+			return false, false
+		}
+		return strings.HasPrefix(strings.ToUpper(status), "OK"), true
+	}
+
+	switch {
+	case strings.HasPrefix(line, "+OK"):
+		return true, true
+	case strings.HasPrefix(line, "-ERR"):
+		return false, true
+	case len(line) >= 4 && line[3] == '-':
+		return false, false // SMTP multi-line reply continues
+	case len(line) >= 3:
+		return line[:3] == "220", true
+	}
+	return false, false
+}
+
+// parseSTARTTLSCommand reports whether line is an upgrade command and
+// returns its IMAP tag, if any
+func parseSTARTTLSCommand(line string) (bool, string) {
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 1 && (strings.EqualFold(fields[0], "STARTTLS") || strings.EqualFold(fields[0], "STLS")):
+		return true, ""
+	case len(fields) == 2 && strings.EqualFold(fields[1], "STARTTLS"):
+		return true, fields[0]
+	}
+	return false, ""
+}
+
+// lastLine returns the last complete line of data without its terminator
+func lastLine(data []byte) string {
+	data = bytes.TrimRight(data, "\r\n")
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	return string(data)
+}
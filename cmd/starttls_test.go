@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+// TestSTARTTLSUpgrade tests that an SMTP session is labeled TLS only after
+// the server accepts STARTTLS, and that TLS records are parsed from then on
+func TestSTARTTLSUpgrade(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19130, "localhost", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19130)
+
+	proxy, _ := manager.GetProxy(19130)
+
+	clientHello := append([]byte{1, 0, 0, 6}, make([]byte, 6)...)
+	serverHello := append([]byte{2, 0, 0, 6}, make([]byte, 6)...)
+	session := []struct {
+		direction string
+		data      []byte
+		tls       bool
+	}{
+		{DirectionServerToClient, []byte("220 mail.example.com ESMTP\r\n"), false},
+		{DirectionClientToServer, []byte("EHLO client.example.com\r\n"), false},
+		{DirectionServerToClient, []byte("250-mail.example.com\r\n250 STARTTLS\r\n"), false},
+		{DirectionClientToServer, []byte("STARTTLS\r\n"), false},
+		{DirectionServerToClient, []byte("220 2.0.0 Ready to start TLS\r\n"), false},
+		{DirectionClientToServer, tlsRecordBytes(tlsHandshake, clientHello), true},
+		{DirectionServerToClient, tlsRecordBytes(tlsHandshake, serverHello), true},
+		{DirectionClientToServer, tlsRecordBytes(tlsApplicationData, []byte("EHLO again")), true},
+	}
+
+	conn := proxy.newConnection(nil, nil)
+	for _, step := range session {
+		proxy.captureData(conn, step.data, step.direction)
+	}
+
+	captures := proxy.Buffer.GetAll()
+	if len(captures) != len(session) {
+		t.Fatalf("Expected %d captures, got %d", len(session), len(captures))
+	}
+	for i, step := range session {
+		isTLS := captures[i].DetectedProtocol == "TLS"
+		if isTLS != step.tls {
+			t.Errorf("Capture %d (%q): expected TLS=%v, got protocol %q", i, step.data, step.tls, captures[i].DetectedProtocol)
+		}
+		if step.tls && len(captures[i].TLSRecords) != 1 {
+			t.Errorf("Capture %d: expected 1 TLS record after the upgrade, got %+v", i, captures[i].TLSRecords)
+		}
+	}
+	if records := captures[5].TLSRecords; len(records) == 1 && records[0].HandshakeType != "client_hello" {
+		t.Errorf("Expected client_hello after the upgrade, got %+v", records[0])
+	}
+	if records := captures[6].TLSRecords; len(records) == 1 && records[0].HandshakeType != "server_hello" {
+		t.Errorf("Expected server_hello after the upgrade, got %+v", records[0])
+	}
+
+	// A refused upgrade leaves the session plaintext
+	refused := proxy.newConnection(nil, nil)
+	proxy.captureData(refused, []byte("a1 STARTTLS\r\n"), DirectionClientToServer)
+	proxy.captureData(refused, []byte("* BYE going away soon\r\na1 NO TLS unavailable\r\n"), DirectionServerToClient)
+	proxy.captureData(refused, tlsRecordBytes(tlsApplicationData, []byte("not really")), DirectionClientToServer)
+	if last := proxy.Buffer.GetAll()[len(session)+2]; last.DetectedProtocol == "TLS" {
+		t.Errorf("Expected no upgrade after a refused STARTTLS, got protocol %q", last.DetectedProtocol)
+	}
+
+	// IMAP and POP3 replies complete the upgrade too
+	for _, exchange := range [][2]string{
+		{"a2 STARTTLS\r\n", "* OK untagged\r\na2 OK Begin TLS negotiation now\r\n"},
+		{"STLS\r\n", "+OK Begin TLS negotiation\r\n"},
+	} {
+		c := proxy.newConnection(nil, nil)
+		proxy.captureData(c, []byte(exchange[0]), DirectionClientToServer)
+		proxy.captureData(c, []byte(exchange[1]), DirectionServerToClient)
+		proxy.captureData(c, tlsRecordBytes(tlsHandshake, clientHello), DirectionClientToServer)
+		all := proxy.Buffer.GetAll()
+		if last := all[len(all)-1]; last.DetectedProtocol != "TLS" {
+			t.Errorf("%q: expected TLS after the upgrade, got %q", exchange[0], last.DetectedProtocol)
+		}
+	}
+}
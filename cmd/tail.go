@@ -0,0 +1,37 @@
+package main
+
+// defaultTailBytes is the tail length returned when get_tail is given no bytes
+const defaultTailBytes = 1024
+
+// captureTail returns the last n stored bytes of captures in capture order,
+// keeping only captures in direction unless it is empty, along with the
+// number of captures the tail draws from and whether any of them were not
+// stored in full
+func captureTail(captures []*CapturedPacket, direction string, n int) ([]byte, int, bool) {
+	var parts [][]byte
+	var used, size int
+	var incomplete bool
+
+	for i := len(captures) - 1; i >= 0 && size < n; i-- {
+		capture := captures[i]
+		if direction != "" && capture.Direction != direction {
+			continue
+		}
+		data := capture.RawData
+		if size+len(data) > n {
+			data = data[len(data)-(n-size):]
+		}
+		parts = append(parts, data)
+		size += len(data)
+		used++
+		if capture.Truncated || len(capture.RawData) < capture.Bytes {
+			incomplete = true
+		}
+	}
+
+	tail := make([]byte, 0, size)
+	for i := len(parts) - 1; i >= 0; i-- {
+		tail = append(tail, parts[i]...)
+	}
+	return tail, used, incomplete
+}
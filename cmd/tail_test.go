@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// TestGetTail tests that the tail matches the most recent bytes of the
+// conversation, interleaved or per direction
+func TestGetTail(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19143, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19143)
+	proxy, _ := manager.GetProxy(19143)
+
+	conn := proxy.newConnection(nil, nil)
+	other := proxy.newConnection(nil, nil)
+	proxy.recordCapture(conn, []byte("GET key1\r\n"), DirectionClientToServer, false)
+	proxy.recordCapture(conn, []byte("value1\r\n"), DirectionServerToClient, false)
+	proxy.recordCapture(other, []byte("PING\r\n"), DirectionClientToServer, false)
+	proxy.recordCapture(conn, []byte("GET key2\r\n"), DirectionClientToServer, false)
+	proxy.recordCapture(conn, []byte("value\x002\r\n"), DirectionServerToClient, false)
+
+	tests := []struct {
+		args map[string]interface{}
+		want string
+		used float64
+	}{
+		{map[string]interface{}{"bytes": float64(11)}, "\r\nvalue\x002\r\n", 2},
+		{map[string]interface{}{"bytes": float64(24)}, "ING\r\nGET key2\r\nvalue\x002\r\n", 3},
+		{map[string]interface{}{"bytes": float64(23), "conn_id": float64(1)}, "e1\r\nGET key2\r\nvalue\x002\r\n", 3},
+		{map[string]interface{}{"bytes": float64(12), "direction": DirectionServerToClient}, "1\r\nvalue\x002\r\n", 2},
+		{map[string]interface{}{}, "GET key1\r\nvalue1\r\nPING\r\nGET key2\r\nvalue\x002\r\n", 5},
+	}
+	for _, tt := range tests {
+		tt.args["listen_port"] = float64(19143)
+		result := callTool(t, NewGetTailHandler(manager).Execute, tt.args)
+
+		data, err := base64.StdEncoding.DecodeString(result["base64"].(string))
+		if err != nil || string(data) != tt.want {
+			t.Errorf("%v: expected %q, got %q (%v)", tt.args, tt.want, data, err)
+		}
+		if text := strings.ReplaceAll(tt.want, "\x00", "."); result["text"] != text {
+			t.Errorf("%v: expected text %q, got %q", tt.args, text, result["text"])
+		}
+		if result["bytes"] != float64(len(tt.want)) || result["captures"] != tt.used {
+			t.Errorf("%v: unexpected counts %v/%v", tt.args, result["bytes"], result["captures"])
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+)
+
+// ForwardTarget is one backend of a proxy that spreads connections over
+// several, chosen at random in proportion to Weight
+type ForwardTarget struct {
+	Host   string
+	Port   int
+	Weight int
+}
+
+// String returns the target as host:port
+func (t ForwardTarget) String() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+// parseForwardTargets reads forward_targets entries of the form
+// {"host": ..., "port": ..., "weight": ...}, where host defaults to
+// localhost and weight to 1
+func parseForwardTargets(entries []interface{}) ([]ForwardTarget, error) {
+	targets := make([]ForwardTarget, 0, len(entries))
+	for i, entry := range entries {
+		fields, ok := entry.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("forward_targets[%d] must be an object with host, port and weight", i)
+		}
+		target := ForwardTarget{Host: "localhost", Weight: 1}
+		if host, _ := getString(fields, "host"); host != "" {
+			target.Host = host
+		}
+		if target.Port, ok = getInt(fields, "port"); !ok || target.Port <= 0 || target.Port > 65535 {
+			return nil, fmt.Errorf("forward_targets[%d] needs a port between 1 and 65535", i)
+		}
+		if weight, ok := getInt(fields, "weight"); ok {
+			if weight <= 0 {
+				return nil, fmt.Errorf("forward_targets[%d] weight must be positive", i)
+			}
+			target.Weight = weight
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// pickTarget chooses the backend for a new connection by weighted random,
+// returning its index in ForwardTargets, or -1 for the single forward target
+func (p *ProxyInstance) pickTarget() int {
+	targets := p.Options.ForwardTargets
+	if len(targets) == 0 {
+		return -1
+	}
+	total := 0
+	for _, target := range targets {
+		total += target.Weight
+	}
+	n := rand.Intn(total)
+	for i, target := range targets {
+		if n < target.Weight {
+			return i
+		}
+		n -= target.Weight
+	}
+	return len(targets) - 1
+}
+
+// targetDistribution reports each forward target's configured share and the
+// share of connections it actually received
+func (p *ProxyInstance) targetDistribution() []map[string]interface{} {
+	total := 0
+	var connections int64
+	for i, target := range p.Options.ForwardTargets {
+		total += target.Weight
+		connections += p.targetConns[i].Load()
+	}
+
+	distribution := make([]map[string]interface{}, 0, len(p.Options.ForwardTargets))
+	for i, target := range p.Options.ForwardTargets {
+		count := p.targetConns[i].Load()
+		entry := map[string]interface{}{
+			"target":       target.String(),
+			"weight":       target.Weight,
+			"weight_share": fmt.Sprintf("%.1f%%", float64(target.Weight)*100/float64(total)),
+			"connections":  count,
+		}
+		if connections > 0 {
+			entry["connection_share"] = fmt.Sprintf("%.1f%%", float64(count)*100/float64(connections))
+		}
+		distribution = append(distribution, entry)
+	}
+	return distribution
+}
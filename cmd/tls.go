@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TLS record content types (RFC 8446 Section 5.1)
+const (
+	tlsChangeCipherSpec = 20
+	tlsAlert            = 21
+	tlsHandshake        = 22
+	tlsApplicationData  = 23
+	tlsHeartbeat        = 24
+)
+
+// tlsRecordHeaderBytes is the size of a TLS record header
+const tlsRecordHeaderBytes = 5
+
+// Values of tls_negotiated_alpn besides a protocol name
+const (
+	alpnNone        = "none"        // The ServerHello selected no protocol
+	alpnUnavailable = "unavailable" // TLS 1.3 sends the selection encrypted
+)
+
+// maxServerHelloBytes bounds the ServerHello kept for ALPN parsing
+const maxServerHelloBytes = 4096
+
+// TLS extension types read from the ServerHello
+const (
+	tlsExtALPN              = 16
+	tlsExtSupportedVersions = 43
+)
+
+// tlsContentTypeNames maps record content types to their names
+var tlsContentTypeNames = map[uint8]string{
+	tlsChangeCipherSpec: "change_cipher_spec",
+	tlsAlert:            "alert",
+	tlsHandshake:        "handshake",
+	tlsApplicationData:  "application_data",
+	tlsHeartbeat:        "heartbeat",
+}
+
+// tlsHandshakeTypeNames maps plaintext handshake message types to their names
+var tlsHandshakeTypeNames = map[uint8]string{
+	1: "client_hello", 2: "server_hello", 4: "new_session_ticket",
+	8: "encrypted_extensions", 11: "certificate", 12: "server_key_exchange",
+	13: "certificate_request", 14: "server_hello_done", 15: "certificate_verify",
+	16: "client_key_exchange", 20: "finished",
+}
+
+// TLSRecordSummary describes one TLS record within a capture
+type TLSRecordSummary struct {
+	ContentType   uint8  `json:"content_type"`
+	TypeName      string `json:"type_name"`
+	Version       string `json:"version"`
+	Length        int    `json:"length"`
+	HandshakeType string `json:"handshake_type,omitempty"` // First message of a plaintext handshake record
+}
+
+// tlsStreamParser follows the record layer of one direction of a TLS
+// connection. Records may span reads, so the partial header and the
+// remaining payload length are carried over between calls.
+type tlsStreamParser struct {
+	state     streamParserState
+	pending   []byte     // Partial record header
+	remaining int        // Payload bytes of the current record still to skip
+	encrypted bool       // change_cipher_spec seen, handshake payloads are opaque
+	hello     []byte     // ServerHello bytes collected so far, nil when not collecting
+	alpn      string     // Protocol the ServerHello selected, "" until parsed
+	mu        sync.Mutex // Injected bytes are parsed from another goroutine
+}
+
+// parse consumes the next bytes of the direction and returns a summary of
+// each record whose header ends within them
+func (p *tlsStreamParser) parse(data []byte) []TLSRecordSummary {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state == parserUndecided && len(data) > 0 {
+		// Every TLS connection opens with a handshake record
+		if len(data) >= 2 && data[0] == tlsHandshake && data[1] == 0x03 {
+			p.state = parserActive
+		} else {
+			p.state = parserInactive
+		}
+	}
+	if p.state != parserActive {
+		return nil
+	}
+
+	var records []TLSRecordSummary
+	for len(data) > 0 {
+		if p.remaining > 0 {
+			n := min(p.remaining, len(data))
+			if p.hello != nil {
+				p.collectServerHello(data[:n])
+			}
+			p.remaining -= n
+			data = data[n:]
+			continue
+		}
+
+		n := min(tlsRecordHeaderBytes-len(p.pending), len(data))
+		p.pending = append(p.pending, data[:n]...)
+		data = data[n:]
+		if len(p.pending) < tlsRecordHeaderBytes {
+			break
+		}
+
+		record := TLSRecordSummary{
+			ContentType: p.pending[0],
+			TypeName:    tlsContentTypeNames[p.pending[0]],
+			Version:     fmt.Sprintf("0x%02x%02x", p.pending[1], p.pending[2]),
+			Length:      int(p.pending[3])<<8 | int(p.pending[4]),
+		}
+		p.pending = p.pending[:0]
+		if record.TypeName == "" {
+			// Lost sync with the record layer, stop parsing this direction
+			p.state = parserInactive
+			record.TypeName = "unknown"
+			return append(records, record)
+		}
+
+		if record.ContentType == tlsChangeCipherSpec {
+			p.encrypted = true
+		}
+		if record.ContentType == tlsHandshake && !p.encrypted && record.Length > 0 && len(data) > 0 {
+			record.HandshakeType = tlsHandshakeTypeNames[data[0]]
+		}
+
+		// A ServerHello may continue into the next handshake record
+		switch {
+		case record.HandshakeType == "server_hello" && p.alpn == "" && p.hello == nil:
+			p.hello = []byte{}
+		case p.hello != nil && record.ContentType != tlsHandshake:
+			p.hello = nil
+		}
+
+		records = append(records, record)
+		p.remaining = record.Length
+	}
+	return records
+}
+
+// collectServerHello appends the next ServerHello bytes and parses the
+// message once it is complete
+// IMPORTANT: This assumes p.mu is already held by the caller
+func (p *tlsStreamParser) collectServerHello(data []byte) {
+	p.hello = append(p.hello, data...)
+	if len(p.hello) < 4 {
+		return
+	}
+	size := 4 + (int(p.hello[1])<<16 | int(p.hello[2])<<8 | int(p.hello[3]))
+	switch {
+	case size > maxServerHelloBytes:
+		p.hello = nil
+	case len(p.hello) >= size:
+		p.alpn = parseServerHelloALPN(p.hello[4:size])
+		p.hello = nil
+	}
+}
+
+// negotiatedALPN returns the protocol the ServerHello selected, alpnNone,
+// alpnUnavailable, or "" when no ServerHello has been parsed
+func (p *tlsStreamParser) negotiatedALPN() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.alpn
+}
+
+// parseServerHelloALPN reads the ALPN selection from a ServerHello body.
+// TLS 1.3 moves it to the encrypted EncryptedExtensions message, which the
+// supported_versions extension reveals. Malformed bodies return "".
+func parseServerHelloALPN(body []byte) string {
+	// legacy_version, random, session_id, cipher_suite, compression_method
+	if len(body) < 35 {
+		return ""
+	}
+	offset := 35 + int(body[34]) + 3
+	if offset == len(body) {
+		return alpnNone // No extensions
+	}
+	if offset+2 > len(body) {
+		return ""
+	}
+	extensions := body[offset+2:]
+	if size := int(body[offset])<<8 | int(body[offset+1]); size <= len(extensions) {
+		extensions = extensions[:size]
+	}
+
+	alpn := alpnNone
+	for len(extensions) >= 4 {
+		extType := int(extensions[0])<<8 | int(extensions[1])
+		size := int(extensions[2])<<8 | int(extensions[3])
+		if 4+size > len(extensions) {
+			return ""
+		}
+		ext := extensions[4 : 4+size]
+		extensions = extensions[4+size:]
+
+		switch extType {
+		case tlsExtSupportedVersions:
+			if len(ext) == 2 && ext[0] == 0x03 && ext[1] >= 0x04 {
+				return alpnUnavailable
+			}
+		case tlsExtALPN:
+			// A protocol name list holding exactly the selected name
+			if len(ext) < 3 || int(ext[2])+3 > len(ext) {
+				return ""
+			}
+			alpn = string(ext[3 : 3+int(ext[2])])
+		}
+	}
+	return alpn
+}
+
+// tlsHandshakeTimer measures a connection's TLS handshake, from the record
+// carrying the ClientHello to the client's first application_data record.
+// TLS 1.3 wraps the server's later handshake messages and the client's
+// Finished in application_data records, so only the client side marks the
+// end; its first such record is sent once the server's flight has arrived.
+type tlsHandshakeTimer struct {
+	helloAt  time.Time // Zero until a ClientHello is seen
+	duration time.Duration
+	done     bool
+	mu       sync.Mutex
+}
+
+// observe checks the records of one read of a direction taken at the given time
+func (t *tlsHandshakeTimer) observe(direction string, records []TLSRecordSummary, at time.Time) {
+	if len(records) == 0 || direction != DirectionClientToServer {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, record := range records {
+		switch {
+		case t.helloAt.IsZero():
+			if record.HandshakeType == "client_hello" {
+				t.helloAt = at
+			}
+		case !t.done && record.ContentType == tlsApplicationData:
+			t.duration = at.Sub(t.helloAt)
+			t.done = true
+		}
+	}
+}
+
+// handshakeMs reports whether a ClientHello was seen and the handshake
+// duration in milliseconds, nil while it has not completed
+func (t *tlsHandshakeTimer) handshakeMs() (bool, *float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.done {
+		return !t.helloAt.IsZero(), nil
+	}
+	ms := float64(t.duration) / float64(time.Millisecond)
+	return true, &ms
+}
+
+// reset forgets everything seen so far, so a connection that upgrades to TLS
+// mid-stream (STARTTLS) is detected from its first handshake record
+func (p *tlsStreamParser) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.state = parserUndecided
+	p.pending = nil
+	p.remaining = 0
+	p.encrypted = false
+	p.hello = nil
+	p.alpn = ""
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// tlsRecordBytes builds a raw TLS record
+func tlsRecordBytes(contentType uint8, payload []byte) []byte {
+	record := []byte{contentType, 0x03, 0x03, byte(len(payload) >> 8), byte(len(payload))}
+	return append(record, payload...)
+}
+
+// TestTLSRecordParsing tests record summaries for a handshake record followed
+// by application data records split across reads
+func TestTLSRecordParsing(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19128, "localhost", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19128)
+
+	proxy, _ := manager.GetProxy(19128)
+	conn := proxy.newConnection(nil, nil)
+
+	clientHello := append([]byte{1, 0, 0, 6}, make([]byte, 6)...)
+	var stream []byte
+	stream = append(stream, tlsRecordBytes(tlsHandshake, clientHello)...)
+	stream = append(stream, tlsRecordBytes(tlsChangeCipherSpec, []byte{1})...)
+	stream = append(stream, tlsRecordBytes(tlsApplicationData, make([]byte, 300))...)
+	stream = append(stream, tlsRecordBytes(tlsApplicationData, make([]byte, 40))...)
+
+	// Split inside the first application data payload and inside the last header
+	split1 := 5 + len(clientHello) + 6 + 100
+	split2 := split1 + 200 + 3
+	proxy.captureData(conn, stream[:split1], DirectionClientToServer)
+	proxy.captureData(conn, stream[split1:split2], DirectionClientToServer)
+	proxy.captureData(conn, stream[split2:], DirectionClientToServer)
+
+	captures := proxy.Buffer.GetAll()
+	if len(captures) != 3 {
+		t.Fatalf("Expected 3 captures, got %d", len(captures))
+	}
+
+	expected := [][]TLSRecordSummary{
+		{
+			{ContentType: tlsHandshake, TypeName: "handshake", Version: "0x0303", Length: 10, HandshakeType: "client_hello"},
+			{ContentType: tlsChangeCipherSpec, TypeName: "change_cipher_spec", Version: "0x0303", Length: 1},
+			{ContentType: tlsApplicationData, TypeName: "application_data", Version: "0x0303", Length: 300},
+		},
+		nil, // Only payload and a partial header
+		{
+			{ContentType: tlsApplicationData, TypeName: "application_data", Version: "0x0303", Length: 40},
+		},
+	}
+	for i, want := range expected {
+		got := captures[i].TLSRecords
+		if len(got) != len(want) {
+			t.Errorf("Capture %d: expected %d records, got %+v", i, len(want), got)
+			continue
+		}
+		for j := range want {
+			if got[j] != want[j] {
+				t.Errorf("Capture %d record %d: expected %+v, got %+v", i, j, want[j], got[j])
+			}
+		}
+	}
+
+	// Non-TLS traffic is left alone
+	other := proxy.newConnection(nil, nil)
+	proxy.captureData(other, []byte("GET / HTTP/1.1\r\n\r\n"), DirectionClientToServer)
+	if records := proxy.Buffer.GetAll()[3].TLSRecords; records != nil {
+		t.Errorf("Expected no TLS records for HTTP traffic, got %+v", records)
+	}
+}
+
+// TestTLSHandshakeDuration tests that tls_handshake_ms spans the ClientHello
+// to the client's first application data, and stays null for a handshake
+// that never completes
+func TestTLSHandshakeDuration(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19184, "localhost", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19184)
+	proxy, _ := manager.GetProxy(19184)
+
+	clientHello := tlsRecordBytes(tlsHandshake, append([]byte{1, 0, 0, 2}, 0, 0))
+	serverHello := tlsRecordBytes(tlsHandshake, append([]byte{2, 0, 0, 2}, 0, 0))
+
+	complete := proxy.newConnection(nil, nil)
+	proxy.registerConnection(complete)
+	proxy.captureData(complete, clientHello, DirectionClientToServer)
+	time.Sleep(20 * time.Millisecond)
+	proxy.captureData(complete, serverHello, DirectionServerToClient)
+	proxy.captureData(complete, tlsRecordBytes(tlsApplicationData, make([]byte, 32)), DirectionServerToClient)
+	time.Sleep(10 * time.Millisecond)
+	proxy.captureData(complete, append(tlsRecordBytes(tlsChangeCipherSpec, []byte{1}),
+		tlsRecordBytes(tlsApplicationData, make([]byte, 16))...), DirectionClientToServer)
+
+	stalled := proxy.newConnection(nil, nil)
+	proxy.registerConnection(stalled)
+	proxy.captureData(stalled, clientHello, DirectionClientToServer)
+
+	plain := proxy.newConnection(nil, nil)
+	proxy.registerConnection(plain)
+	proxy.captureData(plain, []byte("GET / HTTP/1.1\r\n\r\n"), DirectionClientToServer)
+
+	result := callTool(t, NewGetConnectionsHandler(manager).Execute, map[string]interface{}{
+		"listen_port": float64(19184),
+	})
+	conns := result["connections"].([]interface{})
+	if len(conns) != 3 {
+		t.Fatalf("Expected 3 connections, got %v", result)
+	}
+
+	ms, ok := conns[0].(map[string]interface{})["tls_handshake_ms"].(float64)
+	if !ok || ms < 30 || ms > 1000 {
+		t.Errorf("Expected a handshake of at least 30ms, got %v", conns[0].(map[string]interface{})["tls_handshake_ms"])
+	}
+	if value, present := conns[1].(map[string]interface{})["tls_handshake_ms"]; !present || value != nil {
+		t.Errorf("Expected tls_handshake_ms null for an incomplete handshake, got %v (present %v)", value, present)
+	}
+	if value, present := conns[2].(map[string]interface{})["tls_handshake_ms"]; present {
+		t.Errorf("Expected no tls_handshake_ms on a plaintext connection, got %v", value)
+	}
+}
+
+// serverHelloRecord builds a ServerHello handshake record with the given
+// extensions
+func serverHelloRecord(extensions []byte) []byte {
+	body := []byte{0x03, 0x03}
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, 0)                   // session_id
+	body = append(body, 0x13, 0x01, 0)       // cipher_suite, compression_method
+	body = append(body, byte(len(extensions)>>8), byte(len(extensions)))
+	body = append(body, extensions...)
+	message := []byte{2, 0, byte(len(body) >> 8), byte(len(body))}
+	return tlsRecordBytes(tlsHandshake, append(message, body...))
+}
+
+// TestServerHelloALPN tests that the protocol selected in a TLS 1.2
+// ServerHello is recorded, even when the record is split across reads, and
+// that TLS 1.3 reports it as unavailable
+func TestServerHelloALPN(t *testing.T) {
+	manager := NewProxyManager()
+	defer manager.StopAll()
+	if err := manager.StartProxy(19207, "localhost", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	proxy, _ := manager.GetProxy(19207)
+
+	// renegotiation_info, then ALPN selecting h2
+	alpn := []byte{0xff, 0x01, 0, 1, 0, 0, 16, 0, 5, 0, 3, 2, 'h', '2'}
+	hello := serverHelloRecord(alpn)
+	tls12 := proxy.newConnection(nil, nil)
+	proxy.captureData(tls12, tlsRecordBytes(tlsHandshake, append([]byte{1, 0, 0, 2}, 0x03, 0x03)), DirectionClientToServer)
+	proxy.captureData(tls12, hello[:20], DirectionServerToClient)
+	if got := tls12.Info().TLSALPN; got != "" {
+		t.Errorf("Expected no ALPN before the ServerHello is complete, got %q", got)
+	}
+	proxy.captureData(tls12, hello[20:], DirectionServerToClient)
+	if got := tls12.Info().TLSALPN; got != "h2" {
+		t.Errorf("Expected negotiated ALPN h2, got %q", got)
+	}
+
+	// A ServerHello without ALPN selected none
+	none := proxy.newConnection(nil, nil)
+	proxy.captureData(none, serverHelloRecord(nil), DirectionServerToClient)
+	if got := none.Info().TLSALPN; got != alpnNone {
+		t.Errorf("Expected %q without an ALPN extension, got %q", alpnNone, got)
+	}
+
+	// supported_versions selecting TLS 1.3 hides the selection
+	tls13 := proxy.newConnection(nil, nil)
+	proxy.captureData(tls13, serverHelloRecord([]byte{0, 43, 0, 2, 0x03, 0x04}), DirectionServerToClient)
+	if got := tls13.Info().TLSALPN; got != alpnUnavailable {
+		t.Errorf("Expected %q for TLS 1.3, got %q", alpnUnavailable, got)
+	}
+}
@@ -2,8 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 )
@@ -26,10 +33,55 @@ func (h *StartProxyHandler) Execute(ctx context.Context, request mcp.CallToolReq
 		return nil, fmt.Errorf("invalid arguments format")
 	}
 
+	cfg, err := parseProxyConfig(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get existing proxy policy (optional, default: error)
+	ifExists, _ := getString(args, "if_exists")
+	switch ifExists {
+	case "", IfExistsError, IfExistsReturn, IfExistsRestart:
+	default:
+		return nil, fmt.Errorf("invalid if_exists %q (expected %q, %q or %q)", ifExists, IfExistsError, IfExistsReturn, IfExistsRestart)
+	}
+
+	// Start the proxy
+	status, err := h.manager.EnsureProxy(cfg.ListenPort, cfg.ForwardHost, cfg.ForwardPort, cfg.CaptureLimit, cfg.Options, ifExists)
+	if err != nil {
+		// Return error as JSON result
+		result := map[string]interface{}{
+			"error": err.Error(),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	// Return success result
+	result := map[string]interface{}{
+		"status":      status,
+		"listen_port": cfg.ListenPort,
+		"forward_to":  fmt.Sprintf("%s:%d", cfg.ForwardHost, cfg.ForwardPort),
+	}
+	if cfg.Options.CaptureDir != "" {
+		result["capture_dir"] = cfg.Options.CaptureDir
+	}
+	if cfg.Options.Label != "" {
+		result["label"] = cfg.Options.Label
+	}
+	if len(cfg.Options.Tags) > 0 {
+		result["tags"] = cfg.Options.Tags
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// parseProxyConfig reads a proxy configuration from start_proxy arguments
+func parseProxyConfig(args map[string]interface{}) (ProxyConfig, error) {
 	// Get listen port (required)
 	listenPort, ok := getInt(args, "listen_port")
 	if !ok {
-		return nil, fmt.Errorf("listen_port is required")
+		return ProxyConfig{}, fmt.Errorf("listen_port is required")
 	}
 
 	// Get forward host (optional, default: localhost)
@@ -38,37 +90,237 @@ func (h *StartProxyHandler) Execute(ctx context.Context, request mcp.CallToolReq
 		forwardHost = "localhost"
 	}
 
-	// Get forward port (required)
+	// Get weighted forward targets (optional, replacing forward_host/forward_port)
+	var targets []ForwardTarget
+	if entries, ok := args["forward_targets"].([]interface{}); ok && len(entries) > 0 {
+		var err error
+		if targets, err = parseForwardTargets(entries); err != nil {
+			return ProxyConfig{}, err
+		}
+	}
+
+	// Get forward port (required unless forward_targets is given)
 	forwardPort, ok := getInt(args, "forward_port")
 	if !ok {
-		return nil, fmt.Errorf("forward_port is required")
+		if len(targets) == 0 {
+			return ProxyConfig{}, fmt.Errorf("forward_port is required")
+		}
+		forwardHost, forwardPort = targets[0].Host, targets[0].Port
 	}
 
-	// Get capture limit (optional, default: 10MB)
-	captureLimit, _ := getInt(args, "capture_limit")
+	// Get capture limit (optional, default: defaultCaptureLimit)
+	captureLimit, _, err := getSize(args, "capture_limit")
+	if err != nil {
+		return ProxyConfig{}, fmt.Errorf("capture_limit: %v", err)
+	}
 	if captureLimit <= 0 {
-		captureLimit = 10 * 1024 * 1024 // 10MB default
+		captureLimit = defaultCaptureLimit
 	}
 
-	// Start the proxy
-	err := h.manager.StartProxy(listenPort, forwardHost, forwardPort, captureLimit)
-	if err != nil {
-		// Return error as JSON result
-		result := map[string]interface{}{
-			"error": err.Error(),
+	// Get on-disk capture rotation settings (optional)
+	var opts ProxyOptions
+	opts.CaptureDir, _ = getString(args, "capture_dir")
+	rotateBytes, _ := getInt(args, "rotate_bytes")
+	opts.RotateBytes = int64(rotateBytes)
+	opts.MaxFiles, _ = getInt(args, "max_files")
+
+	// Get grouping label and tags (optional)
+	opts.Label, _ = getString(args, "label")
+	opts.Tags, _ = getStringSlice(args, "tags")
+
+	// Get HTTP headers-only flag (optional, default: false)
+	opts.HTTPHeadersOnly, _ = args["http_headers_only"].(bool)
+
+	// Get HTTP body cap (optional, default: unlimited)
+	opts.HTTPMaxBodyBytes, _ = getInt(args, "http_max_body_bytes")
+	if opts.HTTPMaxBodyBytes < 0 {
+		return ProxyConfig{}, fmt.Errorf("http_max_body_bytes must not be negative")
+	}
+	if opts.HTTPMaxBodyBytes > 0 && opts.HTTPHeadersOnly {
+		return ProxyConfig{}, fmt.Errorf("http_max_body_bytes cannot be combined with http_headers_only")
+	}
+
+	// Get per-packet storage cap (optional, default: unlimited)
+	opts.MaxStoredBytesPerPacket, _ = getInt(args, "max_stored_bytes_per_packet")
+
+	// Get redaction settings (optional, default: off)
+	opts.Redact, _ = args["redact"].(bool)
+	opts.RedactPatterns, _ = getStringSlice(args, "redact_patterns")
+
+	// Get capture flag (optional, default: true)
+	if capture, ok := args["capture"].(bool); ok {
+		opts.PassThrough = !capture
+	}
+
+	opts.ForwardTargets = targets
+
+	// Get protocol detection flag (optional, default: true)
+	if detect, ok := args["detect_protocol"].(bool); ok {
+		opts.DisableDetection = !detect
+	}
+
+	// Get per-IP connection cap (optional, default: unlimited)
+	opts.MaxConnsPerIP, _ = getInt(args, "max_conns_per_ip")
+
+	// Get concurrency bound (optional, default: defaultMaxConcurrentConns)
+	opts.MaxConcurrentConns, _ = getInt(args, "max_concurrent_connections")
+	if opts.MaxConcurrentConns < 0 {
+		return ProxyConfig{}, fmt.Errorf("max_concurrent_connections must not be negative")
+	}
+
+	// Get capture exclusions by source (optional)
+	opts.ExcludeCIDRs, _ = getStringSlice(args, "exclude_cidrs")
+
+	// Get TCP keepalive period (optional, default: Go's default; 0 disables)
+	if keepAliveMs, ok := getInt(args, "tcp_keepalive_ms"); ok {
+		if keepAliveMs < 0 {
+			return ProxyConfig{}, fmt.Errorf("tcp_keepalive_ms must not be negative")
+		}
+		if keepAliveMs == 0 {
+			opts.TCPKeepAlive = -1
+		} else {
+			opts.TCPKeepAlive = time.Duration(keepAliveMs) * time.Millisecond
 		}
-		jsonBytes, _ := json.Marshal(result)
-		return mcp.NewToolResultText(string(jsonBytes)), nil
 	}
 
-	// Return success result
-	result := map[string]interface{}{
-		"status":      "started",
-		"listen_port": listenPort,
-		"forward_to":  fmt.Sprintf("%s:%d", forwardHost, forwardPort),
+	// Get TCP_NODELAY flag (optional, default: true)
+	if noDelay, ok := args["tcp_nodelay"].(bool); ok {
+		opts.TCPNagle = !noDelay
 	}
-	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
-	return mcp.NewToolResultText(string(jsonBytes)), nil
+
+	// Get read buffer size (optional, default: defaultReadBufferSize)
+	opts.ReadBufferSize, _ = getInt(args, "read_buffer_size")
+	if opts.ReadBufferSize < 0 || opts.ReadBufferSize > maxReadBufferSize {
+		return ProxyConfig{}, fmt.Errorf("read_buffer_size must be between 0 and %d", maxReadBufferSize)
+	}
+
+	// Get eviction policy (optional, default: fifo)
+	opts.EvictionPolicy, _ = getString(args, "eviction_policy")
+	if _, err := parseEvictionPolicy(opts.EvictionPolicy); err != nil {
+		return ProxyConfig{}, err
+	}
+
+	// Get adaptive sampling flag (optional, default: false)
+	opts.AdaptiveSampling, _ = args["adaptive_sampling"].(bool)
+
+	// Get capture rate cap (optional, default: unlimited)
+	opts.MaxCapturesPerSec, _ = getInt(args, "max_captures_per_sec")
+	if opts.MaxCapturesPerSec < 0 {
+		return ProxyConfig{}, fmt.Errorf("max_captures_per_sec must not be negative")
+	}
+
+	// Get capture stop watermark (optional, default: evict instead)
+	if percent, ok := args["stop_capture_at_percent"].(float64); ok {
+		if percent <= 0 || percent > 100 {
+			return ProxyConfig{}, fmt.Errorf("stop_capture_at_percent must be greater than 0 and at most 100")
+		}
+		opts.StopCaptureAtPercent = percent
+	}
+
+	// Get retention window (optional, default: no time limit)
+	opts.RetainSeconds, _ = getInt(args, "retain_seconds")
+	if opts.RetainSeconds < 0 {
+		return ProxyConfig{}, fmt.Errorf("retain_seconds must not be negative")
+	}
+
+	// Get text-only filter (optional, default: off)
+	opts.TextOnly, _ = args["text_only_capture"].(bool)
+	if ratio, ok := args["text_min_printable_ratio"].(float64); ok {
+		if ratio <= 0 || ratio > 1 {
+			return ProxyConfig{}, fmt.Errorf("text_min_printable_ratio must be greater than 0 and at most 1")
+		}
+		opts.TextMinPrintable = ratio
+	}
+
+	// Get ingest substring filter (optional, default: store everything)
+	opts.IngestContains, _ = getString(args, "ingest_contains")
+
+	// Get first-packet filter (optional, default: false)
+	opts.FirstPacketOnly, _ = args["first_packet_only"].(bool)
+
+	// Get capture coalescing window (optional, default: off)
+	if windowMs, ok := getInt(args, "coalesce_window_ms"); ok && windowMs > 0 {
+		opts.CoalesceWindow = time.Duration(windowMs) * time.Millisecond
+	}
+
+	// Get HTTP error filter (optional, default: false)
+	opts.HTTPErrorsOnly, _ = args["http_capture_errors_only"].(bool)
+	if opts.HTTPErrorsOnly && opts.CoalesceWindow > 0 {
+		return ProxyConfig{}, fmt.Errorf("http_capture_errors_only cannot be combined with coalesce_window_ms")
+	}
+
+	// Get mirror backend (optional)
+	opts.MirrorTarget, _ = getString(args, "mirror_target")
+	if opts.MirrorTarget != "" {
+		if _, _, err := splitTarget(opts.MirrorTarget); err != nil {
+			return ProxyConfig{}, fmt.Errorf("invalid mirror_target: %v", err)
+		}
+	}
+
+	// Get trace correlation header (optional, true for the default name)
+	switch header := args["trace_header"].(type) {
+	case bool:
+		if header {
+			opts.TraceHeader = defaultTraceHeader
+		}
+	case string:
+		if header != "" && !validHeaderName(header) {
+			return ProxyConfig{}, fmt.Errorf("invalid trace_header %q: a header name may only contain letters, digits and !#$%%&'*+-.^_`|~", header)
+		}
+		opts.TraceHeader = header
+	}
+	if opts.TraceHeader != "" && opts.PassThrough {
+		return ProxyConfig{}, fmt.Errorf("trace_header requires capture")
+	}
+
+	// Get line mode (optional, default: false)
+	opts.LineMode, _ = args["line_mode"].(bool)
+	if opts.LineMode && opts.PassThrough {
+		return ProxyConfig{}, fmt.Errorf("line_mode requires capture")
+	}
+
+	// Get UDP/TCP bridging (optional, default: tcp on both sides)
+	opts.ListenProtocol, _ = getString(args, "listen_protocol")
+	if opts.ListenProtocol == "" {
+		opts.ListenProtocol = ProtocolTCP
+	}
+	opts.ForwardProtocol, _ = getString(args, "forward_protocol")
+	if opts.ForwardProtocol == "" {
+		opts.ForwardProtocol = ProtocolTCP
+	}
+	if err := validateProtocols(opts.ListenProtocol, opts.ForwardProtocol); err != nil {
+		return ProxyConfig{}, err
+	}
+
+	// Get listen network (optional, default: tcp, dual-stack where supported)
+	opts.ListenNetwork, _ = getString(args, "listen_network")
+	if opts.ListenNetwork == "" {
+		opts.ListenNetwork = ProtocolTCP
+	}
+	if err := validateListenNetwork(opts.ListenNetwork); err != nil {
+		return ProxyConfig{}, err
+	}
+	if opts.bridged() && (opts.PassThrough || opts.MirrorTarget != "" || opts.TraceHeader != "" || opts.LineMode) {
+		return ProxyConfig{}, fmt.Errorf("udp bridging cannot be combined with capture: false, mirror_target, trace_header or line_mode")
+	}
+
+	// Get match webhook (optional)
+	opts.WebhookURL, _ = getString(args, "webhook_url")
+	opts.WebhookPattern, _ = getString(args, "webhook_pattern")
+	if _, err := validateWebhook(opts.WebhookURL, opts.WebhookPattern); err != nil {
+		return ProxyConfig{}, err
+	}
+	if opts.WebhookURL != "" && opts.PassThrough {
+		return ProxyConfig{}, fmt.Errorf("webhook_url requires capture")
+	}
+
+	return ProxyConfig{
+		ListenPort:   listenPort,
+		ForwardHost:  forwardHost,
+		ForwardPort:  forwardPort,
+		CaptureLimit: captureLimit,
+		Options:      opts,
+	}, nil
 }
 
 // GetProxyOutputHandler handles the get_proxy_output tool
@@ -92,12 +344,74 @@ func (h *GetProxyOutputHandler) Execute(ctx context.Context, request mcp.CallToo
 	// Get listen port (optional)
 	listenPort, hasPort := getInt(args, "listen_port")
 
-	// Get clear_buffer flag (optional, default: true)
-	clearBuffer := true
+	// Get pagination (optional, default: everything)
+	offset, _ := getInt(args, "offset")
+	limit, _ := getInt(args, "limit")
+
+	// Get clear_buffer flag (optional, default: true, false when paginating)
+	clearBuffer := offset <= 0 && limit <= 0
 	if cb, ok := args["clear_buffer"].(bool); ok {
 		clearBuffer = cb
 	}
 
+	// Get dedup flag (optional, default: false)
+	dedup, _ := args["dedup"].(bool)
+
+	// Get view mode (optional, default: packets)
+	view, _ := getString(args, "view")
+	if view == "" {
+		view = "packets"
+	}
+	if view != "packets" && view != "http" {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("invalid view %q (expected \"packets\" or \"http\")", view),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	// Get ordering (optional, default: asc)
+	order, _ := getString(args, "order")
+	if order == "" {
+		order = "asc"
+	}
+	if order != "asc" && order != "desc" {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("invalid order %q (expected \"asc\" or \"desc\")", order),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	// Get output format (optional, default: json)
+	format, _ := getString(args, "format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "cbor-base64" {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("invalid format %q (expected \"json\" or \"cbor-base64\")", format),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	// Get hex dump layout (optional, default: 16 bytes per line with ASCII)
+	layout := defaultHexDump
+	if width, ok := getInt(args, "hexdump_width"); ok {
+		if width != 8 && width != 16 && width != 32 {
+			result := map[string]interface{}{
+				"error": fmt.Sprintf("invalid hexdump_width %d (expected 8, 16 or 32)", width),
+			}
+			jsonBytes, _ := json.Marshal(result)
+			return mcp.NewToolResultText(string(jsonBytes)), nil
+		}
+		layout.width = width
+	}
+	if ascii, ok := args["hexdump_ascii"].(bool); ok {
+		layout.ascii = ascii
+	}
+
 	// Collect proxy data
 	var proxies []*ProxyInstance
 	if hasPort {
@@ -110,28 +424,21 @@ func (h *GetProxyOutputHandler) Execute(ctx context.Context, request mcp.CallToo
 			return mcp.NewToolResultText(string(jsonBytes)), nil
 		}
 		proxies = []*ProxyInstance{proxy}
+	} else if label, ok := getString(args, "label"); ok && label != "" {
+		proxies = h.manager.GetProxiesByLabel(label)
 	} else {
 		proxies = h.manager.GetAllProxies()
 	}
 
-	// Build response
+	// Build response. Returned captures are cleared only once the response
+	// is encoded, so a failed encoding loses nothing.
 	proxyResults := make([]map[string]interface{}, 0, len(proxies))
+	cleared := make(map[*ProxyInstance][]*CapturedPacket)
 
 	for _, proxy := range proxies {
 		// Get captures
 		captures := proxy.Buffer.GetAll()
-		captureData := make([]map[string]interface{}, 0, len(captures))
-
-		for _, capture := range captures {
-			captureData = append(captureData, map[string]interface{}{
-				"timestamp":         capture.Timestamp.Format("2006-01-02T15:04:05.000Z"),
-				"direction":         capture.Direction,
-				"bytes":             capture.Bytes,
-				"hex_dump":          capture.HexDump,
-				"ascii_strings":     capture.AsciiStrings,
-				"detected_protocol": capture.DetectedProtocol,
-			})
-		}
+		totalCaptures := len(captures)
 
 		// Get buffer stats
 		_, totalBytes, usage := proxy.Buffer.GetStats()
@@ -144,17 +451,42 @@ func (h *GetProxyOutputHandler) Execute(ctx context.Context, request mcp.CallToo
 		proxyResult := map[string]interface{}{
 			"listen_port":          proxy.ListenPort,
 			"forward_to":           fmt.Sprintf("%s:%d", proxy.ForwardHost, proxy.ForwardPort),
-			"captures":             captureData,
 			"total_bytes_captured": bytesCaptured,
 			"buffer_usage":         fmt.Sprintf("%.1f%%", usage),
 			"buffer_bytes":         totalBytes,
+			"capture_limit":        proxy.CaptureLimit,
+			"capture_limit_human":  formatSize(proxy.CaptureLimit),
+		}
+
+		// The HTTP view replaces packet captures with parsed transactions,
+		// which are ordered and paginated instead of the packets. Only a
+		// page holding every transaction releases the captures behind them.
+		var returned []*CapturedPacket
+		if view == "http" {
+			transactions := extractHTTPTransactions(captures)
+			page := paginate(transactions, order, offset, limit)
+			proxyResult["total_transactions"] = len(transactions)
+			proxyResult["transactions"] = page
+			if len(page) == len(transactions) {
+				returned = captures
+			}
+		} else {
+			returned = paginate(captures, order, offset, limit)
+			proxyResult["total_captures"] = totalCaptures
+			proxyResult["captures"] = renderCaptures(returned, dedup, layout)
 		}
 
 		proxyResults = append(proxyResults, proxyResult)
 
-		// Clear buffer if requested
+		// Clear what was returned if requested; captures that arrived since
+		// the read or were left off the page stay
 		if clearBuffer {
-			proxy.Buffer.Clear()
+			cleared[proxy] = returned
+		}
+	}
+	clearReturned := func() {
+		for proxy, returned := range cleared {
+			proxy.Buffer.Remove(returned)
 		}
 	}
 
@@ -162,10 +494,120 @@ func (h *GetProxyOutputHandler) Execute(ctx context.Context, request mcp.CallToo
 		"proxies": proxyResults,
 	}
 
+	// CBOR output carries the same structure as JSON in fewer bytes
+	if format == "cbor-base64" {
+		cborBytes, err := encodeCBOR(result)
+		if err != nil {
+			result := map[string]interface{}{
+				"error": fmt.Sprintf("failed to encode CBOR: %v", err),
+			}
+			jsonBytes, _ := json.Marshal(result)
+			return mcp.NewToolResultText(string(jsonBytes)), nil
+		}
+		clearReturned()
+		return mcp.NewToolResultText(base64.StdEncoding.EncodeToString(cborBytes)), nil
+	}
+
 	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	clearReturned()
 	return mcp.NewToolResultText(string(jsonBytes)), nil
 }
 
+// paginate orders items ("asc" keeps capture order, "desc" reverses it) and
+// then returns up to limit items starting at offset; limit <= 0 means no limit
+func paginate[T any](items []T, order string, offset, limit int) []T {
+	result := make([]T, len(items))
+	copy(result, items)
+
+	if order == "desc" {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+	}
+
+	if offset > 0 {
+		if offset > len(result) {
+			offset = len(result)
+		}
+		result = result[offset:]
+	}
+	if limit > 0 && limit < len(result) {
+		result = result[:limit]
+	}
+	return result
+}
+
+// sameRepeat reports whether b repeats a for dedup: the same payload in the
+// same direction of the same connection
+func sameRepeat(a, b *CapturedPacket) bool {
+	return a.Hash == b.Hash && a.ConnID == b.ConnID && a.Direction == b.Direction
+}
+
+// renderCaptures converts captures to their JSON form, optionally collapsing
+// consecutive identical payloads sent the same way on the same connection
+// into a repeat count
+func renderCaptures(captures []*CapturedPacket, dedup bool, layout hexDumpLayout) []map[string]interface{} {
+	captureData := make([]map[string]interface{}, 0, len(captures))
+
+	for i, capture := range captures {
+		if dedup && i > 0 && sameRepeat(captures[i-1], capture) {
+			last := captureData[len(captureData)-1]
+			last["repeat_count"] = last["repeat_count"].(int) + 1
+			continue
+		}
+
+		entry := map[string]interface{}{
+			"seq":               capture.Seq,
+			"timestamp":         formatTimestamp(capture.Timestamp),
+			"conn_id":           capture.ConnID,
+			"direction":         capture.Direction,
+			"bytes":             capture.Bytes,
+			"stream_offset":     capture.StreamOffset,
+			"hex_dump":          capture.FormatHexDump(layout),
+			"ascii_strings":     capture.AsciiStrings(),
+			"detected_protocol": capture.DetectedProtocol,
+			"hash":              capture.Hash,
+		}
+		if capture.Src != "" {
+			entry["src"] = capture.Src
+			entry["dst"] = capture.Dst
+		}
+		if capture.Injected {
+			entry["injected"] = true
+		}
+		if capture.PossibleRetry {
+			entry["possible_retry"] = true
+		}
+		if capture.TraceID != "" {
+			entry["trace_id"] = capture.TraceID
+		}
+		if len(capture.HTTPContentLengths) > 0 {
+			entry["http_content_lengths"] = capture.HTTPContentLengths
+		}
+		if capture.BodyFiltered {
+			entry["body_filtered"] = true
+		}
+		if capture.Truncated {
+			entry["truncated"] = true
+		}
+		if capture.Truncated || capture.BodyFiltered {
+			entry["stored_bytes"] = len(capture.RawData)
+		}
+		if len(capture.HTTP2Frames) > 0 {
+			entry["http2_frames"] = capture.HTTP2Frames
+		}
+		if len(capture.TLSRecords) > 0 {
+			entry["tls_records"] = capture.TLSRecords
+		}
+		if dedup {
+			entry["repeat_count"] = 1
+		}
+		captureData = append(captureData, entry)
+	}
+
+	return captureData
+}
+
 // StopProxyHandler handles the stop_proxy tool
 type StopProxyHandler struct {
 	manager *ProxyManager
@@ -190,8 +632,20 @@ func (h *StopProxyHandler) Execute(ctx context.Context, request mcp.CallToolRequ
 		return nil, fmt.Errorf("listen_port is required")
 	}
 
-	// Stop the proxy
+	// Get ignore_missing (optional, default: false)
+	ignoreMissing, _ := args["ignore_missing"].(bool)
+
+	// Stop the proxy; StopProxy only fails when no proxy is running
 	bytesCaptured, err := h.manager.StopProxy(listenPort)
+	if err != nil && ignoreMissing {
+		result := map[string]interface{}{
+			"status":      "not_running",
+			"listen_port": listenPort,
+			"was_running": false,
+		}
+		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
 	if err != nil {
 		result := map[string]interface{}{
 			"error": err.Error(),
@@ -204,12 +658,70 @@ func (h *StopProxyHandler) Execute(ctx context.Context, request mcp.CallToolRequ
 	result := map[string]interface{}{
 		"status":         "stopped",
 		"listen_port":    listenPort,
+		"was_running":    true,
 		"bytes_captured": bytesCaptured,
 	}
 	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
 	return mcp.NewToolResultText(string(jsonBytes)), nil
 }
 
+// StopProxiesHandler handles the stop_proxies tool
+type StopProxiesHandler struct {
+	manager *ProxyManager
+}
+
+// NewStopProxiesHandler creates a new stop proxies handler
+func NewStopProxiesHandler(manager *ProxyManager) *StopProxiesHandler {
+	return &StopProxiesHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *StopProxiesHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get label (required)
+	label, ok := getString(args, "label")
+	if !ok || label == "" {
+		return nil, fmt.Errorf("label is required")
+	}
+
+	stopped := h.manager.StopProxiesByLabel(label)
+
+	ports := make([]int, 0, len(stopped))
+	for port := range stopped {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+
+	stoppedList := make([]map[string]interface{}, 0, len(ports))
+	for _, port := range ports {
+		stoppedList = append(stoppedList, map[string]interface{}{
+			"listen_port":    port,
+			"bytes_captured": stopped[port],
+		})
+	}
+
+	result := map[string]interface{}{
+		"status":  "stopped",
+		"label":   label,
+		"stopped": stoppedList,
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// listProxiesSortKeys are the metrics list_proxies can sort by
+var listProxiesSortKeys = map[string]bool{
+	"bytes":        true,
+	"connections":  true,
+	"buffer_usage": true,
+	"uptime":       true,
+}
+
 // ListProxiesHandler handles the list_proxies tool
 type ListProxiesHandler struct {
 	manager *ProxyManager
@@ -222,32 +734,178 @@ func NewListProxiesHandler(manager *ProxyManager) *ListProxiesHandler {
 
 // Execute implements the tool handler
 func (h *ListProxiesHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	proxies := h.manager.GetAllProxies()
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		args = make(map[string]interface{}) // Empty args is valid
+	}
+
+	// Filter by label (optional)
+	var proxies []*ProxyInstance
+	if label, ok := getString(args, "label"); ok && label != "" {
+		proxies = h.manager.GetProxiesByLabel(label)
+	} else {
+		proxies = h.manager.GetAllProxies()
+	}
+
+	// Get sort metric (optional, default: unsorted)
+	sortBy, _ := getString(args, "sort_by")
+	if sortBy != "" && !listProxiesSortKeys[sortBy] {
+		return nil, fmt.Errorf("invalid sort_by %q (expected bytes, connections, buffer_usage or uptime)", sortBy)
+	}
+
+	// Get limit (optional, default: all)
+	limit, _ := getInt(args, "limit")
 
 	proxyList := make([]map[string]interface{}, 0, len(proxies))
+	sortKeys := make([]float64, 0, len(proxies))
 
 	for _, proxy := range proxies {
 		// Get stats
 		proxy.Stats.mu.RLock()
 		bytesCaptured := proxy.Stats.BytesCaptured
 		totalConnections := proxy.Stats.Connections
+		sampledOut := proxy.Stats.SampledOut
+		rateLimited := proxy.Stats.RateLimited
+		binarySkipped := proxy.Stats.BinarySkipped
+		noMatchSkipped := proxy.Stats.NoMatchSkipped
+		laterSkipped := proxy.Stats.LaterPacketsSkipped
+		nonErrorSkipped := proxy.Stats.NonErrorSkipped
+		heldDropped := proxy.Stats.HeldDropped
+		rejected := proxy.Stats.Rejected
+		mirrorFailures := proxy.Stats.MirrorFailures
+		budgetDropped := proxy.Stats.BudgetDropped
+		watermarkDropped := proxy.Stats.WatermarkDropped
+		policyDropped := proxy.Stats.PolicyDropped
+		excluded := proxy.Stats.Excluded
+		webhookSent := proxy.Stats.WebhookSent
+		webhookFailures := proxy.Stats.WebhookFailures
+		webhookDropped := proxy.Stats.WebhookDropped
+		acceptsQueued := proxy.Stats.AcceptsQueued
+		statsResetAt := proxy.Stats.ResetAt
 		proxy.Stats.mu.RUnlock()
 
 		activeConnections := proxy.GetConnectionCount()
 		_, _, usage := proxy.Buffer.GetStats()
 
 		proxyInfo := map[string]interface{}{
-			"listen_port":        proxy.ListenPort,
-			"forward_to":         fmt.Sprintf("%s:%d", proxy.ForwardHost, proxy.ForwardPort),
-			"status":             "running",
-			"active_connections": activeConnections,
-			"total_connections":  totalConnections,
-			"bytes_captured":     bytesCaptured,
-			"buffer_usage":       fmt.Sprintf("%.1f%%", usage),
-			"started_at":         proxy.StartedAt.Format("2006-01-02T15:04:05.000Z"),
+			"listen_port":                proxy.ListenPort,
+			"forward_to":                 fmt.Sprintf("%s:%d", proxy.ForwardHost, proxy.ForwardPort),
+			"status":                     "running",
+			"label":                      proxy.Label(),
+			"tags":                       proxy.Tags(),
+			"capture_enabled":            proxy.CaptureEnabled(),
+			"tcp_nodelay":                !proxy.Options.TCPNagle,
+			"listen_network":             proxy.Options.socketNetwork(),
+			"active_connections":         activeConnections,
+			"active_goroutines":          proxy.GetGoroutineCount(),
+			"total_connections":          totalConnections,
+			"rejected_connections":       rejected,
+			"max_concurrent_connections": cap(proxy.handlerSlots),
+			"bytes_captured":             bytesCaptured,
+			"buffer_usage":               fmt.Sprintf("%.1f%%", usage),
+			"capture_limit":              proxy.CaptureLimit,
+			"capture_limit_human":        formatSize(proxy.CaptureLimit),
+			"started_at":                 formatTimestamp(proxy.StartedAt),
+		}
+		if !statsResetAt.IsZero() {
+			proxyInfo["stats_reset_at"] = formatTimestamp(statsResetAt)
+		}
+		if proxy.Options.AdaptiveSampling {
+			proxyInfo["captures_sampled_out"] = sampledOut
+		}
+		if proxy.Options.MaxCapturesPerSec > 0 {
+			proxyInfo["max_captures_per_sec"] = proxy.Options.MaxCapturesPerSec
+			proxyInfo["captures_rate_limited"] = rateLimited
+		}
+		if proxy.Options.TextOnly {
+			proxyInfo["captures_binary_skipped"] = binarySkipped
+		}
+		if proxy.Options.IngestContains != "" {
+			proxyInfo["ingest_contains"] = proxy.Options.IngestContains
+			proxyInfo["captures_no_match_skipped"] = noMatchSkipped
+		}
+		if proxy.Options.FirstPacketOnly {
+			proxyInfo["captures_after_first_skipped"] = laterSkipped
+		}
+		if proxy.Options.HTTPErrorsOnly {
+			proxyInfo["http_capture_errors_only"] = true
+			proxyInfo["captures_non_error_skipped"] = nonErrorSkipped
+			proxyInfo["captures_held_dropped"] = heldDropped
+		}
+		if proxy.Options.MirrorTarget != "" {
+			proxyInfo["mirror_target"] = proxy.Options.MirrorTarget
+			proxyInfo["mirror_failures"] = mirrorFailures
+		}
+		if budgetDropped > 0 {
+			proxyInfo["captures_budget_dropped"] = budgetDropped
+		}
+		if proxy.Options.DisableDetection {
+			proxyInfo["detect_protocol"] = false
+		}
+		if acceptsQueued > 0 {
+			proxyInfo["accepts_queued"] = acceptsQueued
+		}
+		if proxy.Options.EvictionPolicy != "" && proxy.Options.EvictionPolicy != EvictFIFO {
+			proxyInfo["eviction_policy"] = proxy.Options.EvictionPolicy
+			proxyInfo["captures_policy_dropped"] = policyDropped
+		}
+		if proxy.Options.RetainSeconds > 0 {
+			proxyInfo["retain_seconds"] = proxy.Options.RetainSeconds
+		}
+		if proxy.Options.StopCaptureAtPercent > 0 {
+			proxyInfo["stop_capture_at_percent"] = proxy.Options.StopCaptureAtPercent
+			proxyInfo["captures_watermark_dropped"] = watermarkDropped
+		}
+		if len(proxy.Options.ForwardTargets) > 0 {
+			proxyInfo["forward_targets"] = proxy.targetDistribution()
+		}
+		if len(proxy.Options.ExcludeCIDRs) > 0 {
+			proxyInfo["exclude_cidrs"] = proxy.Options.ExcludeCIDRs
+			proxyInfo["excluded_connections"] = excluded
+		}
+		if proxy.Options.bridged() {
+			proxyInfo["listen_protocol"] = proxy.Options.ListenProtocol
+			proxyInfo["forward_protocol"] = proxy.Options.ForwardProtocol
+		}
+		if proxy.Options.WebhookURL != "" {
+			proxyInfo["webhook_url"] = proxy.Options.WebhookURL
+			proxyInfo["webhook_pattern"] = proxy.Options.WebhookPattern
+			proxyInfo["webhooks_sent"] = webhookSent
+			proxyInfo["webhook_failures"] = webhookFailures
+			proxyInfo["webhooks_dropped"] = webhookDropped
 		}
 
 		proxyList = append(proxyList, proxyInfo)
+		switch sortBy {
+		case "bytes":
+			sortKeys = append(sortKeys, float64(bytesCaptured))
+		case "connections":
+			sortKeys = append(sortKeys, float64(totalConnections))
+		case "buffer_usage":
+			sortKeys = append(sortKeys, usage)
+		case "uptime":
+			sortKeys = append(sortKeys, float64(time.Since(proxy.StartedAt)))
+		}
+	}
+
+	// Highest first, so limit keeps the busiest or longest running
+	if sortBy != "" {
+		order := make([]int, len(proxyList))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			return sortKeys[order[a]] > sortKeys[order[b]]
+		})
+		sorted := make([]map[string]interface{}, len(proxyList))
+		for i, j := range order {
+			sorted[i] = proxyList[j]
+		}
+		proxyList = sorted
+	}
+	if limit > 0 && limit < len(proxyList) {
+		proxyList = proxyList[:limit]
 	}
 
 	result := map[string]interface{}{
@@ -258,10 +916,1946 @@ func (h *ListProxiesHandler) Execute(ctx context.Context, request mcp.CallToolRe
 	return mcp.NewToolResultText(string(jsonBytes)), nil
 }
 
-// Helper functions to extract typed values from arguments
+// ListCaptureFilesHandler handles the list_capture_files tool
+type ListCaptureFilesHandler struct {
+	manager *ProxyManager
+}
 
-func getInt(args map[string]interface{}, key string) (int, bool) {
-	val, exists := args[key]
+// NewListCaptureFilesHandler creates a new list capture files handler
+func NewListCaptureFilesHandler(manager *ProxyManager) *ListCaptureFilesHandler {
+	return &ListCaptureFilesHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *ListCaptureFilesHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	if proxy.Files == nil {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("proxy on port %d was not started with capture_dir", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	result := map[string]interface{}{
+		"listen_port": listenPort,
+		"capture_dir": proxy.Options.CaptureDir,
+		"files":       proxy.Files.Files(),
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// ToCurlHandler handles the to_curl tool
+type ToCurlHandler struct {
+	manager *ProxyManager
+}
+
+// NewToCurlHandler creates a new to curl handler
+func NewToCurlHandler(manager *ProxyManager) *ToCurlHandler {
+	return &ToCurlHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *ToCurlHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	// Get capture sequence number (required)
+	seq, ok := getInt(args, "seq")
+	if !ok {
+		return nil, fmt.Errorf("seq is required")
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	req, body, err := requestAtSeq(proxy.Buffer.GetAll(), uint64(seq))
+	if err != nil {
+		result := map[string]interface{}{
+			"error": err.Error(),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	result := map[string]interface{}{
+		"listen_port": listenPort,
+		"seq":         seq,
+		"curl":        buildCurlCommand(req, body, net.JoinHostPort(proxy.ForwardHost, strconv.Itoa(proxy.ForwardPort))),
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// DecodeCaptureHandler handles the decode_capture tool
+type DecodeCaptureHandler struct {
+	manager *ProxyManager
+}
+
+// NewDecodeCaptureHandler creates a new decode capture handler
+func NewDecodeCaptureHandler(manager *ProxyManager) *DecodeCaptureHandler {
+	return &DecodeCaptureHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *DecodeCaptureHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	// Get capture sequence number (required)
+	seq, ok := getInt(args, "seq")
+	if !ok {
+		return nil, fmt.Errorf("seq is required")
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	capture, err := captureAtSeq(proxy.Buffer.GetAll(), uint64(seq))
+	if err != nil {
+		result := map[string]interface{}{
+			"error": err.Error(),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	jsonBytes, _ := json.MarshalIndent(decodeCapture(capture), "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// ExportHexStreamHandler handles the export_hexstream tool
+type ExportHexStreamHandler struct {
+	manager *ProxyManager
+}
+
+// NewExportHexStreamHandler creates a new export hex stream handler
+func NewExportHexStreamHandler(manager *ProxyManager) *ExportHexStreamHandler {
+	return &ExportHexStreamHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *ExportHexStreamHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	// Get connection filter (optional, default: all connections)
+	connID, hasConnID := getInt(args, "conn_id")
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	captures := proxy.Buffer.GetAll()
+	if hasConnID {
+		captures = connCaptures(captures, uint64(connID))
+	}
+
+	hexStream, packets := buildHexStream(captures)
+	result := map[string]interface{}{
+		"listen_port": listenPort,
+		"packets":     packets,
+		"hexstream":   hexStream,
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// InjectBytesHandler handles the inject_bytes tool
+type InjectBytesHandler struct {
+	manager *ProxyManager
+}
+
+// NewInjectBytesHandler creates a new inject bytes handler
+func NewInjectBytesHandler(manager *ProxyManager) *InjectBytesHandler {
+	return &InjectBytesHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *InjectBytesHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	// Get connection ID (required)
+	connID, ok := getInt(args, "conn_id")
+	if !ok {
+		return nil, fmt.Errorf("conn_id is required")
+	}
+
+	// Get direction (required)
+	direction, ok := getString(args, "direction")
+	if !ok {
+		return nil, fmt.Errorf("direction is required")
+	}
+
+	// Get base64 payload (required)
+	encoded, ok := getString(args, "data")
+	if !ok {
+		return nil, fmt.Errorf("data is required")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("data is not valid base64: %v", err),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	if err := proxy.InjectBytes(uint64(connID), direction, data); err != nil {
+		result := map[string]interface{}{
+			"error": err.Error(),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	result := map[string]interface{}{
+		"status":      "injected",
+		"listen_port": listenPort,
+		"conn_id":     connID,
+		"direction":   direction,
+		"bytes":       len(data),
+		"warning":     "injected bytes are not part of the original stream and may corrupt the protocol state of both peers",
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// SetCaptureHandler handles the set_capture tool
+type SetCaptureHandler struct {
+	manager *ProxyManager
+}
+
+// NewSetCaptureHandler creates a new set capture handler
+func NewSetCaptureHandler(manager *ProxyManager) *SetCaptureHandler {
+	return &SetCaptureHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *SetCaptureHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	// Get enabled flag (required)
+	enabled, ok := args["enabled"].(bool)
+	if !ok {
+		return nil, fmt.Errorf("enabled is required")
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	if err := proxy.SetCaptureEnabled(enabled); err != nil {
+		result := map[string]interface{}{
+			"error": err.Error(),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	result := map[string]interface{}{
+		"listen_port":     listenPort,
+		"capture_enabled": enabled,
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// ReloadConfigHandler handles the reload_config tool
+type ReloadConfigHandler struct {
+	config *ConfigManager // nil when the server was started without --config
+}
+
+// NewReloadConfigHandler creates a new reload config handler
+func NewReloadConfigHandler(config *ConfigManager) *ReloadConfigHandler {
+	return &ReloadConfigHandler{config: config}
+}
+
+// Execute implements the tool handler
+func (h *ReloadConfigHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if h.config == nil {
+		result := map[string]interface{}{
+			"error": "no config file loaded (start the server with --config)",
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	diff, err := h.config.Apply()
+	if err != nil {
+		result := map[string]interface{}{
+			"error": err.Error(),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	result := map[string]interface{}{
+		"config":    h.config.Path(),
+		"started":   diff.Started,
+		"stopped":   diff.Stopped,
+		"updated":   diff.Updated,
+		"restarted": diff.Restarted,
+		"unchanged": diff.Unchanged,
+	}
+	if len(diff.Errors) > 0 {
+		result["errors"] = diff.Errors
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// QueryCapturesHandler handles the query_captures tool
+type QueryCapturesHandler struct {
+	manager *ProxyManager
+}
+
+// NewQueryCapturesHandler creates a new query captures handler
+func NewQueryCapturesHandler(manager *ProxyManager) *QueryCapturesHandler {
+	return &QueryCapturesHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *QueryCapturesHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	// Get query (required)
+	queryText, ok := getString(args, "query")
+	if !ok {
+		return nil, fmt.Errorf("query is required")
+	}
+
+	query, err := parseCaptureQuery(queryText)
+	if err != nil {
+		result := map[string]interface{}{
+			"error": err.Error(),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	// Querying never clears the buffer
+	matched := query.run(proxy.Buffer.GetAll())
+
+	result := map[string]interface{}{
+		"listen_port": listenPort,
+		"query":       queryText,
+		"matches":     len(matched),
+		"captures":    renderCaptures(matched, false, defaultHexDump),
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// SnapshotCapturesHandler handles the snapshot_captures tool
+type SnapshotCapturesHandler struct {
+	manager   *ProxyManager
+	snapshots *SnapshotStore
+}
+
+// NewSnapshotCapturesHandler creates a new snapshot captures handler
+func NewSnapshotCapturesHandler(manager *ProxyManager, snapshots *SnapshotStore) *SnapshotCapturesHandler {
+	return &SnapshotCapturesHandler{manager: manager, snapshots: snapshots}
+}
+
+// Execute implements the tool handler
+func (h *SnapshotCapturesHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get pagination (optional, default: everything)
+	offset, _ := getInt(args, "offset")
+	limit, _ := getInt(args, "limit")
+
+	// Read an existing snapshot, or take a new one of listen_port
+	var snapshot *CaptureSnapshot
+	if id, ok := getString(args, "snapshot_id"); ok && id != "" {
+		existing, exists := h.snapshots.Get(id)
+		if !exists {
+			result := map[string]interface{}{
+				"error": fmt.Sprintf("no snapshot with id %q", id),
+			}
+			jsonBytes, _ := json.Marshal(result)
+			return mcp.NewToolResultText(string(jsonBytes)), nil
+		}
+		snapshot = existing
+	} else {
+		listenPort, ok := getInt(args, "listen_port")
+		if !ok {
+			return nil, fmt.Errorf("listen_port or snapshot_id is required")
+		}
+
+		// Get clear_buffer flag (optional, default: false)
+		clearBuffer, _ := args["clear_buffer"].(bool)
+
+		proxy, exists := h.manager.GetProxy(listenPort)
+		if !exists {
+			result := map[string]interface{}{
+				"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+			}
+			jsonBytes, _ := json.Marshal(result)
+			return mcp.NewToolResultText(string(jsonBytes)), nil
+		}
+		snapshot = h.snapshots.Take(proxy, clearBuffer)
+	}
+
+	result := map[string]interface{}{
+		"snapshot_id":    snapshot.ID,
+		"listen_port":    snapshot.ListenPort,
+		"created_at":     formatTimestamp(snapshot.CreatedAt),
+		"total_captures": len(snapshot.Captures),
+		"captures":       renderCaptures(paginate(snapshot.Captures, "asc", offset, limit), false, defaultHexDump),
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// ClearSnapshotHandler handles the clear_snapshot tool
+type ClearSnapshotHandler struct {
+	snapshots *SnapshotStore
+}
+
+// NewClearSnapshotHandler creates a new clear snapshot handler
+func NewClearSnapshotHandler(snapshots *SnapshotStore) *ClearSnapshotHandler {
+	return &ClearSnapshotHandler{snapshots: snapshots}
+}
+
+// Execute implements the tool handler
+func (h *ClearSnapshotHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get snapshot ID (required)
+	id, ok := getString(args, "snapshot_id")
+	if !ok {
+		return nil, fmt.Errorf("snapshot_id is required")
+	}
+
+	released, err := h.snapshots.Remove(id)
+	if err != nil {
+		result := map[string]interface{}{
+			"error": err.Error(),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	result := map[string]interface{}{
+		"snapshot_id":       id,
+		"status":            "cleared",
+		"released_captures": released,
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// ProbeBackendHandler handles the probe_backend tool
+type ProbeBackendHandler struct {
+	manager *ProxyManager
+}
+
+// NewProbeBackendHandler creates a new probe backend handler
+func NewProbeBackendHandler(manager *ProxyManager) *ProbeBackendHandler {
+	return &ProbeBackendHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *ProbeBackendHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		args = make(map[string]interface{}) // Empty args fail below with a clear message
+	}
+
+	// Get the target: a proxy's backend, or host/port
+	var host string
+	var port int
+	if listenPort, ok := getInt(args, "listen_port"); ok {
+		proxy, exists := h.manager.GetProxy(listenPort)
+		if !exists {
+			result := map[string]interface{}{
+				"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+			}
+			jsonBytes, _ := json.Marshal(result)
+			return mcp.NewToolResultText(string(jsonBytes)), nil
+		}
+		host, port = proxy.ForwardHost, proxy.ForwardPort
+	} else {
+		host, _ = getString(args, "host")
+		if host == "" {
+			host = "localhost"
+		}
+		if port, ok = getInt(args, "port"); !ok {
+			return nil, fmt.Errorf("port or listen_port is required")
+		}
+	}
+
+	// Get number of dials (optional, default: 5)
+	count, ok := getInt(args, "count")
+	if !ok {
+		count = defaultProbeCount
+	}
+	if count < 1 || count > maxProbeCount {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("count must be between 1 and %d", maxProbeCount),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	// Get per-dial timeout (optional, default: 2s)
+	timeout := defaultProbeTimeout
+	if timeoutMs, ok := getInt(args, "timeout_ms"); ok && timeoutMs > 0 {
+		timeout = time.Duration(timeoutMs) * time.Millisecond
+	}
+
+	result := probeBackend(ctx, net.JoinHostPort(host, strconv.Itoa(port)), count, timeout)
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// ExportSessionsHandler handles the export_sessions tool
+type ExportSessionsHandler struct {
+	manager *ProxyManager
+}
+
+// NewExportSessionsHandler creates a new export sessions handler
+func NewExportSessionsHandler(manager *ProxyManager) *ExportSessionsHandler {
+	return &ExportSessionsHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *ExportSessionsHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	// Get output directory (required)
+	dir, ok := getString(args, "dir")
+	if !ok || dir == "" {
+		return nil, fmt.Errorf("dir is required")
+	}
+
+	// Get connection filter (optional, default: all connections)
+	connID, hasConnID := getInt(args, "conn_id")
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	captures := proxy.Buffer.GetAll()
+	if hasConnID {
+		captures = connCaptures(captures, uint64(connID))
+	}
+
+	files, err := exportSessions(proxy, captures, dir)
+	if err != nil {
+		result := map[string]interface{}{
+			"error": err.Error(),
+			"files": files,
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	result := map[string]interface{}{
+		"listen_port": listenPort,
+		"dir":         dir,
+		"sessions":    len(files) / 3,
+		"files":       files,
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// GetConnectionHandler handles the get_connection tool
+type GetConnectionHandler struct {
+	manager *ProxyManager
+}
+
+// NewGetConnectionHandler creates a new get connection handler
+func NewGetConnectionHandler(manager *ProxyManager) *GetConnectionHandler {
+	return &GetConnectionHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *GetConnectionHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	// Get connection ID (required)
+	connID, ok := getInt(args, "conn_id")
+	if !ok {
+		return nil, fmt.Errorf("conn_id is required")
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	detail, err := connectionDetail(proxy, uint64(connID))
+	if err != nil {
+		result := map[string]interface{}{
+			"error": err.Error(),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	jsonBytes, _ := json.MarshalIndent(detail, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// GetTailHandler handles the get_tail tool
+type GetTailHandler struct {
+	manager *ProxyManager
+}
+
+// NewGetTailHandler creates a new get tail handler
+func NewGetTailHandler(manager *ProxyManager) *GetTailHandler {
+	return &GetTailHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *GetTailHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	// Get tail length (optional, default: 1KB, at most 64KB)
+	n, ok := getInt(args, "bytes")
+	if !ok {
+		n = defaultTailBytes
+	}
+	if n <= 0 || n > maxConnectionTextBytes {
+		return nil, fmt.Errorf("bytes must be between 1 and %d", maxConnectionTextBytes)
+	}
+
+	// Get direction (optional, default: both interleaved)
+	direction, _ := getString(args, "direction")
+	if direction != "" && direction != DirectionClientToServer && direction != DirectionServerToClient {
+		return nil, fmt.Errorf("invalid direction %q (expected %q or %q)", direction, DirectionClientToServer, DirectionServerToClient)
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	captures := proxy.Buffer.GetAll()
+
+	// Get connection filter (optional)
+	connID, filterConn := getInt(args, "conn_id")
+	if filterConn {
+		captures = connCaptures(captures, uint64(connID))
+	}
+
+	tail, used, incomplete := captureTail(captures, direction, n)
+	text, _ := printableText(tail)
+
+	result := map[string]interface{}{
+		"listen_port": listenPort,
+		"bytes":       len(tail),
+		"captures":    used,
+		"text":        text,
+		"base64":      base64.StdEncoding.EncodeToString(tail),
+	}
+	if filterConn {
+		result["conn_id"] = connID
+	}
+	if direction != "" {
+		result["direction"] = direction
+	}
+	if incomplete {
+		result["incomplete"] = true
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// GetMemoryUsageHandler handles the get_memory_usage tool
+type GetMemoryUsageHandler struct {
+	manager *ProxyManager
+}
+
+// NewGetMemoryUsageHandler creates a new get memory usage handler
+func NewGetMemoryUsageHandler(manager *ProxyManager) *GetMemoryUsageHandler {
+	return &GetMemoryUsageHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *GetMemoryUsageHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	usage := proxy.Buffer.MemoryUsage()
+	result := map[string]interface{}{
+		"listen_port":         listenPort,
+		"capture_limit":       proxy.CaptureLimit,
+		"capture_limit_human": formatSize(proxy.CaptureLimit),
+		"usage":               usage,
+		"total_human":         formatSize(usage.TotalBytes),
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// GetCaptureBudgetHandler handles the get_capture_budget tool
+type GetCaptureBudgetHandler struct {
+	manager *ProxyManager
+}
+
+// NewGetCaptureBudgetHandler creates a new get capture budget handler
+func NewGetCaptureBudgetHandler(manager *ProxyManager) *GetCaptureBudgetHandler {
+	return &GetCaptureBudgetHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *GetCaptureBudgetHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	limit, used, dropped, ok := h.manager.CaptureBudget()
+	if !ok {
+		result := map[string]interface{}{
+			"enabled": false,
+		}
+		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	proxies := make(map[string]int)
+	for _, proxy := range h.manager.GetAllProxies() {
+		_, bytes, _ := proxy.Buffer.GetStats()
+		proxies[strconv.Itoa(proxy.ListenPort)] = bytes
+	}
+
+	result := map[string]interface{}{
+		"enabled":          true,
+		"max_bytes":        limit,
+		"max_human":        formatSize(int(limit)),
+		"used_bytes":       used,
+		"remaining_bytes":  limit - used,
+		"remaining_human":  formatSize(int(limit - used)),
+		"captures_dropped": dropped,
+		"proxies":          proxies,
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// GetConnectionsHandler handles the get_connections tool
+type GetConnectionsHandler struct {
+	manager *ProxyManager
+}
+
+// NewGetConnectionsHandler creates a new get connections handler
+func NewGetConnectionsHandler(manager *ProxyManager) *GetConnectionsHandler {
+	return &GetConnectionsHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *GetConnectionsHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	// Get state filter (optional, default: all)
+	state, _ := getString(args, "state")
+	if state != "" && state != "open" && state != "closed" {
+		return nil, fmt.Errorf("invalid state %q (expected open or closed)", state)
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	connections := make([]map[string]interface{}, 0)
+	for _, info := range proxy.Connections() {
+		closed := !info.ClosedAt.IsZero()
+		if (state == "open" && closed) || (state == "closed" && !closed) {
+			continue
+		}
+		entry := map[string]interface{}{
+			"conn_id":                info.ID,
+			"state":                  "open",
+			"client_addr":            info.ClientAddr,
+			"local_addr":             info.LocalAddr,
+			"backend_addr":           info.BackendAddr,
+			"backend_local_addr":     info.BackendLocalAddr,
+			"opened_at":              formatTimestamp(info.OpenedAt),
+			"client_to_server_bytes": info.ClientToServerBytes,
+			"server_to_client_bytes": info.ServerToClientBytes,
+		}
+		if closed {
+			entry["state"] = "closed"
+			entry["closed_at"] = formatTimestamp(info.ClosedAt)
+			entry["close_reason"] = info.CloseReason
+		}
+		if info.ShortWrites > 0 {
+			entry["short_writes"] = info.ShortWrites
+		}
+		if info.BackendRTTMs != nil {
+			entry["backend_rtt_ms"] = *info.BackendRTTMs
+		}
+		if info.TLS {
+			entry["tls_handshake_ms"] = info.TLSHandshakeMs // null until the handshake completes
+		}
+		if info.TLSALPN != "" {
+			entry["tls_negotiated_alpn"] = info.TLSALPN
+		}
+		connections = append(connections, entry)
+	}
+
+	result := map[string]interface{}{
+		"listen_port": listenPort,
+		"connections": connections,
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// TraceRequestsHandler handles the trace_requests tool
+type TraceRequestsHandler struct {
+	manager *ProxyManager
+}
+
+// NewTraceRequestsHandler creates a new trace requests handler
+func NewTraceRequestsHandler(manager *ProxyManager) *TraceRequestsHandler {
+	return &TraceRequestsHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *TraceRequestsHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		args = make(map[string]interface{}) // Empty args is valid
+	}
+
+	// Get trace ID filter (optional)
+	traceID, _ := getString(args, "trace_id")
+
+	traces := collectTraces(h.manager.GetAllProxies(), traceID)
+	result := map[string]interface{}{
+		"total_traces": len(traces),
+		"traces":       traces,
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// WaitForHandler handles the wait_for tool
+type WaitForHandler struct {
+	manager *ProxyManager
+}
+
+// NewWaitForHandler creates a new wait for handler
+func NewWaitForHandler(manager *ProxyManager) *WaitForHandler {
+	return &WaitForHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *WaitForHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	// Get conditions (at least one required)
+	var cond waitCondition
+	minBytes, _ := getInt(args, "min_bytes")
+	cond.minBytes = int64(minBytes)
+	minConnections, _ := getInt(args, "min_connections")
+	cond.minConnections = int64(minConnections)
+	if pattern, _ := getString(args, "pattern"); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %v", err)
+		}
+		cond.pattern = re
+	}
+	if cond.minBytes <= 0 && cond.minConnections <= 0 && cond.pattern == nil {
+		return nil, fmt.Errorf("one of min_bytes, min_connections or pattern is required")
+	}
+
+	// Get timeout (optional, default: 10s)
+	timeout := defaultWaitTimeout
+	if timeoutMs, ok := getInt(args, "timeout_ms"); ok && timeoutMs > 0 {
+		timeout = time.Duration(timeoutMs) * time.Millisecond
+	}
+	if timeout > maxWaitTimeout {
+		timeout = maxWaitTimeout
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	waited := proxy.waitFor(waitCtx, cond, waitProgress(ctx, request, cond))
+
+	result := map[string]interface{}{
+		"listen_port":    listenPort,
+		"satisfied":      waited.satisfied,
+		"waited_ms":      time.Since(start).Milliseconds(),
+		"bytes_captured": waited.bytes,
+		"connections":    waited.connections,
+	}
+	if waited.matchedSeq != 0 {
+		result["matched_seq"] = waited.matchedSeq
+	}
+	if !waited.satisfied && proxy.ctx.Err() != nil {
+		result["error"] = fmt.Sprintf("proxy on port %d stopped", listenPort)
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// CompareToBaselineHandler handles the compare_to_baseline tool
+type CompareToBaselineHandler struct {
+	manager *ProxyManager
+}
+
+// NewCompareToBaselineHandler creates a new compare to baseline handler
+func NewCompareToBaselineHandler(manager *ProxyManager) *CompareToBaselineHandler {
+	return &CompareToBaselineHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *CompareToBaselineHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	// Get baseline path (required)
+	baselinePath, ok := getString(args, "baseline")
+	if !ok || baselinePath == "" {
+		return nil, fmt.Errorf("baseline is required")
+	}
+
+	// Get mode (optional, default: stream)
+	mode := "stream"
+	if m, ok := getString(args, "mode"); ok && m != "" {
+		mode = m
+	}
+	if mode != "stream" && mode != "http" {
+		return nil, fmt.Errorf("mode must be one of: stream, http")
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	baseline, err := loadCaptureFile(baselinePath)
+	if err != nil {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("failed to load baseline: %v", err),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	live := proxy.Buffer.GetAll()
+	var mismatches []baselineMismatch
+	if mode == "http" {
+		mismatches = compareHTTP(baseline, live)
+	} else {
+		mismatches = compareStreams(baseline, live)
+	}
+
+	result := map[string]interface{}{
+		"listen_port":       listenPort,
+		"baseline":          baselinePath,
+		"mode":              mode,
+		"match":             len(mismatches) == 0,
+		"baseline_captures": len(baseline),
+		"live_captures":     len(live),
+		"mismatch_count":    len(mismatches),
+	}
+	if len(mismatches) > maxBaselineMismatches {
+		mismatches = mismatches[:maxBaselineMismatches]
+	}
+	if len(mismatches) > 0 {
+		result["mismatches"] = mismatches
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// GetGlobalStatsHandler handles the get_global_stats tool
+type GetGlobalStatsHandler struct {
+	manager *ProxyManager
+}
+
+// NewGetGlobalStatsHandler creates a new get global stats handler
+func NewGetGlobalStatsHandler(manager *ProxyManager) *GetGlobalStatsHandler {
+	return &GetGlobalStatsHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *GetGlobalStatsHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	proxies := h.manager.GetAllProxies()
+
+	var bytesCaptured, totalConnections int64
+	var activeConnections, bufferPackets, bufferBytes, captureLimit int
+	for _, proxy := range proxies {
+		proxy.Stats.mu.RLock()
+		bytesCaptured += proxy.Stats.BytesCaptured
+		totalConnections += proxy.Stats.Connections
+		proxy.Stats.mu.RUnlock()
+
+		activeConnections += proxy.GetConnectionCount()
+		packets, bytes, _ := proxy.Buffer.GetStats()
+		bufferPackets += packets
+		bufferBytes += bytes
+		captureLimit += proxy.CaptureLimit
+	}
+
+	result := map[string]interface{}{
+		"proxies":             len(proxies),
+		"bytes_captured":      bytesCaptured,
+		"total_connections":   totalConnections,
+		"active_connections":  activeConnections,
+		"buffer_packets":      bufferPackets,
+		"buffer_bytes":        bufferBytes,
+		"buffer_human":        formatSize(bufferBytes),
+		"capture_limit":       captureLimit,
+		"capture_limit_human": formatSize(captureLimit),
+	}
+	if maxProxies := h.manager.MaxProxies(); maxProxies > 0 {
+		result["max_proxies"] = maxProxies
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// ListProxyHistoryHandler handles the list_proxy_history tool
+type ListProxyHistoryHandler struct {
+	manager *ProxyManager
+}
+
+// NewListProxyHistoryHandler creates a new list proxy history handler
+func NewListProxyHistoryHandler(manager *ProxyManager) *ListProxyHistoryHandler {
+	return &ListProxyHistoryHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *ListProxyHistoryHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		args = make(map[string]interface{}) // Empty args is valid
+	}
+
+	// Get port filter and limit (optional, default: everything)
+	listenPort, hasPort := getInt(args, "listen_port")
+	limit, _ := getInt(args, "limit")
+
+	entries := make([]map[string]interface{}, 0)
+	for _, stopped := range h.manager.History() {
+		if hasPort && stopped.ListenPort != listenPort {
+			continue
+		}
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+		entry := map[string]interface{}{
+			"listen_port":       stopped.ListenPort,
+			"forward_to":        stopped.ForwardTo,
+			"label":             stopped.Label,
+			"bytes_captured":    stopped.BytesCaptured,
+			"total_connections": stopped.TotalConnections,
+			"started_at":        formatTimestamp(stopped.StartedAt),
+			"stopped_at":        formatTimestamp(stopped.StoppedAt),
+			"duration_ms":       stopped.StoppedAt.Sub(stopped.StartedAt).Milliseconds(),
+		}
+		if len(stopped.ForwardTargets) > 0 {
+			entry["forward_targets"] = stopped.ForwardTargets
+		}
+		entries = append(entries, entry)
+	}
+
+	result := map[string]interface{}{
+		"history": entries,
+		"count":   len(entries),
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// CloneProxyHandler handles the clone_proxy tool
+type CloneProxyHandler struct {
+	manager *ProxyManager
+}
+
+// NewCloneProxyHandler creates a new clone proxy handler
+func NewCloneProxyHandler(manager *ProxyManager) *CloneProxyHandler {
+	return &CloneProxyHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *CloneProxyHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get the source and new ports (required)
+	sourcePort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+	newPort, ok := getInt(args, "new_listen_port")
+	if !ok {
+		return nil, fmt.Errorf("new_listen_port is required")
+	}
+
+	clone, err := h.manager.CloneProxy(sourcePort, newPort)
+	if err != nil {
+		result := map[string]interface{}{
+			"error": err.Error(),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	// Return the new proxy's settings
+	result := map[string]interface{}{
+		"status":              "cloned",
+		"cloned_from":         sourcePort,
+		"listen_port":         clone.ListenPort,
+		"forward_to":          fmt.Sprintf("%s:%d", clone.ForwardHost, clone.ForwardPort),
+		"capture_limit":       clone.CaptureLimit,
+		"capture_limit_human": formatSize(clone.CaptureLimit),
+		"capture_enabled":     clone.CaptureEnabled(),
+	}
+	if label := clone.Label(); label != "" {
+		result["label"] = label
+	}
+	if tags := clone.Tags(); len(tags) > 0 {
+		result["tags"] = tags
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// GetSocketInfoHandler handles the get_socket_info tool
+type GetSocketInfoHandler struct {
+	manager *ProxyManager
+}
+
+// NewGetSocketInfoHandler creates a new get socket info handler
+func NewGetSocketInfoHandler(manager *ProxyManager) *GetSocketInfoHandler {
+	return &GetSocketInfoHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *GetSocketInfoHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	// Keepalive as applied to accepted and backend connections
+	keepAlive := map[string]interface{}{
+		"enabled":   proxy.Options.TCPKeepAlive >= 0,
+		"period_ms": defaultKeepAlivePeriod.Milliseconds(),
+	}
+	if proxy.Options.TCPKeepAlive < 0 {
+		keepAlive["period_ms"] = 0
+	} else if proxy.Options.TCPKeepAlive > 0 {
+		keepAlive["period_ms"] = proxy.Options.TCPKeepAlive.Milliseconds()
+	}
+
+	accept := proxy.accepts.info()
+	accept["running"] = proxy.ctx.Err() == nil
+
+	socket, addr := proxy.listeningSocket()
+	result := map[string]interface{}{
+		"listen_port":         listenPort,
+		"network":             proxy.Options.socketNetwork(),
+		"bound_address":       addr.String(),
+		"deadlines_supported": supportsDeadlines(socket),
+		"tcp_keepalive":       keepAlive,
+		"tcp_nodelay":         !proxy.Options.TCPNagle,
+		"accept_loop":         accept,
+	}
+	if options, ok := socketOptions(socket); ok {
+		result["socket_options"] = options
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// PeekCapturesHandler handles the peek_captures tool
+type PeekCapturesHandler struct {
+	output *GetProxyOutputHandler
+}
+
+// NewPeekCapturesHandler creates a new peek captures handler
+func NewPeekCapturesHandler(manager *ProxyManager) *PeekCapturesHandler {
+	return &PeekCapturesHandler{output: NewGetProxyOutputHandler(manager)}
+}
+
+// Execute implements the tool handler. It renders like get_proxy_output but
+// never clears the buffer, whatever clear_buffer says.
+func (h *PeekCapturesHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		args = make(map[string]interface{}) // Empty args is valid
+	}
+
+	// Copy the arguments so the caller's map is left as it was
+	peekArgs := make(map[string]interface{}, len(args)+1)
+	for key, value := range args {
+		peekArgs[key] = value
+	}
+	peekArgs["clear_buffer"] = false
+	request.Params.Arguments = peekArgs
+
+	return h.output.Execute(ctx, request)
+}
+
+// CloseConnectionHandler handles the close_connection tool
+type CloseConnectionHandler struct {
+	manager *ProxyManager
+}
+
+// NewCloseConnectionHandler creates a new close connection handler
+func NewCloseConnectionHandler(manager *ProxyManager) *CloseConnectionHandler {
+	return &CloseConnectionHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *CloseConnectionHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	// Get connection ID (required)
+	connID, ok := getInt(args, "conn_id")
+	if !ok {
+		return nil, fmt.Errorf("conn_id is required")
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	reason, err := proxy.CloseConnection(uint64(connID))
+	if err != nil {
+		result := map[string]interface{}{
+			"error": err.Error(),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	result := map[string]interface{}{
+		"status":       "closed",
+		"listen_port":  listenPort,
+		"conn_id":      connID,
+		"close_reason": reason,
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// ExportCapturesHandler handles the export_captures tool
+type ExportCapturesHandler struct {
+	manager *ProxyManager
+}
+
+// NewExportCapturesHandler creates a new export captures handler
+func NewExportCapturesHandler(manager *ProxyManager) *ExportCapturesHandler {
+	return &ExportCapturesHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *ExportCapturesHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	// Get output path (required)
+	path, ok := getString(args, "path")
+	if !ok || path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	overwrite, _ := args["overwrite"].(bool)
+
+	// The buffer is left as it is
+	captures := proxy.Buffer.GetAll()
+	size, err := exportCaptures(path, captures, overwrite)
+	if err != nil {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("failed to export captures: %v", err),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	result := map[string]interface{}{
+		"status":          "exported",
+		"listen_port":     listenPort,
+		"path":            path,
+		"captures":        len(captures),
+		"file_bytes":      size,
+		"file_size_human": formatSize(int(size)),
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// BenchmarkProxyHandler handles the benchmark_proxy tool
+type BenchmarkProxyHandler struct {
+	manager *ProxyManager
+}
+
+// NewBenchmarkProxyHandler creates a new benchmark proxy handler
+func NewBenchmarkProxyHandler(manager *ProxyManager) *BenchmarkProxyHandler {
+	return &BenchmarkProxyHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *BenchmarkProxyHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	// The direct path must reach the same backend over TCP
+	if proxy.Options.ListenProtocol == ProtocolUDP || proxy.Options.ForwardProtocol == ProtocolUDP || len(proxy.Options.ForwardTargets) > 0 {
+		result := map[string]interface{}{
+			"error": "benchmark_proxy needs a TCP proxy with a single backend",
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	// Get number of samples (optional, default: 10)
+	samples, ok := getInt(args, "samples")
+	if !ok {
+		samples = defaultBenchmarkSamples
+	}
+	if samples < 1 || samples > maxBenchmarkSamples {
+		return nil, fmt.Errorf("samples must be between 1 and %d", maxBenchmarkSamples)
+	}
+
+	// Get payload (optional, default: "ping\n")
+	payload, _ := getString(args, "payload")
+	if payload == "" {
+		payload = defaultBenchmarkPayload
+	}
+
+	// Get per-round-trip timeout (optional, default: 2s)
+	timeout := defaultProbeTimeout
+	if timeoutMs, ok := getInt(args, "timeout_ms"); ok && timeoutMs > 0 {
+		timeout = time.Duration(timeoutMs) * time.Millisecond
+	}
+
+	proxyHost := "127.0.0.1"
+	if proxy.Options.ListenNetwork == NetworkTCP6 {
+		proxyHost = "::1"
+	}
+	proxyAddr := net.JoinHostPort(proxyHost, strconv.Itoa(listenPort))
+	backendAddr := net.JoinHostPort(proxy.ForwardHost, strconv.Itoa(proxy.ForwardPort))
+
+	result := benchmarkProxy(ctx, proxyAddr, backendAddr, samples, []byte(payload), timeout)
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// SetLabelHandler handles the set_label tool
+type SetLabelHandler struct {
+	manager *ProxyManager
+}
+
+// NewSetLabelHandler creates a new set label handler
+func NewSetLabelHandler(manager *ProxyManager) *SetLabelHandler {
+	return &SetLabelHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *SetLabelHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	// Get new label and tags (at least one required; empty values clear them)
+	label, hasLabel := getString(args, "label")
+	_, hasTags := args["tags"]
+	tags, ok := getStringSlice(args, "tags")
+	if hasTags && !ok {
+		return nil, fmt.Errorf("tags must be a list of strings")
+	}
+	if !hasLabel && !hasTags {
+		return nil, fmt.Errorf("label or tags is required")
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	if hasLabel {
+		proxy.SetLabel(label)
+	}
+	if hasTags {
+		proxy.SetTags(tags)
+	}
+
+	result := map[string]interface{}{
+		"status":      "updated",
+		"listen_port": listenPort,
+		"label":       proxy.Label(),
+		"tags":        proxy.Tags(),
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// GetTransactionsHandler handles the get_transactions tool
+type GetTransactionsHandler struct {
+	manager *ProxyManager
+}
+
+// NewGetTransactionsHandler creates a new get transactions handler
+func NewGetTransactionsHandler(manager *ProxyManager) *GetTransactionsHandler {
+	return &GetTransactionsHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *GetTransactionsHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	// Get connection filter (optional, default: all connections)
+	connID, hasConnID := getInt(args, "conn_id")
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	// Requests and responses are paired in order within each connection
+	transactions := []HTTPTransaction{}
+	for _, txn := range extractHTTPTransactions(proxy.Buffer.GetAll()) {
+		if hasConnID && txn.ConnID != uint64(connID) {
+			continue
+		}
+		transactions = append(transactions, txn)
+	}
+
+	unanswered := 0
+	for _, txn := range transactions {
+		if txn.Response == nil {
+			unanswered++
+		}
+	}
+
+	result := map[string]interface{}{
+		"listen_port":        listenPort,
+		"total_transactions": len(transactions),
+		"unanswered":         unanswered,
+		"transactions":       transactions,
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// ResetStatsHandler handles the reset_stats tool
+type ResetStatsHandler struct {
+	manager *ProxyManager
+}
+
+// NewResetStatsHandler creates a new reset stats handler
+func NewResetStatsHandler(manager *ProxyManager) *ResetStatsHandler {
+	return &ResetStatsHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *ResetStatsHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get listen port (required)
+	listenPort, ok := getInt(args, "listen_port")
+	if !ok {
+		return nil, fmt.Errorf("listen_port is required")
+	}
+
+	proxy, exists := h.manager.GetProxy(listenPort)
+	if !exists {
+		result := map[string]interface{}{
+			"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	resetAt := proxy.ResetStats()
+	packets, _, _ := proxy.Buffer.GetStats()
+
+	result := map[string]interface{}{
+		"status":          "reset",
+		"listen_port":     listenPort,
+		"stats_reset_at":  formatTimestamp(resetAt),
+		"buffer_captures": packets,
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// CheckBackendProtocolHandler handles the check_backend_protocol tool
+type CheckBackendProtocolHandler struct {
+	manager *ProxyManager
+}
+
+// NewCheckBackendProtocolHandler creates a new check backend protocol handler
+func NewCheckBackendProtocolHandler(manager *ProxyManager) *CheckBackendProtocolHandler {
+	return &CheckBackendProtocolHandler{manager: manager}
+}
+
+// Execute implements the tool handler
+func (h *CheckBackendProtocolHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		args = make(map[string]interface{}) // Empty args fail below with a clear message
+	}
+
+	// Get the target: a proxy's backend, or host/port
+	var host string
+	var port int
+	if listenPort, ok := getInt(args, "listen_port"); ok {
+		proxy, exists := h.manager.GetProxy(listenPort)
+		if !exists {
+			result := map[string]interface{}{
+				"error": fmt.Sprintf("no proxy running on port %d", listenPort),
+			}
+			jsonBytes, _ := json.Marshal(result)
+			return mcp.NewToolResultText(string(jsonBytes)), nil
+		}
+		host, port = proxy.ForwardHost, proxy.ForwardPort
+	} else {
+		host, _ = getString(args, "host")
+		if host == "" {
+			host = "localhost"
+		}
+		if port, ok = getInt(args, "port"); !ok {
+			return nil, fmt.Errorf("port or listen_port is required")
+		}
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+
+	// Get the probe (optional, default: http); a payload replaces it
+	probe, _ := getString(args, "probe")
+	if probe == "" {
+		probe = protocolProbeHTTP
+	}
+	var payload []byte
+	switch probe {
+	case protocolProbeHTTP:
+		payload = httpProbe(target)
+	case protocolProbeNone:
+	default:
+		return nil, fmt.Errorf("invalid probe %q (expected http or none)", probe)
+	}
+	if text, ok := getString(args, "payload"); ok && text != "" {
+		probe = protocolProbeCustom
+		payload = []byte(text)
+	}
+
+	// Get timeout (optional, default: 2s)
+	timeout := defaultProbeTimeout
+	if timeoutMs, ok := getInt(args, "timeout_ms"); ok && timeoutMs > 0 {
+		timeout = time.Duration(timeoutMs) * time.Millisecond
+	}
+
+	result := checkBackendProtocol(ctx, target, payload, timeout)
+	result.Probe = probe
+
+	// Get expected protocol (optional)
+	if expected, ok := getString(args, "expected_protocol"); ok && expected != "" && result.Error == "" {
+		matches := strings.EqualFold(result.DetectedProtocol, expected)
+		result.ExpectedProtocol = expected
+		result.Matches = &matches
+	}
+
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// SetLogLevelHandler handles the set_log_level tool
+type SetLogLevelHandler struct{}
+
+// NewSetLogLevelHandler creates a new set log level handler
+func NewSetLogLevelHandler() *SetLogLevelHandler {
+	return &SetLogLevelHandler{}
+}
+
+// Execute implements the tool handler
+func (h *SetLogLevelHandler) Execute(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	// Parse arguments
+	args, ok := request.Params.Arguments.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid arguments format")
+	}
+
+	// Get level (required)
+	name, ok := getString(args, "level")
+	if !ok {
+		return nil, fmt.Errorf("level is required")
+	}
+	level, err := parseLogLevel(name)
+	if err != nil {
+		result := map[string]interface{}{
+			"error": err.Error(),
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	}
+
+	previous := SetLogLevel(level)
+	infof("Log level changed from %s to %s", previous, level)
+
+	result := map[string]interface{}{
+		"status":   "updated",
+		"level":    level.String(),
+		"previous": previous.String(),
+	}
+	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+	return mcp.NewToolResultText(string(jsonBytes)), nil
+}
+
+// timestampFormat is RFC 3339 with milliseconds and the zone's real offset
+const timestampFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// displayLocation is the time zone timestamps are rendered in (--timezone)
+var displayLocation = time.Local
+
+// formatTimestamp renders a timestamp in the display time zone
+func formatTimestamp(t time.Time) string {
+	return t.In(displayLocation).Format(timestampFormat)
+}
+
+// Helper functions to extract typed values from arguments
+
+func getInt(args map[string]interface{}, key string) (int, bool) {
+	val, exists := args[key]
 	if !exists {
 		return 0, false
 	}
@@ -278,6 +2872,52 @@ func getInt(args map[string]interface{}, key string) (int, bool) {
 	}
 }
 
+// getSize reads a byte count given either as a number or as a size string
+// such as "512KB"
+func getSize(args map[string]interface{}, key string) (int, bool, error) {
+	if s, ok := args[key].(string); ok {
+		size, err := parseSize(s)
+		return size, err == nil, err
+	}
+	if n, ok := args[key].(float64); ok && n > 0 && n < 1 {
+		return 0, false, fmt.Errorf("invalid size %v: must be at least 1 byte", n)
+	}
+	size, ok := getInt(args, key)
+	return size, ok, nil
+}
+
+// numberOrString widens a property's schema to accept a JSON number as well
+// as a string, for sizes given as a byte count or with a unit
+func numberOrString() mcp.PropertyOption {
+	return func(schema map[string]any) {
+		schema["type"] = []string{"number", "string"}
+	}
+}
+
+func getStringSlice(args map[string]interface{}, key string) ([]string, bool) {
+	val, exists := args[key]
+	if !exists {
+		return nil, false
+	}
+
+	switch v := val.(type) {
+	case []string:
+		return v, true
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			result = append(result, str)
+		}
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
 func getString(args map[string]interface{}, key string) (string, bool) {
 	val, exists := args[key]
 	if !exists {
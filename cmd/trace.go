@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultTraceHeader is the correlation header trace_header: true adds
+const defaultTraceHeader = "X-MCP-Trace-Id"
+
+// validHeaderName reports whether name is a non-empty HTTP token, so it
+// can't smuggle CR, LF, a colon or spaces into requests it is added to
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isHTTPRequestStart reports whether data begins an HTTP/1.x request
+func isHTTPRequestStart(data []byte) bool {
+	return isHTTPMessageStart(data) && !bytes.HasPrefix(data, []byte("HTTP/1."))
+}
+
+// requestHeaderValue returns the value of header in the header block of the
+// request that data begins with. Only the part of the block in data is seen.
+func requestHeaderValue(data []byte, header string) (string, bool) {
+	if !isHTTPRequestStart(data) {
+		return "", false
+	}
+	if end := bytes.Index(data, []byte("\r\n\r\n")); end >= 0 {
+		data = data[:end]
+	}
+	lines := bytes.Split(data, []byte("\r\n"))
+	for _, line := range lines[1:] {
+		name, value, found := bytes.Cut(line, []byte(":"))
+		if found && bytes.EqualFold(bytes.TrimSpace(name), []byte(header)) {
+			return string(bytes.TrimSpace(value)), true
+		}
+	}
+	return "", false
+}
+
+// isPartialRequestStart reports whether data is too short to tell but could
+// still grow into a request line
+func isPartialRequestStart(data []byte) bool {
+	for _, prefix := range httpMethodPrefixes {
+		if len(data) < len(prefix) && bytes.HasPrefix(prefix, data) {
+			return true
+		}
+	}
+	return false
+}
+
+// addTraceHeader adds header with a new trace ID after the request line of
+// the header block that data begins with, unless it already carries one.
+// data is returned unchanged when it does not begin a request or holds only
+// part of the request line.
+func addTraceHeader(data []byte, header string) []byte {
+	if _, ok := requestHeaderValue(data, header); ok || !isHTTPRequestStart(data) {
+		return data
+	}
+	lineEnd := bytes.Index(data, []byte("\r\n"))
+	if lineEnd < 0 {
+		return data
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	field := header + ": " + hex.EncodeToString(id) + "\r\n"
+
+	out := make([]byte, 0, len(data)+len(field))
+	out = append(out, data[:lineEnd+2]...)
+	out = append(out, field...)
+	return append(out, data[lineEnd+2:]...)
+}
+
+// traceMode is the framing state of the client side of a traced connection
+type traceMode int
+
+const (
+	traceModeStart       traceMode = iota // Expecting a request line
+	traceModeHeaders                      // Inside the header block
+	traceModeBody                         // Inside a body of known length
+	traceModeChunkSize                    // Expecting a chunk size line
+	traceModeChunkData                    // Inside a chunk and its trailing CRLF
+	traceModeTrailers                     // Inside the trailers after the last chunk
+	traceModePassthrough                  // Not HTTP/1.x or framing lost, forward as is
+)
+
+// traceInjector adds the trace header to each request a client sends. It
+// follows request framing across reads so only real request starts are
+// tagged, and holds back a header block until it is complete so a header
+// the client already sent is seen wherever it falls.
+type traceInjector struct {
+	mode      traceMode
+	pending   []byte // Bytes held back until a header block or line completes
+	remaining int64  // Bytes left in traceModeBody or traceModeChunkData
+}
+
+// inject returns the bytes to forward for data, with the trace header added
+// to every request whose header block completed. Bytes of an incomplete
+// header block or chunk line are held back until a later call or flush.
+func (t *traceInjector) inject(data []byte, header string) []byte {
+	if len(t.pending) > 0 {
+		data = append(t.pending, data...)
+		t.pending = nil
+	}
+
+	var out []byte
+	for len(data) > 0 {
+		switch t.mode {
+		case traceModeStart:
+			if !isHTTPRequestStart(data) {
+				if isPartialRequestStart(data) {
+					t.pending = append([]byte(nil), data...)
+					return out
+				}
+				t.mode = traceModePassthrough
+				continue
+			}
+			t.mode = traceModeHeaders
+
+		case traceModeHeaders:
+			end := bytes.Index(data, []byte("\r\n\r\n"))
+			if end < 0 {
+				if !t.hold(data, maxHeaderBytes) {
+					continue
+				}
+				return out
+			}
+			block := data[:end+4]
+			data = data[end+4:]
+			out = append(out, addTraceHeader(block, header)...)
+			t.startBody(block)
+
+		case traceModeBody, traceModeChunkData:
+			n := min(int64(len(data)), t.remaining)
+			if out == nil && n == int64(len(data)) {
+				out = data
+			} else {
+				out = append(out, data[:n]...)
+			}
+			data = data[n:]
+			t.remaining -= n
+			if t.remaining == 0 {
+				if t.mode == traceModeBody {
+					t.mode = traceModeStart
+				} else {
+					t.mode = traceModeChunkSize
+				}
+			}
+
+		case traceModeChunkSize, traceModeTrailers:
+			end := bytes.Index(data, []byte("\r\n"))
+			if end < 0 {
+				if !t.hold(data, maxHeaderBytes) {
+					continue
+				}
+				return out
+			}
+			line := data[:end]
+			out = append(out, data[:end+2]...)
+			data = data[end+2:]
+
+			if t.mode == traceModeTrailers {
+				if end == 0 {
+					t.mode = traceModeStart
+				}
+				continue
+			}
+			sizeField, _, _ := bytes.Cut(line, []byte(";"))
+			size, err := strconv.ParseInt(strings.TrimSpace(string(sizeField)), 16, 64)
+			switch {
+			case err != nil || size < 0:
+				t.mode = traceModePassthrough
+			case size == 0:
+				t.mode = traceModeTrailers
+			default:
+				t.mode = traceModeChunkData
+				t.remaining = size + 2
+			}
+
+		case traceModePassthrough:
+			if out == nil {
+				return data
+			}
+			return append(out, data...)
+		}
+	}
+	return out
+}
+
+// hold keeps data back for the next call unless it has grown past limit,
+// in which case the connection falls back to passthrough and false is
+// returned so the caller forwards it
+func (t *traceInjector) hold(data []byte, limit int) bool {
+	if len(data) > limit {
+		t.mode = traceModePassthrough
+		return false
+	}
+	t.pending = append([]byte(nil), data...)
+	return true
+}
+
+// flush returns the bytes still held back, for forwarding unchanged when
+// the client stops sending
+func (t *traceInjector) flush() []byte {
+	pending := t.pending
+	t.pending = nil
+	return pending
+}
+
+// startBody selects the framing of the body that follows a request header
+// block
+func (t *traceInjector) startBody(block []byte) {
+	lines := strings.Split(string(block), "\r\n")
+	method, _, _ := strings.Cut(lines[0], " ")
+
+	contentLength := int64(0)
+	chunked, upgrade := false, false
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "content-length":
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+				contentLength = n
+			}
+		case "transfer-encoding":
+			chunked = strings.Contains(strings.ToLower(value), "chunked")
+		case "upgrade":
+			upgrade = true
+		}
+	}
+
+	switch {
+	case method == "CONNECT" || upgrade:
+		// What follows may not be HTTP/1.x at all
+		t.mode = traceModePassthrough
+	case chunked:
+		t.mode = traceModeChunkSize
+	case contentLength > 0:
+		t.mode = traceModeBody
+		t.remaining = contentLength
+	default:
+		t.mode = traceModeStart
+	}
+}
+
+// TraceHop is one proxy a traced request passed through
+type TraceHop struct {
+	ListenPort  int    `json:"listen_port"`
+	ForwardTo   string `json:"forward_to"`
+	ConnID      uint64 `json:"conn_id"`
+	Seq         uint64 `json:"seq"`
+	Timestamp   string `json:"timestamp"`
+	RequestLine string `json:"request_line"`
+
+	at int64 // Capture time in nanoseconds, for ordering
+}
+
+// Trace is the journey of one request across proxies
+type Trace struct {
+	TraceID string     `json:"trace_id"`
+	Hops    []TraceHop `json:"hops"`
+}
+
+// collectTraces groups the traced requests captured by proxies by trace ID,
+// with hops in capture order and traces ordered by their first hop
+func collectTraces(proxies []*ProxyInstance, traceID string) []Trace {
+	byID := make(map[string]*Trace)
+	var traces []*Trace
+	for _, proxy := range proxies {
+		forwardTo := fmt.Sprintf("%s:%d", proxy.ForwardHost, proxy.ForwardPort)
+		for _, capture := range proxy.Buffer.GetAll() {
+			if capture.TraceID == "" || (traceID != "" && capture.TraceID != traceID) {
+				continue
+			}
+			trace, exists := byID[capture.TraceID]
+			if !exists {
+				trace = &Trace{TraceID: capture.TraceID}
+				byID[capture.TraceID] = trace
+				traces = append(traces, trace)
+			}
+			requestLine, _, _ := bytes.Cut(capture.RawData, []byte("\r\n"))
+			trace.Hops = append(trace.Hops, TraceHop{
+				ListenPort:  proxy.ListenPort,
+				ForwardTo:   forwardTo,
+				ConnID:      capture.ConnID,
+				Seq:         capture.Seq,
+				Timestamp:   formatTimestamp(capture.Timestamp),
+				RequestLine: string(requestLine),
+				at:          capture.Timestamp.UnixNano(),
+			})
+		}
+	}
+
+	result := make([]Trace, 0, len(traces))
+	for _, trace := range traces {
+		sort.SliceStable(trace.Hops, func(i, j int) bool { return trace.Hops[i].at < trace.Hops[j].at })
+		result = append(result, *trace)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Hops[0].at < result[j].Hops[0].at })
+	return result
+}
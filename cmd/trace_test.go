@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTraceRequests tests that a request through two chained proxies gets
+// one trace ID that both record
+func TestTraceRequests(t *testing.T) {
+	echoPort := startEchoServer(t)
+
+	manager := NewProxyManager()
+	opts := ProxyOptions{TraceHeader: defaultTraceHeader}
+	if err := manager.StartProxyWithOptions(19156, "127.0.0.1", echoPort, 1024*1024, opts); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19156)
+	if err := manager.StartProxyWithOptions(19155, "127.0.0.1", 19156, 1024*1024, opts); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19155)
+
+	client, err := net.Dial("tcp", "127.0.0.1:19155")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := bufio.NewReader(client)
+
+	// The echo shows what reached the backend
+	send := func(request string) []string {
+		client.Write([]byte(request))
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read echo: %v", err)
+			}
+			if line == "\r\n" {
+				return lines
+			}
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+
+	echoed := send("GET /orders HTTP/1.1\r\nHost: shop\r\n\r\n")
+	if len(echoed) != 3 || !strings.HasPrefix(echoed[1], defaultTraceHeader+": ") {
+		t.Fatalf("Expected exactly one trace header added, got %q", echoed)
+	}
+	traceID := strings.TrimPrefix(echoed[1], defaultTraceHeader+": ")
+
+	// An existing header is kept as is
+	echoed = send("GET /cart HTTP/1.1\r\nHost: shop\r\nx-mcp-trace-id: caller-7\r\n\r\n")
+	if len(echoed) != 3 || echoed[2] != "x-mcp-trace-id: caller-7" {
+		t.Errorf("Expected the caller's trace header untouched, got %q", echoed)
+	}
+
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if len(collectTraces(manager.GetAllProxies(), "")) == 2 {
+			break
+		}
+	}
+
+	result := callTool(t, NewTraceRequestsHandler(manager).Execute, map[string]interface{}{
+		"trace_id": traceID,
+	})
+	traces := result["traces"].([]interface{})
+	if len(traces) != 1 {
+		t.Fatalf("Expected 1 trace, got %v", traces)
+	}
+	hops := traces[0].(map[string]interface{})["hops"].([]interface{})
+	if len(hops) != 2 {
+		t.Fatalf("Expected the trace on both proxies, got %v", hops)
+	}
+	for i, port := range []float64{19155, 19156} {
+		hop := hops[i].(map[string]interface{})
+		if hop["listen_port"] != port || hop["request_line"] != "GET /orders HTTP/1.1" {
+			t.Errorf("Hop %d: unexpected %v", i, hop)
+		}
+	}
+
+	all := callTool(t, NewTraceRequestsHandler(manager).Execute, map[string]interface{}{})
+	if all["total_traces"] != float64(2) {
+		t.Errorf("Expected 2 traces, got %v", all["total_traces"])
+	}
+}
+
+// TestTraceHeaderValidation tests that trace_header names that would break
+// or smuggle request headers are rejected
+func TestTraceHeaderValidation(t *testing.T) {
+	for _, header := range []string{"X-Trace\r\nEvil: 1", "X-Trace: 1", "X Trace", "X-Trace\n"} {
+		if _, err := parseProxyConfig(map[string]interface{}{
+			"listen_port":  float64(19231),
+			"forward_port": float64(18082),
+			"trace_header": header,
+		}); err == nil {
+			t.Errorf("Expected trace_header %q to be rejected", header)
+		}
+	}
+	cfg, err := parseProxyConfig(map[string]interface{}{
+		"listen_port":  float64(19231),
+		"forward_port": float64(18082),
+		"trace_header": "X-Request_ID.v2",
+	})
+	if err != nil || cfg.Options.TraceHeader != "X-Request_ID.v2" {
+		t.Errorf("Expected a token header name accepted, got %q (%v)", cfg.Options.TraceHeader, err)
+	}
+}
+
+// TestTraceInjectorFraming tests that the trace header is added only at
+// request starts, whatever the read boundaries
+func TestTraceInjectorFraming(t *testing.T) {
+	header := defaultTraceHeader
+	countTags := func(s string) int { return strings.Count(s, header+": ") }
+
+	// A body read that happens to begin with a method name is left alone
+	var tracer traceInjector
+	out := string(tracer.inject([]byte("POST /upload HTTP/1.1\r\nContent-Length: 18\r\n\r\n"), header))
+	if countTags(out) != 1 {
+		t.Fatalf("Expected the request tagged, got %q", out)
+	}
+	body := "GET not a request!"
+	if got := string(tracer.inject([]byte(body), header)); got != body {
+		t.Errorf("Expected the body forwarded unchanged, got %q", got)
+	}
+
+	// A header block split across reads is held until complete, so the
+	// client's own header in the second read is seen
+	tracer = traceInjector{}
+	if got := tracer.inject([]byte("GET /a HTTP/1.1\r\nHost: x\r\n"), header); len(got) != 0 {
+		t.Fatalf("Expected the partial header block held back, got %q", got)
+	}
+	out = string(tracer.inject([]byte(header+": caller-1\r\n\r\n"), header))
+	if out != "GET /a HTTP/1.1\r\nHost: x\r\n"+header+": caller-1\r\n\r\n" {
+		t.Errorf("Expected the caller's header kept without a duplicate, got %q", out)
+	}
+
+	// Every pipelined request in one read is tagged, including one after a
+	// chunked body
+	tracer = traceInjector{}
+	pipelined := "GET /1 HTTP/1.1\r\n\r\n" +
+		"POST /2 HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nGET \r\n0\r\n\r\n" +
+		"GET /3 HTTP/1.1\r\n\r\n"
+	out = string(tracer.inject([]byte(pipelined), header))
+	if countTags(out) != 3 || !strings.Contains(out, "4\r\nGET \r\n0\r\n\r\n") {
+		t.Errorf("Expected 3 requests tagged and the chunked body intact, got %q", out)
+	}
+
+	// Non-HTTP clients are passed through, and held bytes flushed at the end
+	tracer = traceInjector{}
+	if got := string(tracer.inject([]byte("\x16\x03\x01"), header)); got != "\x16\x03\x01" {
+		t.Errorf("Expected non-HTTP bytes forwarded unchanged, got %q", got)
+	}
+	tracer = traceInjector{}
+	tracer.inject([]byte("GET /partial"), header)
+	if got := string(tracer.flush()); got != "GET /partial" {
+		t.Errorf("Expected the held bytes flushed, got %q", got)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Default and maximum time wait_for blocks
+const (
+	defaultWaitTimeout = 10 * time.Second
+	maxWaitTimeout     = 5 * time.Minute
+)
+
+// waitProgressInterval is how often wait_for reports progress to a client
+// that asked for it
+const waitProgressInterval = 500 * time.Millisecond
+
+// waitCondition is what wait_for blocks on; every condition set must hold
+type waitCondition struct {
+	minBytes       int64          // Bytes seen through the proxy (0 = unset)
+	minConnections int64          // Connections accepted (0 = unset)
+	pattern        *regexp.Regexp // Matched by a stored capture (nil = unset)
+}
+
+// waitResult reports the state when wait_for returned
+type waitResult struct {
+	satisfied   bool
+	bytes       int64
+	connections int64
+	matchedSeq  uint64 // Capture matching the pattern, 0 if none
+}
+
+// activity returns a channel closed at the next capture or connection
+func (p *ProxyInstance) activity() <-chan struct{} {
+	p.activityMu.Lock()
+	defer p.activityMu.Unlock()
+	if p.activityCh == nil {
+		p.activityCh = make(chan struct{})
+	}
+	return p.activityCh
+}
+
+// notifyActivity wakes everything waiting on activity. The channel is only
+// allocated while someone waits, so the capture path stays cheap otherwise.
+func (p *ProxyInstance) notifyActivity() {
+	p.activityMu.Lock()
+	defer p.activityMu.Unlock()
+	if p.activityCh != nil {
+		close(p.activityCh)
+		p.activityCh = nil
+	}
+}
+
+// waitFor blocks until cond holds, ctx is done or the proxy stops, checking
+// again each time the capture path signals activity. A non-nil progress is
+// called with the current state every waitProgressInterval.
+func (p *ProxyInstance) waitFor(ctx context.Context, cond waitCondition, progress func(waitResult)) waitResult {
+	var result waitResult
+	var scannedSeq uint64
+	var tick <-chan time.Time
+	if progress != nil {
+		ticker := time.NewTicker(waitProgressInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	report := false
+	for {
+		// Take the channel before checking so no signal in between is missed
+		wake := p.activity()
+
+		p.Stats.mu.RLock()
+		result.bytes = p.Stats.BytesCaptured
+		result.connections = p.Stats.Connections
+		p.Stats.mu.RUnlock()
+
+		if cond.pattern != nil && result.matchedSeq == 0 {
+			for _, capture := range p.Buffer.GetAll() {
+				if capture.Seq <= scannedSeq {
+					continue
+				}
+				scannedSeq = capture.Seq
+				if cond.pattern.Match(capture.RawData) {
+					result.matchedSeq = capture.Seq
+					break
+				}
+			}
+		}
+
+		result.satisfied = result.bytes >= cond.minBytes &&
+			result.connections >= cond.minConnections &&
+			(cond.pattern == nil || result.matchedSeq != 0)
+		if result.satisfied {
+			return result
+		}
+		if report {
+			progress(result)
+			report = false
+		}
+
+		select {
+		case <-tick:
+			report = true
+		case <-wake:
+		case <-ctx.Done():
+			return result
+		case <-p.ctx.Done():
+			return result
+		}
+	}
+}
+
+// waitProgress returns a callback sending MCP progress notifications for a
+// wait_for call, or nil when the client sent no progress token. Progress
+// counts toward min_bytes, else min_connections, else just bytes seen.
+func waitProgress(ctx context.Context, request mcp.CallToolRequest, cond waitCondition) func(waitResult) {
+	if request.Params.Meta == nil || request.Params.Meta.ProgressToken == nil {
+		return nil
+	}
+	srv := server.ServerFromContext(ctx)
+	if srv == nil {
+		return nil
+	}
+	token := request.Params.Meta.ProgressToken
+
+	return func(state waitResult) {
+		params := map[string]any{"progressToken": token}
+		switch {
+		case cond.minBytes > 0:
+			params["progress"] = min(state.bytes, cond.minBytes)
+			params["total"] = cond.minBytes
+		case cond.minConnections > 0:
+			params["progress"] = min(state.connections, cond.minConnections)
+			params["total"] = cond.minConnections
+		default:
+			params["progress"] = state.bytes
+		}
+
+		parts := []string{fmt.Sprintf("%d bytes", state.bytes)}
+		if cond.minBytes > 0 {
+			parts[0] = fmt.Sprintf("%d/%d bytes", state.bytes, cond.minBytes)
+		}
+		if cond.minConnections > 0 {
+			parts = append(parts, fmt.Sprintf("%d/%d connections", state.connections, cond.minConnections))
+		}
+		if cond.pattern != nil && state.matchedSeq != 0 {
+			parts = append(parts, "pattern matched")
+		} else if cond.pattern != nil {
+			parts = append(parts, "pattern not yet seen")
+		}
+		params["message"] = strings.Join(parts, ", ")
+
+		if err := srv.SendNotificationToClient(ctx, "notifications/progress", params); err != nil {
+			debugf("wait_for progress not sent: %v", err)
+		}
+	}
+}
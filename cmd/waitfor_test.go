@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// TestWaitForBytes tests that wait_for blocks until the byte threshold is
+// reached and times out when it is not
+func TestWaitForBytes(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19159, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19159)
+	proxy, _ := manager.GetProxy(19159)
+	conn := proxy.newConnection(nil, nil)
+
+	results := make(chan map[string]interface{}, 1)
+	go func() {
+		results <- callTool(t, NewWaitForHandler(manager).Execute, map[string]interface{}{
+			"listen_port": float64(19159),
+			"min_bytes":   float64(100),
+			"pattern":     "QUIT",
+			"timeout_ms":  float64(5000),
+		})
+	}()
+
+	// Below the threshold the call keeps blocking
+	proxy.recordCapture(conn, make([]byte, 60), DirectionClientToServer, false)
+	proxy.recordCapture(conn, []byte("QUIT\r\n"), DirectionClientToServer, false)
+	select {
+	case result := <-results:
+		t.Fatalf("Returned before the threshold: %v", result)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	proxy.recordCapture(conn, make([]byte, 40), DirectionServerToClient, false)
+	select {
+	case result := <-results:
+		if result["satisfied"] != true || result["bytes_captured"] != float64(106) || result["matched_seq"] != float64(2) {
+			t.Errorf("Unexpected result: %v", result)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait_for did not unblock once the threshold was reached")
+	}
+
+	// A threshold that is never reached times out
+	result := callTool(t, NewWaitForHandler(manager).Execute, map[string]interface{}{
+		"listen_port":     float64(19159),
+		"min_connections": float64(1),
+		"timeout_ms":      float64(50),
+	})
+	if result["satisfied"] != false || result["connections"] != float64(0) {
+		t.Errorf("Expected a timeout, got %v", result)
+	}
+
+	request := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]interface{}{"listen_port": float64(19159)}}}
+	if _, err := NewWaitForHandler(manager).Execute(context.Background(), request); err == nil {
+		t.Error("Expected an error without a condition")
+	}
+}
+
+// TestWaitForCoalescedCapture tests that a pattern in a coalesced capture
+// unblocks wait_for when the capture is flushed after the window
+func TestWaitForCoalescedCapture(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxyWithOptions(19233, "127.0.0.1", 18082, 1024*1024, ProxyOptions{CoalesceWindow: 50 * time.Millisecond}); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19233)
+	proxy, _ := manager.GetProxy(19233)
+	conn := proxy.newConnection(nil, nil)
+
+	results := make(chan map[string]interface{}, 1)
+	go func() {
+		results <- callTool(t, NewWaitForHandler(manager).Execute, map[string]interface{}{
+			"listen_port": float64(19233),
+			"pattern":     "QUIT",
+			"timeout_ms":  float64(5000),
+		})
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// The read is held by the coalescer, so only the idle flush stores it
+	proxy.recordCapture(conn, []byte("QUIT\r\n"), DirectionClientToServer, false)
+	select {
+	case result := <-results:
+		if result["satisfied"] != true || result["matched_seq"] != float64(1) {
+			t.Errorf("Unexpected result: %v", result)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait_for did not unblock when the coalesced capture was flushed")
+	}
+}
+
+// progressSession is a client session collecting notifications
+type progressSession struct {
+	notifications chan mcp.JSONRPCNotification
+}
+
+func (s *progressSession) Initialize() {}
+
+func (s *progressSession) Initialized() bool {
+	return true
+}
+
+func (s *progressSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+func (s *progressSession) SessionID() string {
+	return "progress-test"
+}
+
+// TestWaitForProgress tests that wait_for sends progress notifications while
+// it waits when the request carries a progress token
+func TestWaitForProgress(t *testing.T) {
+	manager := NewProxyManager()
+	if err := manager.StartProxy(19194, "127.0.0.1", 18082, 1024*1024); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19194)
+	proxy, _ := manager.GetProxy(19194)
+	conn := proxy.newConnection(nil, nil)
+
+	mcpServer := server.NewMCPServer("test", "1.0.0")
+	mcpServer.AddTool(mcp.NewTool("wait_for"), NewWaitForHandler(manager).Execute)
+	session := &progressSession{notifications: make(chan mcp.JSONRPCNotification, 100)}
+	if err := mcpServer.RegisterSession(context.Background(), session); err != nil {
+		t.Fatalf("Failed to register session: %v", err)
+	}
+	ctx := mcpServer.WithContext(context.Background(), session)
+
+	// Reach half the target at once and the rest after a few progress ticks
+	proxy.captureData(conn, make([]byte, 50), DirectionClientToServer)
+	go func() {
+		time.Sleep(3 * waitProgressInterval / 2)
+		proxy.captureData(conn, make([]byte, 50), DirectionClientToServer)
+	}()
+
+	message := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"wait_for",` +
+		`"arguments":{"listen_port":19194,"min_bytes":100,"timeout_ms":5000},"_meta":{"progressToken":"wait-1"}}}`
+	response := mcpServer.HandleMessage(ctx, []byte(message))
+	if _, ok := response.(mcp.JSONRPCResponse); !ok {
+		t.Fatalf("Unexpected response: %+v", response)
+	}
+	if !strings.Contains(fmt.Sprint(response), `"satisfied": true`) {
+		t.Fatalf("Expected wait_for to be satisfied, got %+v", response)
+	}
+
+	close(session.notifications)
+	var received int
+	for notification := range session.notifications {
+		if notification.Method != "notifications/progress" {
+			continue
+		}
+		received++
+		params := notification.Params.AdditionalFields
+		if params["progressToken"] != "wait-1" || params["progress"] != int64(50) || params["total"] != int64(100) {
+			t.Errorf("Unexpected progress params: %v", params)
+		}
+		if params["message"] != "50/100 bytes" {
+			t.Errorf("Unexpected progress message: %v", params["message"])
+		}
+	}
+	if received == 0 {
+		t.Error("Expected progress notifications while waiting")
+	}
+}
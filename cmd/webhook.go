@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// Webhook delivery limits
+const (
+	webhookQueueLen    = 64 // Notifications waiting to be posted
+	webhookMaxAttempts = 3  // Posts per notification before it counts as failed
+	webhookRetryDelay  = 200 * time.Millisecond
+	webhookTimeout     = 5 * time.Second // Per-attempt HTTP timeout
+	webhookSnippetMax  = 256             // Bytes of the match included in a notification
+)
+
+// webhookEvent is the JSON body posted when a capture matches
+type webhookEvent struct {
+	ListenPort int    `json:"listen_port"`
+	Seq        uint64 `json:"seq"`
+	ConnID     uint64 `json:"conn_id"`
+	Direction  string `json:"direction"`
+	Snippet    string `json:"snippet"`
+	Timestamp  string `json:"timestamp"`
+}
+
+// webhookNotifier posts matching captures to a URL from its own goroutine.
+// Captures are matched on the data path but only queued there; a full queue
+// drops the notification rather than waiting.
+type webhookNotifier struct {
+	proxy   *ProxyInstance
+	url     string
+	pattern *regexp.Regexp
+	queue   chan webhookEvent
+	client  *http.Client
+}
+
+// validateWebhook checks webhook_url and webhook_pattern, which must be given together
+func validateWebhook(rawURL, pattern string) (*regexp.Regexp, error) {
+	if rawURL == "" && pattern == "" {
+		return nil, nil
+	}
+	if rawURL == "" || pattern == "" {
+		return nil, fmt.Errorf("webhook_url and webhook_pattern must be given together")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook_url %q: expected an http or https URL", rawURL)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook_pattern: %v", err)
+	}
+	return re, nil
+}
+
+// startWebhook starts the notifier of a proxy, or returns nil when the proxy
+// has no webhook_url
+func (p *ProxyInstance) startWebhook(pattern *regexp.Regexp) *webhookNotifier {
+	if pattern == nil {
+		return nil
+	}
+	w := &webhookNotifier{
+		proxy:   p,
+		url:     p.Options.WebhookURL,
+		pattern: pattern,
+		queue:   make(chan webhookEvent, webhookQueueLen),
+		client:  &http.Client{Timeout: webhookTimeout},
+	}
+	p.wg.Add(1)
+	go w.run(p.ctx)
+	return w
+}
+
+// match queues a notification if the capture's stored payload matches
+func (w *webhookNotifier) match(capture *CapturedPacket) {
+	loc := w.pattern.FindIndex(capture.RawData)
+	if loc == nil {
+		return
+	}
+	snippet := capture.RawData[loc[0]:loc[1]]
+	if len(snippet) > webhookSnippetMax {
+		snippet = snippet[:webhookSnippetMax]
+	}
+	text, _ := printableText(snippet)
+
+	event := webhookEvent{
+		ListenPort: w.proxy.ListenPort,
+		Seq:        capture.Seq,
+		ConnID:     capture.ConnID,
+		Direction:  capture.Direction,
+		Snippet:    text,
+		Timestamp:  formatTimestamp(capture.Timestamp),
+	}
+	select {
+	case w.queue <- event:
+	default:
+		w.count(&w.proxy.Stats.WebhookDropped)
+	}
+}
+
+// run posts queued notifications until the proxy stops
+func (w *webhookNotifier) run(ctx context.Context) {
+	defer w.proxy.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-w.queue:
+			w.deliver(ctx, event)
+		}
+	}
+}
+
+// deliver posts one notification, retrying failed attempts with a growing delay
+func (w *webhookNotifier) deliver(ctx context.Context, event webhookEvent) {
+	body, _ := json.Marshal(event)
+
+	var err error
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		if err = w.post(ctx, body); err == nil {
+			w.count(&w.proxy.Stats.WebhookSent)
+			return
+		}
+		if attempt == webhookMaxAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(attempt) * webhookRetryDelay):
+		}
+	}
+
+	if ctx.Err() == nil {
+		warnf("Webhook %s failed for port %d: %v", w.url, w.proxy.ListenPort, err)
+		w.count(&w.proxy.Stats.WebhookFailures)
+	}
+}
+
+// post sends a single attempt, treating any non-2xx status as a failure
+func (w *webhookNotifier) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// count increments a webhook counter of the proxy's stats
+func (w *webhookNotifier) count(counter *int64) {
+	w.proxy.Stats.mu.Lock()
+	*counter++
+	w.proxy.Stats.mu.Unlock()
+}
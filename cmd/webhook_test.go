@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestWebhookOnMatch tests that a matching capture is posted to the webhook,
+// retrying a failed attempt, while non-matching captures are not
+func TestWebhookOnMatch(t *testing.T) {
+	var attempts atomic.Int32
+	events := make(chan webhookEvent, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first attempt so delivery has to retry
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event webhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Invalid webhook body: %v", err)
+		}
+		events <- event
+	}))
+	defer server.Close()
+
+	manager := NewProxyManager()
+	opts := ProxyOptions{WebhookURL: server.URL, WebhookPattern: `ERROR [0-9]+`}
+	if err := manager.StartProxyWithOptions(19166, "127.0.0.1", 18082, 1024*1024, opts); err != nil {
+		t.Fatalf("Failed to start proxy: %v", err)
+	}
+	defer manager.StopProxy(19166)
+	proxy, _ := manager.GetProxy(19166)
+	conn := proxy.newConnection(nil, nil)
+
+	proxy.recordCapture(conn, []byte("all good"), DirectionServerToClient, false)
+	proxy.recordCapture(conn, []byte("status: ERROR 503 upstream"), DirectionServerToClient, false)
+
+	select {
+	case event := <-events:
+		if event.ListenPort != 19166 || event.Seq != 2 || event.Direction != DirectionServerToClient || event.Snippet != "ERROR 503" {
+			t.Errorf("Unexpected webhook event %+v", event)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Webhook did not fire")
+	}
+
+	// Only the matching capture is delivered
+	select {
+	case event := <-events:
+		t.Errorf("Unexpected second webhook event %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	proxy.Stats.mu.RLock()
+	sent, failures := proxy.Stats.WebhookSent, proxy.Stats.WebhookFailures
+	proxy.Stats.mu.RUnlock()
+	if sent != 1 || failures != 0 || attempts.Load() != 2 {
+		t.Errorf("Expected 1 sent after 2 attempts, got sent=%d failures=%d attempts=%d", sent, failures, attempts.Load())
+	}
+
+	// A match the buffer refuses is not posted
+	proxy.Buffer.SetStopAtPercent(1)
+	refused := append(make([]byte, 20000), "ERROR 507"...)
+	proxy.recordCapture(conn, refused, DirectionServerToClient, false)
+	select {
+	case event := <-events:
+		t.Errorf("Unexpected webhook event for a refused capture %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if _, err := parseProxyConfig(map[string]interface{}{
+		"listen_port": float64(19167), "forward_port": float64(18082), "webhook_url": server.URL,
+	}); err == nil {
+		t.Error("Expected webhook_url without webhook_pattern to be rejected")
+	}
+}